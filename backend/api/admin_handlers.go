@@ -0,0 +1,93 @@
+package api
+
+import (
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type AdminHandler struct {
+	RotationService *services.KeyRotationService
+	Throttle        *services.LoginThrottle
+	AuditService    *services.AuditService
+}
+
+func NewAdminHandler(rotationService *services.KeyRotationService, throttle *services.LoginThrottle, auditService *services.AuditService) *AdminHandler {
+	return &AdminHandler{RotationService: rotationService, Throttle: throttle, AuditService: auditService}
+}
+
+// GetKeyring lists the master key versions known to the server, without key material
+func (h *AdminHandler) GetKeyring(c *gin.Context) {
+	ring, err := services.MasterKeyring()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Keyring unavailable"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"active_version": ring.ActiveVersion(),
+		"versions":       ring.Versions(),
+		"providers":      ring.Providers(),
+	})
+}
+
+// StartKeyRotation starts rewrapping all data keys to the active master key
+func (h *AdminHandler) StartKeyRotation(c *gin.Context) {
+	status, err := h.RotationService.Start()
+	if err != nil {
+		if errors.Is(err, services.ErrRotationInProgress) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "status": status})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start key rotation"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "admin.key_rotation.start", ResourceType: "keyring",
+		Result: services.AuditSuccess, Details: map[string]interface{}{"target_version": status.TargetVersion}})
+
+	c.JSON(http.StatusAccepted, status)
+}
+
+// GetKeyRotationStatus reports the progress of the current or last rotation job
+func (h *AdminHandler) GetKeyRotationStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, h.RotationService.Status())
+}
+
+// GetLockouts lists emails that are locked out or backing off after failed sign-ins
+func (h *AdminHandler) GetLockouts(c *gin.Context) {
+	lockouts, err := h.Throttle.ListLockouts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list lockouts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, lockouts)
+}
+
+// ClearLockout lets a locked-out email sign in again immediately
+func (h *AdminHandler) ClearLockout(c *gin.Context) {
+	lockoutID, ok := parseUintParam(c, "lockoutID", "lockout ID")
+	if !ok {
+		return
+	}
+
+	lockout, err := h.Throttle.ClearLockout(lockoutID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Lockout not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to clear lockout"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "admin.lockout.clear", ResourceType: "login_lockout",
+		ResourceID: lockout.ID, Result: services.AuditSuccess,
+		Details: map[string]interface{}{"email": lockout.Email, "failed_count": lockout.FailedCount}})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Lockout cleared"})
+}
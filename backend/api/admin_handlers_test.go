@@ -0,0 +1,53 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAdminRoutesRequireAdmin(t *testing.T) {
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/api/admin/keys"},
+		{http.MethodPost, "/api/admin/keys/rotate"},
+		{http.MethodGet, "/api/admin/keys/rotate"},
+	}
+
+	for _, route := range routes {
+		// A rotation started here would scan the projects next
+		s := newTestServer(t)
+		s.expectSession(2, nil)
+		s.expectUser(2, "user@example.com")
+		s.check(route.method, route.path, 2, nil, nil, http.StatusForbidden)
+
+		s = newTestServer(t)
+		s.check(route.method, route.path, 0, nil, nil, http.StatusUnauthorized)
+	}
+}
+
+func TestGetKeyringListsVersionsWithoutKeyMaterial(t *testing.T) {
+	s := newTestServer(t)
+	s.expectSession(1, nil)
+	s.expectUser(1, adminEmail)
+	w := s.check(http.MethodGet, "/api/admin/keys", 1, nil, nil, http.StatusOK)
+
+	var keyring struct {
+		ActiveVersion uint32            `json:"active_version"`
+		Versions      []uint32          `json:"versions"`
+		Providers     map[string]string `json:"providers"`
+	}
+	decodeBody(t, w, &keyring)
+	if keyring.ActiveVersion != 1 || len(keyring.Versions) != 1 || keyring.Versions[0] != 1 {
+		t.Errorf("Expected version 1 to be listed as active, got %s", w.Body.String())
+	}
+	if name := keyring.Providers[strconv.FormatUint(uint64(keyring.ActiveVersion), 10)]; name != "env:v1" {
+		t.Errorf("Expected the active key to come from the env provider, got %q in %s", name, w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "12345678901234567890123456789012") {
+		t.Error("Expected the keyring listing to leave out key material")
+	}
+}
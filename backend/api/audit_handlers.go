@@ -0,0 +1,233 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuditHandler struct {
+	AuditService      *services.AuditService
+	MembershipService *services.MembershipService
+	UserService       *services.UserService
+}
+
+func NewAuditHandler(auditService *services.AuditService, membershipService *services.MembershipService, userService *services.UserService) *AuditHandler {
+	return &AuditHandler{AuditService: auditService, MembershipService: membershipService, UserService: userService}
+}
+
+// auditRecord describes an action taken by the current request
+type auditRecord struct {
+	Action       string
+	ResourceType string
+	ResourceID   uint
+	ProjectID    *uint
+	Result       string
+	Details      map[string]interface{}
+	ActorID      *uint // Overrides the authenticated user, e.g. for login
+}
+
+// recordAudit appends an audit event for the current request. Failures are logged;
+// most callers ignore the returned error so it does not mask the response, but secret
+// reads fail closed on it.
+func recordAudit(audit *services.AuditService, c *gin.Context, rec auditRecord) error {
+	entry := services.AuditEntry{
+		ActorType:    services.ActorAnonymous,
+		Action:       rec.Action,
+		ResourceType: rec.ResourceType,
+		ProjectID:    rec.ProjectID,
+		IP:           c.ClientIP(),
+		UserAgent:    c.Request.UserAgent(),
+		Result:       rec.Result,
+		Details:      rec.Details,
+	}
+	if rec.ResourceID != 0 {
+		entry.ResourceID = strconv.FormatUint(uint64(rec.ResourceID), 10)
+	}
+
+	actorID := rec.ActorID
+	if actorID == nil {
+		if userID, exists := getUserID(c); exists {
+			actorID = &userID
+		}
+	}
+	if actorID != nil {
+		entry.ActorID = actorID
+		entry.ActorType = services.ActorUser
+	}
+	if token, isToken := getAPIToken(c); isToken && rec.ActorID == nil {
+		entry.ActorType = services.ActorAPIToken
+		if entry.Details == nil {
+			entry.Details = map[string]interface{}{}
+		}
+		entry.Details["token_id"] = token.ID
+	}
+	if key, isKey := getServiceAccountKey(c); isKey && rec.ActorID == nil {
+		entry.ActorID = &key.ServiceAccountID
+		entry.ActorType = services.ActorServiceAccount
+		if entry.Details == nil {
+			entry.Details = map[string]interface{}{}
+		}
+		entry.Details["key_id"] = key.ID
+	}
+	if isSCIMRequest(c) {
+		entry.ActorType = services.ActorSCIM
+	}
+
+	if _, err := audit.Record(entry); err != nil {
+		log.Printf("Failed to record audit event %s: %v", rec.Action, err)
+		return err
+	}
+	return nil
+}
+
+// requireAuditSinks writes a 503 response and returns false if a required audit sink is down.
+// Secret values are only released while every access can be recorded externally.
+func requireAuditSinks(audit *services.AuditService, c *gin.Context) bool {
+	if err := audit.CheckSinks(); err != nil {
+		log.Printf("Refusing secret read: %v", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Audit logging is unavailable; secret reads are disabled"})
+		return false
+	}
+	return true
+}
+
+func uintPtr(v uint) *uint {
+	return &v
+}
+
+// GetAuditEvents lists audit events, newest first. Admins see every event; other users see
+// their own actions and events in projects they administer.
+//
+// Query parameters: actor_id, actor_type, action (suffix "*" for prefix match), resource_type, resource_id,
+// project_id, result, since, until (RFC 3339), before_id (pagination cursor) and limit.
+func (h *AuditHandler) GetAuditEvents(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	filter := services.AuditFilter{
+		ActorType:    c.Query("actor_type"),
+		Action:       c.Query("action"),
+		ResourceType: c.Query("resource_type"),
+		ResourceID:   c.Query("resource_id"),
+		Result:       c.Query("result"),
+	}
+
+	var err error
+	if filter.ActorID, err = optionalUintQuery(c, "actor_id"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid actor_id"})
+		return
+	}
+	if filter.ProjectID, err = optionalUintQuery(c, "project_id"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project_id"})
+		return
+	}
+	if filter.Since, err = optionalTimeQuery(c, "since"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid since, expected RFC 3339"})
+		return
+	}
+	if filter.Until, err = optionalTimeQuery(c, "until"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid until, expected RFC 3339"})
+		return
+	}
+	if beforeID, err := optionalUintQuery(c, "before_id"); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid before_id"})
+		return
+	} else if beforeID != nil {
+		filter.BeforeID = *beforeID
+	}
+	if limitStr := c.Query("limit"); limitStr != "" {
+		if filter.Limit, err = strconv.Atoi(limitStr); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+	}
+
+	user, err := h.UserService.FindUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !isAdminEmail(user.Email) {
+		projects, err := h.MembershipService.ProjectsForUser(userID, nil)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		filter.Restrict = true
+		filter.RestrictActorID = userID
+		for _, project := range projects {
+			if project.Role.AtLeast(models.RoleAdmin) {
+				filter.RestrictProjectID = append(filter.RestrictProjectID, project.ID)
+			}
+		}
+	}
+
+	events, err := h.AuditService.List(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve audit events"})
+		return
+	}
+
+	c.JSON(http.StatusOK, events)
+}
+
+// VerifyAuditChain recomputes the hash chain and reports the first broken event, if any.
+// ?head_seq=&head_hash= anchor the check to an event kept outside the database, such as the
+// last one an audit sink received, so events deleted from the end are detected too.
+func (h *AuditHandler) VerifyAuditChain(c *gin.Context) {
+	var anchor *services.AuditAnchor
+	if seq, hash := c.Query("head_seq"), c.Query("head_hash"); seq != "" || hash != "" {
+		parsed, err := strconv.ParseUint(seq, 10, 64)
+		hash = strings.ToLower(hash)
+		if err != nil || parsed == 0 || !auditHashPattern.MatchString(hash) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "head_seq and head_hash must be an event's seq and its 64 hex digit hash"})
+			return
+		}
+		anchor = &services.AuditAnchor{Seq: parsed, Hash: hash}
+	}
+
+	result, err := h.AuditService.Verify(anchor)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify audit chain"})
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+var auditHashPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+func optionalUintQuery(c *gin.Context, name string) (*uint, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	return uintPtr(uint(parsed)), nil
+}
+
+func optionalTimeQuery(c *gin.Context, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
@@ -1,23 +1,58 @@
 package api
 
 import (
+	"ciphersafe/config"
 	"ciphersafe/services"
+	"errors"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 )
 
 type AuthHandler struct {
-	AuthService *services.AuthService
+	AuthService    *services.AuthService
+	SessionService *services.SessionService
+	AuditService   *services.AuditService
 }
 
-func NewAuthHandler(authService *services.AuthService) *AuthHandler {
-	return &AuthHandler{AuthService: authService}
+func NewAuthHandler(authService *services.AuthService, sessionService *services.SessionService, auditService *services.AuditService) *AuthHandler {
+	return &AuthHandler{AuthService: authService, SessionService: sessionService, AuditService: auditService}
 }
 
 type authInput struct {
 	Email    string `json:"email" binding:"required,email"`
-	Password string `json:"password" binding:"required,min=8"`
+	Password string `json:"password" binding:"required"`
+}
+
+type changePasswordInput struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required"`
+}
+
+type refreshInput struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// sessionResponse is returned by login and refresh. "token" is the short-lived access token.
+func sessionResponse(tokens *services.SessionTokens) gin.H {
+	return gin.H{
+		"token":         tokens.AccessToken,
+		"token_type":    "Bearer",
+		"expires_in":    int(tokens.ExpiresIn.Seconds()),
+		"refresh_token": tokens.RefreshToken,
+		"session_id":    tokens.Session.ID,
+	}
+}
+
+// GetMethods tells the login page which sign-in methods the server offers
+func (h *AuthHandler) GetMethods(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"password": !config.AppConfig.PasswordLoginDisabled,
+		"oidc":     config.AppConfig.OIDCEnabled(),
+		"webauthn": true,
+	})
 }
 
 // Register handles user registration
@@ -28,10 +63,20 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
-	_, err := h.AuthService.Register(input.Email, input.Password)
+	user, err := h.AuthService.Register(input.Email, input.Password)
 	if err != nil {
+		if errors.Is(err, services.ErrPasswordLoginDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrWeakPassword) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		// Check if it's a "user already exists" error
 		if err.Error() == "user with this email already exists" {
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.register", ResourceType: "user",
+				Result: services.AuditFailure, Details: map[string]interface{}{"email": input.Email, "reason": "exists"}})
 			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 			return
 		}
@@ -39,6 +84,9 @@ func (h *AuthHandler) Register(c *gin.Context) {
 		return
 	}
 
+	recordAudit(h.AuditService, c, auditRecord{Action: "auth.register", ResourceType: "user", ResourceID: user.ID,
+		ActorID: uintPtr(user.ID), Result: services.AuditSuccess, Details: map[string]interface{}{"email": user.Email}})
+
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
 }
 
@@ -50,15 +98,161 @@ func (h *AuthHandler) Login(c *gin.Context) {
 		return
 	}
 
-	token, err := h.AuthService.Login(input.Email, input.Password)
+	tokens, user, err := h.AuthService.Login(input.Email, input.Password, c.ClientIP(), c.Request.UserAgent())
+	if errors.Is(err, services.ErrMFAPending) {
+		// The password was right; the session starts once /auth/mfa/verify gets a second factor
+		mfaToken, err := services.GenerateMFAToken(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"mfa_required":            true,
+			"mfa_token":               mfaToken,
+			"mfa_enrollment_required": !user.TOTPEnabled,
+		})
+		return
+	}
 	if err != nil {
-		if err.Error() == "invalid email or password" {
+		rec := auditRecord{Action: "auth.login", ResourceType: "user", Result: services.AuditFailure,
+			Details: map[string]interface{}{"email": input.Email}}
+		// The caller is not authenticated, so the event stays anonymous and targets the account
+		if user != nil {
+			rec.ResourceID = user.ID
+		}
+
+		if errors.Is(err, services.ErrInvalidCredentials) {
+			recordAudit(h.AuditService, c, rec)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
 			return
 		}
+		var throttled *services.LoginThrottledError
+		if errors.As(err, &throttled) {
+			rec.Result = services.AuditDenied
+			rec.Details["reason"] = throttleReason(throttled)
+			recordAudit(h.AuditService, c, rec)
+			respondThrottled(c, throttled)
+			return
+		}
+		if errors.Is(err, services.ErrAuthMethodDenied) || errors.Is(err, services.ErrPasswordLoginDisabled) ||
+			errors.Is(err, services.ErrUserDeactivated) {
+			rec.Result = services.AuditDenied
+			recordAudit(h.AuditService, c, rec)
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"token": token})
+	recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: user.ID,
+		ActorID: uintPtr(user.ID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"session_id": tokens.Session.ID}})
+	c.JSON(http.StatusOK, sessionResponse(tokens))
+}
+
+// respondThrottled refuses a sign-in attempt with 429 and a Retry-After header
+func respondThrottled(c *gin.Context, err *services.LoginThrottledError) {
+	seconds := int(math.Ceil(err.RetryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error(), "retry_after": seconds})
+}
+
+// throttleReason names a throttled attempt in the audit log
+func throttleReason(err *services.LoginThrottledError) string {
+	if err.Locked {
+		return "locked"
+	}
+	return "backoff"
+}
+
+// ChangePassword sets a new password for the authenticated user and signs out their other
+// sessions. The new password must meet the minimum length of each of the user's organizations.
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	var input changePasswordInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	userID, _ := getUserID(c)
+	sessionID, _ := getSessionID(c)
+
+	err := h.AuthService.ChangePassword(userID, sessionID, input.CurrentPassword, input.NewPassword)
+	if err != nil {
+		rec := auditRecord{Action: "auth.password.change", ResourceType: "user", ResourceID: userID,
+			Result: services.AuditFailure, Details: map[string]interface{}{}}
+		var throttled *services.LoginThrottledError
+		switch {
+		case errors.Is(err, services.ErrWeakPassword):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidCredentials):
+			rec.Details["reason"] = "current_password"
+			recordAudit(h.AuditService, c, rec)
+			c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		case errors.As(err, &throttled):
+			rec.Result = services.AuditDenied
+			rec.Details["reason"] = throttleReason(throttled)
+			recordAudit(h.AuditService, c, rec)
+			respondThrottled(c, throttled)
+		case errors.Is(err, services.ErrPasswordLoginDisabled):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		}
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "auth.password.change", ResourceType: "user", ResourceID: userID,
+		Result: services.AuditSuccess})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password changed; your other sessions were signed out"})
+}
+
+// Refresh exchanges a refresh token for a new access token and refresh token.
+// Routine refreshes are not audited; replayed refresh tokens are.
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var input refreshInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tokens, session, err := h.SessionService.Refresh(input.RefreshToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrRefreshTokenReused):
+			// The caller is not authenticated, so the event stays anonymous and targets the session
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.refresh", ResourceType: "session",
+				ResourceID: session.ID, Result: services.AuditDenied,
+				Details: map[string]interface{}{"user_id": session.UserID, "reason": "reuse"}})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrInvalidRefreshToken), errors.Is(err, services.ErrSessionInvalid):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to refresh session"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, sessionResponse(tokens))
+}
+
+// Logout revokes the session of the access token used for the request
+func (h *AuthHandler) Logout(c *gin.Context) {
+	sessionID, ok := getSessionID(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This endpoint requires a user session"})
+		return
+	}
+	userID, _ := getUserID(c)
+
+	if err := h.SessionService.RevokeSession(userID, sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to log out"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "auth.logout", ResourceType: "session", ResourceID: sessionID,
+		Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
 }
@@ -0,0 +1,111 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type exportQuery struct {
+	Environment string `form:"environment" binding:"required"`
+	Format      string `form:"format" binding:"required"`
+	Name        string `form:"name"`      // Kubernetes Secret name, defaults to <project>-<environment>
+	Namespace   string `form:"namespace"` // Kubernetes namespace, omitted when empty
+}
+
+// exportContentTypes are the response types of each export format, with the file extension
+// suggested for downloads
+var exportContentTypes = map[string][2]string{
+	services.ExportFormatDotenv:     {"text/plain; charset=utf-8", ".env"},
+	services.ExportFormatJSON:       {"application/json; charset=utf-8", ".json"},
+	services.ExportFormatYAML:       {"application/yaml; charset=utf-8", ".yaml"},
+	services.ExportFormatShell:      {"text/x-shellscript; charset=utf-8", ".sh"},
+	services.ExportFormatKubernetes: {"application/yaml; charset=utf-8", ".yaml"},
+	services.ExportFormatDocker:     {"text/plain; charset=utf-8", ".env"},
+}
+
+// ExportSecrets returns the decrypted secrets of one environment as a file in one of the
+// export formats. Like reading secrets, it needs a recent step-up and a working audit log.
+func (h *SecretHandler) ExportSecrets(c *gin.Context) {
+	var query exportQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	contentType, ok := exportContentTypes[query.Format]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrExportFormat.Error()})
+		return
+	}
+
+	projectID, ok := parseUintParam(c, "projectID", "project ID")
+	if !ok {
+		return
+	}
+	if _, exists := getPrincipal(c); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	environment, ok := h.authorizeEnvironment(c, projectID, query.Environment, models.RoleReader, "secret.export")
+	if !ok {
+		return
+	}
+	if !requireStepUp(h.AuditService, c, "secret.export", "environment", environment.ID, projectID) {
+		return
+	}
+	if !requireAuditSinks(h.AuditService, c) {
+		return
+	}
+
+	var project models.Project
+	if err := h.DB.Select("id", "name").First(&project, projectID).Error; err != nil {
+		respondExportError(c, err)
+		return
+	}
+	options := services.ExportOptions{Name: query.Name, Namespace: query.Namespace}
+	if options.Name == "" {
+		options.Name = services.KubernetesName(project.Name + "-" + environment.Name)
+	}
+
+	entries, err := h.SecretService.EnvironmentEntries(environment)
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+	out, err := services.ExportSecrets(query.Format, entries, options)
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+
+	err = recordAudit(h.AuditService, c, auditRecord{Action: "secret.export", ResourceType: "environment", ResourceID: environment.ID,
+		ProjectID: uintPtr(projectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"environment": environment.Name, "format": query.Format, "count": len(entries)}})
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to record secret access; secrets were not returned"})
+		return
+	}
+
+	filename := services.KubernetesName(project.Name+"-"+environment.Name) + contentType[1]
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, contentType[0], out)
+}
+
+// respondExportError maps export errors to HTTP responses
+func respondExportError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrExportFormat), errors.Is(err, services.ErrExportUnsupported):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrProjectKeyShredded), errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export secrets"})
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type importInput struct {
+	Environment string `json:"environment" binding:"required"`
+	Format      string `json:"format" binding:"required"` // "dotenv", "json" or "yaml"
+	Content     string `json:"content" binding:"required"`
+	Mode        string `json:"mode"` // "fail" (default), "skip" or "overwrite"
+	DryRun      bool   `json:"dry_run"`
+}
+
+// ImportSecrets creates or updates the secrets of a dotenv, JSON or YAML document in one
+// environment of a project, all in one transaction. A dry run reports what would change.
+func (h *SecretHandler) ImportSecrets(c *gin.Context) {
+	var input importInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Mode == "" {
+		input.Mode = services.ImportFailOnConflict
+	}
+
+	projectID, ok := parseUintParam(c, "projectID", "project ID")
+	if !ok {
+		return
+	}
+	principal, exists := getPrincipal(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	environment, ok := h.authorizeEnvironment(c, projectID, input.Environment, models.RoleWriter, "secret.import")
+	if !ok {
+		return
+	}
+
+	entries, err := services.ParseImport(input.Format, input.Content)
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	results, err := h.SecretService.Import(environment, entries, input.Mode, input.DryRun, principal)
+	if err != nil {
+		if errors.Is(err, services.ErrImportConflict) {
+			recordAudit(h.AuditService, c, auditRecord{Action: "secret.import", ResourceType: "environment", ResourceID: environment.ID,
+				ProjectID: uintPtr(projectID), Result: services.AuditFailure,
+				Details: map[string]interface{}{"environment": environment.Name, "reason": "conflict", "dry_run": input.DryRun}})
+		}
+		respondImportError(c, err)
+		return
+	}
+
+	summary := map[string]int{}
+	changed := []string{}
+	for _, result := range results {
+		summary[result.Result]++
+		if result.Result == services.ImportCreated || result.Result == services.ImportUpdated {
+			changed = append(changed, result.Key)
+		}
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "secret.import", ResourceType: "environment", ResourceID: environment.ID,
+		ProjectID: uintPtr(projectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"environment": environment.Name, "format": input.Format, "mode": input.Mode,
+			"dry_run": input.DryRun, "keys": changed}})
+
+	c.JSON(http.StatusOK, gin.H{"dry_run": input.DryRun, "summary": summary, "secrets": results})
+}
+
+// respondImportError maps import errors to HTTP responses
+func respondImportError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrImportFormat), errors.Is(err, services.ErrImportMode),
+		errors.Is(err, services.ErrImportInvalid), errors.Is(err, services.ErrImportTooLarge):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrImportConflict), errors.Is(err, services.ErrSecretKeyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrQuotaExceeded):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import secrets"})
+	}
+}
@@ -0,0 +1,277 @@
+package api
+
+import (
+	"bytes"
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const adminEmail = "admin@example.com"
+
+func TestMain(m *testing.M) {
+	// Set up test environment variables with a 32-byte key
+	os.Setenv("MASTER_ENCRYPTION_KEY", "12345678901234567890123456789012") // Exactly 32 bytes
+	os.Setenv("JWT_SECRET_KEY", "test-jwt-secret")
+	os.Setenv("DATABASE_URL", "host=localhost user=test password=test dbname=test port=5432 sslmode=disable")
+	os.Setenv("ADMIN_EMAILS", adminEmail)
+
+	// Load config
+	config.LoadConfig()
+	gin.SetMode(gin.TestMode)
+
+	// Run tests
+	code := m.Run()
+	os.Exit(code)
+}
+
+// testServer is the API router over a mocked database. Tests expect the queries a request
+// makes in order; a query nobody expected fails, so a denied request that reaches the
+// database shows up as a 500 or an unmet expectation.
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+	mock   sqlmock.Sqlmock
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create the database mock: %v", err)
+	}
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: conn}), &gorm.Config{Logger: logger.Discard, TranslateError: true})
+	if err != nil {
+		t.Fatalf("Failed to open the database: %v", err)
+	}
+
+	router := gin.New()
+	SetupRoutes(router, db, nil)
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Errorf("Database expectations not met: %v", err)
+		}
+		conn.Close()
+	})
+	return &testServer{t: t, router: router, mock: mock}
+}
+
+// request sends a request as userID, signed in with a session, and returns the response.
+// A userID of 0, or an Authorization header, sends no session.
+func (s *testServer) request(method, path string, userID uint, body interface{}, header http.Header) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var encoded []byte
+	if body != nil {
+		var err error
+		if encoded, err = json.Marshal(body); err != nil {
+			s.t.Fatalf("Failed to encode the request body: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, bytes.NewReader(encoded))
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if userID != 0 && req.Header.Get("Authorization") == "" {
+		req.Header.Set("Authorization", "Bearer "+s.accessToken(userID))
+	}
+
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// check sends a request like request and fails the test unless it is answered with want
+func (s *testServer) check(method, path string, userID uint, body interface{}, header http.Header, want int) *httptest.ResponseRecorder {
+	s.t.Helper()
+	w := s.request(method, path, userID, body, header)
+	if w.Code != want {
+		s.t.Errorf("%s %s: expected %d, got %d: %s", method, path, want, w.Code, w.Body.String())
+	}
+	return w
+}
+
+// accessToken signs a JWT for the session testSessionID(userID), like a login would
+func (s *testServer) accessToken(userID uint) string {
+	s.t.Helper()
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"sid": testSessionID(userID),
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Minute).Unix(),
+	}
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.AppConfig.JWTSecretKey)
+	if err != nil {
+		s.t.Fatalf("Failed to sign the access token: %v", err)
+	}
+	return token
+}
+
+func testSessionID(userID uint) uint {
+	return 1000 + userID
+}
+
+// expectSession expects AuthMiddleware to load the caller's session and account. stepUpAt is when the
+// session last made a WebAuthn assertion, if ever.
+func (s *testServer) expectSession(userID uint, stepUpAt *time.Time) {
+	now := time.Now()
+	s.mock.ExpectQuery(`FROM "sessions"`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "user_id", "created_at", "last_used_at", "expires_at", "step_up_at"}).
+		AddRow(testSessionID(userID), userID, now, now, now.Add(time.Hour), stepUpAt))
+	s.mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "active"}).AddRow(userID, true))
+}
+
+// requireStepUpWithin sets STEP_UP_MAX_AGE for the duration of a test
+func requireStepUpWithin(t *testing.T, maxAge time.Duration) {
+	previous := config.AppConfig.StepUpMaxAge
+	config.AppConfig.StepUpMaxAge = maxAge
+	t.Cleanup(func() { config.AppConfig.StepUpMaxAge = previous })
+}
+
+// expectAPIToken expects AuthMiddleware to authenticate a personal access token of userID
+// with write access to project 7 and load its account. It returns the Authorization header to send.
+func (s *testServer) expectAPIToken(userID uint) http.Header {
+	s.mock.ExpectQuery(`FROM "api_tokens"`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "user_id", "permission", "project_ids", "last_used_at"}).AddRow(5, userID, "write", "[7]", time.Now()))
+	s.mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "active"}).AddRow(userID, true))
+	return http.Header{"Authorization": {"Bearer " + services.APITokenPrefix + "test"}}
+}
+
+// expectUser expects the user with the given email to be looked up, e.g. by AdminMiddleware
+func (s *testServer) expectUser(userID uint, email string) {
+	s.mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "email", "active"}).AddRow(userID, email, true))
+}
+
+// expectRole expects the project role of userID to be looked up in a project outside any
+// organization. An empty role means the user is not a member.
+func (s *testServer) expectRole(projectID, userID uint, role models.Role) {
+	s.mock.ExpectQuery(`FROM "projects"`).WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id"}).AddRow(projectID, nil))
+	members := sqlmock.NewRows([]string{"id", "project_id", "user_id", "role"})
+	if role != "" {
+		members.AddRow(1, projectID, userID, role)
+	}
+	s.mock.ExpectQuery(`FROM "project_members"`).WillReturnRows(members)
+}
+
+// expectEnvironment expects an environment to be looked up
+func (s *testServer) expectEnvironment(environment *models.Environment) {
+	s.mock.ExpectQuery(`FROM "environments"`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "project_id", "name", "read_role", "write_role"}).
+		AddRow(environment.ID, environment.ProjectID, environment.Name, environment.ReadRole, environment.WriteRole))
+}
+
+// expectSecret expects a secret and then its environment to be looked up, as loadSecret does
+func (s *testServer) expectSecret(secret *models.Secret, environment *models.Environment) {
+	s.mock.ExpectQuery(`FROM "secrets"`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "key", "value", "current_version", "project_id", "environment_id"}).
+		AddRow(secret.ID, secret.Key, secret.Value, secret.CurrentVersion, secret.ProjectID, secret.EnvironmentID))
+	s.expectEnvironment(environment)
+}
+
+// staging is the environment of project 7 the secret tests read and write
+var staging = &models.Environment{ID: 3, ProjectID: 7, Name: "staging", ReadRole: models.RoleReader, WriteRole: models.RoleWriter}
+
+// expectSecretsRead expects a reader's authorized read of the staging secrets to load secrets
+// and the project's wrapped data key
+func (s *testServer) expectSecretsRead(wrapped string, secrets ...*models.Secret) {
+	s.expectSession(2, nil)
+	s.expectRole(7, 2, models.RoleReader)
+	s.expectEnvironment(staging)
+	s.expectRole(7, 2, models.RoleReader)
+
+	rows := sqlmock.NewRows([]string{"id", "key", "value", "current_version", "project_id", "environment_id"})
+	for _, secret := range secrets {
+		rows.AddRow(secret.ID, secret.Key, secret.Value, secret.CurrentVersion, secret.ProjectID, secret.EnvironmentID)
+	}
+	s.mock.ExpectQuery(`FROM "secrets"`).WillReturnRows(rows)
+	s.mock.ExpectQuery(`SELECT "id","encrypted_dek","key_shredded_at" FROM "projects"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encrypted_dek", "key_shredded_at"}).AddRow(7, wrapped, nil))
+}
+
+// testSecret returns a secret in staging without a value
+func testSecret(id uint, key string, version int) *models.Secret {
+	secret := &models.Secret{Key: key, CurrentVersion: version, ProjectID: 7, EnvironmentID: staging.ID}
+	secret.ID = id
+	return secret
+}
+
+// testDataKey generates a project data key and wraps it under the master key
+func testDataKey(t *testing.T) ([]byte, string) {
+	t.Helper()
+	dek, err := services.GenerateDataKey()
+	if err != nil {
+		t.Fatalf("Failed to generate a data key: %v", err)
+	}
+	wrapped, err := services.WrapDataKey(dek)
+	if err != nil {
+		t.Fatalf("Failed to wrap the data key: %v", err)
+	}
+	return dek, wrapped
+}
+
+// sealTestValue encrypts a secret value the way SecretService stores it, bound to its project,
+// environment, secret, key and version
+func sealTestValue(t *testing.T, dek []byte, secret *models.Secret, plaintext string) string {
+	t.Helper()
+	aad := fmt.Sprintf("ciphersafe/secret/v2|project=%d|environment=%d|secret=%d|key=%q|version=%d",
+		secret.ProjectID, secret.EnvironmentID, secret.ID, secret.Key, secret.CurrentVersion)
+	ciphertext, err := services.EncryptWithKey(dek, []byte(plaintext), []byte(aad))
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	return "b2:" + ciphertext
+}
+
+// expectAudit expects an audit event with action and result to be appended to the chain
+func (s *testServer) expectAudit(action, result string) {
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectQuery(`FROM "audit_events"`).WillReturnRows(sqlmock.NewRows([]string{"seq", "hash"}))
+	s.mock.ExpectQuery(`INSERT INTO "audit_events"`).WithArgs(auditArgs(action, result)...).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectCommit()
+}
+
+// expectAuditFailure expects an audit event to be attempted while the audit log is unavailable
+func (s *testServer) expectAuditFailure() {
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`pg_advisory_xact_lock`).WillReturnError(fmt.Errorf("connection reset"))
+	s.mock.ExpectRollback()
+}
+
+// auditArgs matches the values of an audit event insert, checking its action and result.
+// The columns follow models.AuditEvent: created_at, seq, actor_id, actor_type, action,
+// resource_type, resource_id, project_id, ip, user_agent, result, details, prev_hash and hash.
+func auditArgs(action, result string) []driver.Value {
+	args := make([]driver.Value, 14)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[4] = action
+	args[10] = result
+	return args
+}
+
+// decodeBody decodes a JSON response body
+func decodeBody(t *testing.T, w *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+		t.Fatalf("Expected a JSON response, got %q: %v", w.Body.String(), err)
+	}
+}
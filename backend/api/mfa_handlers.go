@@ -0,0 +1,329 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type MFAHandler struct {
+	MFAService     *services.MFAService
+	SessionService *services.SessionService
+	UserService    *services.UserService
+	Throttle       *services.LoginThrottle
+	AuditService   *services.AuditService
+}
+
+func NewMFAHandler(mfaService *services.MFAService, sessionService *services.SessionService, userService *services.UserService, throttle *services.LoginThrottle, auditService *services.AuditService) *MFAHandler {
+	return &MFAHandler{MFAService: mfaService, SessionService: sessionService, UserService: userService, Throttle: throttle, AuditService: auditService}
+}
+
+type mfaCodeInput struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type mfaLoginInput struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	Code     string `json:"code" binding:"required"` // TOTP code or recovery code
+}
+
+type mfaTokenInput struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+}
+
+// VerifyLogin finishes a login with a TOTP or recovery code and starts the session
+func (h *MFAHandler) VerifyLogin(c *gin.Context) {
+	var input mfaLoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.pendingUser(c, input.MFAToken)
+	if !ok {
+		return
+	}
+
+	// Codes are throttled with the account's password attempts, so a stolen password does not
+	// allow guessing them
+	attempt, err := h.Throttle.Attempt(user.Email)
+	if !h.checkThrottle(c, user, err) {
+		return
+	}
+
+	method, err := h.MFAService.VerifySecondFactor(user, input.Code)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidMFACode) {
+			// The caller is not authenticated, so the event stays anonymous and targets the account
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: user.ID,
+				Result: services.AuditFailure, Details: map[string]interface{}{"email": user.Email, "reason": "mfa"}})
+			if !h.checkThrottle(c, user, attempt.Fail()) {
+				return
+			}
+		} else if releaseErr := attempt.Release(); releaseErr != nil {
+			log.Printf("Failed to release the sign-in attempt of user %d: %v", user.ID, releaseErr)
+		}
+		respondMFAError(c, err)
+		return
+	}
+
+	h.startSession(c, user, method, nil)
+}
+
+// BeginLoginEnrollment starts TOTP enrollment for a user whose organization requires MFA
+// but who has not set it up yet, in the middle of their login
+func (h *MFAHandler) BeginLoginEnrollment(c *gin.Context) {
+	var input mfaTokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.pendingUser(c, input.MFAToken)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.MFAService.BeginTOTPEnrollment(user)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmLoginEnrollment enables TOTP with the first code from the app, then starts the
+// session. The response carries the recovery codes, which are not shown again.
+func (h *MFAHandler) ConfirmLoginEnrollment(c *gin.Context) {
+	var input mfaLoginInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.pendingUser(c, input.MFAToken)
+	if !ok {
+		return
+	}
+
+	codes, err := h.MFAService.ConfirmTOTPEnrollment(user, input.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "mfa.totp.enable", ResourceType: "user", ResourceID: user.ID,
+		ActorID: uintPtr(user.ID), Result: services.AuditSuccess})
+
+	h.startSession(c, user, services.MFAMethodTOTP, codes)
+}
+
+// GetMFAStatus returns the authenticated user's second factor status
+func (h *MFAHandler) GetMFAStatus(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	status, err := h.MFAService.Status(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve MFA status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// BeginEnrollment returns a new TOTP secret and its provisioning URI
+func (h *MFAHandler) BeginEnrollment(c *gin.Context) {
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	enrollment, err := h.MFAService.BeginTOTPEnrollment(user)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, enrollment)
+}
+
+// ConfirmEnrollment enables TOTP and returns the recovery codes, which are not shown again
+func (h *MFAHandler) ConfirmEnrollment(c *gin.Context) {
+	var input mfaCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.MFAService.ConfirmTOTPEnrollment(user, input.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "mfa.totp.enable", ResourceType: "user", ResourceID: user.ID,
+		Result: services.AuditSuccess})
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTOTP turns two-factor authentication off after checking a code
+func (h *MFAHandler) DisableTOTP(c *gin.Context) {
+	var input mfaCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.MFAService.DisableTOTP(user, input.Code); err != nil {
+		if errors.Is(err, services.ErrMFARequired) || errors.Is(err, services.ErrInvalidMFACode) {
+			recordAudit(h.AuditService, c, auditRecord{Action: "mfa.totp.disable", ResourceType: "user", ResourceID: user.ID,
+				Result: services.AuditDenied})
+		}
+		respondMFAError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "mfa.totp.disable", ResourceType: "user", ResourceID: user.ID,
+		Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes after checking a TOTP code
+func (h *MFAHandler) RegenerateRecoveryCodes(c *gin.Context) {
+	var input mfaCodeInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.currentUser(c)
+	if !ok {
+		return
+	}
+
+	codes, err := h.MFAService.RegenerateRecoveryCodes(user, input.Code)
+	if err != nil {
+		respondMFAError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "mfa.recovery_codes.regenerate", ResourceType: "user",
+		ResourceID: user.ID, Result: services.AuditSuccess})
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// checkThrottle writes the response for a throttle error and returns false, or returns true
+// if err is nil
+func (h *MFAHandler) checkThrottle(c *gin.Context, user *models.User, err error) bool {
+	if err == nil {
+		return true
+	}
+	var throttled *services.LoginThrottledError
+	if errors.As(err, &throttled) {
+		recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: user.ID,
+			Result: services.AuditDenied, Details: map[string]interface{}{"email": user.Email, "reason": throttleReason(throttled)}})
+		respondThrottled(c, throttled)
+		return false
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+	return false
+}
+
+// startSession completes a login that passed its second factor
+func (h *MFAHandler) startSession(c *gin.Context, user *models.User, method string, recoveryCodes []string) {
+	tokens, err := h.SessionService.CreateSession(user.ID, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if errors.Is(err, services.ErrUserDeactivated) {
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: user.ID,
+				Result: services.AuditDenied, Details: map[string]interface{}{"mfa": method, "reason": err.Error()}})
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+
+	if err := h.Throttle.RecordSuccess(user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: user.ID,
+		ActorID: uintPtr(user.ID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"session_id": tokens.Session.ID, "mfa": method}})
+
+	response := sessionResponse(tokens)
+	if recoveryCodes != nil {
+		response["recovery_codes"] = recoveryCodes
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// pendingUser resolves the user of an MFA token from the password step of a login
+func (h *MFAHandler) pendingUser(c *gin.Context, mfaToken string) (*models.User, bool) {
+	userID, err := services.ParseMFAToken(mfaToken)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	user, err := h.UserService.FindUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": services.ErrInvalidMFAToken.Error()})
+		return nil, false
+	}
+	return user, true
+}
+
+// currentUser loads the authenticated user
+func (h *MFAHandler) currentUser(c *gin.Context) (*models.User, bool) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	user, err := h.UserService.FindUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	return user, true
+}
+
+// respondMFAError maps MFA service errors to HTTP responses
+func respondMFAError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrInvalidMFACode):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFAAlreadyEnabled):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFANotEnabled), errors.Is(err, services.ErrMFANotEnrolling):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrMFARequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Two-factor authentication failed"})
+	}
+}
@@ -2,16 +2,25 @@ package api
 
 import (
 	"ciphersafe/config"
+	"ciphersafe/models"
+	"ciphersafe/services"
 	"errors"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
 )
 
-// AuthMiddleware creates a gin.HandlerFunc for JWT authentication
-func AuthMiddleware() gin.HandlerFunc {
+// AuthMiddleware creates a gin.HandlerFunc for JWT, API token and service account key authentication.
+// API token requests act as the token's user, narrowed to the token's projects and permission.
+// JWTs are only accepted while their session is active, so signing out revokes them.
+// Every request gets a principal; only user requests (JWT or API token) get a userID.
+// Deactivated users are refused with either credential.
+func AuthMiddleware(tokenService *services.TokenService, serviceAccountService *services.ServiceAccountService, sessionService *services.SessionService, userService *services.UserService) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -25,6 +34,44 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
+		if services.IsAPIToken(tokenString) {
+			apiToken, err := tokenService.Authenticate(tokenString)
+			if err != nil {
+				if errors.Is(err, services.ErrInvalidAPIToken) {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify API token"})
+				return
+			}
+			if !requireActiveUser(c, userService, apiToken.UserID) {
+				return
+			}
+
+			c.Set("userID", apiToken.UserID)
+			c.Set("principal", services.UserPrincipal(apiToken.UserID))
+			c.Set("apiToken", apiToken)
+			c.Next()
+			return
+		}
+
+		if services.IsServiceAccountKey(tokenString) {
+			account, key, err := serviceAccountService.Authenticate(tokenString)
+			if err != nil {
+				if errors.Is(err, services.ErrInvalidServiceAccountKey) {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify service account key"})
+				return
+			}
+
+			c.Set("principal", services.ServiceAccountPrincipal(account.ID))
+			c.Set("serviceAccountKey", key)
+			c.Next()
+			return
+		}
+
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 			// Check the signing method
 			if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
@@ -41,13 +88,30 @@ func AuthMiddleware() gin.HandlerFunc {
 		if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
 			// Extract user ID from the 'sub' (subject) claim
 			userIDFloat, ok := claims["sub"].(float64) // JWT encodes numbers as float64
-			if !ok {
+			sessionIDFloat, hasSession := claims["sid"].(float64)
+			if !ok || !hasSession {
 				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token claims"})
 				return
 			}
 
+			session, err := sessionService.ValidateSession(uint(sessionIDFloat), uint(userIDFloat))
+			if err != nil {
+				if errors.Is(err, services.ErrSessionInvalid) {
+					c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+					return
+				}
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify session"})
+				return
+			}
+			if !requireActiveUser(c, userService, uint(userIDFloat)) {
+				return
+			}
+
 			// Store the user ID in the context for handlers to use
 			c.Set("userID", uint(userIDFloat))
+			c.Set("principal", services.UserPrincipal(uint(userIDFloat)))
+			c.Set("sessionID", session.ID)
+			c.Set("session", session)
 			c.Next()
 		} else {
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
@@ -55,6 +119,177 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
+// requireActiveUser aborts with 401 and returns false if the user's account is deactivated
+func requireActiveUser(c *gin.Context, userService *services.UserService, userID uint) bool {
+	err := services.EnsureUserActive(userService.DB, userID)
+	switch {
+	case errors.Is(err, services.ErrUserDeactivated):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		return false
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return false
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify user"})
+		return false
+	}
+	return true
+}
+
+// UserSessionMiddleware refuses API tokens and service accounts on routes that manage accounts,
+// organizations, memberships or the server. Automation is limited to reading and writing secrets.
+func UserSessionMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, _ := getPrincipal(c)
+		if _, isToken := getAPIToken(c); isToken || !principal.IsUser() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "This endpoint requires a user session"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// AdminMiddleware restricts a route group to users listed in ADMIN_EMAILS.
+// It must run after AuthMiddleware.
+func AdminMiddleware(userService *services.UserService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := getUserID(c)
+		if !exists {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+
+		user, err := userService.FindUserByID(userID)
+		if err != nil || !isAdminEmail(user.Email) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Admin access required"})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+func isAdminEmail(email string) bool {
+	for _, admin := range config.AppConfig.AdminEmails {
+		if strings.EqualFold(admin, email) {
+			return true
+		}
+	}
+	return false
+}
+
+// SCIMMiddleware authenticates the identity provider on the SCIM endpoint with SCIM_TOKEN.
+// The endpoint does not exist while no token is configured.
+func SCIMMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !config.AppConfig.SCIMEnabled() {
+			scimError(c, http.StatusNotFound, "", "SCIM provisioning is not enabled")
+			return
+		}
+
+		authHeader := c.GetHeader("Authorization")
+		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+		if tokenString == authHeader || !services.CheckSCIMToken(tokenString, config.AppConfig.SCIMToken) {
+			scimError(c, http.StatusUnauthorized, "", "Invalid SCIM token")
+			return
+		}
+
+		c.Set("scim", true)
+		c.Next()
+	}
+}
+
+// RateLimitMiddleware limits requests per client IP, answering 429 with Retry-After
+func RateLimitMiddleware(limiter *services.RateLimiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if allowed, retryAfter := limiter.Allow(c.ClientIP()); !allowed {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests; try again later"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// SealMiddleware refuses requests with 503 while the server is sealed
+func SealMiddleware(sealService *services.SealService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sealService.IsSealed() {
+			c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": "Server is sealed"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// getAPIToken returns the API token the request authenticated with, if any
+func getAPIToken(c *gin.Context) (*models.APIToken, bool) {
+	token, exists := c.Get("apiToken")
+	if !exists {
+		return nil, false
+	}
+	return token.(*models.APIToken), true
+}
+
+// getServiceAccountKey returns the service account key the request authenticated with, if any
+func getServiceAccountKey(c *gin.Context) (*models.ServiceAccountKey, bool) {
+	key, exists := c.Get("serviceAccountKey")
+	if !exists {
+		return nil, false
+	}
+	return key.(*models.ServiceAccountKey), true
+}
+
+// getSessionID returns the session of a JWT-authenticated request
+func getSessionID(c *gin.Context) (uint, bool) {
+	sessionID, exists := c.Get("sessionID")
+	if !exists {
+		return 0, false
+	}
+	return sessionID.(uint), true
+}
+
+// getSession returns the session of a JWT-authenticated request as loaded by AuthMiddleware
+func getSession(c *gin.Context) (*models.Session, bool) {
+	session, exists := c.Get("session")
+	if !exists {
+		return nil, false
+	}
+	return session.(*models.Session), true
+}
+
+// isSCIMRequest reports whether the request came from the identity provider's SCIM client
+func isSCIMRequest(c *gin.Context) bool {
+	return c.GetBool("scim")
+}
+
+// getPrincipal returns the authenticated caller, user or service account
+func getPrincipal(c *gin.Context) (services.Principal, bool) {
+	principal, exists := c.Get("principal")
+	if !exists {
+		return services.Principal{}, false
+	}
+	return principal.(services.Principal), true
+}
+
+// callerProjectRole returns the caller's effective role on a project,
+// narrowed to the API token's scope for token requests
+func callerProjectRole(c *gin.Context, db *gorm.DB, projectID uint) (models.Role, error) {
+	principal, exists := getPrincipal(c)
+	if !exists {
+		return "", nil
+	}
+
+	role, err := services.PrincipalProjectRole(db, principal, projectID)
+	if err != nil {
+		return "", err
+	}
+	if token, isToken := getAPIToken(c); isToken {
+		return token.ScopeRole(projectID, role), nil
+	}
+	return role, nil
+}
+
 // Helper to get user ID from context
 func getUserID(c *gin.Context) (uint, bool) {
 	userID, exists := c.Get("userID")
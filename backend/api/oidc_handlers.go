@@ -0,0 +1,122 @@
+package api
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OIDCHandler struct {
+	OIDCService    *services.OIDCService
+	SessionService *services.SessionService
+	AuditService   *services.AuditService
+}
+
+func NewOIDCHandler(oidcService *services.OIDCService, sessionService *services.SessionService, auditService *services.AuditService) *OIDCHandler {
+	return &OIDCHandler{OIDCService: oidcService, SessionService: sessionService, AuditService: auditService}
+}
+
+// Login sends the browser to the identity provider
+func (h *OIDCHandler) Login(c *gin.Context) {
+	target, err := h.OIDCService.BeginLogin()
+	if err != nil {
+		if errors.Is(err, services.ErrOIDCDisabled) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to reach the identity provider"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, target)
+}
+
+// Callback finishes a single sign-on login and hands the result to the frontend in the URL
+// fragment of OIDC_POST_LOGIN_URL, which browsers do not send to servers. The fragment holds
+// the same fields as the /auth/login response, or "error".
+func (h *OIDCHandler) Callback(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		h.redirect(c, gin.H{"error": "The identity provider refused the sign-in: " + providerError})
+		return
+	}
+
+	user, created, err := h.OIDCService.FinishLogin(c.Query("state"), c.Query("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrOIDCVerification), errors.Is(err, services.ErrOIDCState),
+			errors.Is(err, services.ErrOIDCEmailMissing), errors.Is(err, services.ErrOIDCEmailInUse):
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", Result: services.AuditFailure,
+				Details: map[string]interface{}{"method": models.AuthMethodOIDC, "reason": err.Error()}})
+			h.redirect(c, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrUserDeactivated):
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", Result: services.AuditDenied,
+				Details: map[string]interface{}{"method": models.AuthMethodOIDC, "reason": err.Error()}})
+			h.redirect(c, gin.H{"error": err.Error()})
+		default:
+			h.redirect(c, gin.H{"error": "Single sign-on failed"})
+		}
+		return
+	}
+
+	if created {
+		recordAudit(h.AuditService, c, auditRecord{Action: "auth.register", ResourceType: "user", ResourceID: user.ID,
+			ActorID: uintPtr(user.ID), Result: services.AuditSuccess,
+			Details: map[string]interface{}{"email": user.Email, "method": models.AuthMethodOIDC}})
+	}
+
+	db := h.OIDCService.DB
+	if err := services.CheckAuthMethod(db, user.ID, models.AuthMethodOIDC); err != nil {
+		if errors.Is(err, services.ErrAuthMethodDenied) {
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: user.ID,
+				Result: services.AuditDenied, Details: map[string]interface{}{"method": models.AuthMethodOIDC}})
+			h.redirect(c, gin.H{"error": err.Error()})
+			return
+		}
+		h.redirect(c, gin.H{"error": "Login failed"})
+		return
+	}
+
+	// Two-factor settings apply as they do to password logins
+	required, err := services.MFARequired(db, user.ID)
+	if err != nil {
+		h.redirect(c, gin.H{"error": "Login failed"})
+		return
+	}
+	if user.TOTPEnabled || required {
+		mfaToken, err := services.GenerateMFAToken(user.ID)
+		if err != nil {
+			h.redirect(c, gin.H{"error": "Login failed"})
+			return
+		}
+		h.redirect(c, gin.H{"mfa_required": true, "mfa_token": mfaToken, "mfa_enrollment_required": !user.TOTPEnabled})
+		return
+	}
+
+	tokens, err := h.SessionService.CreateSession(user.ID, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		h.redirect(c, gin.H{"error": "Login failed"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: user.ID,
+		ActorID: uintPtr(user.ID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"session_id": tokens.Session.ID, "method": models.AuthMethodOIDC}})
+
+	h.redirect(c, sessionResponse(tokens))
+}
+
+// redirect sends the browser to the frontend with fields in the URL fragment
+func (h *OIDCHandler) redirect(c *gin.Context, fields gin.H) {
+	fragment := url.Values{}
+	for key, value := range fields {
+		fragment.Set(key, fmt.Sprint(value))
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Redirect(http.StatusFound, config.AppConfig.OIDCPostLoginURL+"#"+fragment.Encode())
+}
@@ -0,0 +1,295 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type OrganizationHandler struct {
+	DB                  *gorm.DB
+	OrganizationService *services.OrganizationService
+	AuditService        *services.AuditService
+}
+
+func NewOrganizationHandler(db *gorm.DB, organizationService *services.OrganizationService, auditService *services.AuditService) *OrganizationHandler {
+	return &OrganizationHandler{DB: db, OrganizationService: organizationService, AuditService: auditService}
+}
+
+type organizationInput struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type organizationSettingsInput struct {
+	PasswordMinLength  int      `json:"password_min_length" binding:"min=0,max=128"`
+	AllowedAuthMethods []string `json:"allowed_auth_methods"`
+	MaxProjects        int      `json:"max_projects" binding:"min=0"`
+	MaxSecrets         int      `json:"max_secrets" binding:"min=0"`
+	RequireMFA         bool     `json:"require_mfa"`
+}
+
+type orgMemberInput struct {
+	Email string         `json:"email" binding:"required,email"`
+	Role  models.OrgRole `json:"role" binding:"required"`
+}
+
+type orgMemberRoleInput struct {
+	Role models.OrgRole `json:"role" binding:"required"`
+}
+
+// CreateOrganization creates an organization owned by the authenticated user
+func (h *OrganizationHandler) CreateOrganization(c *gin.Context) {
+	var input organizationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	org, err := h.OrganizationService.CreateOrganization(input.Name, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create organization"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "org.create", ResourceType: "organization", ResourceID: org.ID,
+		Result: services.AuditSuccess, Details: map[string]interface{}{"name": org.Name}})
+
+	c.JSON(http.StatusCreated, org)
+}
+
+// GetOrganizations lists the organizations the authenticated user belongs to
+func (h *OrganizationHandler) GetOrganizations(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	orgs, err := h.OrganizationService.ListOrganizations(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve organizations"})
+		return
+	}
+
+	c.JSON(http.StatusOK, orgs)
+}
+
+// GetOrganization returns a single organization with its settings
+func (h *OrganizationHandler) GetOrganization(c *gin.Context) {
+	orgID, role, ok := h.authorizeOrg(c, models.OrgRoleMember, "org.read")
+	if !ok {
+		return
+	}
+
+	org, err := h.OrganizationService.GetOrganization(orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve organization"})
+		return
+	}
+
+	org.Role = role
+	c.JSON(http.StatusOK, org)
+}
+
+// UpdateSettings replaces an organization's policies and quotas
+func (h *OrganizationHandler) UpdateSettings(c *gin.Context) {
+	var input organizationSettingsInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, method := range input.AllowedAuthMethods {
+		if !isKnownAuthMethod(method) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown auth method: " + method})
+			return
+		}
+	}
+
+	orgID, _, ok := h.authorizeOrg(c, models.OrgRoleAdmin, "org.settings.update")
+	if !ok {
+		return
+	}
+
+	org, err := h.OrganizationService.UpdateSettings(orgID, models.OrganizationSettings{
+		PasswordMinLength:  input.PasswordMinLength,
+		AllowedAuthMethods: input.AllowedAuthMethods,
+		MaxProjects:        input.MaxProjects,
+		MaxSecrets:         input.MaxSecrets,
+		RequireMFA:         input.RequireMFA,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update settings"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "org.settings.update", ResourceType: "organization", ResourceID: orgID,
+		Result: services.AuditSuccess, Details: map[string]interface{}{"settings": org.Settings}})
+
+	c.JSON(http.StatusOK, org)
+}
+
+// ListMembers lists the members of an organization
+func (h *OrganizationHandler) ListMembers(c *gin.Context) {
+	orgID, _, ok := h.authorizeOrg(c, models.OrgRoleMember, "org.member.list")
+	if !ok {
+		return
+	}
+
+	members, err := h.OrganizationService.ListMembers(orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// AddMember adds an existing user to an organization with a role
+func (h *OrganizationHandler) AddMember(c *gin.Context) {
+	var input orgMemberInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgID, actorRole, ok := h.authorizeOrg(c, models.OrgRoleAdmin, "org.member.add")
+	if !ok {
+		return
+	}
+
+	member, err := h.OrganizationService.AddMember(orgID, input.Email, input.Role, actorRole)
+	if err != nil {
+		respondOrgMembershipError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "org.member.add", ResourceType: "user", ResourceID: member.UserID,
+		Result: services.AuditSuccess, Details: map[string]interface{}{"organization_id": orgID, "role": member.Role}})
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// UpdateMember changes the role of an organization member
+func (h *OrganizationHandler) UpdateMember(c *gin.Context) {
+	var input orgMemberRoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orgID, actorRole, ok := h.authorizeOrg(c, models.OrgRoleAdmin, "org.member.update")
+	if !ok {
+		return
+	}
+
+	memberUserID, ok := parseUintParam(c, "userID", "user ID")
+	if !ok {
+		return
+	}
+
+	member, err := h.OrganizationService.UpdateMemberRole(orgID, memberUserID, input.Role, actorRole)
+	if err != nil {
+		respondOrgMembershipError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "org.member.update", ResourceType: "user", ResourceID: memberUserID,
+		Result: services.AuditSuccess, Details: map[string]interface{}{"organization_id": orgID, "role": member.Role}})
+
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember removes a user from an organization. Admins can remove others; anyone can leave.
+func (h *OrganizationHandler) RemoveMember(c *gin.Context) {
+	memberUserID, ok := parseUintParam(c, "userID", "user ID")
+	if !ok {
+		return
+	}
+
+	userID, _ := getUserID(c)
+	minRole := models.OrgRoleAdmin
+	if memberUserID == userID {
+		minRole = models.OrgRoleMember
+	}
+
+	orgID, actorRole, ok := h.authorizeOrg(c, minRole, "org.member.remove")
+	if !ok {
+		return
+	}
+
+	if err := h.OrganizationService.RemoveMember(orgID, memberUserID, actorRole); err != nil {
+		respondOrgMembershipError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "org.member.remove", ResourceType: "user", ResourceID: memberUserID,
+		Result: services.AuditSuccess, Details: map[string]interface{}{"organization_id": orgID}})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// authorizeOrg parses :orgID and checks the caller has at least minRole on it.
+// It returns the caller's role; on failure it writes the error response and returns false.
+// Permission denials are audited under action.
+func (h *OrganizationHandler) authorizeOrg(c *gin.Context, minRole models.OrgRole, action string) (uint, models.OrgRole, bool) {
+	orgID, ok := parseUintParam(c, "orgID", "organization ID")
+	if !ok {
+		return 0, "", false
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return 0, "", false
+	}
+
+	role, err := services.OrgRole(h.DB, userID, orgID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, "", false
+	}
+	if !role.AtLeast(minRole) {
+		recordAudit(h.AuditService, c, auditRecord{Action: action, ResourceType: "organization", ResourceID: orgID,
+			Result: services.AuditDenied})
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission for this organization"})
+		return 0, "", false
+	}
+
+	return orgID, role, true
+}
+
+// respondOrgMembershipError maps organization membership errors to HTTP responses
+func respondOrgMembershipError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrOrgMemberExists), errors.Is(err, services.ErrLastOrgOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrOrgOwnerRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update members"})
+	}
+}
+
+func isKnownAuthMethod(method string) bool {
+	for _, known := range models.KnownAuthMethods {
+		if known == method {
+			return true
+		}
+	}
+	return false
+}
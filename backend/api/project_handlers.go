@@ -2,22 +2,50 @@ package api
 
 import (
 	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type ProjectHandler struct {
-	DB *gorm.DB
+	DB                    *gorm.DB
+	MembershipService     *services.MembershipService
+	ServiceAccountService *services.ServiceAccountService
+	EnvironmentService    *services.EnvironmentService
+	AuditService          *services.AuditService
 }
 
-func NewProjectHandler(db *gorm.DB) *ProjectHandler {
-	return &ProjectHandler{DB: db}
+func NewProjectHandler(db *gorm.DB, membershipService *services.MembershipService, serviceAccountService *services.ServiceAccountService, environmentService *services.EnvironmentService, auditService *services.AuditService) *ProjectHandler {
+	return &ProjectHandler{DB: db, MembershipService: membershipService, ServiceAccountService: serviceAccountService,
+		EnvironmentService: environmentService, AuditService: auditService}
 }
 
 type projectInput struct {
-	Name string `json:"name" binding:"required"`
+	Name           string `json:"name" binding:"required"`
+	OrganizationID *uint  `json:"organization_id"` // Omit for a personal project
+}
+
+type memberInput struct {
+	Email string      `json:"email" binding:"required,email"`
+	Role  models.Role `json:"role" binding:"required"`
+}
+
+type memberRoleInput struct {
+	Role models.Role `json:"role" binding:"required"`
+}
+
+type serviceAccountGrantInput struct {
+	Role models.Role `json:"role" binding:"required"`
+}
+
+type environmentInput struct {
+	Name      string      `json:"name" binding:"required"`
+	ReadRole  models.Role `json:"read_role"`  // Defaults to reader
+	WriteRole models.Role `json:"write_role"` // Defaults to writer
 }
 
 // CreateProject handles creation of a new project
@@ -34,33 +62,483 @@ func (h *ProjectHandler) CreateProject(c *gin.Context) {
 		return
 	}
 
+	// Any organization member may create projects in it, within the organization's quota
+	if input.OrganizationID != nil {
+		orgRole, err := services.OrgRole(h.DB, userID, *input.OrganizationID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !orgRole.Valid() {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You are not a member of this organization"})
+			return
+		}
+	}
+
+	// Every project gets its own data encryption key, wrapped by the master key
+	wrappedDEK, err := services.NewWrappedDataKey()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create project key"})
+		return
+	}
+
 	project := models.Project{
-		Name:    input.Name,
-		OwnerID: userID,
+		Name:           input.Name,
+		OwnerID:        userID,
+		OrganizationID: input.OrganizationID,
+		EncryptedDEK:   wrappedDEK,
 	}
 
-	if err := h.DB.Create(&project).Error; err != nil {
+	// The quota is checked under the organization's row lock so concurrent creates can't both
+	// take the last slot. The creator becomes the project's first owner.
+	err = h.DB.Transaction(func(tx *gorm.DB) error {
+		if project.OrganizationID != nil {
+			if err := services.CheckProjectQuota(tx, *project.OrganizationID); err != nil {
+				return err
+			}
+		}
+		if err := tx.Create(&project).Error; err != nil {
+			return err
+		}
+		if err := services.CreateDefaultEnvironments(tx, project.ID); err != nil {
+			return err
+		}
+		return tx.Create(&models.ProjectMember{ProjectID: project.ID, UserID: userID, Role: models.RoleOwner}).Error
+	})
+	if errors.Is(err, services.ErrQuotaExceeded) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create project"})
 		return
 	}
 
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.create", ResourceType: "project", ResourceID: project.ID,
+		ProjectID: uintPtr(project.ID), Result: services.AuditSuccess, Details: map[string]interface{}{"name": project.Name}})
+
+	project.Role = models.RoleOwner
 	c.JSON(http.StatusCreated, project)
 }
 
-// GetProjects lists all projects for the authenticated user
+// GetProjects lists all projects the authenticated user can access, across personal
+// projects and every organization they belong to. ?organization_id= filters by organization.
+// Service accounts get the projects they have been granted.
 func (h *ProjectHandler) GetProjects(c *gin.Context) {
+	if principal, _ := getPrincipal(c); principal.Kind == services.PrincipalServiceAccount {
+		projects, err := h.ServiceAccountService.ProjectsForServiceAccount(principal.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve projects"})
+			return
+		}
+		c.JSON(http.StatusOK, projects)
+		return
+	}
+
 	userID, exists := getUserID(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	var projects []models.Project
-	// Eager load secrets to show them
-	if err := h.DB.Preload("Secrets").Where("owner_id = ?", userID).Find(&projects).Error; err != nil {
+	var orgID *uint
+	if orgIDStr := c.Query("organization_id"); orgIDStr != "" {
+		id, err := strconv.ParseUint(orgIDStr, 10, 32)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization ID"})
+			return
+		}
+		value := uint(id)
+		orgID = &value
+	}
+
+	projects, err := h.MembershipService.ProjectsForUser(userID, orgID)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve projects"})
 		return
 	}
 
+	// API tokens only see the projects they are scoped to
+	if token, isToken := getAPIToken(c); isToken {
+		scoped := projects[:0]
+		for _, project := range projects {
+			if project.Role = token.ScopeRole(project.ID, project.Role); project.Role != "" {
+				scoped = append(scoped, project)
+			}
+		}
+		projects = scoped
+	}
+
 	c.JSON(http.StatusOK, projects)
 }
+
+// DeleteProject deletes a project and crypto-shreds its data encryption key,
+// making every secret it held permanently unrecoverable
+func (h *ProjectHandler) DeleteProject(c *gin.Context) {
+	projectID, _, ok := h.authorizeProject(c, models.RoleOwner, "project.delete")
+	if !ok {
+		return
+	}
+
+	if err := services.ShredProjectKey(h.DB, projectID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete project"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.delete", ResourceType: "project", ResourceID: projectID,
+		ProjectID: uintPtr(projectID), Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// ListMembers lists the members of a project and their roles
+func (h *ProjectHandler) ListMembers(c *gin.Context) {
+	projectID, _, ok := h.authorizeProject(c, models.RoleReader, "project.member.list")
+	if !ok {
+		return
+	}
+
+	members, err := h.MembershipService.ListMembers(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve members"})
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// AddMember invites an existing user to a project with a role
+func (h *ProjectHandler) AddMember(c *gin.Context) {
+	var input memberInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	projectID, actorRole, ok := h.authorizeProject(c, models.RoleAdmin, "project.member.add")
+	if !ok {
+		return
+	}
+
+	member, err := h.MembershipService.AddMember(projectID, input.Email, input.Role, actorRole)
+	if err != nil {
+		respondMembershipError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.member.add", ResourceType: "user", ResourceID: member.UserID,
+		ProjectID: uintPtr(projectID), Result: services.AuditSuccess, Details: map[string]interface{}{"role": member.Role}})
+
+	c.JSON(http.StatusCreated, member)
+}
+
+// UpdateMember changes the role of a project member
+func (h *ProjectHandler) UpdateMember(c *gin.Context) {
+	var input memberRoleInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	projectID, actorRole, ok := h.authorizeProject(c, models.RoleAdmin, "project.member.update")
+	if !ok {
+		return
+	}
+
+	memberUserID, ok := parseUintParam(c, "userID", "user ID")
+	if !ok {
+		return
+	}
+
+	member, err := h.MembershipService.UpdateMemberRole(projectID, memberUserID, input.Role, actorRole)
+	if err != nil {
+		respondMembershipError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.member.update", ResourceType: "user", ResourceID: memberUserID,
+		ProjectID: uintPtr(projectID), Result: services.AuditSuccess, Details: map[string]interface{}{"role": member.Role}})
+
+	c.JSON(http.StatusOK, member)
+}
+
+// RemoveMember revokes a member's access. Admins can remove others; anyone can leave.
+func (h *ProjectHandler) RemoveMember(c *gin.Context) {
+	memberUserID, ok := parseUintParam(c, "userID", "user ID")
+	if !ok {
+		return
+	}
+
+	userID, _ := getUserID(c)
+	minRole := models.RoleAdmin
+	if memberUserID == userID {
+		minRole = models.RoleReader
+	}
+
+	projectID, actorRole, ok := h.authorizeProject(c, minRole, "project.member.remove")
+	if !ok {
+		return
+	}
+
+	if err := h.MembershipService.RemoveMember(projectID, memberUserID, actorRole); err != nil {
+		respondMembershipError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.member.remove", ResourceType: "user", ResourceID: memberUserID,
+		ProjectID: uintPtr(projectID), Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// ListServiceAccountGrants lists the service accounts with access to a project
+func (h *ProjectHandler) ListServiceAccountGrants(c *gin.Context) {
+	projectID, _, ok := h.authorizeProject(c, models.RoleReader, "project.service_account.list")
+	if !ok {
+		return
+	}
+
+	grants, err := h.ServiceAccountService.ListGrants(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve service accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, grants)
+}
+
+// GrantServiceAccount gives a service account of this project (or its organization) a role on it
+func (h *ProjectHandler) GrantServiceAccount(c *gin.Context) {
+	var input serviceAccountGrantInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	projectID, _, ok := h.authorizeProject(c, models.RoleAdmin, "project.service_account.grant")
+	if !ok {
+		return
+	}
+
+	serviceAccountID, ok := parseUintParam(c, "serviceAccountID", "service account ID")
+	if !ok {
+		return
+	}
+
+	grant, err := h.ServiceAccountService.GrantRole(projectID, serviceAccountID, input.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+		case errors.Is(err, services.ErrServiceAccountRole):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrServiceAccountScope):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant access"})
+		}
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.service_account.grant", ResourceType: "service_account",
+		ResourceID: serviceAccountID, ProjectID: uintPtr(projectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"role": grant.Role}})
+
+	c.JSON(http.StatusOK, grant)
+}
+
+// RevokeServiceAccount removes a service account's access to a project
+func (h *ProjectHandler) RevokeServiceAccount(c *gin.Context) {
+	projectID, _, ok := h.authorizeProject(c, models.RoleAdmin, "project.service_account.revoke")
+	if !ok {
+		return
+	}
+
+	serviceAccountID, ok := parseUintParam(c, "serviceAccountID", "service account ID")
+	if !ok {
+		return
+	}
+
+	if err := h.ServiceAccountService.RevokeGrant(projectID, serviceAccountID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service account has no access to this project"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke access"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.service_account.revoke", ResourceType: "service_account",
+		ResourceID: serviceAccountID, ProjectID: uintPtr(projectID), Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// ListEnvironments lists the environments of a project
+func (h *ProjectHandler) ListEnvironments(c *gin.Context) {
+	projectID, _, ok := h.authorizeProject(c, models.RoleReader, "project.environment.list")
+	if !ok {
+		return
+	}
+
+	environments, err := h.EnvironmentService.ListEnvironments(projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve environments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, environments)
+}
+
+// CreateEnvironment adds an environment to a project
+func (h *ProjectHandler) CreateEnvironment(c *gin.Context) {
+	var input environmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	projectID, _, ok := h.authorizeProject(c, models.RoleAdmin, "project.environment.create")
+	if !ok {
+		return
+	}
+
+	environment, err := h.EnvironmentService.CreateEnvironment(projectID, input.Name, input.ReadRole, input.WriteRole)
+	if err != nil {
+		respondEnvironmentError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.environment.create", ResourceType: "environment",
+		ResourceID: environment.ID, ProjectID: uintPtr(projectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"name": environment.Name, "read_role": environment.ReadRole, "write_role": environment.WriteRole}})
+
+	c.JSON(http.StatusCreated, environment)
+}
+
+// UpdateEnvironment renames an environment or changes the roles its secrets need
+func (h *ProjectHandler) UpdateEnvironment(c *gin.Context) {
+	var input environmentInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	projectID, _, ok := h.authorizeProject(c, models.RoleAdmin, "project.environment.update")
+	if !ok {
+		return
+	}
+
+	environmentID, ok := parseUintParam(c, "environmentID", "environment ID")
+	if !ok {
+		return
+	}
+
+	environment, err := h.EnvironmentService.UpdateEnvironment(projectID, environmentID, input.Name, input.ReadRole, input.WriteRole)
+	if err != nil {
+		respondEnvironmentError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.environment.update", ResourceType: "environment",
+		ResourceID: environment.ID, ProjectID: uintPtr(projectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"name": environment.Name, "read_role": environment.ReadRole, "write_role": environment.WriteRole}})
+
+	c.JSON(http.StatusOK, environment)
+}
+
+// DeleteEnvironment removes an empty environment from a project
+func (h *ProjectHandler) DeleteEnvironment(c *gin.Context) {
+	projectID, _, ok := h.authorizeProject(c, models.RoleAdmin, "project.environment.delete")
+	if !ok {
+		return
+	}
+
+	environmentID, ok := parseUintParam(c, "environmentID", "environment ID")
+	if !ok {
+		return
+	}
+
+	environment, err := h.EnvironmentService.DeleteEnvironment(projectID, environmentID)
+	if err != nil {
+		respondEnvironmentError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "project.environment.delete", ResourceType: "environment",
+		ResourceID: environment.ID, ProjectID: uintPtr(projectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"name": environment.Name}})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// authorizeProject parses :projectID and checks the caller has at least minRole on it.
+// It returns the caller's role; on failure it writes the error response and returns false.
+// Permission denials are audited under action.
+func (h *ProjectHandler) authorizeProject(c *gin.Context, minRole models.Role, action string) (uint, models.Role, bool) {
+	projectID, ok := parseUintParam(c, "projectID", "project ID")
+	if !ok {
+		return 0, "", false
+	}
+
+	_, exists := getPrincipal(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return 0, "", false
+	}
+
+	role, err := callerProjectRole(c, h.DB, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, "", false
+	}
+	if !role.AtLeast(minRole) {
+		recordAudit(h.AuditService, c, auditRecord{Action: action, ResourceType: "project", ResourceID: projectID,
+			ProjectID: uintPtr(projectID), Result: services.AuditDenied})
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission for this project"})
+		return 0, "", false
+	}
+
+	return projectID, role, true
+}
+
+// respondMembershipError maps membership service errors to HTTP responses
+func respondMembershipError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+	case errors.Is(err, services.ErrMemberExists), errors.Is(err, services.ErrLastOwner):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrOwnerRequired):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update members"})
+	}
+}
+
+// respondEnvironmentError maps environment service errors to HTTP responses
+func respondEnvironmentError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+	case errors.Is(err, services.ErrEnvironmentExists), errors.Is(err, services.ErrEnvironmentNotEmpty):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrEnvironmentName), errors.Is(err, services.ErrEnvironmentRoles),
+		errors.Is(err, services.ErrInvalidRole):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update environments"})
+	}
+}
+
+// parseUintParam parses a numeric path parameter, writing a 400 response if it is invalid
+func parseUintParam(c *gin.Context, name, label string) (uint, bool) {
+	value, err := strconv.ParseUint(c.Param(name), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + label})
+		return 0, false
+	}
+	return uint(value), true
+}
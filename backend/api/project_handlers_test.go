@@ -0,0 +1,93 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDeleteProjectRequiresOwner(t *testing.T) {
+	tests := []struct {
+		name string
+		role models.Role
+	}{
+		{"non-member", ""},
+		{"reader", models.RoleReader},
+		{"writer", models.RoleWriter},
+		{"admin", models.RoleAdmin},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The data key must not be shredded, which would be the next query
+			s := newTestServer(t)
+			s.expectSession(2, nil)
+			s.expectRole(7, 2, tt.role)
+			s.expectAudit("project.delete", services.AuditDenied)
+			s.check(http.MethodDelete, "/api/projects/7", 2, nil, nil, http.StatusForbidden)
+		})
+	}
+
+	s := newTestServer(t)
+	s.check(http.MethodDelete, "/api/projects/7", 0, nil, nil, http.StatusUnauthorized)
+}
+
+func TestDeleteProjectShredsDataKey(t *testing.T) {
+	s := newTestServer(t)
+	s.expectSession(1, nil)
+	s.expectRole(7, 1, models.RoleOwner)
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`UPDATE "projects" SET "encrypted_dek"=\$1,"key_shredded_at"=\$2`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(`UPDATE "secrets" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 3))
+	s.mock.ExpectExec(`DELETE FROM "environments"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(`DELETE FROM "project_members"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec(`DELETE FROM "service_account_grants"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(`UPDATE "service_accounts" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectExec(`UPDATE "projects" SET "deleted_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectAudit("project.delete", services.AuditSuccess)
+
+	s.check(http.MethodDelete, "/api/projects/7", 1, nil, nil, http.StatusNoContent)
+}
+
+func TestProjectMembershipRequiresAdmin(t *testing.T) {
+	for _, role := range []models.Role{"", models.RoleReader, models.RoleWriter} {
+		s := newTestServer(t)
+		s.expectSession(2, nil)
+		s.expectRole(7, 2, role)
+		s.expectAudit("project.member.add", services.AuditDenied)
+
+		body := map[string]string{"email": "new@example.com", "role": string(models.RoleReader)}
+		s.check(http.MethodPost, "/api/projects/7/members", 2, body, nil, http.StatusForbidden)
+	}
+}
+
+func TestPlainOrganizationMemberGetsNoProjectRole(t *testing.T) {
+	s := newTestServer(t)
+	s.expectSession(2, nil)
+	s.mock.ExpectQuery(`FROM "projects"`).WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id"}).AddRow(7, 4))
+	s.mock.ExpectQuery(`FROM "project_members"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.mock.ExpectQuery(`FROM "organization_members"`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "organization_id", "user_id", "role"}).AddRow(1, 4, 2, models.OrgRoleMember))
+	s.expectAudit("project.member.list", services.AuditDenied)
+
+	s.check(http.MethodGet, "/api/projects/7/members", 2, nil, nil, http.StatusForbidden)
+}
+
+func TestCreateProjectChecksQuotaUnderLock(t *testing.T) {
+	s := newTestServer(t)
+	s.expectSession(2, nil)
+	s.mock.ExpectQuery(`FROM "organization_members"`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "organization_id", "user_id", "role"}).AddRow(1, 4, 2, models.OrgRoleMember))
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(`FROM "organizations" WHERE "organizations"."id" = \$1 .* FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "setting_max_projects"}).AddRow(4, 2))
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "projects" WHERE organization_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	s.mock.ExpectRollback()
+
+	body := map[string]interface{}{"name": "api", "organization_id": 4}
+	s.check(http.MethodPost, "/api/projects", 2, body, nil, http.StatusForbidden)
+}
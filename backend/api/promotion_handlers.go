@@ -0,0 +1,160 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+// environmentPairInput names a source and a target environment, which may be in different
+// projects. The target project defaults to the source project.
+type environmentPairInput struct {
+	SourceProjectID   uint   `json:"source_project_id" form:"source_project_id" binding:"required"`
+	SourceEnvironment string `json:"source_environment" form:"source_environment" binding:"required"`
+	TargetProjectID   uint   `json:"target_project_id" form:"target_project_id"`
+	TargetEnvironment string `json:"target_environment" form:"target_environment" binding:"required"`
+}
+
+type promoteInput struct {
+	environmentPairInput
+	Keys []string `json:"keys" binding:"required,min=1,dive,required"`
+}
+
+// DiffEnvironments compares the keys of two environments: missing from the target, extra in
+// the target, or present in both with changed or the same values. Values are compared by
+// hash and never returned, but they are decrypted to do so, so like reading secrets the diff
+// needs a recent step-up and a working audit log.
+func (h *SecretHandler) DiffEnvironments(c *gin.Context) {
+	var input environmentPairInput
+	if err := c.ShouldBindQuery(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	source, target, ok := h.authorizeEnvironmentPair(c, &input, models.RoleReader, "secret.diff")
+	if !ok {
+		return
+	}
+	if !requireStepUp(h.AuditService, c, "secret.diff", "environment", target.ID, target.ProjectID) {
+		return
+	}
+	if !requireAuditSinks(h.AuditService, c) {
+		return
+	}
+
+	diffs, err := h.SecretService.DiffEnvironments(source, target)
+	if err != nil {
+		respondPromotionError(c, err, "Failed to compare environments")
+		return
+	}
+
+	err = recordAudit(h.AuditService, c, auditRecord{Action: "secret.diff", ResourceType: "environment", ResourceID: target.ID,
+		ProjectID: uintPtr(target.ProjectID), Result: services.AuditSuccess, Details: environmentPairDetails(source, target)})
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to record secret access; the diff was not returned"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"source": gin.H{"project_id": source.ProjectID, "environment": source.Name},
+		"target": gin.H{"project_id": target.ProjectID, "environment": target.Name},
+		"keys":   diffs,
+	})
+}
+
+// PromoteSecrets copies the values of the selected keys from the source environment into
+// the target, creating or updating them there in a single transaction. It decrypts the source
+// values, so it needs a recent step-up and a working audit log as well.
+func (h *SecretHandler) PromoteSecrets(c *gin.Context) {
+	var input promoteInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	source, target, ok := h.authorizeEnvironmentPair(c, &input.environmentPairInput, models.RoleWriter, "secret.promote")
+	if !ok {
+		return
+	}
+	if !requireStepUp(h.AuditService, c, "secret.promote", "environment", target.ID, target.ProjectID) {
+		return
+	}
+	if !requireAuditSinks(h.AuditService, c) {
+		return
+	}
+
+	principal, _ := getPrincipal(c)
+	promoted, err := h.SecretService.Promote(source, target, input.Keys, principal)
+	if err != nil {
+		details := environmentPairDetails(source, target)
+		details["keys"] = input.Keys
+		details["error"] = err.Error()
+		recordAudit(h.AuditService, c, auditRecord{Action: "secret.promote", ResourceType: "environment", ResourceID: target.ID,
+			ProjectID: uintPtr(target.ProjectID), Result: services.AuditFailure, Details: details})
+		respondPromotionError(c, err, "Failed to promote secrets")
+		return
+	}
+
+	details := environmentPairDetails(source, target)
+	changed := []string{}
+	for _, secret := range promoted {
+		if secret.Result != services.PromoteUnchanged {
+			changed = append(changed, secret.Key)
+		}
+	}
+	details["keys"] = changed
+	recordAudit(h.AuditService, c, auditRecord{Action: "secret.promote", ResourceType: "environment", ResourceID: target.ID,
+		ProjectID: uintPtr(target.ProjectID), Result: services.AuditSuccess, Details: details})
+
+	c.JSON(http.StatusOK, gin.H{"promoted": promoted})
+}
+
+// authorizeEnvironmentPair resolves both environments. The caller needs to read the source
+// and to have minRole in the target.
+func (h *SecretHandler) authorizeEnvironmentPair(c *gin.Context, input *environmentPairInput, minRole models.Role, action string) (*models.Environment, *models.Environment, bool) {
+	if _, exists := getPrincipal(c); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, nil, false
+	}
+	if input.TargetProjectID == 0 {
+		input.TargetProjectID = input.SourceProjectID
+	}
+
+	source, ok := h.authorizeEnvironment(c, input.SourceProjectID, input.SourceEnvironment, models.RoleReader, action)
+	if !ok {
+		return nil, nil, false
+	}
+	target, ok := h.authorizeEnvironment(c, input.TargetProjectID, input.TargetEnvironment, minRole, action)
+	if !ok {
+		return nil, nil, false
+	}
+	return source, target, true
+}
+
+func environmentPairDetails(source, target *models.Environment) map[string]interface{} {
+	return map[string]interface{}{
+		"source_project_id":  source.ProjectID,
+		"source_environment": source.Name,
+		"target_environment": target.Name,
+	}
+}
+
+// respondPromotionError maps diff and promotion errors to HTTP responses
+func respondPromotionError(c *gin.Context, err error, fallback string) {
+	switch {
+	case errors.Is(err, services.ErrSameEnvironment), errors.Is(err, services.ErrPromoteKeyMissing):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrQuotaExceeded):
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSecretKeyExists):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrProjectKeyShredded), errors.Is(err, gorm.ErrRecordNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Project not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fallback})
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	appconfig "ciphersafe/config"
 	"ciphersafe/services"
 
 	"github.com/gin-contrib/cors"
@@ -9,41 +10,217 @@ import (
 )
 
 // SetupRoutes configures the application's routes
-func SetupRoutes(r *gin.Engine, db *gorm.DB) {
+func SetupRoutes(r *gin.Engine, db *gorm.DB, auditSinks *services.AuditStreamer) {
 	// Configure CORS
 	config := cors.DefaultConfig()
 	config.AllowOrigins = []string{"http://localhost:3000"} // Your frontend URL
 	config.AllowMethods = []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"}
-	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization"}
+	config.AllowHeaders = []string{"Origin", "Content-Type", "Authorization", "If-Match"}
+	config.ExposeHeaders = []string{"ETag"}
 	r.Use(cors.New(config))
 
 	// Instantiate services
 	userService := services.NewUserService(db)
-	authService := services.NewAuthService(userService)
+	sessionService := services.NewSessionService(db)
+	loginThrottle := services.NewLoginThrottle(db)
+	authService := services.NewAuthService(userService, sessionService, loginThrottle)
+	mfaService := services.NewMFAService(db)
+	webAuthnService := services.NewWebAuthnService(db)
+	oidcService := services.NewOIDCService(db, userService)
+	secretService := services.NewSecretService(db)
+	rotationService := services.NewKeyRotationService(db)
+	sealService := services.NewSealService(db)
+	membershipService := services.NewMembershipService(db)
+	organizationService := services.NewOrganizationService(db)
+	auditService := services.NewAuditService(db, auditSinks)
+	tokenService := services.NewTokenService(db)
+	serviceAccountService := services.NewServiceAccountService(db)
+	scimService := services.NewSCIMService(db)
+	environmentService := services.NewEnvironmentService(db)
 
 	// Instantiate handlers
-	authHandler := NewAuthHandler(authService)
-	projectHandler := NewProjectHandler(db)
-	secretHandler := NewSecretHandler(db)
+	authHandler := NewAuthHandler(authService, sessionService, auditService)
+	projectHandler := NewProjectHandler(db, membershipService, serviceAccountService, environmentService, auditService)
+	organizationHandler := NewOrganizationHandler(db, organizationService, auditService)
+	secretHandler := NewSecretHandler(db, secretService, auditService)
+	adminHandler := NewAdminHandler(rotationService, loginThrottle, auditService)
+	sysHandler := NewSysHandler(sealService, auditService)
+	auditHandler := NewAuditHandler(auditService, membershipService, userService)
+	tokenHandler := NewTokenHandler(tokenService, auditService)
+	serviceAccountHandler := NewServiceAccountHandler(db, serviceAccountService, auditService)
+	sessionHandler := NewSessionHandler(sessionService, auditService)
+	mfaHandler := NewMFAHandler(mfaService, sessionService, userService, loginThrottle, auditService)
+	webAuthnHandler := NewWebAuthnHandler(db, webAuthnService, sessionService, userService, auditService)
+	oidcHandler := NewOIDCHandler(oidcService, sessionService, auditService)
+	scimHandler := NewSCIMHandler(scimService, auditService)
+
+	authMiddleware := AuthMiddleware(tokenService, serviceAccountService, sessionService, userService)
+	signInLimit := RateLimitMiddleware(services.NewRateLimiter(appconfig.AppConfig.LoginIPRateLimit))
+	unsealLimit := RateLimitMiddleware(services.NewRateLimiter(appconfig.AppConfig.LoginIPRateLimit))
 
 	// Public routes (auth)
 	authGroup := r.Group("/auth")
 	{
-		authGroup.POST("/register", authHandler.Register)
-		authGroup.POST("/login", authHandler.Login)
+		authGroup.GET("/methods", authHandler.GetMethods)
+		authGroup.POST("/register", signInLimit, authHandler.Register)
+		authGroup.POST("/login", signInLimit, authHandler.Login)
+		authGroup.POST("/refresh", authHandler.Refresh)
+		authGroup.POST("/logout", authMiddleware, authHandler.Logout)
+
+		// Second step of a login for users with MFA (takes the mfa_token from /auth/login)
+		authGroup.POST("/mfa/verify", signInLimit, mfaHandler.VerifyLogin)
+		authGroup.POST("/mfa/enroll", signInLimit, mfaHandler.BeginLoginEnrollment)
+		authGroup.POST("/mfa/enroll/confirm", signInLimit, mfaHandler.ConfirmLoginEnrollment)
+
+		// Passkey login
+		authGroup.POST("/webauthn/login/begin", webAuthnHandler.BeginLogin)
+		authGroup.POST("/webauthn/login/finish", signInLimit, webAuthnHandler.FinishLogin)
+
+		// Single sign-on (authorization code flow with PKCE)
+		authGroup.GET("/oidc/login", oidcHandler.Login)
+		authGroup.GET("/oidc/callback", oidcHandler.Callback)
+	}
+
+	// SCIM 2.0 provisioning for the identity provider, authenticated with SCIM_TOKEN
+	scimGroup := r.Group("/scim/v2")
+	scimGroup.Use(SCIMMiddleware())
+	{
+		scimGroup.GET("/ServiceProviderConfig", scimHandler.ServiceProviderConfig)
+		scimGroup.GET("/Users", scimHandler.ListUsers)
+		scimGroup.POST("/Users", scimHandler.CreateUser)
+		scimGroup.GET("/Users/:id", scimHandler.GetUser)
+		scimGroup.PUT("/Users/:id", scimHandler.ReplaceUser)
+		scimGroup.PATCH("/Users/:id", scimHandler.PatchUser)
+		scimGroup.DELETE("/Users/:id", scimHandler.DeleteUser)
+		scimGroup.GET("/Groups", scimHandler.ListGroups)
+		scimGroup.POST("/Groups", scimHandler.CreateGroup)
+		scimGroup.GET("/Groups/:id", scimHandler.GetGroup)
+		scimGroup.PUT("/Groups/:id", scimHandler.ReplaceGroup)
+		scimGroup.PATCH("/Groups/:id", scimHandler.PatchGroup)
+		scimGroup.DELETE("/Groups/:id", scimHandler.DeleteGroup)
+	}
+
+	// Seal management (sealed mode)
+	sysGroup := r.Group("/sys")
+	{
+		sysGroup.GET("/seal-status", sysHandler.SealStatus)
+		sysGroup.POST("/init", unsealLimit, sysHandler.Init)
+		sysGroup.POST("/unseal", unsealLimit, sysHandler.Unseal)
+		sysGroup.POST("/seal", authMiddleware, UserSessionMiddleware(), AdminMiddleware(userService), sysHandler.Seal)
 	}
 
-	// Protected routes (main API)
+	// Protected routes (main API), unavailable while sealed
 	api := r.Group("/api")
-	api.Use(AuthMiddleware())
+	api.Use(authMiddleware, SealMiddleware(sealService))
 	{
-		// Project routes
-		api.POST("/projects", projectHandler.CreateProject)
+		// Project and secret routes, also available to API tokens and service accounts within their grants
 		api.GET("/projects", projectHandler.GetProjects)
-
-		// Secret routes
+		api.GET("/projects/:projectID/environments", projectHandler.ListEnvironments)
 		api.POST("/secrets", secretHandler.CreateSecret)
 		api.GET("/projects/:projectID/secrets", secretHandler.GetSecretsForProject)
+		api.GET("/projects/:projectID/secrets/keys", secretHandler.ListSecretKeys)
+		api.POST("/projects/:projectID/import", secretHandler.ImportSecrets)
+		api.GET("/projects/:projectID/export", secretHandler.ExportSecrets)
+		api.GET("/secrets/:secretID", secretHandler.GetSecret)
+		api.PUT("/secrets/:secretID", secretHandler.UpdateSecret)
 		api.DELETE("/secrets/:secretID", secretHandler.DeleteSecret)
+
+		// Comparing environments and promoting secrets between them
+		api.GET("/secrets/diff", secretHandler.DiffEnvironments)
+		api.POST("/secrets/promote", secretHandler.PromoteSecrets)
+
+		// Secret version history
+		api.GET("/secrets/:secretID/versions", secretHandler.ListSecretVersions)
+		api.GET("/secrets/:secretID/versions/diff", secretHandler.DiffSecretVersions)
+		api.GET("/secrets/:secretID/versions/:version", secretHandler.GetSecretVersion)
+		api.POST("/secrets/:secretID/versions/:version/rollback", secretHandler.RollbackSecret)
+	}
+
+	// Account, organization and membership management require a user session
+	user := api.Group("")
+	user.Use(UserSessionMiddleware())
+	{
+		// Organization routes
+		user.POST("/orgs", organizationHandler.CreateOrganization)
+		user.GET("/orgs", organizationHandler.GetOrganizations)
+		user.GET("/orgs/:orgID", organizationHandler.GetOrganization)
+		user.PUT("/orgs/:orgID/settings", organizationHandler.UpdateSettings)
+		user.GET("/orgs/:orgID/members", organizationHandler.ListMembers)
+		user.POST("/orgs/:orgID/members", organizationHandler.AddMember)
+		user.PUT("/orgs/:orgID/members/:userID", organizationHandler.UpdateMember)
+		user.DELETE("/orgs/:orgID/members/:userID", organizationHandler.RemoveMember)
+
+		// Project routes
+		user.POST("/projects", projectHandler.CreateProject)
+		user.DELETE("/projects/:projectID", projectHandler.DeleteProject)
+
+		// Project membership routes
+		user.GET("/projects/:projectID/members", projectHandler.ListMembers)
+		user.POST("/projects/:projectID/members", projectHandler.AddMember)
+		user.PUT("/projects/:projectID/members/:userID", projectHandler.UpdateMember)
+		user.DELETE("/projects/:projectID/members/:userID", projectHandler.RemoveMember)
+
+		// Project environments
+		user.POST("/projects/:projectID/environments", projectHandler.CreateEnvironment)
+		user.PUT("/projects/:projectID/environments/:environmentID", projectHandler.UpdateEnvironment)
+		user.DELETE("/projects/:projectID/environments/:environmentID", projectHandler.DeleteEnvironment)
+
+		// Project service account grants
+		user.GET("/projects/:projectID/service-accounts", projectHandler.ListServiceAccountGrants)
+		user.PUT("/projects/:projectID/service-accounts/:serviceAccountID", projectHandler.GrantServiceAccount)
+		user.DELETE("/projects/:projectID/service-accounts/:serviceAccountID", projectHandler.RevokeServiceAccount)
+
+		// Service accounts and their keys
+		user.POST("/service-accounts", serviceAccountHandler.CreateServiceAccount)
+		user.GET("/service-accounts", serviceAccountHandler.GetServiceAccounts)
+		user.GET("/service-accounts/:serviceAccountID", serviceAccountHandler.GetServiceAccount)
+		user.DELETE("/service-accounts/:serviceAccountID", serviceAccountHandler.DeleteServiceAccount)
+		user.POST("/service-accounts/:serviceAccountID/keys", serviceAccountHandler.CreateKey)
+		user.GET("/service-accounts/:serviceAccountID/keys", serviceAccountHandler.GetKeys)
+		user.DELETE("/service-accounts/:serviceAccountID/keys/:keyID", serviceAccountHandler.RevokeKey)
+
+		// Personal access tokens
+		user.POST("/tokens", tokenHandler.CreateToken)
+		user.GET("/tokens", tokenHandler.GetTokens)
+		user.GET("/tokens/:tokenID", tokenHandler.GetToken)
+		user.DELETE("/tokens/:tokenID", tokenHandler.RevokeToken)
+
+		// Password and sign-in sessions
+		user.PUT("/password", authHandler.ChangePassword)
+		user.GET("/sessions", sessionHandler.GetSessions)
+		user.DELETE("/sessions", sessionHandler.RevokeAllSessions)
+		user.DELETE("/sessions/:sessionID", sessionHandler.RevokeSession)
+
+		// Two-factor authentication
+		user.GET("/mfa", mfaHandler.GetMFAStatus)
+		user.POST("/mfa/totp", mfaHandler.BeginEnrollment)
+		user.POST("/mfa/totp/confirm", mfaHandler.ConfirmEnrollment)
+		user.DELETE("/mfa/totp", mfaHandler.DisableTOTP)
+		user.POST("/mfa/recovery-codes", mfaHandler.RegenerateRecoveryCodes)
+
+		// Passkeys and security keys, and step-up re-authentication with them
+		user.POST("/webauthn/register/begin", webAuthnHandler.BeginRegistration)
+		user.POST("/webauthn/register/finish", webAuthnHandler.FinishRegistration)
+		user.GET("/webauthn/credentials", webAuthnHandler.GetCredentials)
+		user.DELETE("/webauthn/credentials/:credentialID", webAuthnHandler.DeleteCredential)
+		user.POST("/webauthn/step-up/begin", webAuthnHandler.BeginStepUp)
+		user.POST("/webauthn/step-up/finish", webAuthnHandler.FinishStepUp)
+
+		// Audit log (scoped to the caller unless they are an admin)
+		user.GET("/audit", auditHandler.GetAuditEvents)
+		user.GET("/audit/verify", AdminMiddleware(userService), auditHandler.VerifyAuditChain)
+	}
+
+	// Admin routes
+	admin := user.Group("/admin")
+	admin.Use(AdminMiddleware(userService))
+	{
+		admin.GET("/keys", adminHandler.GetKeyring)
+		admin.POST("/keys/rotate", adminHandler.StartKeyRotation)
+		admin.GET("/keys/rotate", adminHandler.GetKeyRotationStatus)
+
+		// Sign-in lockouts
+		admin.GET("/lockouts", adminHandler.GetLockouts)
+		admin.DELETE("/lockouts/:lockoutID", adminHandler.ClearLockout)
 	}
 }
@@ -0,0 +1,279 @@
+package api
+
+import (
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// scimContentType is the media type of SCIM requests and responses (RFC 7644)
+const scimContentType = "application/scim+json"
+
+type SCIMHandler struct {
+	SCIMService  *services.SCIMService
+	AuditService *services.AuditService
+}
+
+func NewSCIMHandler(scimService *services.SCIMService, auditService *services.AuditService) *SCIMHandler {
+	return &SCIMHandler{SCIMService: scimService, AuditService: auditService}
+}
+
+// ServiceProviderConfig describes what this SCIM endpoint supports
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, gin.H{
+		"schemas":        []string{services.SCIMSchemaServiceProviderConfig},
+		"patch":          gin.H{"supported": true},
+		"bulk":           gin.H{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         gin.H{"supported": true, "maxResults": services.SCIMMaxPageSize},
+		"changePassword": gin.H{"supported": false},
+		"sort":           gin.H{"supported": false},
+		"etag":           gin.H{"supported": false},
+		"authenticationSchemes": []gin.H{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer token",
+			"description": "The SCIM_TOKEN configured on the server",
+		}},
+	})
+}
+
+// ListUsers returns users matching an optional filter
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	startIndex, count := scimPage(c)
+	list, err := h.SCIMService.ListUsers(c.Query("filter"), startIndex, count)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, list)
+}
+
+// GetUser returns one user
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, err := h.SCIMService.GetUser(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, user)
+}
+
+// CreateUser provisions a user
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var input services.SCIMUserResource
+	if !bindSCIM(c, &input) {
+		return
+	}
+
+	user, err := h.SCIMService.CreateUser(&input)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	h.auditUser(c, "scim.user.create", user)
+	scimJSON(c, http.StatusCreated, user)
+}
+
+// ReplaceUser updates a user from a full representation
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	var input services.SCIMUserResource
+	if !bindSCIM(c, &input) {
+		return
+	}
+
+	user, err := h.SCIMService.ReplaceUser(c.Param("id"), &input)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	h.auditUser(c, "scim.user.update", user)
+	scimJSON(c, http.StatusOK, user)
+}
+
+// PatchUser applies PATCH operations to a user, such as deactivating it
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	var input services.SCIMPatchRequest
+	if !bindSCIM(c, &input) {
+		return
+	}
+
+	user, err := h.SCIMService.PatchUser(c.Param("id"), input.Operations)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	h.auditUser(c, "scim.user.update", user)
+	scimJSON(c, http.StatusOK, user)
+}
+
+// DeleteUser deactivates a user and drops their SCIM group memberships
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	if err := h.SCIMService.DeleteUser(c.Param("id")); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	recordAudit(h.AuditService, c, auditRecord{Action: "scim.user.delete", ResourceType: "user", ResourceID: uint(id),
+		Result: services.AuditSuccess})
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroups returns groups matching an optional filter
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	startIndex, count := scimPage(c)
+	list, err := h.SCIMService.ListGroups(c.Query("filter"), startIndex, count)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, list)
+}
+
+// GetGroup returns one group
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, err := h.SCIMService.GetGroup(c.Param("id"))
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, group)
+}
+
+// CreateGroup creates a group and syncs its members' project roles
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var input services.SCIMGroupResource
+	if !bindSCIM(c, &input) {
+		return
+	}
+
+	group, err := h.SCIMService.CreateGroup(&input)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	h.auditGroup(c, "scim.group.create", group)
+	scimJSON(c, http.StatusCreated, group)
+}
+
+// ReplaceGroup updates a group from a full representation
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	var input services.SCIMGroupResource
+	if !bindSCIM(c, &input) {
+		return
+	}
+
+	group, err := h.SCIMService.ReplaceGroup(c.Param("id"), &input)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	h.auditGroup(c, "scim.group.update", group)
+	scimJSON(c, http.StatusOK, group)
+}
+
+// PatchGroup applies PATCH operations to a group, typically adding or removing members
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	var input services.SCIMPatchRequest
+	if !bindSCIM(c, &input) {
+		return
+	}
+
+	group, err := h.SCIMService.PatchGroup(c.Param("id"), input.Operations)
+	if err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	h.auditGroup(c, "scim.group.update", group)
+	scimJSON(c, http.StatusOK, group)
+}
+
+// DeleteGroup deletes a group and revokes the project roles it granted
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	if err := h.SCIMService.DeleteGroup(c.Param("id")); err != nil {
+		respondSCIMError(c, err)
+		return
+	}
+
+	id, _ := strconv.ParseUint(c.Param("id"), 10, 64)
+	recordAudit(h.AuditService, c, auditRecord{Action: "scim.group.delete", ResourceType: "scim_group", ResourceID: uint(id),
+		Result: services.AuditSuccess})
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) auditUser(c *gin.Context, action string, user *services.SCIMUserResource) {
+	id, _ := strconv.ParseUint(user.ID, 10, 64)
+	recordAudit(h.AuditService, c, auditRecord{Action: action, ResourceType: "user", ResourceID: uint(id),
+		Result: services.AuditSuccess, Details: map[string]interface{}{"user_name": user.UserName, "active": *user.Active}})
+}
+
+func (h *SCIMHandler) auditGroup(c *gin.Context, action string, group *services.SCIMGroupResource) {
+	id, _ := strconv.ParseUint(group.ID, 10, 64)
+	recordAudit(h.AuditService, c, auditRecord{Action: action, ResourceType: "scim_group", ResourceID: uint(id),
+		Result: services.AuditSuccess, Details: map[string]interface{}{"display_name": group.DisplayName, "members": len(group.Members)}})
+}
+
+// scimPage reads startIndex (1-based) and count, clamped to SCIMMaxPageSize
+func scimPage(c *gin.Context) (int, int) {
+	startIndex, err := strconv.Atoi(c.Query("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err := strconv.Atoi(c.DefaultQuery("count", strconv.Itoa(services.SCIMMaxPageSize)))
+	if err != nil || count < 0 {
+		count = services.SCIMMaxPageSize
+	}
+	if count > services.SCIMMaxPageSize {
+		count = services.SCIMMaxPageSize
+	}
+	return startIndex, count
+}
+
+// bindSCIM decodes a SCIM request body, writing a 400 on failure
+func bindSCIM(c *gin.Context, v interface{}) bool {
+	if err := c.ShouldBindJSON(v); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return false
+	}
+	return true
+}
+
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scimContentType)
+	c.JSON(status, body)
+}
+
+// scimError writes a SCIM error response and aborts the request
+func scimError(c *gin.Context, status int, scimType, detail string) {
+	body := gin.H{"schemas": []string{services.SCIMSchemaError}, "status": strconv.Itoa(status), "detail": detail}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	c.Header("Content-Type", scimContentType)
+	c.AbortWithStatusJSON(status, body)
+}
+
+func respondSCIMError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrSCIMNotFound):
+		scimError(c, http.StatusNotFound, "", err.Error())
+	case errors.Is(err, services.ErrSCIMUniqueness):
+		scimError(c, http.StatusConflict, "uniqueness", err.Error())
+	case errors.Is(err, services.ErrSCIMInvalidFilter):
+		scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+	case errors.Is(err, services.ErrSCIMInvalidPath):
+		scimError(c, http.StatusBadRequest, "invalidPath", err.Error())
+	case errors.Is(err, services.ErrSCIMInvalidValue):
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+	default:
+		scimError(c, http.StatusInternalServerError, "", "SCIM request failed")
+	}
+}
@@ -1,45 +1,112 @@
 package api
 
 import (
+	"ciphersafe/config"
 	"ciphersafe/models"
 	"ciphersafe/services"
 	"errors"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"gorm.io/gorm"
 )
 
 type SecretHandler struct {
-	DB *gorm.DB
+	DB            *gorm.DB
+	SecretService *services.SecretService
+	AuditService  *services.AuditService
 }
 
-func NewSecretHandler(db *gorm.DB) *SecretHandler {
-	return &SecretHandler{DB: db}
+func NewSecretHandler(db *gorm.DB, secretService *services.SecretService, auditService *services.AuditService) *SecretHandler {
+	return &SecretHandler{DB: db, SecretService: secretService, AuditService: auditService}
 }
 
 type secretInput struct {
-	ProjectID uint   `json:"project_id" binding:"required"`
-	Key       string `json:"key" binding:"required"`
-	Value     string `json:"value" binding:"required"` // This is the PLAINTEXT value
+	ProjectID   uint   `json:"project_id" binding:"required"`
+	Environment string `json:"environment" binding:"required"` // Environment name, e.g. "production"
+	Key         string `json:"key" binding:"required"`
+	Value       string `json:"value" binding:"required"` // This is the PLAINTEXT value
+}
+
+type updateSecretInput struct {
+	Key   *string `json:"key" binding:"omitempty,min=1"`
+	Value *string `json:"value"` // PLAINTEXT value
 }
 
 // DecryptedSecret is a struct for sending secrets to the user
 type DecryptedSecret struct {
-	ID        uint   `json:"id"`
-	Key       string `json:"key"`
-	Value     string `json:"value"` // This will hold the DECRYPTED value
-	ProjectID uint   `json:"project_id"`
+	ID            uint   `json:"id"`
+	Key           string `json:"key"`
+	Value         string `json:"value"` // This will hold the DECRYPTED value
+	ProjectID     uint   `json:"project_id"`
+	EnvironmentID uint   `json:"environment_id"`
+	Environment   string `json:"environment"`
+	Version       int    `json:"version"`
+}
+
+// SecretKey identifies a secret of an environment without its value
+type SecretKey struct {
+	ID          uint   `json:"id"`
+	Key         string `json:"key"`
+	Environment string `json:"environment"`
+	Version     int    `json:"version"`
+}
+
+// DecryptedSecretVersion is a single historical version sent to the user
+type DecryptedSecretVersion struct {
+	SecretID     uint      `json:"secret_id"`
+	Version      int       `json:"version"`
+	Value        string    `json:"value"` // DECRYPTED value at this version
+	AuthorID     uint      `json:"author_id"`
+	AuthorType   string    `json:"author_type"` // "user" or "service_account"
+	RestoredFrom *int      `json:"restored_from,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// verifyProjectRole is a crucial helper function: it checks the caller is a member
+// of the project with at least minRole (and, for API tokens, that the token allows it)
+func verifyProjectRole(c *gin.Context, db *gorm.DB, projectID uint, minRole models.Role) bool {
+	role, err := callerProjectRole(c, db, projectID)
+	if err != nil {
+		return false
+	}
+	return role.AtLeast(minRole)
+}
+
+// verifyEnvironmentRole checks the caller has the project role an operation needing minRole
+// takes in environment, which may be more than the project asks for (e.g. writer to read
+// production)
+func verifyEnvironmentRole(c *gin.Context, db *gorm.DB, environment *models.Environment, minRole models.Role) bool {
+	return verifyProjectRole(c, db, environment.ProjectID, environment.RequiredRole(minRole))
 }
 
-// verifyProjectOwnership is a crucial helper function
-func verifyProjectOwnership(db *gorm.DB, userID, projectID uint) bool {
-	var project models.Project
-	if err := db.First(&project, projectID).Error; err != nil {
-		return false // Project not found
+// requireStepUp enforces STEP_UP_MAX_AGE: a user session must have made a WebAuthn assertion
+// recently before it may reveal or delete secrets, or mint credentials. API tokens and service
+// accounts cannot perform one and are exempt; their grants already bound what they can reach,
+// and creating them requires a step-up, so a session cannot use them to skip it.
+// On failure it writes a 403 with step_up_required and audits the denial under action.
+// A projectID of 0 records the denial without a project.
+func requireStepUp(auditService *services.AuditService, c *gin.Context, action, resourceType string, resourceID, projectID uint) bool {
+	maxAge := config.AppConfig.StepUpMaxAge
+	session, isSession := getSession(c)
+	if maxAge == 0 || !isSession {
+		return true
 	}
-	return project.OwnerID == userID
+	if session.StepUpAt != nil && time.Since(*session.StepUpAt) <= maxAge {
+		return true
+	}
+
+	rec := auditRecord{Action: action, ResourceType: resourceType, ResourceID: resourceID,
+		Result: services.AuditDenied, Details: map[string]interface{}{"reason": "step_up"}}
+	if projectID != 0 {
+		rec.ProjectID = uintPtr(projectID)
+	}
+	recordAudit(auditService, c, rec)
+	c.JSON(http.StatusForbidden, gin.H{"error": "Confirm with your passkey or security key to continue", "step_up_required": true})
+	return false
 }
 
 // CreateSecret encrypts and saves a new secret
@@ -50,40 +117,42 @@ func (h *SecretHandler) CreateSecret(c *gin.Context) {
 		return
 	}
 
-	userID, exists := getUserID(c)
+	principal, exists := getPrincipal(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
-	// Verify the authenticated user actually owns the project they're adding a secret to
-	if !verifyProjectOwnership(h.DB, userID, input.ProjectID) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission for this project"})
+	// Verify the authenticated user may write to the environment they're adding a secret to
+	environment, ok := h.authorizeEnvironment(c, input.ProjectID, input.Environment, models.RoleWriter, "secret.create")
+	if !ok {
 		return
 	}
 
-	// Encrypt the secret value before saving
-	encryptedValue, err := services.Encrypt(input.Value)
+	// The service encrypts the value and records it as version 1
+	secret, err := h.SecretService.CreateSecret(environment, input.Key, input.Value, principal)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt secret"})
-		return
-	}
-
-	secret := models.Secret{
-		ProjectID: input.ProjectID,
-		Key:       input.Key,
-		Value:     encryptedValue, // Save the ENCRYPTED value
-	}
-
-	if err := h.DB.Create(&secret).Error; err != nil {
+		if errors.Is(err, services.ErrQuotaExceeded) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, services.ErrSecretKeyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save secret"})
 		return
 	}
 
+	recordAudit(h.AuditService, c, auditRecord{Action: "secret.create", ResourceType: "secret", ResourceID: secret.ID,
+		ProjectID: uintPtr(secret.ProjectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"key": secret.Key, "environment": environment.Name}})
+
 	c.JSON(http.StatusCreated, gin.H{"message": "Secret created successfully"})
 }
 
-// GetSecretsForProject decrypts and returns all secrets for a project
+// GetSecretsForProject decrypts and returns the secrets of one environment of a project,
+// selected with ?environment=<name>
 func (h *SecretHandler) GetSecretsForProject(c *gin.Context) {
 	projectIDStr := c.Param("projectID")
 	projectID, err := strconv.ParseUint(projectIDStr, 10, 32)
@@ -92,79 +161,521 @@ func (h *SecretHandler) GetSecretsForProject(c *gin.Context) {
 		return
 	}
 
-	userID, exists := getUserID(c)
+	environmentName := c.Query("environment")
+	if environmentName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The environment query parameter is required"})
+		return
+	}
+
+	_, exists := getPrincipal(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 		return
 	}
 
 	// *** CRITICAL SECURITY CHECK ***
-	if !verifyProjectOwnership(h.DB, userID, uint(projectID)) {
-		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission for this project"})
+	environment, ok := h.authorizeEnvironment(c, uint(projectID), environmentName, models.RoleReader, "secret.read")
+	if !ok {
+		return
+	}
+
+	if !requireStepUp(h.AuditService, c, "secret.read", "project", uint(projectID), uint(projectID)) {
+		return
+	}
+
+	if !requireAuditSinks(h.AuditService, c) {
 		return
 	}
 
 	var secrets []models.Secret
-	if err := h.DB.Where("project_id = ?", projectID).Find(&secrets).Error; err != nil {
+	if err := h.DB.Where("project_id = ? AND environment_id = ?", projectID, environment.ID).Find(&secrets).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve secrets"})
 		return
 	}
 
-	// Decrypt secrets before sending them
+	dek, err := h.SecretService.DataKey(uint(projectID))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load project key"})
+		return
+	}
+
+	// Decrypt secrets before sending them. A value that fails to authenticate was tampered with
+	// or copied from elsewhere; each one is audited and nothing is returned.
 	var decryptedSecrets []DecryptedSecret
+	var failedKeys []string
 	for _, secret := range secrets {
-		decryptedValue, err := services.Decrypt(secret.Value)
+		decryptedValue, err := h.SecretService.DecryptValue(dek, services.CurrentContext(&secret), secret.Value)
 		if err != nil {
+			recordAudit(h.AuditService, c, auditRecord{Action: "secret.read", ResourceType: "secret", ResourceID: secret.ID,
+				ProjectID: uintPtr(secret.ProjectID), Result: services.AuditFailure,
+				Details: map[string]interface{}{"key": secret.Key, "environment": environment.Name, "reason": "decrypt"}})
+			failedKeys = append(failedKeys, secret.Key)
 			continue
 		}
 
 		decryptedSecrets = append(decryptedSecrets, DecryptedSecret{
-			ID:        secret.ID,
-			Key:       secret.Key,
-			Value:     decryptedValue, // Send the DECRYPTED value
-			ProjectID: secret.ProjectID,
+			ID:            secret.ID,
+			Key:           secret.Key,
+			Value:         decryptedValue, // Send the DECRYPTED value
+			ProjectID:     secret.ProjectID,
+			EnvironmentID: secret.EnvironmentID,
+			Environment:   environment.Name,
+			Version:       secret.CurrentVersion,
 		})
 	}
 
+	if len(failedKeys) > 0 {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Some secrets could not be decrypted; secrets were not returned", "failed_keys": failedKeys})
+		return
+	}
+
+	err = recordAudit(h.AuditService, c, auditRecord{Action: "secret.read", ResourceType: "project", ResourceID: uint(projectID),
+		ProjectID: uintPtr(uint(projectID)), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"count": len(decryptedSecrets), "environment": environment.Name}})
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to record secret access; secrets were not returned"})
+		return
+	}
+
 	c.JSON(http.StatusOK, decryptedSecrets)
 }
 
+// ListSecretKeys lists the keys of one environment of a project, selected with
+// ?environment=<name>, without decrypting their values
+func (h *SecretHandler) ListSecretKeys(c *gin.Context) {
+	projectID, ok := parseUintParam(c, "projectID", "project ID")
+	if !ok {
+		return
+	}
+	environmentName := c.Query("environment")
+	if environmentName == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The environment query parameter is required"})
+		return
+	}
+	if _, exists := getPrincipal(c); !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	environment, ok := h.authorizeEnvironment(c, projectID, environmentName, models.RoleReader, "secret.list")
+	if !ok {
+		return
+	}
+
+	var secrets []models.Secret
+	err := h.DB.Select("id", "key", "current_version").Where("project_id = ? AND environment_id = ?", projectID, environment.ID).
+		Order("key").Find(&secrets).Error
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve secrets"})
+		return
+	}
+
+	keys := make([]SecretKey, 0, len(secrets))
+	for _, secret := range secrets {
+		keys = append(keys, SecretKey{ID: secret.ID, Key: secret.Key, Environment: environment.Name, Version: secret.CurrentVersion})
+	}
+	c.JSON(http.StatusOK, keys)
+}
+
+// GetSecret decrypts and returns the current value of a single secret. Like reading an
+// environment, it needs a recent step-up and a working audit log, but the read is audited
+// against the secret alone.
+func (h *SecretHandler) GetSecret(c *gin.Context) {
+	secret, ok := h.loadSecret(c, models.RoleReader, "secret.read")
+	if !ok {
+		return
+	}
+
+	if !requireStepUp(h.AuditService, c, "secret.read", "secret", secret.ID, secret.ProjectID) {
+		return
+	}
+
+	if !requireAuditSinks(h.AuditService, c) {
+		return
+	}
+
+	var environment models.Environment
+	if err := h.DB.Select("id", "name").First(&environment, secret.EnvironmentID).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	dek, err := h.SecretService.DataKey(secret.ProjectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load project key"})
+		return
+	}
+
+	details := map[string]interface{}{"key": secret.Key, "environment": environment.Name}
+	decryptedValue, err := h.SecretService.DecryptValue(dek, services.CurrentContext(secret), secret.Value)
+	if err != nil {
+		details["reason"] = "decrypt"
+		recordAudit(h.AuditService, c, auditRecord{Action: "secret.read", ResourceType: "secret", ResourceID: secret.ID,
+			ProjectID: uintPtr(secret.ProjectID), Result: services.AuditFailure, Details: details})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt secret"})
+		return
+	}
+
+	err = recordAudit(h.AuditService, c, auditRecord{Action: "secret.read", ResourceType: "secret", ResourceID: secret.ID,
+		ProjectID: uintPtr(secret.ProjectID), Result: services.AuditSuccess, Details: details})
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to record secret access; secret was not returned"})
+		return
+	}
+
+	c.Header("ETag", secretETag(secret.CurrentVersion))
+	c.JSON(http.StatusOK, DecryptedSecret{
+		ID:            secret.ID,
+		Key:           secret.Key,
+		Value:         decryptedValue,
+		ProjectID:     secret.ProjectID,
+		EnvironmentID: secret.EnvironmentID,
+		Environment:   environment.Name,
+		Version:       secret.CurrentVersion,
+	})
+}
+
 // DeleteSecret deletes a specific secret
 func (h *SecretHandler) DeleteSecret(c *gin.Context) {
+	secret, ok := h.loadSecret(c, models.RoleWriter, "secret.delete")
+	if !ok {
+		return
+	}
+
+	if !requireStepUp(h.AuditService, c, "secret.delete", "secret", secret.ID, secret.ProjectID) {
+		return
+	}
+
+	// All checks passed, delete the secret
+	if err := h.DB.Delete(secret).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete secret"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "secret.delete", ResourceType: "secret", ResourceID: secret.ID,
+		ProjectID: uintPtr(secret.ProjectID), Result: services.AuditSuccess, Details: map[string]interface{}{"key": secret.Key}})
+
+	c.JSON(http.StatusNoContent, nil) // 204 No Content is standard for successful delete
+}
+
+// UpdateSecret writes a new version of a secret with a new value and/or key name.
+// The client must send If-Match with the ETag (version) it last read; a stale ETag fails with 412.
+func (h *SecretHandler) UpdateSecret(c *gin.Context) {
+	var input updateSecretInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Key == nil && input.Value == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Nothing to update: provide key and/or value"})
+		return
+	}
+
+	ifMatch := c.GetHeader("If-Match")
+	if ifMatch == "" {
+		c.JSON(http.StatusPreconditionRequired, gin.H{"error": "If-Match header with the secret's ETag is required"})
+		return
+	}
+	expectedVersion, ok := parseETag(ifMatch)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid If-Match header"})
+		return
+	}
+
+	secret, ok := h.loadSecret(c, models.RoleWriter, "secret.update")
+	if !ok {
+		return
+	}
+
+	principal, _ := getPrincipal(c)
+	updated, err := h.SecretService.UpdateSecret(secret.ID, expectedVersion, input.Key, input.Value, principal)
+	if err != nil {
+		if errors.Is(err, services.ErrVersionConflict) {
+			current, _ := h.SecretService.CurrentVersion(secret.ID)
+			c.Header("ETag", secretETag(current))
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": err.Error(), "current_version": current})
+			return
+		}
+		if errors.Is(err, services.ErrSecretKeyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update secret"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "secret.update", ResourceType: "secret", ResourceID: updated.ID,
+		ProjectID: uintPtr(updated.ProjectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"key": updated.Key, "version": updated.CurrentVersion}})
+
+	c.Header("ETag", secretETag(updated.CurrentVersion))
+	c.JSON(http.StatusOK, gin.H{
+		"id":             updated.ID,
+		"key":            updated.Key,
+		"project_id":     updated.ProjectID,
+		"environment_id": updated.EnvironmentID,
+		"version":        updated.CurrentVersion,
+	})
+}
+
+// ListSecretVersions returns the version history of a secret without values
+func (h *SecretHandler) ListSecretVersions(c *gin.Context) {
+	secret, ok := h.loadSecret(c, models.RoleReader, "secret.version.list")
+	if !ok {
+		return
+	}
+
+	versions, err := h.SecretService.ListVersions(secret.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve versions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, versions)
+}
+
+// GetSecretVersion decrypts and returns a specific version of a secret
+func (h *SecretHandler) GetSecretVersion(c *gin.Context) {
+	secret, ok := h.loadSecret(c, models.RoleReader, "secret.version.read")
+	if !ok {
+		return
+	}
+
+	version, ok := parseVersionParam(c)
+	if !ok {
+		return
+	}
+
+	if !requireStepUp(h.AuditService, c, "secret.version.read", "secret", secret.ID, secret.ProjectID) {
+		return
+	}
+
+	if !requireAuditSinks(h.AuditService, c) {
+		return
+	}
+
+	v, err := h.SecretService.GetVersion(secret.ID, version)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	dek, err := h.SecretService.DataKey(secret.ProjectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load project key"})
+		return
+	}
+
+	decryptedValue, err := h.SecretService.DecryptValue(dek, services.VersionContext(secret, v), v.Value)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt secret"})
+		return
+	}
+
+	err = recordAudit(h.AuditService, c, auditRecord{Action: "secret.version.read", ResourceType: "secret", ResourceID: secret.ID,
+		ProjectID: uintPtr(secret.ProjectID), Result: services.AuditSuccess, Details: map[string]interface{}{"version": v.Version}})
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to record secret access; secret was not returned"})
+		return
+	}
+
+	if v.Version == secret.CurrentVersion {
+		c.Header("ETag", secretETag(v.Version))
+	}
+	c.JSON(http.StatusOK, DecryptedSecretVersion{
+		SecretID:     v.SecretID,
+		Version:      v.Version,
+		Value:        decryptedValue,
+		AuthorID:     v.AuthorID,
+		AuthorType:   v.AuthorType,
+		RestoredFrom: v.RestoredFrom,
+		CreatedAt:    v.CreatedAt,
+	})
+}
+
+// DiffSecretVersions decrypts two versions of a secret and reports how its key and value changed
+func (h *SecretHandler) DiffSecretVersions(c *gin.Context) {
+	secret, ok := h.loadSecret(c, models.RoleReader, "secret.version.diff")
+	if !ok {
+		return
+	}
+
+	from, err := strconv.Atoi(c.Query("from"))
+	if err != nil || from < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid from version"})
+		return
+	}
+	to, err := strconv.Atoi(c.Query("to"))
+	if err != nil || to < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid to version"})
+		return
+	}
+
+	if !requireStepUp(h.AuditService, c, "secret.version.diff", "secret", secret.ID, secret.ProjectID) {
+		return
+	}
+
+	if !requireAuditSinks(h.AuditService, c) {
+		return
+	}
+
+	diff, err := h.SecretService.DiffVersions(secret, from, to)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to compare versions"})
+		return
+	}
+
+	err = recordAudit(h.AuditService, c, auditRecord{Action: "secret.version.diff", ResourceType: "secret", ResourceID: secret.ID,
+		ProjectID: uintPtr(secret.ProjectID), Result: services.AuditSuccess, Details: map[string]interface{}{"from": from, "to": to}})
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to record secret access; versions were not returned"})
+		return
+	}
+
+	c.JSON(http.StatusOK, diff)
+}
+
+// RollbackSecret makes an earlier version current again by writing it as a new version
+func (h *SecretHandler) RollbackSecret(c *gin.Context) {
+	secret, ok := h.loadSecret(c, models.RoleWriter, "secret.rollback")
+	if !ok {
+		return
+	}
+
+	version, ok := parseVersionParam(c)
+	if !ok {
+		return
+	}
+
+	principal, _ := getPrincipal(c)
+	v, err := h.SecretService.Rollback(secret.ID, version, principal)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Version not found"})
+			return
+		}
+		if errors.Is(err, services.ErrVersionAlreadyCurrent) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to roll back secret"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "secret.rollback", ResourceType: "secret", ResourceID: secret.ID,
+		ProjectID: uintPtr(secret.ProjectID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"restored_from": version, "version": v.Version}})
+
+	c.JSON(http.StatusOK, v)
+}
+
+// loadSecret parses :secretID, loads the secret and verifies the caller has at least
+// minRole on its project, or more if its environment requires it. On failure it writes the
+// error response and returns false. Permission denials are audited under action.
+func (h *SecretHandler) loadSecret(c *gin.Context, minRole models.Role, action string) (*models.Secret, bool) {
 	secretIDStr := c.Param("secretID")
 	secretID, err := strconv.ParseUint(secretIDStr, 10, 32)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid secret ID"})
-		return
+		return nil, false
 	}
 
-	userID, exists := getUserID(c)
+	_, exists := getPrincipal(c)
 	if !exists {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
-		return
+		return nil, false
 	}
 
-	// Verify the user owns the project that this secret belongs to.
+	// Verify the user has access to the project that this secret belongs to.
 	var secret models.Secret
 	if err := h.DB.First(&secret, uint(secretID)).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "Secret not found"})
-			return
+			return nil, false
 		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
+		return nil, false
+	}
+
+	var environment models.Environment
+	if err := h.DB.First(&environment, secret.EnvironmentID).Error; err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
 	}
 
-	if !verifyProjectOwnership(h.DB, userID, secret.ProjectID) {
+	if !verifyProjectRole(c, h.DB, secret.ProjectID, environment.RequiredRole(minRole)) {
+		recordAudit(h.AuditService, c, auditRecord{Action: action, ResourceType: "secret", ResourceID: secret.ID,
+			ProjectID: uintPtr(secret.ProjectID), Result: services.AuditDenied})
 		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission for this secret"})
-		return
+		return nil, false
 	}
 
-	// All checks passed, delete the secret
-	if err := h.DB.Delete(&secret).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete secret"})
-		return
+	return &secret, true
+}
+
+// authorizeEnvironment finds an environment of a project by name and verifies the caller has
+// at least minRole on the project, or more if the environment requires it. On failure it
+// writes the error response and returns false. Permission denials are audited under action.
+func (h *SecretHandler) authorizeEnvironment(c *gin.Context, projectID uint, name string, minRole models.Role, action string) (*models.Environment, bool) {
+	if !verifyProjectRole(c, h.DB, projectID, minRole) {
+		recordAudit(h.AuditService, c, auditRecord{Action: action, ResourceType: "project", ResourceID: projectID,
+			ProjectID: uintPtr(projectID), Result: services.AuditDenied})
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission for this project"})
+		return nil, false
 	}
 
-	c.JSON(http.StatusNoContent, nil) // 204 No Content is standard for successful delete
+	environment, err := services.FindEnvironment(h.DB, projectID, name)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Environment not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+
+	if !verifyEnvironmentRole(c, h.DB, environment, minRole) {
+		recordAudit(h.AuditService, c, auditRecord{Action: action, ResourceType: "environment", ResourceID: environment.ID,
+			ProjectID: uintPtr(projectID), Result: services.AuditDenied, Details: map[string]interface{}{"environment": environment.Name}})
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission for this environment"})
+		return nil, false
+	}
+	return environment, true
+}
+
+// secretETag formats a secret version as a strong ETag
+func secretETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// parseETag parses an If-Match value produced by secretETag.
+// "*" matches any version and yields nil.
+func parseETag(value string) (*int, bool) {
+	value = strings.TrimSpace(value)
+	if value == "*" {
+		return nil, true
+	}
+	version, err := strconv.Atoi(strings.Trim(value, `"`))
+	if err != nil || version < 1 {
+		return nil, false
+	}
+	return &version, true
+}
+
+// parseVersionParam parses the :version path parameter
+func parseVersionParam(c *gin.Context) (int, bool) {
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid version"})
+		return 0, false
+	}
+	return version, true
 }
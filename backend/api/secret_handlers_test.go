@@ -0,0 +1,396 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestGetSecretsReportsValuesBoundElsewhere(t *testing.T) {
+	dek, wrapped := testDataKey(t)
+	password := testSecret(10, "DB_PASSWORD", 2)
+	password.Value = sealTestValue(t, dek, password, "hunter2")
+	// A ciphertext copied from another secret is bound to that secret
+	copied := testSecret(11, "API_TOKEN", 1)
+	copied.Value = password.Value
+	// Values from before binding are only read by the startup migration
+	unbound := testSecret(12, "LEGACY", 1)
+	var err error
+	if unbound.Value, err = services.EncryptWithKey(dek, []byte("legacy value"), nil); err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	s := newTestServer(t)
+	s.expectSecretsRead(wrapped, password, copied, unbound)
+	s.expectAudit("secret.read", services.AuditFailure)
+	s.expectAudit("secret.read", services.AuditFailure)
+
+	w := s.check(http.MethodGet, "/api/projects/7/secrets?environment=staging", 2, nil, nil, http.StatusInternalServerError)
+	var body struct {
+		FailedKeys []string `json:"failed_keys"`
+	}
+	decodeBody(t, w, &body)
+	if len(body.FailedKeys) != 2 || body.FailedKeys[0] != "API_TOKEN" || body.FailedKeys[1] != "LEGACY" {
+		t.Errorf("Expected API_TOKEN and LEGACY to be reported, got %s", w.Body.String())
+	}
+	if strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("Expected no values when a secret cannot be decrypted, got %s", w.Body.String())
+	}
+}
+
+func TestGetSecretsFailsClosedWithoutAudit(t *testing.T) {
+	dek, wrapped := testDataKey(t)
+	password := testSecret(10, "DB_PASSWORD", 1)
+	password.Value = sealTestValue(t, dek, password, "hunter2")
+
+	s := newTestServer(t)
+	s.expectSecretsRead(wrapped, password)
+	s.expectAuditFailure()
+
+	w := s.check(http.MethodGet, "/api/projects/7/secrets?environment=staging", 2, nil, nil, http.StatusServiceUnavailable)
+	if strings.Contains(w.Body.String(), "hunter2") {
+		t.Errorf("Expected no values when the read cannot be audited, got %s", w.Body.String())
+	}
+}
+
+func TestSecretAccessRequiresStepUp(t *testing.T) {
+	requireStepUpWithin(t, 5*time.Minute)
+	stale := time.Now().Add(-10 * time.Minute)
+
+	s := newTestServer(t)
+	s.expectSession(2, &stale)
+	s.expectRole(7, 2, models.RoleReader)
+	s.expectEnvironment(staging)
+	s.expectRole(7, 2, models.RoleReader)
+	s.expectAudit("secret.read", services.AuditDenied)
+	s.check(http.MethodGet, "/api/projects/7/secrets?environment=staging", 2, nil, nil, http.StatusForbidden)
+
+	s = newTestServer(t)
+	s.expectSession(2, nil)
+	s.expectSecret(testSecret(10, "DB_PASSWORD", 2), staging)
+	s.expectRole(7, 2, models.RoleWriter)
+	s.expectAudit("secret.delete", services.AuditDenied)
+	w := s.check(http.MethodDelete, "/api/secrets/10", 2, nil, nil, http.StatusForbidden)
+	var response struct {
+		StepUpRequired bool `json:"step_up_required"`
+	}
+	decodeBody(t, w, &response)
+	if !response.StepUpRequired {
+		t.Errorf("Expected step_up_required in %s", w.Body.String())
+	}
+}
+
+func TestDiffAndPromoteRequireStepUp(t *testing.T) {
+	requireStepUpWithin(t, 5*time.Minute)
+	stale := time.Now().Add(-10 * time.Minute)
+	production := &models.Environment{ID: 4, ProjectID: 7, Name: "production", ReadRole: models.RoleReader, WriteRole: models.RoleWriter}
+
+	// Both decrypt secret values, even though the diff only returns how they compare
+	s := newTestServer(t)
+	s.expectSession(2, &stale)
+	for _, environment := range []*models.Environment{staging, production} {
+		s.expectRole(7, 2, models.RoleWriter)
+		s.expectEnvironment(environment)
+		s.expectRole(7, 2, models.RoleWriter)
+	}
+	s.expectAudit("secret.diff", services.AuditDenied)
+	s.check(http.MethodGet, "/api/secrets/diff?source_project_id=7&source_environment=staging&target_environment=production",
+		2, nil, nil, http.StatusForbidden)
+
+	s = newTestServer(t)
+	s.expectSession(2, &stale)
+	for _, environment := range []*models.Environment{staging, production} {
+		s.expectRole(7, 2, models.RoleWriter)
+		s.expectEnvironment(environment)
+		s.expectRole(7, 2, models.RoleWriter)
+	}
+	s.expectAudit("secret.promote", services.AuditDenied)
+	body := map[string]interface{}{"source_project_id": 7, "source_environment": "staging",
+		"target_environment": "production", "keys": []string{"DB_PASSWORD"}}
+	s.check(http.MethodPost, "/api/secrets/promote", 2, body, nil, http.StatusForbidden)
+}
+
+func TestAPITokenReadsWithoutStepUp(t *testing.T) {
+	// A token cannot make a WebAuthn assertion; its scope bounds what it can read instead
+	requireStepUpWithin(t, 5*time.Minute)
+	dek, wrapped := testDataKey(t)
+	password := testSecret(10, "DB_PASSWORD", 1)
+	password.Value = sealTestValue(t, dek, password, "hunter2")
+
+	s := newTestServer(t)
+	header := s.expectAPIToken(2)
+	s.expectRole(7, 2, models.RoleReader)
+	s.expectEnvironment(staging)
+	s.expectRole(7, 2, models.RoleReader)
+	s.mock.ExpectQuery(`FROM "secrets"`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "key", "value", "current_version", "project_id", "environment_id"}).
+		AddRow(password.ID, password.Key, password.Value, password.CurrentVersion, password.ProjectID, password.EnvironmentID))
+	s.mock.ExpectQuery(`SELECT "id","encrypted_dek","key_shredded_at" FROM "projects"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encrypted_dek", "key_shredded_at"}).AddRow(7, wrapped, nil))
+	s.expectAudit("secret.read", services.AuditSuccess)
+
+	s.check(http.MethodGet, "/api/projects/7/secrets?environment=staging", 0, nil, header, http.StatusOK)
+}
+
+func TestGetSecretReadsOneSecret(t *testing.T) {
+	dek, wrapped := testDataKey(t)
+	password := testSecret(10, "DB_PASSWORD", 2)
+	password.Value = sealTestValue(t, dek, password, "hunter2")
+
+	s := newTestServer(t)
+	s.expectSession(2, nil)
+	s.expectSecret(password, staging)
+	s.expectRole(7, 2, models.RoleReader)
+	s.expectEnvironment(staging)
+	s.mock.ExpectQuery(`SELECT "id","encrypted_dek","key_shredded_at" FROM "projects"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encrypted_dek", "key_shredded_at"}).AddRow(7, wrapped, nil))
+	// The read is audited against the secret, not the whole project
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec(`pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	s.mock.ExpectQuery(`FROM "audit_events"`).WillReturnRows(sqlmock.NewRows([]string{"seq", "hash"}))
+	args := auditArgs("secret.read", services.AuditSuccess)
+	args[5], args[6] = "secret", "10"
+	s.mock.ExpectQuery(`INSERT INTO "audit_events"`).WithArgs(args...).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	s.mock.ExpectCommit()
+
+	w := s.check(http.MethodGet, "/api/secrets/10", 2, nil, nil, http.StatusOK)
+	var secret DecryptedSecret
+	decodeBody(t, w, &secret)
+	if secret.Value != "hunter2" || secret.Environment != "staging" || secret.Version != 2 {
+		t.Errorf("Unexpected secret %+v", secret)
+	}
+	if etag := w.Header().Get("ETag"); etag != `"2"` {
+		t.Errorf("Expected ETag \"2\", got %q", etag)
+	}
+}
+
+func TestUpdateSecretPreconditions(t *testing.T) {
+	value := map[string]string{"value": "new value"}
+	tests := []struct {
+		name    string
+		body    interface{}
+		ifMatch string
+		want    int
+	}{
+		{"missing If-Match", value, "", http.StatusPreconditionRequired},
+		{"invalid If-Match", value, `"first"`, http.StatusBadRequest},
+		{"version zero", value, `"0"`, http.StatusBadRequest},
+		{"nothing to update", map[string]string{}, `"1"`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The secret is not even loaded
+			s := newTestServer(t)
+			s.expectSession(2, nil)
+			header := http.Header{}
+			if tt.ifMatch != "" {
+				header.Set("If-Match", tt.ifMatch)
+			}
+			s.check(http.MethodPut, "/api/secrets/10", 2, tt.body, header, tt.want)
+		})
+	}
+}
+
+func TestUpdateSecretRequiresWriter(t *testing.T) {
+	for _, role := range []models.Role{"", models.RoleReader} {
+		s := newTestServer(t)
+		s.expectSession(2, nil)
+		s.expectSecret(testSecret(10, "DB_PASSWORD", 4), staging)
+		s.expectRole(7, 2, role)
+		s.expectAudit("secret.update", services.AuditDenied)
+
+		header := http.Header{"If-Match": {`"4"`}}
+		s.check(http.MethodPut, "/api/secrets/10", 2, map[string]string{"value": "new value"}, header, http.StatusForbidden)
+	}
+}
+
+func TestUpdateSecretStaleVersion(t *testing.T) {
+	s := newTestServer(t)
+	s.expectSession(2, nil)
+	s.expectSecret(testSecret(10, "DB_PASSWORD", 5), staging)
+	s.expectRole(7, 2, models.RoleWriter)
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery(`FROM "secrets" .* FOR UPDATE`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "key", "current_version", "project_id", "environment_id"}).AddRow(10, "DB_PASSWORD", 5, 7, staging.ID))
+	s.mock.ExpectRollback()
+	s.mock.ExpectQuery(`SELECT "current_version" FROM "secrets"`).WillReturnRows(sqlmock.NewRows([]string{"current_version"}).AddRow(5))
+
+	// Someone else wrote version 5 since this client read version 4
+	header := http.Header{"If-Match": {`"4"`}}
+	w := s.check(http.MethodPut, "/api/secrets/10", 2, map[string]string{"value": "new value"}, header, http.StatusPreconditionFailed)
+	if etag := w.Header().Get("ETag"); etag != `"5"` {
+		t.Errorf("Expected the current ETag \"5\", got %q", etag)
+	}
+	var body struct {
+		CurrentVersion int `json:"current_version"`
+	}
+	decodeBody(t, w, &body)
+	if body.CurrentVersion != 5 {
+		t.Errorf("Expected current_version 5, got %d", body.CurrentVersion)
+	}
+}
+
+func TestSecretRoutesDenyByRole(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   models.Role
+		method string
+		path   string
+		body   interface{}
+		action string
+		secret bool // The route loads the secret before checking the role
+	}{
+		{"non-member reads", "", http.MethodGet, "/api/projects/7/secrets?environment=staging", nil, "secret.read", false},
+		{"reader creates", models.RoleReader, http.MethodPost, "/api/secrets",
+			map[string]interface{}{"project_id": 7, "environment": "staging", "key": "API_KEY", "value": "x"}, "secret.create", false},
+		{"reader deletes", models.RoleReader, http.MethodDelete, "/api/secrets/10", nil, "secret.delete", true},
+		{"reader rolls back", models.RoleReader, http.MethodPost, "/api/secrets/10/versions/1/rollback", nil, "secret.rollback", true},
+		{"non-member lists versions", "", http.MethodGet, "/api/secrets/10/versions", nil, "secret.version.list", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t)
+			s.expectSession(2, nil)
+			if tt.secret {
+				s.expectSecret(testSecret(10, "DB_PASSWORD", 2), staging)
+			}
+			s.expectRole(7, 2, tt.role)
+			s.expectAudit(tt.action, services.AuditDenied)
+			s.check(tt.method, tt.path, 2, tt.body, nil, http.StatusForbidden)
+		})
+	}
+}
+
+func TestEnvironmentRolesRaiseProjectRoles(t *testing.T) {
+	production := &models.Environment{ID: 4, ProjectID: 7, Name: "production", ReadRole: models.RoleWriter, WriteRole: models.RoleAdmin}
+	tests := []struct {
+		name   string
+		role   models.Role
+		method string
+		path   string
+		body   interface{}
+		action string
+	}{
+		{"reader reads production", models.RoleReader, http.MethodGet, "/api/projects/7/secrets?environment=production", nil, "secret.read"},
+		{"writer writes production", models.RoleWriter, http.MethodPost, "/api/secrets",
+			map[string]interface{}{"project_id": 7, "environment": "production", "key": "API_KEY", "value": "x"}, "secret.create"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The project role is enough for the project, but not for this environment
+			s := newTestServer(t)
+			s.expectSession(2, nil)
+			s.expectRole(7, 2, tt.role)
+			s.expectEnvironment(production)
+			s.expectRole(7, 2, tt.role)
+			s.expectAudit(tt.action, services.AuditDenied)
+
+			w := s.check(tt.method, tt.path, 2, tt.body, nil, http.StatusForbidden)
+			if !strings.Contains(w.Body.String(), "environment") {
+				t.Errorf("Expected the environment to be named in the denial, got %s", w.Body.String())
+			}
+		})
+	}
+
+	// Secrets of another secret's environment are checked against that environment
+	s := newTestServer(t)
+	s.expectSession(2, nil)
+	secret := testSecret(10, "DB_PASSWORD", 2)
+	secret.EnvironmentID = production.ID
+	s.expectSecret(secret, production)
+	s.expectRole(7, 2, models.RoleReader)
+	s.expectAudit("secret.version.list", services.AuditDenied)
+	s.check(http.MethodGet, "/api/secrets/10/versions", 2, nil, nil, http.StatusForbidden)
+}
+
+func TestGetSecretsNeedsKnownEnvironment(t *testing.T) {
+	s := newTestServer(t)
+	s.expectSession(2, nil)
+	s.check(http.MethodGet, "/api/projects/7/secrets", 2, nil, nil, http.StatusBadRequest)
+
+	s = newTestServer(t)
+	s.expectSession(2, nil)
+	s.expectRole(7, 2, models.RoleReader)
+	s.mock.ExpectQuery(`FROM "environments"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.check(http.MethodGet, "/api/projects/7/secrets?environment=qa", 2, nil, nil, http.StatusNotFound)
+}
+
+func TestCreateSecretRejectsDuplicateKey(t *testing.T) {
+	body := map[string]interface{}{"project_id": 7, "environment": "staging", "key": "DB_PASSWORD", "value": "x"}
+	expectCreate := func(s *testServer) {
+		s.expectSession(2, nil)
+		s.expectRole(7, 2, models.RoleWriter)
+		s.expectEnvironment(staging)
+		s.expectRole(7, 2, models.RoleWriter)
+		s.mock.ExpectBegin()
+		s.mock.ExpectQuery(`FROM "projects"`).WillReturnRows(sqlmock.NewRows([]string{"id", "organization_id"}).AddRow(7, nil))
+	}
+
+	s := newTestServer(t)
+	expectCreate(s)
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "secrets"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	s.mock.ExpectRollback()
+	s.check(http.MethodPost, "/api/secrets", 2, body, nil, http.StatusConflict)
+
+	// A concurrent write that takes the key first trips the unique index instead
+	s = newTestServer(t)
+	expectCreate(s)
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "secrets"`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectQuery(`INSERT INTO "secrets"`).WillReturnError(&pgconn.PgError{Code: "23505", ConstraintName: "idx_secrets_environment_key"})
+	s.mock.ExpectRollback()
+	s.check(http.MethodPost, "/api/secrets", 2, body, nil, http.StatusConflict)
+}
+
+func TestDiffSecretVersions(t *testing.T) {
+	dek, wrapped := testDataKey(t)
+	secret := testSecret(10, "DB_PASSWORD", 3)
+	expectVersion := func(s *testServer, key string, version int, value string) {
+		sealed := sealTestValue(t, dek, testSecret(10, key, version), value)
+		s.mock.ExpectQuery(`FROM "secret_versions"`).WillReturnRows(sqlmock.NewRows(
+			[]string{"id", "secret_id", "version", "key", "value"}).AddRow(version, 10, version, key, sealed))
+	}
+
+	s := newTestServer(t)
+	s.expectSession(2, nil)
+	s.expectSecret(secret, staging)
+	s.expectRole(7, 2, models.RoleReader)
+	expectVersion(s, "DB_PASS", 1, "hunter1")
+	expectVersion(s, "DB_PASSWORD", 3, "hunter2")
+	s.mock.ExpectQuery(`SELECT "id","encrypted_dek","key_shredded_at" FROM "projects"`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "encrypted_dek", "key_shredded_at"}).AddRow(7, wrapped, nil))
+	s.expectAudit("secret.version.diff", services.AuditSuccess)
+
+	w := s.check(http.MethodGet, "/api/secrets/10/versions/diff?from=1&to=3", 2, nil, nil, http.StatusOK)
+	var diff services.SecretVersionDiff
+	decodeBody(t, w, &diff)
+	want := services.SecretVersionDiff{SecretID: 10, From: 1, To: 3, FromKey: "DB_PASS", ToKey: "DB_PASSWORD", KeyChanged: true,
+		FromValue: "hunter1", ToValue: "hunter2", ValueChanged: true}
+	if diff != want {
+		t.Errorf("Expected %+v, got %+v", want, diff)
+	}
+}
+
+func TestDiffSecretVersionsRequiresStepUp(t *testing.T) {
+	requireStepUpWithin(t, 5*time.Minute)
+	stale := time.Now().Add(-10 * time.Minute)
+
+	// The versions are not even loaded
+	s := newTestServer(t)
+	s.expectSession(2, &stale)
+	s.expectSecret(testSecret(10, "DB_PASSWORD", 3), staging)
+	s.expectRole(7, 2, models.RoleReader)
+	s.expectAudit("secret.version.diff", services.AuditDenied)
+	s.check(http.MethodGet, "/api/secrets/10/versions/diff?from=1&to=3", 2, nil, nil, http.StatusForbidden)
+
+	s = newTestServer(t)
+	s.expectSession(2, nil)
+	s.expectSecret(testSecret(10, "DB_PASSWORD", 3), staging)
+	s.expectRole(7, 2, models.RoleReader)
+	s.check(http.MethodGet, "/api/secrets/10/versions/diff?from=1", 2, nil, nil, http.StatusBadRequest)
+}
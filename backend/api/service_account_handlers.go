@@ -0,0 +1,276 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type ServiceAccountHandler struct {
+	DB                    *gorm.DB
+	ServiceAccountService *services.ServiceAccountService
+	AuditService          *services.AuditService
+}
+
+func NewServiceAccountHandler(db *gorm.DB, serviceAccountService *services.ServiceAccountService, auditService *services.AuditService) *ServiceAccountHandler {
+	return &ServiceAccountHandler{DB: db, ServiceAccountService: serviceAccountService, AuditService: auditService}
+}
+
+type serviceAccountInput struct {
+	Name           string `json:"name" binding:"required"`
+	Description    string `json:"description"`
+	OrganizationID *uint  `json:"organization_id"` // Exactly one of organization_id and project_id
+	ProjectID      *uint  `json:"project_id"`
+}
+
+type serviceAccountKeyInput struct {
+	Name      string     `json:"name" binding:"required"`
+	ExpiresAt *time.Time `json:"expires_at"` // Omit for a key that never expires
+}
+
+// createdServiceAccountKey is returned once, when the plaintext key is still known
+type createdServiceAccountKey struct {
+	models.ServiceAccountKey
+	Key string `json:"key"`
+}
+
+// CreateServiceAccount creates a service account owned by an organization (requires org admin)
+// or a project (requires project admin). It has no access until granted a role on a project.
+func (h *ServiceAccountHandler) CreateServiceAccount(c *gin.Context) {
+	var input serviceAccountInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (input.OrganizationID == nil) == (input.ProjectID == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": services.ErrServiceAccountOwner.Error()})
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	owner := &models.ServiceAccount{OrganizationID: input.OrganizationID, ProjectID: input.ProjectID}
+	if !h.authorizeOwner(c, userID, owner, 0, "service_account.create") {
+		return
+	}
+
+	account, err := h.ServiceAccountService.CreateServiceAccount(input.Name, input.Description, input.OrganizationID, input.ProjectID, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create service account"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "service_account.create", ResourceType: "service_account",
+		ResourceID: account.ID, ProjectID: account.ProjectID, Result: services.AuditSuccess,
+		Details: map[string]interface{}{"name": account.Name, "organization_id": account.OrganizationID}})
+
+	c.JSON(http.StatusCreated, account)
+}
+
+// GetServiceAccounts lists the service accounts of ?organization_id= or ?project_id=
+func (h *ServiceAccountHandler) GetServiceAccounts(c *gin.Context) {
+	orgID, err := optionalUintQuery(c, "organization_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid organization_id"})
+		return
+	}
+	projectID, err := optionalUintQuery(c, "project_id")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid project_id"})
+		return
+	}
+	if (orgID == nil) == (projectID == nil) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Specify either organization_id or project_id"})
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	owner := &models.ServiceAccount{OrganizationID: orgID, ProjectID: projectID}
+	if !h.authorizeOwner(c, userID, owner, 0, "service_account.list") {
+		return
+	}
+
+	accounts, err := h.ServiceAccountService.ListServiceAccounts(orgID, projectID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve service accounts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, accounts)
+}
+
+// GetServiceAccount returns a single service account
+func (h *ServiceAccountHandler) GetServiceAccount(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c, "service_account.read")
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, account)
+}
+
+// DeleteServiceAccount deletes a service account, revoking all of its keys and grants
+func (h *ServiceAccountHandler) DeleteServiceAccount(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c, "service_account.delete")
+	if !ok {
+		return
+	}
+
+	if err := h.ServiceAccountService.DeleteServiceAccount(account.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete service account"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "service_account.delete", ResourceType: "service_account",
+		ResourceID: account.ID, ProjectID: account.ProjectID, Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// CreateKey issues a key for a service account. The key is only shown in this response.
+func (h *ServiceAccountHandler) CreateKey(c *gin.Context) {
+	var input serviceAccountKeyInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	account, ok := h.loadServiceAccount(c, "service_account.key.create")
+	if !ok {
+		return
+	}
+
+	// A key reads secrets without step-up, so minting one needs it
+	var projectID uint
+	if account.ProjectID != nil {
+		projectID = *account.ProjectID
+	}
+	if !requireStepUp(h.AuditService, c, "service_account.key.create", "service_account", account.ID, projectID) {
+		return
+	}
+
+	key, plaintext, err := h.ServiceAccountService.CreateKey(account.ID, input.Name, input.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, services.ErrTokenExpiryInPast) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create key"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "service_account.key.create", ResourceType: "service_account",
+		ResourceID: account.ID, ProjectID: account.ProjectID, Result: services.AuditSuccess,
+		Details: map[string]interface{}{"key_id": key.ID, "name": key.Name}})
+
+	c.JSON(http.StatusCreated, createdServiceAccountKey{ServiceAccountKey: *key, Key: plaintext})
+}
+
+// GetKeys lists a service account's keys without their secret values
+func (h *ServiceAccountHandler) GetKeys(c *gin.Context) {
+	account, ok := h.loadServiceAccount(c, "service_account.key.list")
+	if !ok {
+		return
+	}
+
+	keys, err := h.ServiceAccountService.ListKeys(account.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve keys"})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RevokeKey permanently disables one key of a service account
+func (h *ServiceAccountHandler) RevokeKey(c *gin.Context) {
+	keyID, ok := parseUintParam(c, "keyID", "key ID")
+	if !ok {
+		return
+	}
+
+	account, ok := h.loadServiceAccount(c, "service_account.key.revoke")
+	if !ok {
+		return
+	}
+
+	if err := h.ServiceAccountService.RevokeKey(account.ID, keyID); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Key not found"})
+		case errors.Is(err, services.ErrKeyAlreadyRevoked):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke key"})
+		}
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "service_account.key.revoke", ResourceType: "service_account",
+		ResourceID: account.ID, ProjectID: account.ProjectID, Result: services.AuditSuccess,
+		Details: map[string]interface{}{"key_id": keyID}})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// loadServiceAccount parses :serviceAccountID and checks the caller administers its owner.
+// On failure it writes the error response and returns false. Denials are audited under action.
+func (h *ServiceAccountHandler) loadServiceAccount(c *gin.Context, action string) (*models.ServiceAccount, bool) {
+	serviceAccountID, ok := parseUintParam(c, "serviceAccountID", "service account ID")
+	if !ok {
+		return nil, false
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, false
+	}
+
+	account, err := h.ServiceAccountService.GetServiceAccount(serviceAccountID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Service account not found"})
+			return nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+
+	if !h.authorizeOwner(c, userID, account, account.ID, action) {
+		return nil, false
+	}
+	return account, true
+}
+
+// authorizeOwner checks the user administers the organization or project owning account.
+// On failure it writes the error response and returns false.
+func (h *ServiceAccountHandler) authorizeOwner(c *gin.Context, userID uint, account *models.ServiceAccount, resourceID uint, action string) bool {
+	allowed, err := services.CanManageServiceAccount(h.DB, userID, account)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return false
+	}
+	if !allowed {
+		recordAudit(h.AuditService, c, auditRecord{Action: action, ResourceType: "service_account", ResourceID: resourceID,
+			ProjectID: account.ProjectID, Result: services.AuditDenied,
+			Details: map[string]interface{}{"organization_id": account.OrganizationID}})
+		c.JSON(http.StatusForbidden, gin.H{"error": "You do not have permission to manage these service accounts"})
+		return false
+	}
+	return true
+}
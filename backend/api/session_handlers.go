@@ -0,0 +1,92 @@
+package api
+
+import (
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type SessionHandler struct {
+	SessionService *services.SessionService
+	AuditService   *services.AuditService
+}
+
+func NewSessionHandler(sessionService *services.SessionService, auditService *services.AuditService) *SessionHandler {
+	return &SessionHandler{SessionService: sessionService, AuditService: auditService}
+}
+
+// GetSessions lists the authenticated user's active sessions, marking the current one
+func (h *SessionHandler) GetSessions(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sessions, err := h.SessionService.ListSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sessions"})
+		return
+	}
+
+	currentID, _ := getSessionID(c)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == currentID
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+// RevokeSession signs out one session, e.g. a lost device
+func (h *SessionHandler) RevokeSession(c *gin.Context) {
+	sessionID, ok := parseUintParam(c, "sessionID", "session ID")
+	if !ok {
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.SessionService.RevokeSession(userID, sessionID); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		case errors.Is(err, services.ErrSessionAlreadyRevoked):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session"})
+		}
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "session.revoke", ResourceType: "session", ResourceID: sessionID,
+		Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// RevokeAllSessions signs the user out everywhere, including the current session
+func (h *SessionHandler) RevokeAllSessions(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	revoked, err := h.SessionService.RevokeAllSessions(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke sessions"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "session.revoke_all", ResourceType: "user", ResourceID: userID,
+		Result: services.AuditSuccess, Details: map[string]interface{}{"sessions": revoked}})
+
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
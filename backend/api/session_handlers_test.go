@@ -0,0 +1,26 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSignedOutSessionIsRejected(t *testing.T) {
+	// A revoked or expired session is not found, even though the JWT is still valid
+	s := newTestServer(t)
+	s.mock.ExpectQuery(`FROM "sessions"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	s.check(http.MethodGet, "/api/projects", 2, nil, nil, http.StatusUnauthorized)
+}
+
+func TestDeactivatedAccountIsRejected(t *testing.T) {
+	now := time.Now()
+	s := newTestServer(t)
+	s.mock.ExpectQuery(`FROM "sessions"`).WillReturnRows(sqlmock.NewRows(
+		[]string{"id", "user_id", "created_at", "last_used_at", "expires_at"}).
+		AddRow(testSessionID(2), 2, now, now, now.Add(time.Hour)))
+	s.mock.ExpectQuery(`FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "active"}).AddRow(2, false))
+	s.check(http.MethodGet, "/api/projects", 2, nil, nil, http.StatusUnauthorized)
+}
@@ -0,0 +1,139 @@
+package api
+
+import (
+	"ciphersafe/services"
+	"encoding/hex"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SysHandler struct {
+	SealService  *services.SealService
+	AuditService *services.AuditService
+}
+
+func NewSysHandler(sealService *services.SealService, auditService *services.AuditService) *SysHandler {
+	return &SysHandler{SealService: sealService, AuditService: auditService}
+}
+
+type initInput struct {
+	Shares    int `json:"shares" binding:"required,min=1,max=255"`
+	Threshold int `json:"threshold" binding:"required,min=2"`
+}
+
+type unsealInput struct {
+	Share string `json:"share"` // Hex-encoded key share
+	Reset bool   `json:"reset"` // Discard shares submitted so far
+}
+
+// SealStatus reports whether the server is initialized and sealed
+func (h *SysHandler) SealStatus(c *gin.Context) {
+	status, err := h.SealService.Status()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read seal status"})
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// Init runs the init ceremony and returns the unseal key shares. They are shown only once.
+func (h *SysHandler) Init(c *gin.Context) {
+	var input initInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	shares, err := h.SealService.Initialize(input.Shares, input.Threshold)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSealNotSupported):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrAlreadyInitialized):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "sys.init", ResourceType: "seal", Result: services.AuditSuccess,
+		Details: map[string]interface{}{"shares": input.Shares, "threshold": input.Threshold}})
+
+	encoded := make([]string, len(shares))
+	for i, share := range shares {
+		encoded[i] = hex.EncodeToString(share)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"shares":    encoded,
+		"threshold": input.Threshold,
+	})
+}
+
+// Unseal accepts one unseal key share; the server unseals once the threshold is reached
+func (h *SysHandler) Unseal(c *gin.Context) {
+	var input unsealInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if input.Reset {
+		status, err := h.SealService.ResetUnseal()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset unseal progress"})
+			return
+		}
+		c.JSON(http.StatusOK, status)
+		return
+	}
+
+	share, err := hex.DecodeString(input.Share)
+	if err != nil || len(share) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid key share"})
+		return
+	}
+
+	status, err := h.SealService.SubmitShare(share)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrSealNotSupported), errors.Is(err, services.ErrNotInitialized),
+			errors.Is(err, services.ErrInvalidKeyShares):
+			recordAudit(h.AuditService, c, auditRecord{Action: "sys.unseal", ResourceType: "seal", Result: services.AuditFailure,
+				Details: map[string]interface{}{"reason": err.Error()}})
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unseal"})
+		}
+		return
+	}
+
+	details := map[string]interface{}{"progress": status.Progress, "sealed": status.Sealed}
+	if status.MigrationError != "" {
+		details["migration_error"] = status.MigrationError
+	}
+	recordAudit(h.AuditService, c, auditRecord{Action: "sys.unseal", ResourceType: "seal", Result: services.AuditSuccess, Details: details})
+
+	c.JSON(http.StatusOK, status)
+}
+
+// Seal drops the master key from memory in an emergency
+func (h *SysHandler) Seal(c *gin.Context) {
+	status, err := h.SealService.Seal()
+	if err != nil {
+		if errors.Is(err, services.ErrSealNotSupported) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to seal"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "sys.seal", ResourceType: "seal", Result: services.AuditSuccess})
+
+	c.JSON(http.StatusOK, status)
+}
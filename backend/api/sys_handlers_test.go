@@ -0,0 +1,78 @@
+package api
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/services"
+	"net/http"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestSealRequiresAdmin(t *testing.T) {
+	s := newTestServer(t)
+	s.expectSession(2, nil)
+	s.expectUser(2, "user@example.com")
+	s.check(http.MethodPost, "/sys/seal", 2, nil, nil, http.StatusForbidden)
+
+	s = newTestServer(t)
+	s.check(http.MethodPost, "/sys/seal", 0, nil, nil, http.StatusUnauthorized)
+}
+
+func TestSealedServerRefusesAPI(t *testing.T) {
+	// Without SEAL_MODE=shamir the master key always comes from the key provider; the keyring
+	// dropped by sealing is rebuilt from it once the mode is restored
+	config.AppConfig.SealMode = config.SealModeShamir
+	t.Cleanup(func() { config.AppConfig.SealMode = "" })
+
+	s := newTestServer(t)
+	s.expectSession(1, nil)
+	s.expectUser(1, adminEmail)
+	s.mock.ExpectQuery(`FROM "seal_configs"`).WillReturnRows(sqlmock.NewRows([]string{"id", "shares", "threshold"}).AddRow(1, 5, 3))
+	s.expectAudit("sys.seal", services.AuditSuccess)
+
+	w := s.check(http.MethodPost, "/sys/seal", 1, nil, nil, http.StatusOK)
+	var status services.SealStatus
+	decodeBody(t, w, &status)
+	if !status.Sealed {
+		t.Errorf("Expected the server to report it is sealed, got %+v", status)
+	}
+
+	// Credentials are still checked, but nothing behind them is reachable
+	s.expectSession(2, nil)
+	s.check(http.MethodGet, "/api/projects", 2, nil, nil, http.StatusServiceUnavailable)
+	if _, err := services.MasterKeyring(); err == nil {
+		t.Error("Expected the master keyring to be unavailable while sealed")
+	}
+}
+
+func TestInitRefusesDataUnderAnotherMasterKey(t *testing.T) {
+	config.AppConfig.SealMode = config.SealModeShamir
+	t.Cleanup(func() { config.AppConfig.SealMode = "" })
+
+	// No project holds a data key, but legacy secret values and TOTP secrets still do
+	s := newTestServer(t)
+	s.mock.ExpectQuery(`FROM "seal_configs"`).WillReturnRows(sqlmock.NewRows([]string{"id", "shares", "threshold"}))
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "projects" WHERE encrypted_dek <> ''`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "secrets" WHERE value <> ''`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+	s.mock.ExpectQuery(`SELECT count\(\*\) FROM "users" WHERE totp_secret <> ''`).WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	s.check(http.MethodPost, "/sys/init", 0, map[string]int{"shares": 5, "threshold": 3}, nil, http.StatusBadRequest)
+}
+
+func TestUnsealIsRateLimited(t *testing.T) {
+	limit := config.AppConfig.LoginIPRateLimit
+	config.AppConfig.LoginIPRateLimit = 2
+	t.Cleanup(func() { config.AppConfig.LoginIPRateLimit = limit })
+
+	s := newTestServer(t)
+	s.check(http.MethodPost, "/sys/unseal", 0, nil, nil, http.StatusBadRequest)
+	s.check(http.MethodPost, "/sys/unseal", 0, nil, nil, http.StatusBadRequest)
+	w := s.check(http.MethodPost, "/sys/unseal", 0, nil, nil, http.StatusTooManyRequests)
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header on the throttled unseal")
+	}
+
+	// Init draws on the same budget
+	s.check(http.MethodPost, "/sys/init", 0, nil, nil, http.StatusTooManyRequests)
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type TokenHandler struct {
+	TokenService *services.TokenService
+	AuditService *services.AuditService
+}
+
+func NewTokenHandler(tokenService *services.TokenService, auditService *services.AuditService) *TokenHandler {
+	return &TokenHandler{TokenService: tokenService, AuditService: auditService}
+}
+
+type tokenInput struct {
+	Name       string                 `json:"name" binding:"required"`
+	Permission models.TokenPermission `json:"permission" binding:"required"`
+	ProjectIDs []uint                 `json:"project_ids" binding:"required"`
+	ExpiresAt  *time.Time             `json:"expires_at"` // Omit for a token that never expires
+}
+
+// createdToken is returned once, when the plaintext token is still known
+type createdToken struct {
+	models.APIToken
+	Token string `json:"token"`
+}
+
+// CreateToken issues a personal access token. The token is only shown in this response.
+func (h *TokenHandler) CreateToken(c *gin.Context) {
+	var input tokenInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// A token reads secrets without step-up, so minting one needs it
+	if !requireStepUp(h.AuditService, c, "token.create", "api_token", 0, 0) {
+		return
+	}
+
+	token, plaintext, err := h.TokenService.CreateToken(userID, input.Name, input.Permission, input.ProjectIDs, input.ExpiresAt)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidPermission), errors.Is(err, services.ErrTokenProjects),
+			errors.Is(err, services.ErrTokenExpiryInPast):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrTokenScope):
+			recordAudit(h.AuditService, c, auditRecord{Action: "token.create", ResourceType: "api_token",
+				Result: services.AuditDenied, Details: map[string]interface{}{"project_ids": input.ProjectIDs}})
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create token"})
+		}
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "token.create", ResourceType: "api_token", ResourceID: token.ID,
+		Result: services.AuditSuccess, Details: map[string]interface{}{
+			"name": token.Name, "permission": token.Permission, "project_ids": token.ProjectIDs,
+		}})
+
+	c.JSON(http.StatusCreated, createdToken{APIToken: *token, Token: plaintext})
+}
+
+// GetTokens lists the authenticated user's tokens without their secret values
+func (h *TokenHandler) GetTokens(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	tokens, err := h.TokenService.ListTokens(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve tokens"})
+		return
+	}
+
+	c.JSON(http.StatusOK, tokens)
+}
+
+// GetToken returns a single token's metadata
+func (h *TokenHandler) GetToken(c *gin.Context) {
+	tokenID, ok := parseUintParam(c, "tokenID", "token ID")
+	if !ok {
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	token, err := h.TokenService.GetToken(userID, tokenID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// RevokeToken permanently disables a token
+func (h *TokenHandler) RevokeToken(c *gin.Context) {
+	tokenID, ok := parseUintParam(c, "tokenID", "token ID")
+	if !ok {
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if _, err := h.TokenService.RevokeToken(userID, tokenID); err != nil {
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Token not found"})
+		case errors.Is(err, services.ErrTokenAlreadyRevoked):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke token"})
+		}
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "token.revoke", ResourceType: "api_token", ResourceID: tokenID,
+		Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
+}
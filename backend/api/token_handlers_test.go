@@ -0,0 +1,61 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAPITokensCannotManageAccounts(t *testing.T) {
+	routes := []struct {
+		method string
+		path   string
+	}{
+		{http.MethodGet, "/api/tokens"},
+		{http.MethodPost, "/api/projects"},
+		{http.MethodGet, "/api/projects/7/members"},
+		{http.MethodGet, "/api/orgs"},
+		{http.MethodGet, "/api/admin/keys"},
+		{http.MethodPost, "/sys/seal"},
+	}
+	for _, route := range routes {
+		// Even an admin's token is refused before the account is looked up
+		s := newTestServer(t)
+		header := s.expectAPIToken(1)
+		s.check(route.method, route.path, 0, nil, header, http.StatusForbidden)
+	}
+}
+
+func TestAPITokenScopedToItsProjects(t *testing.T) {
+	// The token's user owns project 8, but the token only covers project 7
+	s := newTestServer(t)
+	header := s.expectAPIToken(2)
+	s.expectRole(8, 2, models.RoleOwner)
+	s.expectAudit("secret.read", services.AuditDenied)
+
+	s.check(http.MethodGet, "/api/projects/8/secrets?environment=staging", 0, nil, header, http.StatusForbidden)
+}
+
+func TestCreateTokenRequiresStepUp(t *testing.T) {
+	requireStepUpWithin(t, 5*time.Minute)
+	stale := time.Now().Add(-10 * time.Minute)
+
+	for _, stepUpAt := range []*time.Time{nil, &stale} {
+		s := newTestServer(t)
+		s.expectSession(2, stepUpAt)
+		s.expectAudit("token.create", services.AuditDenied)
+
+		// Minting the token would insert it next
+		body := map[string]interface{}{"name": "ci", "permission": "read", "project_ids": []uint{7}}
+		w := s.check(http.MethodPost, "/api/tokens", 2, body, nil, http.StatusForbidden)
+		var response struct {
+			StepUpRequired bool `json:"step_up_required"`
+		}
+		decodeBody(t, w, &response)
+		if !response.StepUpRequired {
+			t.Errorf("Expected step_up_required in %s", w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,300 @@
+package api
+
+import (
+	"ciphersafe/models"
+	"ciphersafe/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"gorm.io/gorm"
+)
+
+type WebAuthnHandler struct {
+	DB              *gorm.DB
+	WebAuthnService *services.WebAuthnService
+	SessionService  *services.SessionService
+	UserService     *services.UserService
+	AuditService    *services.AuditService
+}
+
+func NewWebAuthnHandler(db *gorm.DB, webAuthnService *services.WebAuthnService, sessionService *services.SessionService, userService *services.UserService, auditService *services.AuditService) *WebAuthnHandler {
+	return &WebAuthnHandler{DB: db, WebAuthnService: webAuthnService, SessionService: sessionService, UserService: userService, AuditService: auditService}
+}
+
+type finishRegistrationInput struct {
+	Name       string                        `json:"name"`
+	Credential services.RegistrationResponse `json:"credential" binding:"required"`
+}
+
+// BeginLogin returns the options for a passkey login
+func (h *WebAuthnHandler) BeginLogin(c *gin.Context) {
+	options, err := h.WebAuthnService.BeginLogin()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start passkey login"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// FinishLogin verifies a passkey assertion and starts a session. A user-verified passkey is
+// possession plus PIN or biometric, so it satisfies the organization's MFA requirement, and
+// the new session counts as freshly stepped up.
+func (h *WebAuthnHandler) FinishLogin(c *gin.Context) {
+	var input services.AssertionResponse
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	credential, err := h.WebAuthnService.FinishLogin(input)
+	if err != nil {
+		if isWebAuthnRejection(err) {
+			// The credential is not trusted, so the event stays anonymous and names no account
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", Result: services.AuditFailure,
+				Details: map[string]interface{}{"method": models.AuthMethodWebAuthn}})
+		}
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	if err := services.CheckAuthMethod(h.DB, credential.UserID, models.AuthMethodWebAuthn); err != nil {
+		if errors.Is(err, services.ErrAuthMethodDenied) {
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: credential.UserID,
+				Result: services.AuditDenied, Details: map[string]interface{}{"method": models.AuthMethodWebAuthn}})
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+
+	tokens, err := h.SessionService.CreateSession(credential.UserID, c.ClientIP(), c.Request.UserAgent())
+	if err != nil {
+		if errors.Is(err, services.ErrUserDeactivated) {
+			recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: credential.UserID,
+				Result: services.AuditDenied, Details: map[string]interface{}{"method": models.AuthMethodWebAuthn, "reason": err.Error()}})
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+	if err := h.SessionService.MarkStepUp(tokens.Session.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Login failed"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "auth.login", ResourceType: "user", ResourceID: credential.UserID,
+		ActorID: uintPtr(credential.UserID), Result: services.AuditSuccess,
+		Details: map[string]interface{}{"session_id": tokens.Session.ID, "mfa": models.AuthMethodWebAuthn, "credential_id": credential.ID}})
+
+	c.JSON(http.StatusOK, sessionResponse(tokens))
+}
+
+// BeginRegistration returns the options for adding a passkey or security key
+func (h *WebAuthnHandler) BeginRegistration(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	if !h.requireRegistrationStepUp(c, userID) {
+		return
+	}
+
+	user, err := h.UserService.FindUserByID(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	options, err := h.WebAuthnService.BeginRegistration(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start registration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// FinishRegistration verifies the authenticator's response and stores the credential
+func (h *WebAuthnHandler) FinishRegistration(c *gin.Context) {
+	var input finishRegistrationInput
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if !h.requireRegistrationStepUp(c, userID) {
+		return
+	}
+
+	name := strings.TrimSpace(input.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	credential, err := h.WebAuthnService.FinishRegistration(userID, name, input.Credential)
+	if err != nil {
+		if isWebAuthnRejection(err) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "webauthn.register", ResourceType: "webauthn_credential",
+		ResourceID: credential.ID, Result: services.AuditSuccess, Details: map[string]interface{}{"name": credential.Name}})
+
+	c.JSON(http.StatusCreated, credential)
+}
+
+// requireRegistrationStepUp requires a step-up to add a credential once the user has one,
+// so a hijacked session cannot enroll its own key and pass step-up with it. The first
+// credential needs none, since there is nothing to step up with yet.
+func (h *WebAuthnHandler) requireRegistrationStepUp(c *gin.Context, userID uint) bool {
+	credentials, err := h.WebAuthnService.ListCredentials(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve credentials"})
+		return false
+	}
+	if len(credentials) == 0 {
+		return true
+	}
+	return requireStepUp(h.AuditService, c, "webauthn.register", "user", userID, 0)
+}
+
+// GetCredentials lists the authenticated user's passkeys and security keys
+func (h *WebAuthnHandler) GetCredentials(c *gin.Context) {
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	credentials, err := h.WebAuthnService.ListCredentials(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve credentials"})
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// DeleteCredential removes one of the authenticated user's credentials
+func (h *WebAuthnHandler) DeleteCredential(c *gin.Context) {
+	credentialID, ok := parseUintParam(c, "credentialID", "credential ID")
+	if !ok {
+		return
+	}
+
+	userID, exists := getUserID(c)
+	if !exists {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if err := h.WebAuthnService.DeleteCredential(userID, credentialID); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Credential not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete credential"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "webauthn.delete", ResourceType: "webauthn_credential",
+		ResourceID: credentialID, Result: services.AuditSuccess})
+
+	c.JSON(http.StatusNoContent, nil)
+}
+
+// BeginStepUp returns the options for re-authenticating the current session
+func (h *WebAuthnHandler) BeginStepUp(c *gin.Context) {
+	userID, sessionID, ok := currentSession(c)
+	if !ok {
+		return
+	}
+
+	options, err := h.WebAuthnService.BeginStepUp(userID, sessionID)
+	if err != nil {
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"publicKey": options})
+}
+
+// FinishStepUp verifies the assertion and marks the current session as recently re-authenticated
+func (h *WebAuthnHandler) FinishStepUp(c *gin.Context) {
+	var input services.AssertionResponse
+	if err := c.ShouldBindJSON(&input); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID, sessionID, ok := currentSession(c)
+	if !ok {
+		return
+	}
+
+	credential, err := h.WebAuthnService.FinishStepUp(userID, sessionID, input)
+	if err != nil {
+		if isWebAuthnRejection(err) {
+			recordAudit(h.AuditService, c, auditRecord{Action: "webauthn.step_up", ResourceType: "session",
+				ResourceID: sessionID, Result: services.AuditFailure})
+		}
+		respondWebAuthnError(c, err)
+		return
+	}
+
+	if err := h.SessionService.MarkStepUp(sessionID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record step-up"})
+		return
+	}
+
+	recordAudit(h.AuditService, c, auditRecord{Action: "webauthn.step_up", ResourceType: "session", ResourceID: sessionID,
+		Result: services.AuditSuccess, Details: map[string]interface{}{"credential_id": credential.ID}})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Step-up verified"})
+}
+
+// currentSession returns the user and session of a JWT-authenticated request
+func currentSession(c *gin.Context) (uint, uint, bool) {
+	userID, exists := getUserID(c)
+	sessionID, hasSession := getSessionID(c)
+	if !exists || !hasSession {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return 0, 0, false
+	}
+	return userID, sessionID, true
+}
+
+// isWebAuthnRejection reports whether err means the authenticator's response was refused,
+// as opposed to a server failure
+func isWebAuthnRejection(err error) bool {
+	return errors.Is(err, services.ErrWebAuthnVerification) || errors.Is(err, services.ErrWebAuthnChallenge) ||
+		errors.Is(err, services.ErrUnknownCredential)
+}
+
+// respondWebAuthnError maps WebAuthn service errors to HTTP responses
+func respondWebAuthnError(c *gin.Context, err error) {
+	switch {
+	case isWebAuthnRejection(err):
+		c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrNoWebAuthnCredentials):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "WebAuthn request failed"})
+	}
+}
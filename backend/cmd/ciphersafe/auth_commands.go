@@ -0,0 +1,156 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// loginResponse is the response of /auth/login: a session, or a request for a second factor
+type loginResponse struct {
+	sessionTokens
+	MFARequired           bool   `json:"mfa_required"`
+	MFAToken              string `json:"mfa_token"`
+	MFAEnrollmentRequired bool   `json:"mfa_enrollment_required"`
+}
+
+func (a *app) login(args []string) error {
+	fs := a.flags("login", "login [-email EMAIL] [-password-stdin] [-code CODE]\n       ciphersafe login -with-token < token")
+	email := fs.String("email", "", "Account email, prompted for when omitted")
+	passwordStdin := fs.Bool("password-stdin", false, "Read the password from standard input")
+	code := fs.String("code", "", "Two-factor code or recovery code, prompted for when needed")
+	withToken := fs.Bool("with-token", false, "Log in with an API token or service account key read from standard input")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("login takes no arguments")
+	}
+
+	// Log in to the -server or $CIPHERSAFE_SERVER given, or else the stored server
+	config := &Config{Server: a.config.Server}
+	client := newClient(config, nil)
+
+	if *withToken {
+		token, err := a.readSecret("Token: ")
+		if err != nil {
+			return err
+		}
+		config.Token = strings.TrimSpace(token)
+		if config.Token == "" {
+			return usagef("no token given")
+		}
+		if err := client.getJSON("/api/projects", nil, &[]struct{}{}); err != nil {
+			return err
+		}
+		return a.saveLogin(config)
+	}
+
+	if *email == "" {
+		if !a.interactive {
+			return usagef("-email is required when standard input is not a terminal")
+		}
+		var err error
+		if *email, err = a.prompt("Email: "); err != nil {
+			return err
+		}
+	}
+	var password string
+	var err error
+	if *passwordStdin {
+		password, err = a.readStdin()
+	} else {
+		password, err = a.readSecret("Password: ")
+	}
+	if err != nil {
+		return err
+	}
+
+	var response loginResponse
+	data, _, err := client.request(http.MethodPost, "/auth/login", nil, map[string]string{"email": *email, "password": password}, nil, "")
+	if err == nil {
+		err = decodeResponse(data, "/auth/login", &response)
+	}
+	if err != nil {
+		return err
+	}
+
+	if response.MFARequired {
+		if response.MFAEnrollmentRequired {
+			return errors.New("this account must set up two-factor authentication in the dashboard before logging in")
+		}
+		if *code == "" {
+			if !a.interactive {
+				return usagef("-code is required for this account when standard input is not a terminal")
+			}
+			if *code, err = a.prompt("Two-factor code: "); err != nil {
+				return err
+			}
+		}
+		data, _, err := client.request(http.MethodPost, "/auth/mfa/verify", nil, map[string]string{"mfa_token": response.MFAToken, "code": *code}, nil, "")
+		if err == nil {
+			err = decodeResponse(data, "/auth/mfa/verify", &response.sessionTokens)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	config.Email = *email
+	config.setSession(response.sessionTokens)
+	return a.saveLogin(config)
+}
+
+// saveLogin replaces the stored login with config
+func (a *app) saveLogin(config *Config) error {
+	if err := saveConfig(a.configPath, config); err != nil {
+		return err
+	}
+	if os.Getenv("CIPHERSAFE_TOKEN") != "" {
+		fmt.Fprintln(a.stderr, "Warning: CIPHERSAFE_TOKEN is set and takes precedence over this login")
+	}
+
+	if a.jsonOutput {
+		return a.printJSON(map[string]string{"server": config.Server, "email": config.Email})
+	}
+	if config.Email != "" {
+		fmt.Fprintf(a.stdout, "Logged in to %s as %s\n", config.Server, config.Email)
+	} else {
+		fmt.Fprintf(a.stdout, "Logged in to %s with a token\n", config.Server)
+	}
+	return nil
+}
+
+func (a *app) logout(args []string) error {
+	fs := a.flags("logout", "logout")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+
+	// Password logins end their server session; tokens stay valid until revoked
+	var revokeErr error
+	if a.stored.RefreshToken != "" {
+		client := newClient(a.stored, func(sessionTokens) error { return nil })
+		revokeErr = client.sendJSON(http.MethodPost, "/auth/logout", nil, nil)
+		var apiErr *APIError
+		if errors.As(revokeErr, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+			revokeErr = nil // Already ended
+		}
+	}
+
+	a.stored.clearCredentials()
+	if err := saveConfig(a.configPath, a.stored); err != nil {
+		return err
+	}
+	if revokeErr != nil {
+		return fmt.Errorf("forgot the stored credentials, but could not end the session on the server: %w", revokeErr)
+	}
+
+	if a.jsonOutput {
+		return a.printJSON(map[string]bool{"logged_out": true})
+	}
+	fmt.Fprintln(a.stdout, "Logged out")
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var errNotLoggedIn = errors.New("not logged in; run ciphersafe login")
+
+// refreshMargin is how long before expiry an access token is refreshed
+const refreshMargin = 30 * time.Second
+
+// APIError is an error response from the server
+type APIError struct {
+	Status         int
+	Message        string
+	StepUpRequired bool
+}
+
+func (e *APIError) Error() string {
+	if e.StepUpRequired {
+		return e.Message + " (step-up needs a browser; use an API token for the CLI)"
+	}
+	return e.Message
+}
+
+// Client talks to the REST API with a server and credentials. Password logins are refreshed
+// when the access token expires.
+type Client struct {
+	config *Config
+	// onRefresh stores the tokens of a refresh. The server rotates refresh tokens and revokes
+	// sessions whose old refresh token is reused, so they must be kept.
+	onRefresh func(sessionTokens) error
+	http      *http.Client
+}
+
+// sessionTokens is the response of /auth/login, /auth/mfa/verify and /auth/refresh
+type sessionTokens struct {
+	Token        string `json:"token"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+	SessionID    uint   `json:"session_id"`
+}
+
+func newClient(config *Config, onRefresh func(sessionTokens) error) *Client {
+	return &Client{config: config, onRefresh: onRefresh, http: &http.Client{Timeout: 60 * time.Second}}
+}
+
+// getJSON sends a GET request and decodes the JSON response into out
+func (c *Client) getJSON(path string, query url.Values, out interface{}) error {
+	return c.doJSON(http.MethodGet, path, query, nil, nil, out)
+}
+
+// sendJSON sends body as JSON and decodes the JSON response into out, if out is not nil
+func (c *Client) sendJSON(method, path string, body, out interface{}) error {
+	return c.doJSON(method, path, nil, body, nil, out)
+}
+
+func (c *Client) doJSON(method, path string, query url.Values, body interface{}, header http.Header, out interface{}) error {
+	data, _, err := c.do(method, path, query, body, header)
+	if err != nil {
+		return err
+	}
+	if out == nil || len(data) == 0 {
+		return nil
+	}
+	return decodeResponse(data, path, out)
+}
+
+func decodeResponse(data []byte, path string, out interface{}) error {
+	if err := json.Unmarshal(data, out); err != nil {
+		return fmt.Errorf("unexpected response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// do sends an authenticated request, refreshing the session once if the server rejects an
+// expired access token, and returns the response body of a 2xx response
+func (c *Client) do(method, path string, query url.Values, body interface{}, header http.Header) ([]byte, http.Header, error) {
+	if c.config.Token == "" {
+		return nil, nil, errNotLoggedIn
+	}
+	if c.config.RefreshToken != "" && !c.config.ExpiresAt.IsZero() && time.Until(c.config.ExpiresAt) < refreshMargin {
+		if err := c.refresh(); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	data, responseHeader, err := c.request(method, path, query, body, header, c.config.Token)
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized && c.config.RefreshToken != "" {
+		if err := c.refresh(); err != nil {
+			return nil, nil, err
+		}
+		data, responseHeader, err = c.request(method, path, query, body, header, c.config.Token)
+	}
+	return data, responseHeader, err
+}
+
+// refresh exchanges the refresh token for new tokens
+func (c *Client) refresh() error {
+	var tokens sessionTokens
+	data, _, err := c.request(http.MethodPost, "/auth/refresh", nil, map[string]string{"refresh_token": c.config.RefreshToken}, nil, "")
+	if err != nil {
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && apiErr.Status == http.StatusUnauthorized {
+			return fmt.Errorf("session expired; run ciphersafe login: %w", err)
+		}
+		return err
+	}
+	if err := decodeResponse(data, "/auth/refresh", &tokens); err != nil {
+		return err
+	}
+
+	c.config.setSession(tokens)
+	if c.onRefresh != nil {
+		return c.onRefresh(tokens)
+	}
+	return nil
+}
+
+// request sends one request. Non-2xx responses become an *APIError.
+func (c *Client) request(method, path string, query url.Values, body interface{}, header http.Header, token string) ([]byte, http.Header, error) {
+	target := strings.TrimRight(c.config.Server, "/") + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return nil, nil, err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(method, target, reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.Header.Set("User-Agent", "ciphersafe-cli")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &APIError{Status: resp.StatusCode, Message: resp.Status}
+		var payload struct {
+			Error          string `json:"error"`
+			StepUpRequired bool   `json:"step_up_required"`
+		}
+		if json.Unmarshal(data, &payload) == nil && payload.Error != "" {
+			apiErr.Message = payload.Error
+			apiErr.StepUpRequired = payload.StepUpRequired
+		}
+		return nil, nil, apiErr
+	}
+	return data, resp.Header, nil
+}
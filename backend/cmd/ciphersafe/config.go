@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// defaultServer is used until login is given --server or CIPHERSAFE_SERVER
+const defaultServer = "http://localhost:8080"
+
+// Config is what the CLI keeps between runs. It holds credentials, so it is written with
+// 0600 permissions and refused when other users can read it.
+type Config struct {
+	Server       string    `json:"server"`
+	Email        string    `json:"email,omitempty"`
+	Token        string    `json:"token,omitempty"`         // Access token, API token or service account key
+	RefreshToken string    `json:"refresh_token,omitempty"` // Only for password logins
+	ExpiresAt    time.Time `json:"expires_at,omitempty"`    // When the access token expires
+}
+
+// defaultConfigPath is $CIPHERSAFE_CONFIG, or ciphersafe/config.json in the user config directory
+func defaultConfigPath() (string, error) {
+	if path := os.Getenv("CIPHERSAFE_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("cannot find a config directory, set CIPHERSAFE_CONFIG: %w", err)
+	}
+	return filepath.Join(dir, "ciphersafe", "config.json"), nil
+}
+
+// loadConfig reads the config file. A missing file gives an empty config.
+func loadConfig(path string) (*Config, error) {
+	cfg := &Config{}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.Mode().Perm()&0o077 != 0 {
+			return nil, fmt.Errorf("%s is accessible by other users; run chmod 600 %s", path, path)
+		}
+	}
+
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// saveConfig replaces the config file, creating its directory with 0700 and the file with 0600
+func saveConfig(path string, cfg *Config) error {
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+
+	// Write a temporary file and rename it, so a failed write never leaves half a config
+	tmp, err := os.CreateTemp(dir, ".config-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0o600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// clearCredentials forgets the stored login but keeps the server
+func (cfg *Config) clearCredentials() {
+	cfg.Email = ""
+	cfg.Token = ""
+	cfg.RefreshToken = ""
+	cfg.ExpiresAt = time.Time{}
+}
+
+// setSession stores the tokens of a password login or refresh
+func (cfg *Config) setSession(tokens sessionTokens) {
+	cfg.Token = tokens.Token
+	cfg.RefreshToken = tokens.RefreshToken
+	cfg.ExpiresAt = time.Now().Add(time.Duration(tokens.ExpiresIn) * time.Second)
+}
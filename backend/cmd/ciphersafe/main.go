@@ -0,0 +1,288 @@
+// Command ciphersafe is a command-line client for the CipherSafe REST API.
+//
+//	ciphersafe -server https://vault.example.com login -email me@example.com
+//	ciphersafe secrets set -p billing -e staging DB_PASSWORD < password.txt
+//	ciphersafe export -p billing -e production -format kubernetes | kubectl apply -f -
+//
+// Run ciphersafe help for the list of commands.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+)
+
+// Exit codes, so scripts can tell failures apart
+const (
+	exitOK        = 0
+	exitError     = 1 // Any other failure, e.g. the server could not be reached
+	exitUsage     = 2 // Invalid command line
+	exitAuth      = 3 // Not logged in, or the credentials were rejected
+	exitForbidden = 4 // Logged in, but not allowed
+	exitNotFound  = 5 // No such project, environment or secret
+	exitConflict  = 6 // The secret changed meanwhile, or an import conflicts with existing values
+)
+
+const usage = `Usage: ciphersafe [-server URL] [-json] <command> [flags] [arguments]
+
+Commands:
+  login                       Log in with an email and password, or with -with-token
+  logout                      End the session and forget the stored credentials
+  projects list               List the projects you can access
+  projects create NAME        Create a project
+  secrets list                List the keys of an environment (-show for values)
+  secrets get KEY             Print the value of a secret
+  secrets set KEY [VALUE]     Create or update a secret; the value is read from stdin when omitted
+  secrets delete KEY          Delete a secret
+  import FILE                 Import a dotenv, JSON or YAML file into an environment
+  export                      Export an environment as dotenv, json, yaml, shell, kubernetes or docker
+
+Secret, import and export commands take -p PROJECT (name or ID) and -e ENVIRONMENT, which
+default to $CIPHERSAFE_PROJECT and $CIPHERSAFE_ENVIRONMENT. Run ciphersafe <command> -h for
+the flags of a command.
+
+Credentials are stored in $CIPHERSAFE_CONFIG (default: ciphersafe/config.json in the user
+config directory) with 0600 permissions. $CIPHERSAFE_TOKEN and $CIPHERSAFE_SERVER take
+precedence over the stored login, e.g. for an API token in CI.
+`
+
+var errNotFound = errors.New("not found")
+
+// usageError is an invalid command line. An empty message means the flag package already
+// reported it.
+type usageError struct {
+	message string
+}
+
+func (e *usageError) Error() string {
+	return e.message
+}
+
+func usagef(format string, args ...interface{}) error {
+	return &usageError{message: fmt.Sprintf(format, args...)}
+}
+
+// app is one run of the CLI
+type app struct {
+	stdin       *bufio.Reader
+	stdout      io.Writer
+	stderr      io.Writer
+	interactive bool // stdin is a terminal, so input can be prompted for
+
+	configPath string
+	stored     *Config // The config file
+	config     *Config // stored with the -server flag and environment variables applied
+	server     string  // -server
+	jsonOutput bool
+}
+
+func main() {
+	a := &app{stdin: bufio.NewReader(os.Stdin), stdout: os.Stdout, stderr: os.Stderr, interactive: stdinIsTerminal()}
+	os.Exit(a.run(os.Args[1:]))
+}
+
+// run executes a command line and returns the exit code
+func (a *app) run(args []string) int {
+	err := a.dispatch(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return exitOK
+	}
+
+	code := exitCode(err)
+	var usageErr *usageError
+	if errors.As(err, &usageErr) && usageErr.message == "" {
+		return code
+	}
+	if a.jsonOutput {
+		out := map[string]interface{}{"error": err.Error(), "exit_code": code}
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			out["status"] = apiErr.Status
+		}
+		encoded, _ := json.Marshal(out)
+		fmt.Fprintln(a.stderr, string(encoded))
+	} else {
+		fmt.Fprintln(a.stderr, "ciphersafe:", err)
+	}
+	if errors.As(err, &usageErr) {
+		fmt.Fprintln(a.stderr, "Run ciphersafe help for usage.")
+	}
+	return code
+}
+
+func (a *app) dispatch(args []string) error {
+	global := flag.NewFlagSet("ciphersafe", flag.ContinueOnError)
+	global.SetOutput(a.stderr)
+	global.Usage = func() { fmt.Fprint(a.stderr, usage) }
+	global.StringVar(&a.server, "server", "", "Server URL")
+	global.StringVar(&a.configPath, "config", "", "Config file")
+	global.BoolVar(&a.jsonOutput, "json", false, "Print JSON")
+	if err := a.parse(global, args); err != nil {
+		return err
+	}
+	args = global.Args()
+	if len(args) == 0 || args[0] == "help" {
+		fmt.Fprint(a.stdout, usage)
+		return nil
+	}
+
+	if err := a.loadConfig(); err != nil {
+		return err
+	}
+
+	command, args := args[0], args[1:]
+	switch command {
+	case "login":
+		return a.login(args)
+	case "logout":
+		return a.logout(args)
+	case "projects":
+		return a.subcommand("projects", args, map[string]func([]string) error{
+			"list":   a.listProjects,
+			"create": a.createProject,
+		})
+	case "secrets":
+		return a.subcommand("secrets", args, map[string]func([]string) error{
+			"list":   a.listSecrets,
+			"get":    a.getSecret,
+			"set":    a.setSecret,
+			"delete": a.deleteSecret,
+		})
+	case "import":
+		return a.importSecrets(args)
+	case "export":
+		return a.exportSecrets(args)
+	}
+	return usagef("unknown command %q", command)
+}
+
+func (a *app) subcommand(command string, args []string, commands map[string]func([]string) error) error {
+	if len(args) == 0 {
+		return usagef("%s needs a subcommand", command)
+	}
+	run, ok := commands[args[0]]
+	if !ok {
+		return usagef("unknown command %q", command+" "+args[0])
+	}
+	return run(args[1:])
+}
+
+// loadConfig reads the config file and applies -server, CIPHERSAFE_SERVER and CIPHERSAFE_TOKEN
+func (a *app) loadConfig() error {
+	if a.configPath == "" {
+		path, err := defaultConfigPath()
+		if err != nil {
+			return err
+		}
+		a.configPath = path
+	}
+	stored, err := loadConfig(a.configPath)
+	if err != nil {
+		return err
+	}
+	a.stored = stored
+
+	config := *stored
+	if config.Server == "" {
+		config.Server = defaultServer
+	}
+	server := a.server
+	if server == "" {
+		server = os.Getenv("CIPHERSAFE_SERVER")
+	}
+	if server != "" && strings.TrimRight(server, "/") != strings.TrimRight(config.Server, "/") {
+		// Never send the stored credentials to a different server
+		config.Server = server
+		config.clearCredentials()
+	}
+	if token := os.Getenv("CIPHERSAFE_TOKEN"); token != "" {
+		config.clearCredentials()
+		config.Token = token
+	}
+	a.config = &config
+	return nil
+}
+
+// client returns a client for the configured server and credentials. Refreshed tokens are
+// saved to the config file.
+func (a *app) client() *Client {
+	return newClient(a.config, func(tokens sessionTokens) error {
+		a.stored.setSession(tokens)
+		return saveConfig(a.configPath, a.stored)
+	})
+}
+
+// flags creates the flag set of a command. Every command also takes -json.
+func (a *app) flags(name, synopsis string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.BoolVar(&a.jsonOutput, "json", a.jsonOutput, "Print JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(a.stderr, "Usage: ciphersafe %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func (a *app) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return &usageError{}
+	}
+	return nil
+}
+
+// printJSON writes v to stdout as indented JSON
+func (a *app) printJSON(v interface{}) error {
+	encoded, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(a.stdout, string(encoded))
+	return err
+}
+
+// printTable writes rows as aligned columns, the first row being the header
+func (a *app) printTable(rows [][]string) error {
+	w := tabwriter.NewWriter(a.stdout, 0, 4, 2, ' ', 0)
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// exitCode maps an error to the exit code scripts see
+func exitCode(err error) int {
+	var usageErr *usageError
+	var apiErr *APIError
+	switch {
+	case errors.As(err, &usageErr):
+		return exitUsage
+	case errors.Is(err, errNotLoggedIn):
+		return exitAuth
+	case errors.Is(err, errNotFound):
+		return exitNotFound
+	case errors.As(err, &apiErr):
+		switch apiErr.Status {
+		case http.StatusUnauthorized:
+			return exitAuth
+		case http.StatusForbidden:
+			return exitForbidden
+		case http.StatusNotFound:
+			return exitNotFound
+		case http.StatusConflict, http.StatusPreconditionFailed:
+			return exitConflict
+		}
+	}
+	return exitError
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"ciphersafe/services"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeServer serves the few endpoints the CLI uses, with one project and one secret
+type fakeServer struct {
+	mu            sync.Mutex
+	accessToken   string
+	refreshToken  string
+	version       int
+	value         string
+	lastIfMatch   string
+	refreshes     int
+	authorization []string
+	paths         []string
+}
+
+func (f *fakeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.authorization = append(f.authorization, r.Header.Get("Authorization"))
+	f.paths = append(f.paths, r.Method+" "+r.URL.Path)
+
+	respond := func(status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body)
+	}
+	switch r.URL.Path {
+	case "/auth/login":
+		var input map[string]string
+		json.NewDecoder(r.Body).Decode(&input)
+		if input["password"] != "correct horse" {
+			respond(http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
+			return
+		}
+		respond(http.StatusOK, map[string]interface{}{"token": f.accessToken, "expires_in": 900, "refresh_token": f.refreshToken})
+		return
+	case "/auth/refresh":
+		var input map[string]string
+		json.NewDecoder(r.Body).Decode(&input)
+		if input["refresh_token"] != f.refreshToken {
+			respond(http.StatusUnauthorized, map[string]string{"error": "invalid refresh token"})
+			return
+		}
+		f.refreshes++
+		f.accessToken, f.refreshToken = "access-refreshed", "refresh-rotated"
+		respond(http.StatusOK, map[string]interface{}{"token": f.accessToken, "expires_in": 900, "refresh_token": f.refreshToken})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer "+f.accessToken {
+		respond(http.StatusUnauthorized, map[string]string{"error": "token expired"})
+		return
+	}
+	switch {
+	case r.URL.Path == "/api/projects":
+		respond(http.StatusOK, []map[string]interface{}{{"ID": 7, "name": "billing", "role": "owner"}})
+	case r.URL.Path == "/api/projects/7/secrets" && r.URL.Query().Get("environment") == "staging":
+		respond(http.StatusOK, []map[string]interface{}{{"id": 3, "key": "DB_PASSWORD", "value": f.value, "environment": "staging", "version": f.version}})
+	case r.URL.Path == "/api/projects/7/secrets/keys" && r.URL.Query().Get("environment") == "staging":
+		respond(http.StatusOK, []map[string]interface{}{{"id": 3, "key": "DB_PASSWORD", "environment": "staging", "version": f.version}})
+	case r.URL.Path == "/api/projects/7/secrets", r.URL.Path == "/api/projects/7/secrets/keys":
+		respond(http.StatusNotFound, map[string]string{"error": "Environment not found"})
+	case r.URL.Path == "/api/secrets/3" && r.Method == http.MethodGet:
+		respond(http.StatusOK, map[string]interface{}{"id": 3, "key": "DB_PASSWORD", "value": f.value, "environment": "staging", "version": f.version})
+	case r.URL.Path == "/api/secrets/3" && r.Method == http.MethodPut:
+		f.lastIfMatch = r.Header.Get("If-Match")
+		if f.lastIfMatch != strconv.Quote(strconv.Itoa(f.version)) {
+			respond(http.StatusPreconditionFailed, map[string]string{"error": "secret was modified"})
+			return
+		}
+		var input map[string]string
+		json.NewDecoder(r.Body).Decode(&input)
+		f.value = input["value"]
+		f.version++
+		respond(http.StatusOK, map[string]interface{}{"id": 3, "key": "DB_PASSWORD", "version": f.version})
+	default:
+		respond(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+}
+
+// runCLI runs a command line with stdin and returns the exit code, stdout and stderr
+func runCLI(t *testing.T, configPath, stdin string, args ...string) (int, string, string) {
+	t.Helper()
+	for _, name := range []string{"CIPHERSAFE_SERVER", "CIPHERSAFE_TOKEN", "CIPHERSAFE_PROJECT", "CIPHERSAFE_ENVIRONMENT"} {
+		t.Setenv(name, "")
+	}
+	var stdout, stderr bytes.Buffer
+	a := &app{stdin: bufio.NewReader(strings.NewReader(stdin)), stdout: &stdout, stderr: &stderr}
+	code := a.run(append([]string{"-config", configPath}, args...))
+	return code, stdout.String(), stderr.String()
+}
+
+func TestLoginStoresCredentialsPrivately(t *testing.T) {
+	fake := &fakeServer{accessToken: "access-1", refreshToken: "refresh-1", version: 1, value: "old"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	configPath := filepath.Join(t.TempDir(), "ciphersafe", "config.json")
+
+	code, _, stderr := runCLI(t, configPath, "wrong\n", "-server", server.URL, "login", "-email", "me@example.com", "-password-stdin")
+	if code != exitAuth {
+		t.Fatalf("Expected exit code %d for a wrong password, got %d (%s)", exitAuth, code, stderr)
+	}
+
+	code, stdout, stderr := runCLI(t, configPath, "correct horse\n", "-server", server.URL, "login", "-email", "me@example.com", "-password-stdin")
+	if code != exitOK {
+		t.Fatalf("Login failed with exit code %d: %s", code, stderr)
+	}
+	if !strings.Contains(stdout, "me@example.com") {
+		t.Errorf("Expected the login message to name the account, got %q", stdout)
+	}
+
+	info, err := os.Stat(configPath)
+	if err != nil {
+		t.Fatalf("Config file not written: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0o600 {
+		t.Errorf("Expected config permissions 0600, got %o", perm)
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if cfg.Server != server.URL || cfg.Token != "access-1" || cfg.RefreshToken != "refresh-1" {
+		t.Errorf("Unexpected stored config: %+v", cfg)
+	}
+
+	os.Chmod(configPath, 0o644)
+	if code, _, _ := runCLI(t, configPath, "", "projects", "list"); code != exitError {
+		t.Errorf("Expected a config readable by others to be refused, got exit code %d", code)
+	}
+}
+
+func TestSecretsSetRefreshesAndUpdates(t *testing.T) {
+	fake := &fakeServer{accessToken: "access-2", refreshToken: "refresh-1", version: 4, value: "old"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	// The stored access token is stale, so the first request is refused and refreshed
+	if err := saveConfig(configPath, &Config{Server: server.URL, Token: "access-1", RefreshToken: "refresh-1"}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	code, stdout, stderr := runCLI(t, configPath, "new value\n", "-json", "secrets", "set", "-p", "billing", "-e", "staging", "DB_PASSWORD")
+	if code != exitOK {
+		t.Fatalf("secrets set failed with exit code %d: %s", code, stderr)
+	}
+	var result setResult
+	if err := json.Unmarshal([]byte(stdout), &result); err != nil {
+		t.Fatalf("Expected JSON output, got %q: %v", stdout, err)
+	}
+	if result.Result != "updated" || result.Version != 5 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+	if fake.value != "new value" || fake.lastIfMatch != `"4"` {
+		t.Errorf("Expected the value to be written with If-Match \"4\", got %q with %q", fake.value, fake.lastIfMatch)
+	}
+
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if fake.refreshes != 1 || cfg.Token != "access-refreshed" || cfg.RefreshToken != "refresh-rotated" {
+		t.Errorf("Expected one refresh with the rotated tokens stored, got %d refreshes and %+v", fake.refreshes, cfg)
+	}
+
+	code, stdout, _ = runCLI(t, configPath, "", "secrets", "get", "-p", "7", "-e", "staging", "DB_PASSWORD")
+	if code != exitOK || stdout != "new value\n" {
+		t.Errorf("Expected secrets get to print the value, got exit code %d and %q", code, stdout)
+	}
+
+	// Setting and getting a secret never read the values of the whole environment
+	for _, path := range fake.paths {
+		if path == "GET /api/projects/7/secrets" {
+			t.Errorf("Expected only single-secret reads, got %q", fake.paths)
+			break
+		}
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	fake := &fakeServer{accessToken: "access-1", refreshToken: "refresh-1", version: 1, value: "old"}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	if err := saveConfig(configPath, &Config{Server: server.URL, Token: "access-1"}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"unknown command", []string{"rotate"}, exitUsage},
+		{"missing environment", []string{"secrets", "list", "-p", "billing"}, exitUsage},
+		{"unknown flag", []string{"secrets", "list", "-x"}, exitUsage},
+		{"unknown project", []string{"secrets", "list", "-p", "payroll", "-e", "staging"}, exitNotFound},
+		{"unknown environment", []string{"secrets", "list", "-p", "billing", "-e", "qa"}, exitNotFound},
+		{"unknown key", []string{"secrets", "get", "-p", "billing", "-e", "staging", "API_KEY"}, exitNotFound},
+		{"listing", []string{"secrets", "list", "-p", "billing", "-e", "staging"}, exitOK},
+	}
+	for _, tt := range tests {
+		if code, _, stderr := runCLI(t, configPath, "", tt.args...); code != tt.want {
+			t.Errorf("%s: expected exit code %d, got %d (%s)", tt.name, tt.want, code, stderr)
+		}
+	}
+
+	// Another server never gets the stored credentials
+	other := &fakeServer{accessToken: "other"}
+	otherServer := httptest.NewServer(other)
+	defer otherServer.Close()
+	if code, _, _ := runCLI(t, configPath, "", "-server", otherServer.URL, "projects", "list"); code != exitAuth {
+		t.Errorf("Expected exit code %d without credentials for another server, got %d", exitAuth, code)
+	}
+	if len(other.authorization) != 0 {
+		t.Errorf("Expected no requests to the other server, got %q", other.authorization)
+	}
+}
+
+func TestConstantsMatchServer(t *testing.T) {
+	// The CLI does not link the server's packages, so its copies must be kept in step
+	pairs := [][2]string{
+		{importFormatDotenv, services.ImportFormatDotenv},
+		{importFormatJSON, services.ImportFormatJSON},
+		{importFormatYAML, services.ImportFormatYAML},
+		{importFailOnConflict, services.ImportFailOnConflict},
+		{importCreated, services.ImportCreated},
+		{importUpdated, services.ImportUpdated},
+		{importUnchanged, services.ImportUnchanged},
+		{importSkipped, services.ImportSkipped},
+		{exportFormatDotenv, services.ExportFormatDotenv},
+	}
+	for _, pair := range pairs {
+		if pair[0] != pair[1] {
+			t.Errorf("CLI constant %q does not match the server's %q", pair[0], pair[1])
+		}
+	}
+}
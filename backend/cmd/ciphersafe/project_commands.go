@@ -0,0 +1,134 @@
+package main
+
+import (
+	"ciphersafe/models"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// projectOutput is a project as printed with -json
+type projectOutput struct {
+	ID             uint        `json:"id"`
+	Name           string      `json:"name"`
+	Role           models.Role `json:"role,omitempty"`
+	OrganizationID *uint       `json:"organization_id,omitempty"`
+}
+
+func newProjectOutput(project models.Project) projectOutput {
+	return projectOutput{ID: project.ID, Name: project.Name, Role: project.Role, OrganizationID: project.OrganizationID}
+}
+
+func (a *app) listProjects(args []string) error {
+	fs := a.flags("projects list", "projects list")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+
+	projects, err := a.projects()
+	if err != nil {
+		return err
+	}
+
+	if a.jsonOutput {
+		out := make([]projectOutput, 0, len(projects))
+		for _, project := range projects {
+			out = append(out, newProjectOutput(project))
+		}
+		return a.printJSON(out)
+	}
+	rows := [][]string{{"ID", "NAME", "ROLE", "ORGANIZATION"}}
+	for _, project := range projects {
+		organization := "-"
+		if project.OrganizationID != nil {
+			organization = strconv.FormatUint(uint64(*project.OrganizationID), 10)
+		}
+		rows = append(rows, []string{strconv.FormatUint(uint64(project.ID), 10), project.Name, string(project.Role), organization})
+	}
+	return a.printTable(rows)
+}
+
+func (a *app) createProject(args []string) error {
+	fs := a.flags("projects create", "projects create [-org ID] NAME")
+	organizationID := fs.Uint("org", 0, "Organization to create the project in; omit for a personal project")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("projects create takes one project name")
+	}
+
+	input := map[string]interface{}{"name": fs.Arg(0)}
+	if *organizationID != 0 {
+		input["organization_id"] = *organizationID
+	}
+	var project models.Project
+	if err := a.client().sendJSON(http.MethodPost, "/api/projects", input, &project); err != nil {
+		return err
+	}
+
+	if a.jsonOutput {
+		return a.printJSON(newProjectOutput(project))
+	}
+	fmt.Fprintf(a.stdout, "Created project %s (ID %d)\n", project.Name, project.ID)
+	return nil
+}
+
+func (a *app) projects() ([]models.Project, error) {
+	var projects []models.Project
+	if err := a.client().getJSON("/api/projects", nil, &projects); err != nil {
+		return nil, err
+	}
+	return projects, nil
+}
+
+// scope is the project and environment a secret, import or export command works on
+type scope struct {
+	project     string
+	environment string
+}
+
+// scopeFlags registers -p/-project and -e/-env on fs
+func scopeFlags(fs *flag.FlagSet) *scope {
+	s := &scope{}
+	for _, name := range []string{"p", "project"} {
+		fs.StringVar(&s.project, name, os.Getenv("CIPHERSAFE_PROJECT"), "Project name or ID (default $CIPHERSAFE_PROJECT)")
+	}
+	for _, name := range []string{"e", "env"} {
+		fs.StringVar(&s.environment, name, os.Getenv("CIPHERSAFE_ENVIRONMENT"), "Environment name (default $CIPHERSAFE_ENVIRONMENT)")
+	}
+	return s
+}
+
+// resolve returns the project ID, looking the project up by name unless given an ID
+func (a *app) resolve(s *scope) (uint, error) {
+	if s.project == "" {
+		return 0, usagef("-p PROJECT is required")
+	}
+	if s.environment == "" {
+		return 0, usagef("-e ENVIRONMENT is required")
+	}
+	if id, err := strconv.ParseUint(s.project, 10, 32); err == nil && id > 0 {
+		return uint(id), nil
+	}
+
+	projects, err := a.projects()
+	if err != nil {
+		return 0, err
+	}
+	var matches []models.Project
+	for _, project := range projects {
+		if project.Name == s.project {
+			matches = append(matches, project)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("project %q: %w", s.project, errNotFound)
+	case 1:
+		return matches[0].ID, nil
+	}
+	return 0, fmt.Errorf("%d projects are named %q; use the project ID", len(matches), s.project)
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// stdinIsTerminal reports whether standard input is an interactive terminal
+func stdinIsTerminal() bool {
+	info, err := os.Stdin.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// prompt asks for one line of input on stderr
+func (a *app) prompt(label string) (string, error) {
+	fmt.Fprint(a.stderr, label)
+	return a.readLine()
+}
+
+// promptHidden asks for a password or token without echoing it. Echo is turned off with
+// stty, so systems without it show the input.
+func (a *app) promptHidden(label string) (string, error) {
+	fmt.Fprint(a.stderr, label)
+	if stty(false) == nil {
+		defer func() {
+			stty(true)
+			fmt.Fprintln(a.stderr)
+		}()
+	}
+	return a.readLine()
+}
+
+func (a *app) readLine() (string, error) {
+	line, err := a.stdin.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readStdin reads all of standard input, dropping one trailing newline
+func (a *app) readStdin() (string, error) {
+	data, err := io.ReadAll(a.stdin)
+	if err != nil {
+		return "", err
+	}
+	value := strings.TrimSuffix(string(data), "\n")
+	return strings.TrimSuffix(value, "\r"), nil
+}
+
+// readSecret reads a password, token or secret value: hidden from a terminal, otherwise
+// all of standard input
+func (a *app) readSecret(label string) (string, error) {
+	if a.interactive {
+		return a.promptHidden(label)
+	}
+	return a.readStdin()
+}
+
+func stty(echo bool) error {
+	mode := "-echo"
+	if echo {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = os.Stdin
+	return cmd.Run()
+}
@@ -0,0 +1,414 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Import and export values the server accepts and reports. The CLI talks to the server over
+// HTTP only, so these mirror the server's constants rather than importing its packages.
+const (
+	importFormatDotenv = "dotenv"
+	importFormatJSON   = "json"
+	importFormatYAML   = "yaml"
+
+	importFailOnConflict = "fail"
+
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importUnchanged = "unchanged"
+	importSkipped   = "skipped"
+
+	exportFormatDotenv = "dotenv"
+)
+
+// importedSecret is what an import did, or would do, with one key
+type importedSecret struct {
+	Key      string `json:"key"`
+	Result   string `json:"result"`
+	SecretID uint   `json:"secret_id,omitempty"`
+	Version  int    `json:"version,omitempty"`
+}
+
+// secret is a decrypted secret as returned by GET /api/secrets/:secretID, or one entry of
+// GET /api/projects/:projectID/secrets/keys without its value
+type secret struct {
+	ID          uint   `json:"id"`
+	Key         string `json:"key"`
+	Value       string `json:"value"`
+	Environment string `json:"environment"`
+	Version     int    `json:"version"`
+}
+
+// secretOutput is a secret as printed with -json. Value is left out unless asked for.
+type secretOutput struct {
+	ID          uint    `json:"id"`
+	Key         string  `json:"key"`
+	Value       *string `json:"value,omitempty"`
+	Environment string  `json:"environment"`
+	Version     int     `json:"version"`
+}
+
+func newSecretOutput(s *secret, withValue bool) secretOutput {
+	out := secretOutput{ID: s.ID, Key: s.Key, Environment: s.Environment, Version: s.Version}
+	if withValue {
+		out.Value = &s.Value
+	}
+	return out
+}
+
+func (a *app) listSecrets(args []string) error {
+	fs := a.flags("secrets list", "secrets list -p PROJECT -e ENVIRONMENT [-show]")
+	s := scopeFlags(fs)
+	show := fs.Bool("show", false, "Include the values")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("secrets list takes no arguments")
+	}
+
+	secrets, err := a.secrets(s)
+	if err != nil {
+		return err
+	}
+
+	if a.jsonOutput {
+		out := make([]secretOutput, 0, len(secrets))
+		for _, secret := range secrets {
+			out = append(out, newSecretOutput(secret, *show))
+		}
+		return a.printJSON(out)
+	}
+	header := []string{"KEY", "VERSION"}
+	if *show {
+		header = append(header, "VALUE")
+	}
+	rows := [][]string{header}
+	for _, secret := range secrets {
+		row := []string{secret.Key, strconv.Itoa(secret.Version)}
+		if *show {
+			row = append(row, strconv.Quote(secret.Value))
+		}
+		rows = append(rows, row)
+	}
+	return a.printTable(rows)
+}
+
+func (a *app) getSecret(args []string) error {
+	fs := a.flags("secrets get", "secrets get -p PROJECT -e ENVIRONMENT KEY")
+	s := scopeFlags(fs)
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("secrets get takes one key")
+	}
+
+	// Only the one secret is read, and audited, rather than the whole environment
+	client := a.client()
+	found, err := a.findSecret(client, s, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var secret secret
+	if err := client.getJSON("/api/secrets/"+strconv.FormatUint(uint64(found.ID), 10), nil, &secret); err != nil {
+		return err
+	}
+
+	if a.jsonOutput {
+		return a.printJSON(newSecretOutput(&secret, true))
+	}
+	fmt.Fprintln(a.stdout, secret.Value)
+	return nil
+}
+
+// setResult is what secrets set did
+type setResult struct {
+	Key         string `json:"key"`
+	Environment string `json:"environment"`
+	Result      string `json:"result"`       // "created" or "updated"
+	ID          uint   `json:"id,omitempty"` // Not returned when creating
+	Version     int    `json:"version"`
+}
+
+func (a *app) setSecret(args []string) error {
+	fs := a.flags("secrets set", "secrets set -p PROJECT -e ENVIRONMENT KEY [VALUE]\n\nWithout VALUE, or with VALUE -, the value is read from standard input.")
+	s := scopeFlags(fs)
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() < 1 || fs.NArg() > 2 {
+		return usagef("secrets set takes a key and an optional value")
+	}
+	key, value := fs.Arg(0), fs.Arg(1)
+	if fs.NArg() == 1 || value == "-" {
+		var err error
+		if value, err = a.readSecret("Value: "); err != nil {
+			return err
+		}
+	}
+
+	projectID, err := a.resolve(s)
+	if err != nil {
+		return err
+	}
+	client := a.client()
+	keys, err := environmentKeys(client, projectID, s.environment)
+	if err != nil {
+		return err
+	}
+
+	result := setResult{Key: key, Environment: s.environment}
+	if existing := latestSecret(keys, key); existing != nil {
+		// If-Match makes the update fail if someone else changed the secret since the list
+		var updated struct {
+			ID      uint `json:"id"`
+			Version int  `json:"version"`
+		}
+		header := http.Header{"If-Match": {strconv.Quote(strconv.Itoa(existing.Version))}}
+		if err := client.doJSON(http.MethodPut, "/api/secrets/"+strconv.FormatUint(uint64(existing.ID), 10), nil,
+			map[string]string{"value": value}, header, &updated); err != nil {
+			return err
+		}
+		result.Result, result.ID, result.Version = "updated", updated.ID, updated.Version
+	} else {
+		input := map[string]interface{}{"project_id": projectID, "environment": s.environment, "key": key, "value": value}
+		if err := client.sendJSON(http.MethodPost, "/api/secrets", input, nil); err != nil {
+			return err
+		}
+		result.Result, result.Version = "created", 1
+	}
+
+	if a.jsonOutput {
+		return a.printJSON(result)
+	}
+	fmt.Fprintf(a.stdout, "Secret %s %s in %s (version %d)\n", key, result.Result, s.environment, result.Version)
+	return nil
+}
+
+func (a *app) deleteSecret(args []string) error {
+	fs := a.flags("secrets delete", "secrets delete -p PROJECT -e ENVIRONMENT KEY")
+	s := scopeFlags(fs)
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("secrets delete takes one key")
+	}
+
+	client := a.client()
+	secret, err := a.findSecret(client, s, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	if err := client.sendJSON(http.MethodDelete, "/api/secrets/"+strconv.FormatUint(uint64(secret.ID), 10), nil, nil); err != nil {
+		return err
+	}
+
+	if a.jsonOutput {
+		return a.printJSON(map[string]interface{}{"key": secret.Key, "environment": s.environment, "id": secret.ID, "result": "deleted"})
+	}
+	fmt.Fprintf(a.stdout, "Deleted %s from %s\n", secret.Key, s.environment)
+	return nil
+}
+
+func (a *app) importSecrets(args []string) error {
+	fs := a.flags("import", "import -p PROJECT -e ENVIRONMENT [-format FORMAT] [-mode MODE] [-dry-run] FILE\n\nFILE - reads standard input.")
+	s := scopeFlags(fs)
+	format := fs.String("format", "", "dotenv, json or yaml (default: from the file extension, else dotenv)")
+	mode := fs.String("mode", importFailOnConflict, "What to do with keys that hold a different value: fail, skip or overwrite")
+	dryRun := fs.Bool("dry-run", false, "Report what would change without writing anything")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return usagef("import takes one file")
+	}
+	path := fs.Arg(0)
+
+	var content []byte
+	var err error
+	if path == "-" {
+		var text string
+		text, err = a.readStdin()
+		content = []byte(text)
+	} else {
+		content, err = os.ReadFile(path)
+	}
+	if err != nil {
+		return err
+	}
+	if *format == "" {
+		*format = importFormat(path)
+	}
+
+	projectID, err := a.resolve(s)
+	if err != nil {
+		return err
+	}
+	var response struct {
+		DryRun  bool             `json:"dry_run"`
+		Summary map[string]int   `json:"summary"`
+		Secrets []importedSecret `json:"secrets"`
+	}
+	input := map[string]interface{}{"environment": s.environment, "format": *format, "content": string(content), "mode": *mode, "dry_run": *dryRun}
+	path = fmt.Sprintf("/api/projects/%d/import", projectID)
+	if err := a.client().sendJSON(http.MethodPost, path, input, &response); err != nil {
+		return err
+	}
+
+	if a.jsonOutput {
+		return a.printJSON(response)
+	}
+	rows := [][]string{{"KEY", "RESULT"}}
+	for _, secret := range response.Secrets {
+		rows = append(rows, []string{secret.Key, secret.Result})
+	}
+	if err := a.printTable(rows); err != nil {
+		return err
+	}
+	var counts []string
+	for _, result := range []string{importCreated, importUpdated, importSkipped, importUnchanged} {
+		if n := response.Summary[result]; n > 0 {
+			counts = append(counts, fmt.Sprintf("%d %s", n, result))
+		}
+	}
+	summary := strings.Join(counts, ", ")
+	if summary == "" {
+		summary = "nothing to import"
+	}
+	if response.DryRun {
+		summary += " (dry run, nothing was written)"
+	}
+	fmt.Fprintln(a.stdout, summary)
+	return nil
+}
+
+func (a *app) exportSecrets(args []string) error {
+	fs := a.flags("export", "export -p PROJECT -e ENVIRONMENT [-format FORMAT] [-name NAME] [-namespace NAMESPACE] [-o FILE]")
+	s := scopeFlags(fs)
+	format := fs.String("format", exportFormatDotenv, "dotenv, json, yaml, shell, kubernetes or docker")
+	name := fs.String("name", "", "Kubernetes Secret name (default: <project>-<environment>)")
+	namespace := fs.String("namespace", "", "Kubernetes namespace")
+	output := fs.String("o", "", "Write to FILE, created with 0600 permissions, instead of standard output")
+	if err := a.parse(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usagef("export takes no arguments")
+	}
+
+	projectID, err := a.resolve(s)
+	if err != nil {
+		return err
+	}
+	query := url.Values{"environment": {s.environment}, "format": {*format}}
+	if *name != "" {
+		query.Set("name", *name)
+	}
+	if *namespace != "" {
+		query.Set("namespace", *namespace)
+	}
+	data, _, err := a.client().do(http.MethodGet, fmt.Sprintf("/api/projects/%d/export", projectID), query, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		_, err := a.stdout.Write(data)
+		return err
+	}
+	return writePrivateFile(*output, data)
+}
+
+// secrets lists the secrets of the scope's environment, sorted by key
+func (a *app) secrets(s *scope) ([]*secret, error) {
+	projectID, err := a.resolve(s)
+	if err != nil {
+		return nil, err
+	}
+	return a.environmentSecrets(a.client(), projectID, s.environment)
+}
+
+func (a *app) environmentSecrets(client *Client, projectID uint, environment string) ([]*secret, error) {
+	var secrets []*secret
+	path := fmt.Sprintf("/api/projects/%d/secrets", projectID)
+	if err := client.getJSON(path, url.Values{"environment": {environment}}, &secrets); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(secrets, func(i, j int) bool { return secrets[i].Key < secrets[j].Key })
+	return secrets, nil
+}
+
+// environmentKeys lists the secrets of an environment without their values
+func environmentKeys(client *Client, projectID uint, environment string) ([]*secret, error) {
+	var keys []*secret
+	path := fmt.Sprintf("/api/projects/%d/secrets/keys", projectID)
+	if err := client.getJSON(path, url.Values{"environment": {environment}}, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// findSecret returns the secret with key in the scope's environment, without its value
+func (a *app) findSecret(client *Client, s *scope, key string) (*secret, error) {
+	projectID, err := a.resolve(s)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := environmentKeys(client, projectID, s.environment)
+	if err != nil {
+		return nil, err
+	}
+	if secret := latestSecret(keys, key); secret != nil {
+		return secret, nil
+	}
+	return nil, fmt.Errorf("secret %s in %s: %w", key, s.environment, errNotFound)
+}
+
+// latestSecret returns the secret with key. If a key appears more than once, the newest wins,
+// as it does on the server.
+func latestSecret(secrets []*secret, key string) *secret {
+	var latest *secret
+	for _, secret := range secrets {
+		if secret.Key == key && (latest == nil || secret.ID > latest.ID) {
+			latest = secret
+		}
+	}
+	return latest
+}
+
+// importFormat guesses an import format from a file name
+func importFormat(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return importFormatJSON
+	case ".yaml", ".yml":
+		return importFormatYAML
+	}
+	return importFormatDotenv
+}
+
+// writePrivateFile writes data to path with 0600 permissions, tightening those of an existing file
+func writePrivateFile(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := file.Chmod(0o600); err != nil {
+		file.Close()
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
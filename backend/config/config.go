@@ -1,16 +1,119 @@
 package config
 
 import (
+	"ciphersafe/utils"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
 
+// Master key providers
+const (
+	KeyProviderEnv    = "env"    // Raw keys in MASTER_ENCRYPTION_KEY(S), development only
+	KeyProviderFile   = "file"   // Key files referenced by MASTER_KEY_REFS
+	KeyProviderPKCS11 = "pkcs11" // Keys held in a PKCS#11 token, referenced by label
+	KeyProviderKMS    = "kms"    // Keys held by a remote KMS, referenced by key ID
+)
+
+// Audit sinks
+const (
+	AuditSinkFile    = "file"    // Rotating JSON-lines file
+	AuditSinkSyslog  = "syslog"  // RFC 5424 syslog over UDP or TCP
+	AuditSinkWebhook = "webhook" // HTTP POST with a disk-backed retry buffer
+)
+
+// Targets of a group mapping
+const (
+	GroupTargetOrganization = "org"
+	GroupTargetProject      = "project"
+)
+
+// SealModeShamir starts the server sealed; the master key is rebuilt from operator key shares
+const SealModeShamir = "shamir"
+
+// GroupMapping grants members of an identity provider group a role on an organization or project
+type GroupMapping struct {
+	Group    string
+	Target   string // GroupTargetOrganization or GroupTargetProject
+	TargetID uint
+	Role     string
+}
+
 type Config struct {
-	MasterEncryptionKey []byte
-	JWTSecretKey        []byte
-	DatabaseURL         string
+	Environment            string
+	SealMode               string // "" (keys from KEY_PROVIDER) or SealModeShamir
+	KeyProvider            string
+	MasterKeys             map[uint32][]byte // Raw master keys by version (env provider only)
+	MasterKeyRefs          map[uint32]string // Provider key references by version (file path, token label or KMS key ID)
+	ActiveMasterKeyVersion uint32
+	PKCS11Module           string
+	PKCS11TokenLabel       string
+	PKCS11PIN              string
+	KMSURL                 string
+	KMSToken               string
+	JWTSecretKey           []byte
+	AccessTokenTTL         time.Duration // Lifetime of session JWTs
+	RefreshTokenTTL        time.Duration // How long an unused refresh token stays valid
+	SessionMaxLifetime     time.Duration // Sign-in lifetime, after which the user logs in again
+	DatabaseURL            string
+	AdminEmails            []string
+
+	// Sign-in throttling. Each failure for an email doubles the wait before its next attempt,
+	// starting at LoginBackoffBase; LoginMaxFailures failures lock it for LoginLockoutDuration.
+	LoginMaxFailures     int // 0 disables lockout and backoff
+	LoginBackoffBase     time.Duration
+	LoginLockoutDuration time.Duration
+	LoginIPRateLimit     int // Sign-in requests per minute per client IP; 0 disables
+
+	// Reverse proxies (IPs or CIDRs) whose X-Forwarded-For header names the client IP. Unset
+	// trusts none, so rate limits and audit events use the peer address.
+	TrustedProxies []string
+
+	// Password hashing and policy. Hashes made with other parameters are upgraded at sign-in.
+	PasswordHash          utils.PasswordParams
+	PasswordMinLength     int
+	PasswordMaxLength     int
+	BreachedPasswordsPath string // Local Pwned Passwords list (file or range directory); unset skips the check
+
+	// WebAuthn relying party; Origins are the frontend origins allowed to use passkeys
+	WebAuthnRPID    string
+	WebAuthnRPName  string
+	WebAuthnOrigins []string
+	StepUpMaxAge    time.Duration // Revealing or deleting secrets needs a WebAuthn assertion this recent; unset disables
+
+	// OpenID Connect single sign-on, enabled by setting OIDCIssuer
+	OIDCIssuer            string
+	OIDCClientID          string
+	OIDCClientSecret      string
+	OIDCRedirectURL       string // This server's /auth/oidc/callback, as registered with the provider
+	OIDCPostLoginURL      string // Frontend page the callback hands the session to
+	OIDCScopes            []string
+	OIDCGroupsClaim       string
+	OIDCGroupMappings     []GroupMapping
+	PasswordLoginDisabled bool // Refuse /auth/register and /auth/login; users sign in with SSO or passkeys
+
+	// SCIM provisioning, enabled by setting SCIMToken
+	SCIMToken         string
+	SCIMGroupMappings []GroupMapping // Project roles granted to members of SCIM groups
+
+	// Audit event streaming; a sink is enabled by setting its destination
+	AuditFilePath          string
+	AuditFileMaxSize       int64 // Bytes before the file is rotated
+	AuditFileMaxBackups    int
+	AuditSyslogNetwork     string // "udp" or "tcp"
+	AuditSyslogAddr        string
+	AuditWebhookURL        string
+	AuditWebhookSecret     string // Signs webhook bodies with HMAC-SHA256 when set
+	AuditWebhookBufferPath string
+	AuditWebhookBufferMax  int64 // Bytes of undelivered events before the webhook counts as down
+	AuditWebhookMaxRetries int
+	AuditRequiredSinks     []string // Sinks that must be available for secrets to be read
 }
 
 var AppConfig *Config
@@ -20,24 +123,474 @@ func LoadConfig() {
 		log.Println("No .env file found")
 	}
 
-	key := os.Getenv("MASTER_ENCRYPTION_KEY")
-	if key == "" {
-		log.Fatal("MASTER_ENCRYPTION_KEY is not set")
+	cfg := &Config{
+		Environment:      getEnv("APP_ENV", "development"),
+		KeyProvider:      getEnv("KEY_PROVIDER", KeyProviderEnv),
+		SealMode:         os.Getenv("SEAL_MODE"),
+		PKCS11Module:     os.Getenv("PKCS11_MODULE"),
+		PKCS11TokenLabel: os.Getenv("PKCS11_TOKEN_LABEL"),
+		PKCS11PIN:        os.Getenv("PKCS11_PIN"),
+		KMSURL:           os.Getenv("KMS_URL"),
+		KMSToken:         os.Getenv("KMS_TOKEN"),
+		AdminEmails:      splitList(os.Getenv("ADMIN_EMAILS")),
+	}
+
+	switch cfg.SealMode {
+	case "":
+		if err := loadMasterKeys(cfg); err != nil {
+			log.Fatal(err)
+		}
+	case SealModeShamir:
+		// The master key only exists in memory after operators unseal the server
+	default:
+		log.Fatalf("unknown SEAL_MODE %q", cfg.SealMode)
+	}
+
+	if err := loadAuditSinks(cfg); err != nil {
+		log.Fatal(err)
 	}
 
 	jwtKey := os.Getenv("JWT_SECRET_KEY")
 	if jwtKey == "" {
 		log.Fatal("JWT_SECRET_KEY is not set")
 	}
+	cfg.JWTSecretKey = []byte(jwtKey)
+
+	if err := loadSessionLifetimes(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadLoginThrottling(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadPasswordPolicy(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadWebAuthn(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadOIDC(cfg); err != nil {
+		log.Fatal(err)
+	}
+	if err := loadSCIM(cfg); err != nil {
+		log.Fatal(err)
+	}
 
 	dbURL := os.Getenv("DATABASE_URL")
 	if dbURL == "" {
 		log.Fatal("DATABASE_URL is not set")
 	}
+	cfg.DatabaseURL = dbURL
+
+	AppConfig = cfg
+}
+
+// IsSealable reports whether the master key comes from unseal key shares
+func (c *Config) IsSealable() bool {
+	return c.SealMode == SealModeShamir
+}
+
+// IsProduction reports whether the server runs with APP_ENV=production
+func (c *Config) IsProduction() bool {
+	return c.Environment == "production"
+}
+
+// loadMasterKeys reads the master keyring for the configured provider.
+// Keys are given as comma-separated "version:value" pairs, with MASTER_KEY_ACTIVE_VERSION
+// selecting the key used for new encryptions (default: highest version).
+// The env provider reads raw keys from MASTER_ENCRYPTION_KEYS, or MASTER_ENCRYPTION_KEY as
+// version 1; all other providers read key references from MASTER_KEY_REFS.
+func loadMasterKeys(cfg *Config) error {
+	var versions []uint32
+
+	switch cfg.KeyProvider {
+	case KeyProviderEnv:
+		if cfg.IsProduction() {
+			return errors.New("KEY_PROVIDER=env is not allowed in production; use file, pkcs11 or kms")
+		}
+
+		raw := map[uint32]string{}
+		if ring := os.Getenv("MASTER_ENCRYPTION_KEYS"); ring != "" {
+			var err error
+			if raw, err = parseVersioned("MASTER_ENCRYPTION_KEYS", ring); err != nil {
+				return err
+			}
+		} else {
+			key := os.Getenv("MASTER_ENCRYPTION_KEY")
+			if key == "" {
+				return errors.New("MASTER_ENCRYPTION_KEY is not set")
+			}
+			raw[1] = key
+		}
+
+		cfg.MasterKeys = make(map[uint32][]byte, len(raw))
+		for version, key := range raw {
+			cfg.MasterKeys[version] = []byte(key) // Store as bytes
+			versions = append(versions, version)
+		}
+
+	case KeyProviderFile, KeyProviderPKCS11, KeyProviderKMS:
+		refs := os.Getenv("MASTER_KEY_REFS")
+		if refs == "" {
+			return fmt.Errorf("MASTER_KEY_REFS is required for KEY_PROVIDER=%s", cfg.KeyProvider)
+		}
+		parsed, err := parseVersioned("MASTER_KEY_REFS", refs)
+		if err != nil {
+			return err
+		}
+		cfg.MasterKeyRefs = parsed
+		for version := range parsed {
+			versions = append(versions, version)
+		}
+
+		if cfg.KeyProvider == KeyProviderPKCS11 && (cfg.PKCS11Module == "" || cfg.PKCS11TokenLabel == "") {
+			return errors.New("PKCS11_MODULE and PKCS11_TOKEN_LABEL are required for KEY_PROVIDER=pkcs11")
+		}
+		if cfg.KeyProvider == KeyProviderKMS && cfg.KMSURL == "" {
+			return errors.New("KMS_URL is required for KEY_PROVIDER=kms")
+		}
+
+	default:
+		return fmt.Errorf("unknown KEY_PROVIDER %q", cfg.KeyProvider)
+	}
+
+	if activeStr := os.Getenv("MASTER_KEY_ACTIVE_VERSION"); activeStr != "" {
+		version, err := strconv.ParseUint(activeStr, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid MASTER_KEY_ACTIVE_VERSION %q", activeStr)
+		}
+		cfg.ActiveMasterKeyVersion = uint32(version)
+	} else {
+		for _, version := range versions {
+			if version > cfg.ActiveMasterKeyVersion {
+				cfg.ActiveMasterKeyVersion = version
+			}
+		}
+	}
+
+	for _, version := range versions {
+		if version == cfg.ActiveMasterKeyVersion {
+			return nil
+		}
+	}
+	return fmt.Errorf("active master key version %d is not in the keyring", cfg.ActiveMasterKeyVersion)
+}
+
+// loadSessionLifetimes reads how long access tokens, refresh tokens and sessions last
+func loadSessionLifetimes(cfg *Config) error {
+	var err error
+	if cfg.AccessTokenTTL, err = getEnvDuration("ACCESS_TOKEN_TTL", 15*time.Minute); err != nil {
+		return err
+	}
+	if cfg.RefreshTokenTTL, err = getEnvDuration("REFRESH_TOKEN_TTL", 7*24*time.Hour); err != nil {
+		return err
+	}
+	if cfg.SessionMaxLifetime, err = getEnvDuration("SESSION_MAX_LIFETIME", 30*24*time.Hour); err != nil {
+		return err
+	}
+	return nil
+}
+
+// loadLoginThrottling reads the account lockout and per-IP rate limit settings, and the proxies
+// trusted to report client IPs
+func loadLoginThrottling(cfg *Config) error {
+	var err error
+	if cfg.LoginMaxFailures, err = getEnvInt("LOGIN_MAX_FAILURES", 5); err != nil {
+		return err
+	}
+	if cfg.LoginBackoffBase, err = getEnvDuration("LOGIN_BACKOFF_BASE", time.Second); err != nil {
+		return err
+	}
+	if cfg.LoginLockoutDuration, err = getEnvDuration("LOGIN_LOCKOUT_DURATION", 15*time.Minute); err != nil {
+		return err
+	}
+	cfg.LoginIPRateLimit, err = getEnvInt("LOGIN_IP_RATE_LIMIT", 30)
+	cfg.TrustedProxies = splitList(os.Getenv("TRUSTED_PROXIES"))
+	return err
+}
+
+// loadPasswordPolicy reads how passwords are hashed and which passwords are accepted
+func loadPasswordPolicy(cfg *Config) error {
+	params := utils.PasswordParams{Algorithm: getEnv("PASSWORD_HASH_ALGORITHM", utils.PasswordAlgorithmArgon2id)}
+	if params.Algorithm != utils.PasswordAlgorithmArgon2id && params.Algorithm != utils.PasswordAlgorithmBcrypt {
+		return fmt.Errorf("unknown PASSWORD_HASH_ALGORITHM %q", params.Algorithm)
+	}
+
+	memory, err := getEnvInt("ARGON2_MEMORY_KIB", 64*1024)
+	if err != nil || memory < 8*1024 || memory > 4*1024*1024 {
+		return fmt.Errorf("ARGON2_MEMORY_KIB must be between 8192 and 4194304")
+	}
+	iterations, err := getEnvInt("ARGON2_ITERATIONS", 3)
+	if err != nil || iterations < 1 || iterations > 100 {
+		return fmt.Errorf("ARGON2_ITERATIONS must be between 1 and 100")
+	}
+	parallelism, err := getEnvInt("ARGON2_PARALLELISM", 2)
+	if err != nil || parallelism < 1 || parallelism > 255 {
+		return fmt.Errorf("ARGON2_PARALLELISM must be between 1 and 255")
+	}
+	cost, err := getEnvInt("BCRYPT_COST", 14)
+	if err != nil || cost < 10 || cost > 31 {
+		return fmt.Errorf("BCRYPT_COST must be between 10 and 31")
+	}
+	params.Memory, params.Iterations, params.Parallelism, params.BcryptCost = uint32(memory), uint32(iterations), uint8(parallelism), cost
+	cfg.PasswordHash = params
+
+	if cfg.PasswordMinLength, err = getEnvInt("PASSWORD_MIN_LENGTH", 8); err != nil {
+		return err
+	}
+	if cfg.PasswordMaxLength, err = getEnvInt("PASSWORD_MAX_LENGTH", 256); err != nil {
+		return err
+	}
+	if cfg.PasswordMinLength < 1 || cfg.PasswordMaxLength < cfg.PasswordMinLength {
+		return errors.New("PASSWORD_MIN_LENGTH must be at least 1 and at most PASSWORD_MAX_LENGTH")
+	}
+
+	cfg.BreachedPasswordsPath = os.Getenv("BREACHED_PASSWORDS_PATH")
+	if cfg.BreachedPasswordsPath != "" {
+		if _, err := os.Stat(cfg.BreachedPasswordsPath); err != nil {
+			return fmt.Errorf("BREACHED_PASSWORDS_PATH: %w", err)
+		}
+	}
+	return nil
+}
+
+// loadWebAuthn reads the passkey relying party and step-up settings
+func loadWebAuthn(cfg *Config) error {
+	cfg.WebAuthnRPID = getEnv("WEBAUTHN_RP_ID", "localhost")
+	cfg.WebAuthnRPName = getEnv("WEBAUTHN_RP_NAME", "CipherSafe")
+	cfg.WebAuthnOrigins = splitList(getEnv("WEBAUTHN_ORIGINS", "http://localhost:3000"))
+
+	var err error
+	cfg.StepUpMaxAge, err = getEnvDuration("STEP_UP_MAX_AGE", 0)
+	return err
+}
+
+// loadOIDC reads the single sign-on settings. OIDC_GROUP_MAPPINGS is a semicolon-separated list
+// of "group=org:ID:role" or "group=project:ID:role" entries; group names may contain commas.
+func loadOIDC(cfg *Config) error {
+	cfg.OIDCIssuer = strings.TrimSuffix(os.Getenv("OIDC_ISSUER_URL"), "/")
+	cfg.OIDCClientID = os.Getenv("OIDC_CLIENT_ID")
+	cfg.OIDCClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	cfg.OIDCRedirectURL = getEnv("OIDC_REDIRECT_URL", "http://localhost:8080/auth/oidc/callback")
+	cfg.OIDCPostLoginURL = getEnv("OIDC_POST_LOGIN_URL", "http://localhost:3000/login")
+	cfg.OIDCScopes = strings.Fields(getEnv("OIDC_SCOPES", "openid email profile"))
+	cfg.OIDCGroupsClaim = getEnv("OIDC_GROUPS_CLAIM", "groups")
+
+	disabled, err := strconv.ParseBool(getEnv("DISABLE_PASSWORD_LOGIN", "false"))
+	if err != nil {
+		return fmt.Errorf("invalid DISABLE_PASSWORD_LOGIN %q", os.Getenv("DISABLE_PASSWORD_LOGIN"))
+	}
+	cfg.PasswordLoginDisabled = disabled
+
+	if cfg.OIDCIssuer == "" {
+		return nil
+	}
+	if cfg.OIDCClientID == "" {
+		return errors.New("OIDC_CLIENT_ID is required when OIDC_ISSUER_URL is set")
+	}
+
+	cfg.OIDCGroupMappings, err = parseGroupMappings("OIDC_GROUP_MAPPINGS")
+	return err
+}
+
+// loadSCIM reads the SCIM provisioning settings. SCIM_GROUP_MAPPINGS uses the format of
+// OIDC_GROUP_MAPPINGS, limited to project targets.
+func loadSCIM(cfg *Config) error {
+	cfg.SCIMToken = os.Getenv("SCIM_TOKEN")
+	if cfg.SCIMToken == "" {
+		return nil
+	}
+	if len(cfg.SCIMToken) < 32 {
+		return errors.New("SCIM_TOKEN must be at least 32 characters")
+	}
+
+	var err error
+	if cfg.SCIMGroupMappings, err = parseGroupMappings("SCIM_GROUP_MAPPINGS"); err != nil {
+		return err
+	}
+	for _, mapping := range cfg.SCIMGroupMappings {
+		if mapping.Target != GroupTargetProject {
+			return fmt.Errorf("SCIM_GROUP_MAPPINGS only supports project targets, got %s for group %q", mapping.Target, mapping.Group)
+		}
+	}
+	return nil
+}
+
+// parseGroupMappings reads a semicolon-separated list of group mappings from an environment variable
+func parseGroupMappings(name string) ([]GroupMapping, error) {
+	var mappings []GroupMapping
+	for _, entry := range strings.Split(os.Getenv(name), ";") {
+		if entry = strings.TrimSpace(entry); entry == "" {
+			continue
+		}
+		mapping, err := parseGroupMapping(name, entry)
+		if err != nil {
+			return nil, err
+		}
+		mappings = append(mappings, mapping)
+	}
+	return mappings, nil
+}
+
+// parseGroupMapping parses one "group=target:ID:role" entry. The group is everything before
+// the last "=", so LDAP-style names such as "cn=ops,ou=groups" work.
+func parseGroupMapping(name, entry string) (GroupMapping, error) {
+	invalid := fmt.Errorf("invalid %s entry %q, expected group=org:ID:role or group=project:ID:role", name, entry)
+
+	split := strings.LastIndex(entry, "=")
+	if split <= 0 {
+		return GroupMapping{}, invalid
+	}
+	parts := strings.Split(entry[split+1:], ":")
+	if len(parts) != 3 {
+		return GroupMapping{}, invalid
+	}
+	id, err := strconv.ParseUint(parts[1], 10, 32)
+	if err != nil || id == 0 {
+		return GroupMapping{}, invalid
+	}
+
+	mapping := GroupMapping{Group: entry[:split], Target: parts[0], TargetID: uint(id), Role: parts[2]}
+	var roles []string
+	switch mapping.Target {
+	case GroupTargetOrganization:
+		roles = []string{"member", "admin", "owner"}
+	case GroupTargetProject:
+		roles = []string{"reader", "writer", "admin", "owner"}
+	default:
+		return GroupMapping{}, invalid
+	}
+	for _, role := range roles {
+		if mapping.Role == role {
+			return mapping, nil
+		}
+	}
+	return GroupMapping{}, fmt.Errorf("invalid role %q for %s in %s entry %q", mapping.Role, mapping.Target, name, entry)
+}
+
+// SCIMEnabled reports whether the SCIM provisioning endpoint is configured
+func (c *Config) SCIMEnabled() bool {
+	return c.SCIMToken != ""
+}
+
+// OIDCEnabled reports whether single sign-on is configured
+func (c *Config) OIDCEnabled() bool {
+	return c.OIDCIssuer != ""
+}
 
-	AppConfig = &Config{
-		MasterEncryptionKey: []byte(key), // Store as bytes
-		JWTSecretKey:        []byte(jwtKey),
-		DatabaseURL:         dbURL,
+// loadAuditSinks reads the audit sink settings. AUDIT_REQUIRED_SINKS lists sinks (file, syslog,
+// webhook) that must be reachable; the server refuses secret reads while one of them is down.
+func loadAuditSinks(cfg *Config) error {
+	cfg.AuditFilePath = os.Getenv("AUDIT_FILE_PATH")
+	cfg.AuditSyslogNetwork = getEnv("AUDIT_SYSLOG_NETWORK", "udp")
+	cfg.AuditSyslogAddr = os.Getenv("AUDIT_SYSLOG_ADDR")
+	cfg.AuditWebhookURL = os.Getenv("AUDIT_WEBHOOK_URL")
+	cfg.AuditWebhookSecret = os.Getenv("AUDIT_WEBHOOK_SECRET")
+	cfg.AuditWebhookBufferPath = getEnv("AUDIT_WEBHOOK_BUFFER_PATH", "audit-webhook-buffer.jsonl")
+	cfg.AuditRequiredSinks = splitList(os.Getenv("AUDIT_REQUIRED_SINKS"))
+
+	maxSizeMB, err := getEnvInt("AUDIT_FILE_MAX_SIZE_MB", 100)
+	if err != nil {
+		return err
+	}
+	cfg.AuditFileMaxSize = int64(maxSizeMB) << 20
+	if cfg.AuditFileMaxBackups, err = getEnvInt("AUDIT_FILE_MAX_BACKUPS", 5); err != nil {
+		return err
+	}
+	bufferMaxMB, err := getEnvInt("AUDIT_WEBHOOK_BUFFER_MAX_MB", 64)
+	if err != nil {
+		return err
+	}
+	cfg.AuditWebhookBufferMax = int64(bufferMaxMB) << 20
+	if cfg.AuditWebhookMaxRetries, err = getEnvInt("AUDIT_WEBHOOK_MAX_RETRIES", 3); err != nil {
+		return err
+	}
+
+	if cfg.AuditSyslogNetwork != "udp" && cfg.AuditSyslogNetwork != "tcp" {
+		return fmt.Errorf("AUDIT_SYSLOG_NETWORK must be udp or tcp, got %q", cfg.AuditSyslogNetwork)
+	}
+
+	for _, sink := range cfg.AuditRequiredSinks {
+		if !cfg.AuditSinkEnabled(sink) {
+			return fmt.Errorf("AUDIT_REQUIRED_SINKS lists %q, but that sink is not configured", sink)
+		}
+	}
+	return nil
+}
+
+// AuditSinkEnabled reports whether the named audit sink has a destination configured
+func (c *Config) AuditSinkEnabled(sink string) bool {
+	switch sink {
+	case AuditSinkFile:
+		return c.AuditFilePath != ""
+	case AuditSinkSyslog:
+		return c.AuditSyslogAddr != ""
+	case AuditSinkWebhook:
+		return c.AuditWebhookURL != ""
+	}
+	return false
+}
+
+// parseVersioned parses comma-separated "version:value" pairs
+func parseVersioned(name, value string) (map[uint32]string, error) {
+	entries := make(map[uint32]string)
+	for _, entry := range splitList(value) {
+		versionStr, item, found := strings.Cut(entry, ":")
+		if !found || item == "" {
+			return nil, fmt.Errorf("invalid %s entry %q, expected version:value", name, entry)
+		}
+		version, err := strconv.ParseUint(versionStr, 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("invalid %s version %q", name, versionStr)
+		}
+		if _, dup := entries[uint32(version)]; dup {
+			return nil, fmt.Errorf("duplicate %s version %d", name, version)
+		}
+		entries[uint32(version)] = item
+	}
+	return entries, nil
+}
+
+// splitList splits a comma-separated value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// getEnv returns the value of an environment variable or a default
+func getEnv(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// getEnvDuration returns a positive duration environment variable (e.g. "15m") or a default
+func getEnvDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil || parsed <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return parsed, nil
+}
+
+// getEnvInt returns a non-negative integer environment variable or a default
+func getEnvInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
 	}
+	return parsed, nil
 }
@@ -4,6 +4,7 @@ import (
 	"ciphersafe/api"
 	"ciphersafe/config"
 	"ciphersafe/models"
+	"ciphersafe/services"
 	"log"
 
 	"github.com/gin-gonic/gin"
@@ -15,21 +16,73 @@ func main() {
 	// 1. Load config from .env
 	config.LoadConfig()
 
+	// Fail fast on a misconfigured keyring rather than on the first request.
+	// In sealed mode there is no keyring until operators unseal the server.
+	if !config.AppConfig.IsSealable() {
+		if _, err := services.MasterKeyring(); err != nil {
+			log.Fatal("Invalid master keyring:", err)
+		}
+	}
+
 	// 2. Connect to Database
-	db, err := gorm.Open(postgres.Open(config.AppConfig.DatabaseURL), &gorm.Config{})
+	db, err := gorm.Open(postgres.Open(config.AppConfig.DatabaseURL), &gorm.Config{TranslateError: true})
 	if err != nil {
 		log.Fatal("Failed to connect to database:", err)
 	}
 
 	// 3. Auto-migrate the schema
 	log.Println("Migrating database...")
-	db.AutoMigrate(&models.User{}, &models.Project{}, &models.Secret{})
+	db.AutoMigrate(&models.User{}, &models.Project{}, &models.Secret{}, &models.SecretVersion{},
+		&models.SealConfig{}, &models.ProjectMember{}, &models.Organization{}, &models.OrganizationMember{},
+		&models.AuditEvent{}, &models.APIToken{},
+		&models.ServiceAccount{}, &models.ServiceAccountKey{}, &models.ServiceAccountGrant{},
+		&models.Session{}, &models.RefreshToken{}, &models.RecoveryCode{},
+		&models.WebAuthnCredential{}, &models.WebAuthnChallenge{}, &models.OIDCLoginState{},
+		&models.SCIMGroup{}, &models.LoginLockout{}, &models.Environment{})
+
+	// The audit log is append-only at the database level as well
+	if err := services.InstallAuditTriggers(db); err != nil {
+		log.Fatal("Failed to install audit log triggers:", err)
+	}
+
+	// Give projects created before sharing existed an owner membership
+	if err := services.BackfillProjectOwners(db); err != nil {
+		log.Fatal("Failed to backfill project owners:", err)
+	}
+
+	// Give projects created before environments existed their default environments
+	if err := services.BackfillEnvironments(db); err != nil {
+		log.Fatal("Failed to backfill environments:", err)
+	}
+
+	// Stream audit events to external sinks (file, syslog, webhook)
+	auditSinks, err := services.NewAuditSinks(config.AppConfig)
+	if err != nil {
+		log.Fatal("Failed to set up audit sinks:", err)
+	}
+	defer auditSinks.Close()
+
+	// Secret keys are unique per environment, which needs every secret in an environment first
+	if err := services.InstallSecretKeyIndex(db, services.NewAuditService(db, auditSinks)); err != nil {
+		log.Fatal("Failed to install the secret key index:", err)
+	}
+
+	// Upgrade stored ciphertexts; sealed servers do this right after unseal
+	if !config.AppConfig.IsSealable() {
+		if err := services.RunEncryptionMigrations(db); err != nil {
+			log.Fatal(err)
+		}
+	}
 
 	// 4. Set up Gin router
 	r := gin.Default()
+	// Only configured proxies may set the client IP that rate limits and audit events use
+	if err := r.SetTrustedProxies(config.AppConfig.TrustedProxies); err != nil {
+		log.Fatal("Invalid TRUSTED_PROXIES:", err)
+	}
 
 	// 5. Setup routes
-	api.SetupRoutes(r, db)
+	api.SetupRoutes(r, db, auditSinks)
 
 	// 6. Start server
 	log.Println("Starting server on port 8080...")
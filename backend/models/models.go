@@ -1,6 +1,9 @@
 package models
 
 import (
+	"encoding/json"
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -10,22 +13,452 @@ type User struct {
 	Email    string    `gorm:"uniqueIndex;not null" json:"email"`
 	Password string    `gorm:"not null" json:"-"`
 	Projects []Project `gorm:"foreignKey:OwnerID" json:"projects,omitempty"`
+
+	// TOTP second factor. The secret is encrypted under the master key and is set
+	// (but not enabled) while an enrollment waits for its first code.
+	TOTPSecret      string `gorm:"column:totp_secret" json:"-"`
+	TOTPEnabled     bool   `gorm:"column:totp_enabled;not null;default:false" json:"totp_enabled"`
+	TOTPLastCounter int64  `gorm:"column:totp_last_counter;not null;default:0" json:"-"` // Last accepted time step, refused on replay
+
+	// Identity at the OIDC provider for users who signed in with single sign-on. Users
+	// provisioned by SSO have no password.
+	OIDCIssuer  *string `gorm:"column:oidc_issuer;uniqueIndex:idx_user_oidc" json:"-"`
+	OIDCSubject *string `gorm:"column:oidc_subject;uniqueIndex:idx_user_oidc" json:"-"`
+
+	// Deactivated users keep their records for the audit trail but cannot sign in or use
+	// their tokens. SCIM provisioning deactivates rather than deletes.
+	Active         bool       `gorm:"not null;default:true" json:"active"`
+	DeactivatedAt  *time.Time `json:"deactivated_at,omitempty"`
+	DisplayName    string     `json:"display_name,omitempty"`
+	SCIMExternalID *string    `gorm:"column:scim_external_id;uniqueIndex" json:"-"` // Identity provider's ID for the user
 }
 
 // Project represents a project that contains secrets
 type Project struct {
 	gorm.Model
-	Name    string   `gorm:"not null" json:"name"`
-	OwnerID uint     `gorm:"not null" json:"owner_id"`
-	Owner   User     `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
-	Secrets []Secret `gorm:"foreignKey:ProjectID" json:"secrets,omitempty"`
+	Name           string        `gorm:"not null" json:"name"`
+	OwnerID        uint          `gorm:"not null" json:"owner_id"`
+	OrganizationID *uint         `gorm:"index" json:"organization_id,omitempty"` // Nil for personal projects
+	Organization   *Organization `gorm:"foreignKey:OrganizationID" json:"organization,omitempty"`
+	Owner          User          `gorm:"foreignKey:OwnerID" json:"owner,omitempty"`
+	Secrets        []Secret      `gorm:"foreignKey:ProjectID" json:"secrets,omitempty"`
+	Environments   []Environment `gorm:"foreignKey:ProjectID" json:"environments,omitempty"`
+	EncryptedDEK   string        `gorm:"column:encrypted_dek;not null;default:''" json:"-"` // Project data key, wrapped by the master key
+	KeyShreddedAt  *time.Time    `json:"-"`                                                 // Set once the data key has been destroyed
+	Role           Role          `gorm:"->;-:migration" json:"role,omitempty"`              // Caller's role, filled in by membership queries
+}
+
+// Role is a member's permission level on a project
+type Role string
+
+// Project roles, from least to most privileged
+const (
+	RoleReader Role = "reader" // Read secrets
+	RoleWriter Role = "writer" // Also create, update and delete secrets
+	RoleAdmin  Role = "admin"  // Also manage non-owner members
+	RoleOwner  Role = "owner"  // Also manage owners and delete the project
+)
+
+var roleRanks = map[Role]int{
+	RoleReader: 1,
+	RoleWriter: 2,
+	RoleAdmin:  3,
+	RoleOwner:  4,
+}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether r grants at least the permissions of min
+func (r Role) AtLeast(min Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[min]
+}
+
+// ProjectMember grants a user a role on a project
+type ProjectMember struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ProjectID uint      `gorm:"not null;uniqueIndex:idx_project_member" json:"project_id"`
+	UserID    uint      `gorm:"not null;uniqueIndex:idx_project_member" json:"user_id"`
+	User      User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role      Role      `gorm:"type:varchar(16);not null" json:"role"`
+	Source    string    `gorm:"type:varchar(16);not null;default:''" json:"source,omitempty"` // MemberSourceSCIM for memberships synced from SCIM groups
+}
+
+// MemberSourceSCIM marks project memberships managed by SCIM group sync. Sync only ever
+// changes or removes memberships it created.
+const MemberSourceSCIM = "scim"
+
+// Environment is a stage of a project, such as development or production. Secrets belong to
+// one environment, so the same key holds a different value in each.
+type Environment struct {
+	ID        uint      `gorm:"primarykey" json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	ProjectID uint      `gorm:"not null;uniqueIndex:idx_project_environment" json:"project_id"`
+	Name      string    `gorm:"not null;uniqueIndex:idx_project_environment" json:"name"`
+	ReadRole  Role      `gorm:"type:varchar(16);not null;default:'reader'" json:"read_role"`  // Project role needed to read its secrets
+	WriteRole Role      `gorm:"type:varchar(16);not null;default:'writer'" json:"write_role"` // Project role needed to change them
+}
+
+// RequiredRole is the project role an operation needing minRole takes in this environment:
+// minRole, raised to ReadRole for reads or WriteRole for writes
+func (e *Environment) RequiredRole(minRole Role) Role {
+	threshold := e.ReadRole
+	if minRole.AtLeast(RoleWriter) {
+		threshold = e.WriteRole
+	}
+	if threshold.AtLeast(minRole) {
+		return threshold
+	}
+	return minRole
 }
 
 // Secret represents an encrypted secret key-value pair
 type Secret struct {
 	gorm.Model
-	Key       string  `gorm:"not null" json:"key"`
-	Value     string  `gorm:"not null" json:"value"` // This will be encrypted
-	ProjectID uint    `gorm:"not null" json:"project_id"`
-	Project   Project `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
+	Key            string          `gorm:"not null" json:"key"`   // Unique per environment among secrets that are not deleted
+	Value          string          `gorm:"not null" json:"value"` // This will be encrypted (copy of the current version)
+	CurrentVersion int             `gorm:"not null;default:0" json:"current_version"`
+	ProjectID      uint            `gorm:"not null" json:"project_id"`
+	EnvironmentID  uint            `gorm:"not null;default:0;index" json:"environment_id"`
+	Project        Project         `gorm:"foreignKey:ProjectID" json:"project,omitempty"`
+	Versions       []SecretVersion `gorm:"foreignKey:SecretID" json:"versions,omitempty"`
+}
+
+// SecretVersion is an immutable snapshot of a secret's value.
+// A new row is written on every change; rows are never updated.
+type SecretVersion struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	SecretID     uint      `gorm:"not null;uniqueIndex:idx_secret_version" json:"secret_id"`
+	Version      int       `gorm:"not null;uniqueIndex:idx_secret_version" json:"version"`
+	Key          string    `gorm:"not null;default:''" json:"key"` // Key name at this version
+	Value        string    `gorm:"not null" json:"-"`              // Encrypted value at this version
+	AuthorID     uint      `gorm:"not null" json:"author_id"`
+	AuthorType   string    `gorm:"type:varchar(32);not null;default:'user'" json:"author_type"` // "user" or "service_account"
+	RestoredFrom *int      `json:"restored_from,omitempty"`                                     // Set when the version was created by a rollback
+}
+
+// SealConfig records the result of the init ceremony for a server in sealed mode.
+// Only a check value is stored; the master key itself exists solely as operator key shares.
+type SealConfig struct {
+	ID        uint      `gorm:"primarykey" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	Shares    int       `gorm:"not null" json:"shares"`
+	Threshold int       `gorm:"not null" json:"threshold"`
+	KeyCheck  string    `gorm:"not null" json:"-"` // Known value encrypted under the master key
+}
+
+// Organization owns projects and has its own members, settings and quotas
+type Organization struct {
+	gorm.Model
+	Name     string               `gorm:"not null" json:"name"`
+	Settings OrganizationSettings `gorm:"embedded;embeddedPrefix:setting_" json:"settings"`
+	Projects []Project            `gorm:"foreignKey:OrganizationID" json:"projects,omitempty"`
+	Role     OrgRole              `gorm:"->;-:migration" json:"role,omitempty"` // Caller's role, filled in by membership queries
+}
+
+// OrganizationSettings are policies and quotas applied to an organization's members and projects
+type OrganizationSettings struct {
+	PasswordMinLength  int      `gorm:"not null;default:0" json:"password_min_length"`         // 0 uses the server default
+	AllowedAuthMethods []string `gorm:"serializer:json" json:"allowed_auth_methods,omitempty"` // Empty allows every method
+	MaxProjects        int      `gorm:"not null;default:0" json:"max_projects"`                // 0 means unlimited
+	MaxSecrets         int      `gorm:"not null;default:0" json:"max_secrets"`                 // 0 means unlimited, across all projects
+	RequireMFA         bool     `gorm:"not null;default:false" json:"require_mfa"`             // Members must sign in with a second factor
+}
+
+// Sign-in methods an organization can allow
+const (
+	AuthMethodPassword = "password"
+	AuthMethodWebAuthn = "webauthn" // Passkeys and hardware security keys
+	AuthMethodOIDC     = "oidc"     // Single sign-on through the configured OpenID Connect provider
+)
+
+// KnownAuthMethods lists every sign-in method the server supports
+var KnownAuthMethods = []string{AuthMethodPassword, AuthMethodWebAuthn, AuthMethodOIDC}
+
+// AllowsAuthMethod reports whether members may sign in with method
+func (s OrganizationSettings) AllowsAuthMethod(method string) bool {
+	if len(s.AllowedAuthMethods) == 0 {
+		return true
+	}
+	for _, allowed := range s.AllowedAuthMethods {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+// OrgRole is a member's permission level on an organization
+type OrgRole string
+
+// Organization roles, from least to most privileged
+const (
+	OrgRoleMember OrgRole = "member" // Create projects in the organization
+	OrgRoleAdmin  OrgRole = "admin"  // Also manage settings, members and every project (as project admin)
+	OrgRoleOwner  OrgRole = "owner"  // Also manage owners (and every project as project owner)
+)
+
+var orgRoleRanks = map[OrgRole]int{
+	OrgRoleMember: 1,
+	OrgRoleAdmin:  2,
+	OrgRoleOwner:  3,
+}
+
+// Valid reports whether r is a known organization role
+func (r OrgRole) Valid() bool {
+	_, ok := orgRoleRanks[r]
+	return ok
+}
+
+// AtLeast reports whether r grants at least the permissions of min
+func (r OrgRole) AtLeast(min OrgRole) bool {
+	return r.Valid() && orgRoleRanks[r] >= orgRoleRanks[min]
+}
+
+// ProjectRole is the role an organization role implies on every project of the organization
+func (r OrgRole) ProjectRole() Role {
+	switch r {
+	case OrgRoleOwner:
+		return RoleOwner
+	case OrgRoleAdmin:
+		return RoleAdmin
+	default:
+		return "" // Plain members only see projects they are added to
+	}
+}
+
+// OrganizationMember grants a user a role on an organization
+type OrganizationMember struct {
+	ID             uint      `gorm:"primarykey" json:"id"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+	OrganizationID uint      `gorm:"not null;uniqueIndex:idx_org_member" json:"organization_id"`
+	UserID         uint      `gorm:"not null;uniqueIndex:idx_org_member" json:"user_id"`
+	User           User      `gorm:"foreignKey:UserID" json:"user,omitempty"`
+	Role           OrgRole   `gorm:"type:varchar(16);not null" json:"role"`
+}
+
+// AuditEvent is an append-only, hash-chained record of a security-relevant action.
+// Hash covers every field plus the previous event's hash, so edits and deletions break the chain.
+type AuditEvent struct {
+	ID           uint      `gorm:"primarykey" json:"id"`
+	Seq          uint64    `gorm:"not null;uniqueIndex" json:"seq"` // Gapless position in the chain
+	CreatedAt    time.Time `gorm:"index" json:"created_at"`
+	ActorID      *uint     `gorm:"index" json:"actor_id,omitempty"`
+	ActorType    string    `gorm:"type:varchar(32);not null" json:"actor_type"` // "user", "api_token", "service_account", "scim" or "anonymous"
+	Action       string    `gorm:"type:varchar(64);not null;index" json:"action"`
+	ResourceType string    `gorm:"type:varchar(32)" json:"resource_type,omitempty"`
+	ResourceID   string    `gorm:"type:varchar(64)" json:"resource_id,omitempty"`
+	ProjectID    *uint     `gorm:"index" json:"project_id,omitempty"`
+	IP           string    `gorm:"type:varchar(64)" json:"ip,omitempty"`
+	UserAgent    string    `gorm:"type:text" json:"user_agent,omitempty"`
+	Result       string    `gorm:"type:varchar(16);not null" json:"result"` // "success", "failure" or "denied"
+	Details      string    `gorm:"type:text" json:"-"`                      // JSON object
+	PrevHash     string    `gorm:"type:char(64);not null" json:"prev_hash"`
+	Hash         string    `gorm:"type:char(64);not null" json:"hash"`
+}
+
+// MarshalJSON renders Details as a JSON object rather than a string
+func (e AuditEvent) MarshalJSON() ([]byte, error) {
+	type event AuditEvent
+	var details json.RawMessage
+	if e.Details != "" {
+		details = json.RawMessage(e.Details)
+	}
+	return json.Marshal(struct {
+		event
+		Details json.RawMessage `json:"details,omitempty"`
+	}{event(e), details})
+}
+
+// TokenPermission is the most an API token may do on its projects
+type TokenPermission string
+
+// API token permissions
+const (
+	TokenRead  TokenPermission = "read"  // Read secrets
+	TokenWrite TokenPermission = "write" // Also create, update and delete secrets
+)
+
+// Role is the highest project role the permission allows
+func (p TokenPermission) Role() Role {
+	switch p {
+	case TokenRead:
+		return RoleReader
+	case TokenWrite:
+		return RoleWriter
+	}
+	return ""
+}
+
+// APIToken is a long-lived personal access token for automation. It acts as its user,
+// but only on the listed projects and never beyond its permission.
+type APIToken struct {
+	ID         uint            `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time       `json:"created_at"`
+	UserID     uint            `gorm:"not null;index" json:"user_id"`
+	Name       string          `gorm:"not null" json:"name"`
+	Prefix     string          `gorm:"type:varchar(32);not null" json:"prefix"`     // Start of the token, to recognize it
+	TokenHash  string          `gorm:"type:char(64);not null;uniqueIndex" json:"-"` // SHA-256 of the token
+	Permission TokenPermission `gorm:"type:varchar(16);not null" json:"permission"`
+	ProjectIDs []uint          `gorm:"serializer:json;not null" json:"project_ids"`
+	ExpiresAt  *time.Time      `json:"expires_at,omitempty"` // Nil never expires
+	LastUsedAt *time.Time      `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time      `json:"revoked_at,omitempty"`
+}
+
+// ScopeRole narrows a user's project role to what the token allows on that project
+func (t *APIToken) ScopeRole(projectID uint, role Role) Role {
+	allowed := false
+	for _, id := range t.ProjectIDs {
+		if id == projectID {
+			allowed = true
+			break
+		}
+	}
+	if !allowed || !role.Valid() {
+		return ""
+	}
+
+	if limit := t.Permission.Role(); role.AtLeast(limit) {
+		return limit
+	}
+	return role
+}
+
+// ServiceAccount is a non-human principal owned by an organization or a single project,
+// so automation keeps working when the people who set it up leave
+type ServiceAccount struct {
+	gorm.Model
+	Name           string `gorm:"not null" json:"name"`
+	Description    string `json:"description,omitempty"`
+	OrganizationID *uint  `gorm:"index" json:"organization_id,omitempty"` // Exactly one of OrganizationID
+	ProjectID      *uint  `gorm:"index" json:"project_id,omitempty"`      // and ProjectID is set
+	CreatedByID    uint   `gorm:"not null" json:"created_by_id"`
+}
+
+// ServiceAccountKey is one credential of a service account. An account can hold several
+// so keys can be rotated without downtime.
+type ServiceAccountKey struct {
+	ID               uint       `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time  `json:"created_at"`
+	ServiceAccountID uint       `gorm:"not null;index" json:"service_account_id"`
+	Name             string     `gorm:"not null" json:"name"`
+	Prefix           string     `gorm:"type:varchar(32);not null" json:"prefix"`
+	TokenHash        string     `gorm:"type:char(64);not null;uniqueIndex" json:"-"` // SHA-256 of the key
+	ExpiresAt        *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt       *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt        *time.Time `json:"revoked_at,omitempty"`
+}
+
+// ServiceAccountGrant gives a service account a role on a project, like ProjectMember does for users
+type ServiceAccountGrant struct {
+	ID               uint           `gorm:"primarykey" json:"id"`
+	CreatedAt        time.Time      `json:"created_at"`
+	UpdatedAt        time.Time      `json:"updated_at"`
+	ProjectID        uint           `gorm:"not null;uniqueIndex:idx_service_account_grant" json:"project_id"`
+	ServiceAccountID uint           `gorm:"not null;uniqueIndex:idx_service_account_grant" json:"service_account_id"`
+	ServiceAccount   ServiceAccount `gorm:"foreignKey:ServiceAccountID" json:"service_account,omitempty"`
+	Role             Role           `gorm:"type:varchar(16);not null" json:"role"`
+}
+
+// Session is one sign-in of a user. Access tokens carry its ID, so revoking it
+// signs that device out immediately; its refresh tokens form one rotation family.
+type Session struct {
+	ID         uint       `gorm:"primarykey" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uint       `gorm:"not null;index" json:"user_id"`
+	IP         string     `gorm:"type:varchar(64)" json:"ip"`
+	UserAgent  string     `json:"user_agent"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	ExpiresAt  time.Time  `gorm:"not null" json:"expires_at"` // Absolute lifetime; refreshing does not extend it
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	StepUpAt   *time.Time `json:"step_up_at,omitempty"` // Last WebAuthn assertion made in this session
+	Current    bool       `gorm:"-" json:"current"`     // Whether the listing request came from this session
+}
+
+// RefreshToken is a single-use credential for a new access token. Using one replaces it
+// with the next token of its session; presenting a used one again revokes the session.
+type RefreshToken struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	SessionID uint       `gorm:"not null;index"`
+	TokenHash string     `gorm:"type:char(64);not null;uniqueIndex"` // SHA-256 of the token
+	ExpiresAt time.Time  `gorm:"not null"`
+	UsedAt    *time.Time // Set once the token has been exchanged
+}
+
+// RecoveryCode is a single-use fallback for a lost authenticator. Only its hash is stored.
+type RecoveryCode struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint       `gorm:"not null;index"`
+	CodeHash  string     `gorm:"type:char(64);not null"` // SHA-256 of the normalized code
+	UsedAt    *time.Time // Set once the code has been used
+}
+
+// WebAuthnCredential is a passkey or hardware security key registered by a user
+type WebAuthnCredential struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UserID       uint       `gorm:"not null;index" json:"user_id"`
+	Name         string     `gorm:"not null" json:"name"`
+	CredentialID string     `gorm:"not null;uniqueIndex" json:"credential_id"` // base64url
+	PublicKey    []byte     `gorm:"not null" json:"-"`                         // COSE_Key
+	Algorithm    int64      `gorm:"not null" json:"algorithm"`                 // COSE algorithm
+	SignCount    uint32     `gorm:"not null;default:0" json:"-"`
+	AAGUID       string     `gorm:"type:char(32)" json:"aaguid"` // Authenticator model, hex
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// WebAuthnChallenge is a pending registration, login or step-up ceremony. Each challenge
+// can be answered once.
+type WebAuthnChallenge struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	Challenge string    `gorm:"not null;uniqueIndex"` // base64url
+	Purpose   string    `gorm:"type:varchar(16);not null"`
+	UserID    *uint     // Nil for passkey logins, where the user is not known yet
+	SessionID *uint     // Set for step-up
+	ExpiresAt time.Time `gorm:"not null"`
+}
+
+// OIDCLoginState is a single sign-on login waiting for the provider's callback
+type OIDCLoginState struct {
+	ID           uint `gorm:"primarykey"`
+	CreatedAt    time.Time
+	State        string    `gorm:"not null;uniqueIndex"`
+	Nonce        string    `gorm:"not null"`
+	CodeVerifier string    `gorm:"not null"` // PKCE verifier, sent with the authorization code
+	ExpiresAt    time.Time `gorm:"not null"`
+}
+
+// LoginLockout tracks failed sign-in attempts for an email, whether or not an account has it,
+// so lockouts do not reveal which emails are registered. It is deleted on a successful login.
+type LoginLockout struct {
+	ID           uint       `gorm:"primarykey" json:"id"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+	Email        string     `gorm:"not null;uniqueIndex" json:"email"` // Lowercased
+	FailedCount  int        `gorm:"not null;default:0" json:"failed_count"`
+	LastFailedAt time.Time  `gorm:"not null" json:"last_failed_at"`
+	LockedUntil  *time.Time `json:"locked_until,omitempty"`
+}
+
+// SCIMGroup is a group pushed by the identity provider over SCIM. Its members get the
+// project roles SCIM_GROUP_MAPPINGS configures for its display name.
+type SCIMGroup struct {
+	ID          uint      `gorm:"primarykey" json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	DisplayName string    `gorm:"not null;uniqueIndex" json:"display_name"`
+	ExternalID  *string   `json:"external_id,omitempty"`
+	Members     []User    `gorm:"many2many:scim_group_members" json:"members,omitempty"`
 }
@@ -0,0 +1,341 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Audit results
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// Audit actor types
+const (
+	ActorUser           = "user"
+	ActorAPIToken       = "api_token" // A user acting through a personal access token
+	ActorServiceAccount = "service_account"
+	ActorSCIM           = "scim" // The identity provider, through the SCIM endpoint
+	ActorAnonymous      = "anonymous"
+	ActorSystem         = "system" // The server itself, e.g. startup migrations
+)
+
+// auditGenesisHash is the PrevHash of the first event in the chain
+var auditGenesisHash = strings.Repeat("0", 64)
+
+// auditChainLockID serializes appends to the chain across server processes
+const auditChainLockID = 0x61756469 // "audi"
+
+// AuditEntry describes an action to record
+type AuditEntry struct {
+	ActorID      *uint
+	ActorType    string
+	Action       string
+	ResourceType string
+	ResourceID   string
+	ProjectID    *uint
+	IP           string
+	UserAgent    string
+	Result       string
+	Details      map[string]interface{}
+}
+
+// AuditFilter narrows down audit event queries. Zero values are ignored.
+type AuditFilter struct {
+	ActorID      *uint
+	ActorType    string
+	Action       string // Exact match, or a prefix when it ends with "*"
+	ResourceType string
+	ResourceID   string
+	ProjectID    *uint
+	Result       string
+	Since        *time.Time
+	Until        *time.Time
+	BeforeID     uint // Return events older than this ID (pagination cursor)
+	Limit        int
+
+	// Restrict limits results to events by this actor or in these projects (non-admin callers)
+	Restrict          bool
+	RestrictActorID   uint
+	RestrictProjectID []uint
+}
+
+// AuditVerification is the result of checking the hash chain
+type AuditVerification struct {
+	Valid      bool   `json:"valid"`
+	Events     int64  `json:"events"`
+	HeadSeq    uint64 `json:"head_seq"`
+	HeadHash   string `json:"head_hash"`
+	BrokenAt   uint64 `json:"broken_at,omitempty"` // Seq of the first event failing verification
+	Reason     string `json:"reason,omitempty"`
+	Anchored   bool   `json:"anchored"` // The chain was also checked against an AuditAnchor
+	VerifiedAt string `json:"verified_at"`
+}
+
+// AuditAnchor is the seq and hash of an event as kept outside the database, e.g. the last event
+// an audit sink received. Deleting events from the end of the chain leaves a valid but shorter
+// chain, which only a comparison with such an anchor reveals.
+type AuditAnchor struct {
+	Seq  uint64
+	Hash string
+}
+
+// AuditService writes and reads the tamper-evident audit log
+type AuditService struct {
+	DB    *gorm.DB
+	Sinks *AuditStreamer // Optional external destinations for every recorded event
+}
+
+// NewAuditService creates a new AuditService
+func NewAuditService(db *gorm.DB, sinks *AuditStreamer) *AuditService {
+	return &AuditService{DB: db, Sinks: sinks}
+}
+
+// Record appends an event to the chain and streams it to the audit sinks.
+// The event is stored even if a sink fails; a required sink failure is returned
+// as ErrAuditSinkUnavailable along with the event.
+func (s *AuditService) Record(entry AuditEntry) (*models.AuditEvent, error) {
+	details := ""
+	if len(entry.Details) > 0 {
+		encoded, err := json.Marshal(entry.Details)
+		if err != nil {
+			return nil, err
+		}
+		details = string(encoded)
+	}
+
+	event := &models.AuditEvent{
+		// Postgres stores microseconds; truncate so the hash matches what is read back
+		CreatedAt:    time.Now().UTC().Truncate(time.Microsecond),
+		ActorID:      entry.ActorID,
+		ActorType:    entry.ActorType,
+		Action:       entry.Action,
+		ResourceType: entry.ResourceType,
+		ResourceID:   entry.ResourceID,
+		ProjectID:    entry.ProjectID,
+		IP:           entry.IP,
+		UserAgent:    entry.UserAgent,
+		Result:       entry.Result,
+		Details:      details,
+	}
+	if event.ActorType == "" {
+		event.ActorType = ActorAnonymous
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", auditChainLockID).Error; err != nil {
+			return err
+		}
+
+		var head models.AuditEvent
+		result := tx.Select("seq", "hash").Order("seq desc").Limit(1).Find(&head)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			event.Seq = 1
+			event.PrevHash = auditGenesisHash
+		} else {
+			event.Seq = head.Seq + 1
+			event.PrevHash = head.Hash
+		}
+
+		event.Hash = AuditEventHash(event)
+		return tx.Create(event).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return event, s.Sinks.Publish(event)
+}
+
+// CheckSinks reports ErrAuditSinkUnavailable if a required audit sink is down
+func (s *AuditService) CheckSinks() error {
+	return s.Sinks.Check()
+}
+
+// List returns events matching the filter, newest first
+func (s *AuditService) List(filter AuditFilter) ([]models.AuditEvent, error) {
+	query := s.DB.Model(&models.AuditEvent{})
+
+	if filter.ActorID != nil {
+		query = query.Where("actor_id = ?", *filter.ActorID)
+	}
+	if filter.ActorType != "" {
+		query = query.Where("actor_type = ?", filter.ActorType)
+	}
+	if filter.Action != "" {
+		if strings.HasSuffix(filter.Action, "*") {
+			query = query.Where("action LIKE ?", strings.TrimSuffix(filter.Action, "*")+"%")
+		} else {
+			query = query.Where("action = ?", filter.Action)
+		}
+	}
+	if filter.ResourceType != "" {
+		query = query.Where("resource_type = ?", filter.ResourceType)
+	}
+	if filter.ResourceID != "" {
+		query = query.Where("resource_id = ?", filter.ResourceID)
+	}
+	if filter.ProjectID != nil {
+		query = query.Where("project_id = ?", *filter.ProjectID)
+	}
+	if filter.Result != "" {
+		query = query.Where("result = ?", filter.Result)
+	}
+	if filter.Since != nil {
+		query = query.Where("created_at >= ?", *filter.Since)
+	}
+	if filter.Until != nil {
+		query = query.Where("created_at < ?", *filter.Until)
+	}
+	if filter.BeforeID != 0 {
+		query = query.Where("id < ?", filter.BeforeID)
+	}
+	if filter.Restrict {
+		// Service account IDs overlap user IDs, so own events are matched on user actor types only
+		ownEvents := "actor_type IN ? AND actor_id = ?"
+		userActors := []string{ActorUser, ActorAPIToken}
+		if len(filter.RestrictProjectID) > 0 {
+			query = query.Where("("+ownEvents+") OR project_id IN ?", userActors, filter.RestrictActorID, filter.RestrictProjectID)
+		} else {
+			query = query.Where(ownEvents, userActors, filter.RestrictActorID)
+		}
+	}
+
+	limit := filter.Limit
+	if limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+
+	var events []models.AuditEvent
+	if err := query.Order("id desc").Limit(limit).Find(&events).Error; err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+// Verify walks the whole chain in order, recomputing every hash and link. With an anchor, the
+// chain must also reach the anchored event and hold the anchored hash there.
+func (s *AuditService) Verify(anchor *AuditAnchor) (*AuditVerification, error) {
+	result := &AuditVerification{Valid: true, HeadHash: auditGenesisHash}
+	var hashAtAnchor string
+
+	for {
+		var batch []models.AuditEvent
+		if err := s.DB.Where("seq > ?", result.HeadSeq).Order("seq").Limit(500).Find(&batch).Error; err != nil {
+			return nil, err
+		}
+		if len(batch) == 0 {
+			break
+		}
+
+		for i := range batch {
+			event := &batch[i]
+			switch {
+			case event.Seq != result.HeadSeq+1:
+				result.fail(event.Seq, fmt.Sprintf("expected seq %d, found %d (events missing)", result.HeadSeq+1, event.Seq))
+			case event.PrevHash != result.HeadHash:
+				result.fail(event.Seq, "previous hash does not match the preceding event")
+			case AuditEventHash(event) != event.Hash:
+				result.fail(event.Seq, "event contents do not match its hash")
+			}
+			if !result.Valid {
+				result.VerifiedAt = time.Now().UTC().Format(time.RFC3339)
+				return result, nil
+			}
+			result.Events++
+			result.HeadSeq = event.Seq
+			result.HeadHash = event.Hash
+			if anchor != nil && event.Seq == anchor.Seq {
+				hashAtAnchor = event.Hash
+			}
+		}
+	}
+
+	if anchor != nil {
+		result.checkAnchor(*anchor, hashAtAnchor)
+	}
+	result.VerifiedAt = time.Now().UTC().Format(time.RFC3339)
+	return result, nil
+}
+
+func (v *AuditVerification) fail(seq uint64, reason string) {
+	v.Valid = false
+	v.BrokenAt = seq
+	v.Reason = reason
+}
+
+// checkAnchor compares a chain that verified with an anchor. hashAtAnchor is the hash of the
+// chain's event at the anchor's seq, if it has one.
+func (v *AuditVerification) checkAnchor(anchor AuditAnchor, hashAtAnchor string) {
+	v.Anchored = true
+	switch {
+	case v.HeadSeq < anchor.Seq:
+		v.fail(v.HeadSeq+1, fmt.Sprintf("chain ends at seq %d, before the anchor at seq %d (events missing at the end)", v.HeadSeq, anchor.Seq))
+	case hashAtAnchor != anchor.Hash:
+		v.fail(anchor.Seq, "event hash does not match the anchor")
+	}
+}
+
+// AuditEventHash computes the chained SHA-256 hash of an event.
+// Fields are serialized in a fixed order so the hash is reproducible.
+func AuditEventHash(e *models.AuditEvent) string {
+	canonical, _ := json.Marshal(struct {
+		Seq          uint64 `json:"seq"`
+		PrevHash     string `json:"prev_hash"`
+		CreatedAt    string `json:"created_at"`
+		ActorID      *uint  `json:"actor_id"`
+		ActorType    string `json:"actor_type"`
+		Action       string `json:"action"`
+		ResourceType string `json:"resource_type"`
+		ResourceID   string `json:"resource_id"`
+		ProjectID    *uint  `json:"project_id"`
+		IP           string `json:"ip"`
+		UserAgent    string `json:"user_agent"`
+		Result       string `json:"result"`
+		Details      string `json:"details"`
+	}{
+		Seq:          e.Seq,
+		PrevHash:     e.PrevHash,
+		CreatedAt:    e.CreatedAt.UTC().Format(time.RFC3339Nano),
+		ActorID:      e.ActorID,
+		ActorType:    e.ActorType,
+		Action:       e.Action,
+		ResourceType: e.ResourceType,
+		ResourceID:   e.ResourceID,
+		ProjectID:    e.ProjectID,
+		IP:           e.IP,
+		UserAgent:    e.UserAgent,
+		Result:       e.Result,
+		Details:      e.Details,
+	})
+
+	sum := sha256.Sum256(canonical)
+	return hex.EncodeToString(sum[:])
+}
+
+// InstallAuditTriggers makes the audit_events table append-only at the database level
+func InstallAuditTriggers(db *gorm.DB) error {
+	return db.Exec(`
+		CREATE OR REPLACE FUNCTION audit_events_append_only() RETURNS trigger AS $$
+		BEGIN
+			RAISE EXCEPTION 'audit_events is append-only';
+		END;
+		$$ LANGUAGE plpgsql;
+
+		DROP TRIGGER IF EXISTS audit_events_no_update ON audit_events;
+		CREATE TRIGGER audit_events_no_update BEFORE UPDATE OR DELETE ON audit_events
+			FOR EACH ROW EXECUTE FUNCTION audit_events_append_only();
+	`).Error
+}
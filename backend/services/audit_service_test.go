@@ -0,0 +1,83 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuditEventHashChain(t *testing.T) {
+	actorID := uint(7)
+	projectID := uint(3)
+	created := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+
+	first := &models.AuditEvent{
+		Seq: 1, PrevHash: auditGenesisHash, CreatedAt: created,
+		ActorID: &actorID, ActorType: ActorUser, Action: "secret.read",
+		ResourceType: "project", ResourceID: "3", ProjectID: &projectID,
+		IP: "10.0.0.1", UserAgent: "curl/8.0", Result: AuditSuccess, Details: `{"count":2}`,
+	}
+	first.Hash = AuditEventHash(first)
+
+	if len(first.Hash) != 64 {
+		t.Fatalf("Expected a hex SHA-256 hash, got %q", first.Hash)
+	}
+	if AuditEventHash(first) != first.Hash {
+		t.Fatal("Hash should be deterministic")
+	}
+
+	second := &models.AuditEvent{
+		Seq: 2, PrevHash: first.Hash, CreatedAt: created.Add(time.Second),
+		ActorType: ActorAnonymous, Action: "auth.login", ResourceType: "user", Result: AuditFailure,
+	}
+	second.Hash = AuditEventHash(second)
+
+	// Tampering with any field or link must change the hash
+	tampered := *first
+	tampered.Action = "secret.create"
+	if AuditEventHash(&tampered) == first.Hash {
+		t.Fatal("Changing the action should change the hash")
+	}
+
+	tampered = *first
+	otherActor := uint(8)
+	tampered.ActorID = &otherActor
+	if AuditEventHash(&tampered) == first.Hash {
+		t.Fatal("Changing the actor should change the hash")
+	}
+
+	relinked := *second
+	relinked.PrevHash = auditGenesisHash
+	if AuditEventHash(&relinked) == second.Hash {
+		t.Fatal("Changing the previous hash should change the hash")
+	}
+}
+
+func TestAuditVerificationAnchor(t *testing.T) {
+	head := strings.Repeat("a", 64)
+	tests := []struct {
+		name         string
+		headSeq      uint64 // Last event of the verified chain
+		anchor       AuditAnchor
+		hashAtAnchor string
+		valid        bool
+		brokenAt     uint64
+	}{
+		{"anchor at the head", 10, AuditAnchor{Seq: 10, Hash: head}, head, true, 0},
+		{"events after the anchor", 12, AuditAnchor{Seq: 10, Hash: head}, head, true, 0},
+		{"events deleted from the end", 8, AuditAnchor{Seq: 10, Hash: head}, "", false, 9},
+		{"chain rewritten", 10, AuditAnchor{Seq: 10, Hash: head}, strings.Repeat("b", 64), false, 10},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &AuditVerification{Valid: true, HeadSeq: tt.headSeq}
+			result.checkAnchor(tt.anchor, tt.hashAtAnchor)
+			if !result.Anchored || result.Valid != tt.valid || result.BrokenAt != tt.brokenAt {
+				t.Fatalf("Got valid %v broken at %d (%s), want valid %v broken at %d",
+					result.Valid, result.BrokenAt, result.Reason, tt.valid, tt.brokenAt)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// ErrAuditSinkUnavailable is returned when a required audit sink cannot accept events
+var ErrAuditSinkUnavailable = errors.New("required audit sink is unavailable")
+
+// AuditSink streams audit events to a destination outside the database
+type AuditSink interface {
+	// Name identifies the sink in configuration and logs
+	Name() string
+	// Write delivers (or durably queues) one event
+	Write(event *models.AuditEvent) error
+	// Healthy reports whether the sink can currently accept events
+	Healthy() error
+	// Close flushes and releases the sink's resources
+	Close() error
+}
+
+type auditSinkEntry struct {
+	sink     AuditSink
+	required bool
+}
+
+// AuditStreamer fans audit events out to the configured sinks.
+// A nil *AuditStreamer has no sinks.
+type AuditStreamer struct {
+	sinks []auditSinkEntry
+}
+
+// NewAuditStreamer wraps sinks; the sinks named in required must accept every event
+func NewAuditStreamer(sinks []AuditSink, required []string) (*AuditStreamer, error) {
+	streamer := &AuditStreamer{}
+	found := make(map[string]bool)
+	for _, sink := range sinks {
+		isRequired := false
+		for _, name := range required {
+			if name == sink.Name() {
+				isRequired = true
+				found[name] = true
+			}
+		}
+		streamer.sinks = append(streamer.sinks, auditSinkEntry{sink: sink, required: isRequired})
+	}
+
+	for _, name := range required {
+		if !found[name] {
+			return nil, fmt.Errorf("required audit sink %q is not configured", name)
+		}
+	}
+	return streamer, nil
+}
+
+// NewAuditSinks creates the audit sinks enabled in the configuration
+func NewAuditSinks(cfg *config.Config) (*AuditStreamer, error) {
+	var sinks []AuditSink
+
+	if cfg.AuditFilePath != "" {
+		sink, err := NewFileAuditSink(cfg.AuditFilePath, cfg.AuditFileMaxSize, cfg.AuditFileMaxBackups)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	if cfg.AuditSyslogAddr != "" {
+		sinks = append(sinks, NewSyslogAuditSink(cfg.AuditSyslogNetwork, cfg.AuditSyslogAddr))
+	}
+
+	if cfg.AuditWebhookURL != "" {
+		sink, err := NewWebhookAuditSink(WebhookSinkOptions{
+			URL:        cfg.AuditWebhookURL,
+			Secret:     cfg.AuditWebhookSecret,
+			BufferPath: cfg.AuditWebhookBufferPath,
+			BufferMax:  cfg.AuditWebhookBufferMax,
+			MaxRetries: cfg.AuditWebhookMaxRetries,
+		})
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	return NewAuditStreamer(sinks, cfg.AuditRequiredSinks)
+}
+
+// Publish writes an event to every sink. Failures of optional sinks are only logged;
+// a failure of a required sink is returned as ErrAuditSinkUnavailable.
+func (s *AuditStreamer) Publish(event *models.AuditEvent) error {
+	if s == nil {
+		return nil
+	}
+
+	var failed error
+	for _, entry := range s.sinks {
+		if err := entry.sink.Write(event); err != nil {
+			log.Printf("Audit sink %s failed to write event %d: %v", entry.sink.Name(), event.Seq, err)
+			if entry.required && failed == nil {
+				failed = fmt.Errorf("%w: %s: %v", ErrAuditSinkUnavailable, entry.sink.Name(), err)
+			}
+		}
+	}
+	return failed
+}
+
+// Check reports ErrAuditSinkUnavailable if any required sink is unhealthy
+func (s *AuditStreamer) Check() error {
+	if s == nil {
+		return nil
+	}
+
+	for _, entry := range s.sinks {
+		if !entry.required {
+			continue
+		}
+		if err := entry.sink.Healthy(); err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrAuditSinkUnavailable, entry.sink.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Close closes every sink
+func (s *AuditStreamer) Close() error {
+	if s == nil {
+		return nil
+	}
+
+	var firstErr error
+	for _, entry := range s.sinks {
+		if err := entry.sink.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// FileAuditSink appends events as JSON lines, rotating the file once it reaches maxSize.
+// Rotated files are kept as path.1 (newest) to path.<maxBackups>.
+type FileAuditSink struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64
+	maxBackups int
+	file       *os.File
+	size       int64
+	err        error // Last write error, cleared by a successful reopen
+}
+
+// NewFileAuditSink opens (or creates) the audit file at path
+func NewFileAuditSink(path string, maxSize int64, maxBackups int) (*FileAuditSink, error) {
+	sink := &FileAuditSink{path: path, maxSize: maxSize, maxBackups: maxBackups}
+	if err := sink.open(); err != nil {
+		return nil, err
+	}
+	return sink, nil
+}
+
+func (s *FileAuditSink) Name() string { return config.AuditSinkFile }
+
+func (s *FileAuditSink) Write(event *models.AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			return err
+		}
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			s.err = err
+			return err
+		}
+	}
+
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	if err != nil {
+		s.err = err
+		s.file.Close()
+		s.file = nil
+		return err
+	}
+	s.err = nil
+	return nil
+}
+
+func (s *FileAuditSink) Healthy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return s.open()
+	}
+	return s.err
+}
+
+func (s *FileAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+func (s *FileAuditSink) open() error {
+	file, err := os.OpenFile(s.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file = file
+	s.size = info.Size()
+	s.err = nil
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1 and starts a new file
+func (s *FileAuditSink) rotate() error {
+	if err := s.file.Close(); err != nil {
+		return err
+	}
+	s.file = nil
+
+	if s.maxBackups == 0 {
+		if err := os.Remove(s.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return s.open()
+	}
+
+	backup := func(n int) string { return s.path + "." + strconv.Itoa(n) }
+	if err := os.Remove(backup(s.maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for n := s.maxBackups - 1; n >= 1; n-- {
+		if err := os.Rename(backup(n), backup(n+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(s.path, backup(1)); err != nil {
+		return err
+	}
+	return s.open()
+}
@@ -0,0 +1,150 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	syslogFacilityAuthPriv = 10
+	syslogSeverityWarning  = 4
+	syslogSeverityInfo     = 6
+	syslogAppName          = "ciphersafe"
+	syslogDialTimeout      = 5 * time.Second
+	syslogWriteTimeout     = 5 * time.Second
+)
+
+// SyslogAuditSink sends events as RFC 5424 messages over UDP or TCP.
+// TCP messages use octet-counting framing (RFC 6587); the message body is the event as JSON.
+type SyslogAuditSink struct {
+	mu       sync.Mutex
+	network  string
+	addr     string
+	hostname string
+	conn     net.Conn
+	err      error // Last write error, cleared by a successful write
+}
+
+// NewSyslogAuditSink creates a sink for a syslog collector. It connects on first use,
+// so a collector that is down at startup only affects the sink's health.
+func NewSyslogAuditSink(network, addr string) *SyslogAuditSink {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+	return &SyslogAuditSink{network: network, addr: addr, hostname: hostname}
+}
+
+func (s *SyslogAuditSink) Name() string { return config.AuditSinkSyslog }
+
+func (s *SyslogAuditSink) Write(event *models.AuditEvent) error {
+	msg, err := s.format(event)
+	if err != nil {
+		return err
+	}
+	if s.network == "tcp" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// A stale TCP connection only shows up on write, so reconnect and retry once
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if err = s.connect(); err != nil {
+				break
+			}
+		}
+		s.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		if _, err = s.conn.Write(msg); err == nil {
+			s.err = nil
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+
+	s.err = err
+	return err
+}
+
+func (s *SyslogAuditSink) Healthy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return s.connect()
+	}
+	return s.err
+}
+
+func (s *SyslogAuditSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+func (s *SyslogAuditSink) connect() error {
+	conn, err := net.DialTimeout(s.network, s.addr, syslogDialTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	s.err = nil
+	return nil
+}
+
+// format renders an RFC 5424 message:
+// <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+func (s *SyslogAuditSink) format(event *models.AuditEvent) ([]byte, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	severity := syslogSeverityInfo
+	if event.Result != AuditSuccess {
+		severity = syslogSeverityWarning
+	}
+
+	header := fmt.Sprintf("<%d>1 %s %s %s %d %s - ",
+		syslogFacilityAuthPriv*8+severity,
+		event.CreatedAt.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname,
+		syslogAppName,
+		os.Getpid(),
+		syslogMsgID(event.Action),
+	)
+	return append([]byte(header), body...), nil
+}
+
+// syslogMsgID makes an action usable as a MSGID: printable ASCII, no spaces, at most 32 characters
+func syslogMsgID(action string) string {
+	id := strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, action)
+	if len(id) > 32 {
+		id = id[:32]
+	}
+	if id == "" {
+		return "-"
+	}
+	return id
+}
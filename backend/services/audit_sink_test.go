@@ -0,0 +1,368 @@
+package services
+
+import (
+	"bufio"
+	"ciphersafe/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testAuditEvent(seq uint64, action string) *models.AuditEvent {
+	return &models.AuditEvent{
+		Seq:       seq,
+		CreatedAt: time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+		ActorType: ActorUser,
+		Action:    action,
+		Result:    AuditSuccess,
+		Details:   `{"count":1}`,
+	}
+}
+
+func TestFileAuditSinkRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+
+	// Each event is a couple of hundred bytes, so every second write rotates
+	sink, err := NewFileAuditSink(path, 400, 2)
+	if err != nil {
+		t.Fatalf("Failed to create file sink: %v", err)
+	}
+	defer sink.Close()
+
+	for seq := uint64(1); seq <= 6; seq++ {
+		if err := sink.Write(testAuditEvent(seq, "secret.read")); err != nil {
+			t.Fatalf("Write %d failed: %v", seq, err)
+		}
+	}
+
+	current, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit file: %v", err)
+	}
+	var last map[string]interface{}
+	lines := strings.Split(strings.TrimSpace(string(current)), "\n")
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatalf("Audit file is not JSON lines: %v", err)
+	}
+	if last["seq"].(float64) != 6 {
+		t.Fatalf("Expected the newest event last, got seq %v", last["seq"])
+	}
+	if _, ok := last["details"].(map[string]interface{}); !ok {
+		t.Fatal("Details should be embedded as a JSON object")
+	}
+
+	for _, backup := range []string{path + ".1", path + ".2"} {
+		if _, err := os.Stat(backup); err != nil {
+			t.Fatalf("Expected rotated file %s: %v", backup, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatal("Only maxBackups rotated files should be kept")
+	}
+
+	info, _ := os.Stat(path)
+	if info.Mode().Perm() != 0o600 {
+		t.Fatalf("Audit file should be private, got mode %o", info.Mode().Perm())
+	}
+}
+
+func TestSyslogAuditSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	sink := NewSyslogAuditSink("udp", conn.LocalAddr().String())
+	defer sink.Close()
+
+	event := testAuditEvent(1, "secret.read")
+	event.Result = AuditDenied
+	if err := sink.Write(event); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+
+	buf := make([]byte, 4096)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("No syslog message received: %v", err)
+	}
+
+	msg := string(buf[:n])
+	// authpriv (10) * 8 + warning (4)
+	if !strings.HasPrefix(msg, "<84>1 2024-05-01T12:00:00.000000Z ") {
+		t.Fatalf("Unexpected RFC 5424 header: %q", msg)
+	}
+	fields := strings.SplitN(msg, " ", 8)
+	if fields[3] != "ciphersafe" || fields[5] != "secret.read" || fields[6] != "-" {
+		t.Fatalf("Unexpected header fields: %q", fields[:7])
+	}
+	if !json.Valid([]byte(fields[7])) {
+		t.Fatalf("Message body should be the event as JSON: %q", fields[7])
+	}
+}
+
+func TestSyslogAuditSinkTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for {
+			// Octet-counting framing: "<length> <message>"
+			lengthStr, err := reader.ReadString(' ')
+			if err != nil {
+				return
+			}
+			length, _ := strconv.Atoi(strings.TrimSpace(lengthStr))
+			msg := make([]byte, length)
+			if _, err := io.ReadFull(reader, msg); err != nil {
+				return
+			}
+			received <- string(msg)
+		}
+	}()
+
+	sink := NewSyslogAuditSink("tcp", listener.Addr().String())
+	defer sink.Close()
+
+	if err := sink.Healthy(); err != nil {
+		t.Fatalf("Sink should connect to a listening collector: %v", err)
+	}
+	for seq := uint64(1); seq <= 2; seq++ {
+		if err := sink.Write(testAuditEvent(seq, "auth.login")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			// authpriv (10) * 8 + info (6)
+			if !strings.HasPrefix(msg, "<86>1 ") || !strings.Contains(msg, " auth.login - {") {
+				t.Fatalf("Unexpected message: %q", msg)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("Timed out waiting for syslog message")
+		}
+	}
+}
+
+func TestSyslogAuditSinkUnavailable(t *testing.T) {
+	listener, _ := net.Listen("tcp", "127.0.0.1:0")
+	addr := listener.Addr().String()
+	listener.Close()
+
+	sink := NewSyslogAuditSink("tcp", addr)
+	if err := sink.Healthy(); err == nil {
+		t.Fatal("Sink should be unhealthy without a collector")
+	}
+	if err := sink.Write(testAuditEvent(1, "secret.read")); err == nil {
+		t.Fatal("Write should fail without a collector")
+	}
+}
+
+func TestWebhookAuditSinkRetriesAndBuffers(t *testing.T) {
+	var mu sync.Mutex
+	var bodies [][]byte
+	failures := 2
+	up := true
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		body, _ := io.ReadAll(r.Body)
+		mac := hmac.New(sha256.New, []byte("hook-secret"))
+		mac.Write(body)
+		if r.Header.Get(WebhookSignatureHeader) != "sha256="+hex.EncodeToString(mac.Sum(nil)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !up || failures > 0 {
+			failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		bodies = append(bodies, body)
+	}))
+	defer server.Close()
+
+	bufferPath := filepath.Join(t.TempDir(), "webhook-buffer.jsonl")
+	sink, err := NewWebhookAuditSink(WebhookSinkOptions{
+		URL:           server.URL,
+		Secret:        "hook-secret",
+		BufferPath:    bufferPath,
+		MaxRetries:    3,
+		RetryBackoff:  time.Millisecond,
+		FlushInterval: 20 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create webhook sink: %v", err)
+	}
+	defer sink.Close()
+
+	delivered := func(n int) bool {
+		deadline := time.Now().Add(3 * time.Second)
+		for time.Now().Before(deadline) {
+			mu.Lock()
+			count := len(bodies)
+			mu.Unlock()
+			if count >= n && sink.Pending() == 0 {
+				return true
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		return false
+	}
+
+	// The first two requests fail; retries deliver the event
+	if err := sink.Write(testAuditEvent(1, "secret.read")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	if !delivered(1) {
+		t.Fatal("Event was not delivered after retries")
+	}
+
+	// While the webhook is down, events wait in the buffer and are delivered in order later
+	mu.Lock()
+	up = false
+	mu.Unlock()
+	for seq := uint64(2); seq <= 4; seq++ {
+		if err := sink.Write(testAuditEvent(seq, "secret.delete")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	if sink.Pending() == 0 {
+		t.Fatal("Undelivered events should stay in the buffer")
+	}
+	if err := sink.Healthy(); err != nil {
+		t.Fatalf("A webhook outage alone should not make the sink unhealthy: %v", err)
+	}
+
+	mu.Lock()
+	up = true
+	mu.Unlock()
+	if !delivered(4) {
+		t.Fatal("Buffered events were not delivered once the webhook recovered")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for i, body := range bodies {
+		var event map[string]interface{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("Webhook body is not JSON: %v", err)
+		}
+		if uint64(event["seq"].(float64)) != uint64(i+1) {
+			t.Fatalf("Events delivered out of order: position %d has seq %v", i, event["seq"])
+		}
+	}
+}
+
+func TestWebhookAuditSinkBufferFull(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	sink, err := NewWebhookAuditSink(WebhookSinkOptions{
+		URL:           server.URL,
+		BufferPath:    filepath.Join(t.TempDir(), "webhook-buffer.jsonl"),
+		BufferMax:     300,
+		RetryBackoff:  time.Millisecond,
+		FlushInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed to create webhook sink: %v", err)
+	}
+	defer sink.Close()
+
+	if err := sink.Write(testAuditEvent(1, "secret.read")); err != nil {
+		t.Fatalf("First write should fit in the buffer: %v", err)
+	}
+	if err := sink.Write(testAuditEvent(2, "secret.read")); !errors.Is(err, ErrAuditBufferFull) {
+		t.Fatalf("Expected ErrAuditBufferFull, got %v", err)
+	}
+}
+
+// stubAuditSink is an in-memory sink whose availability tests can toggle
+type stubAuditSink struct {
+	name   string
+	err    error
+	events int
+}
+
+func (s *stubAuditSink) Name() string { return s.name }
+func (s *stubAuditSink) Write(event *models.AuditEvent) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.events++
+	return nil
+}
+func (s *stubAuditSink) Healthy() error { return s.err }
+func (s *stubAuditSink) Close() error   { return nil }
+
+func TestAuditStreamerRequiredSinks(t *testing.T) {
+	required := &stubAuditSink{name: "webhook"}
+	optional := &stubAuditSink{name: "syslog", err: errors.New("collector down")}
+
+	streamer, err := NewAuditStreamer([]AuditSink{required, optional}, []string{"webhook"})
+	if err != nil {
+		t.Fatalf("Failed to create streamer: %v", err)
+	}
+
+	// An optional sink failing does not block anything
+	if err := streamer.Check(); err != nil {
+		t.Fatalf("Optional sink failures should not fail the check: %v", err)
+	}
+	if err := streamer.Publish(testAuditEvent(1, "secret.read")); err != nil {
+		t.Fatalf("Optional sink failures should not fail publishing: %v", err)
+	}
+	if required.events != 1 {
+		t.Fatal("Required sink should have received the event")
+	}
+
+	// A required sink failing closes the gate
+	required.err = errors.New("buffer full")
+	if err := streamer.Check(); !errors.Is(err, ErrAuditSinkUnavailable) {
+		t.Fatalf("Expected ErrAuditSinkUnavailable from Check, got %v", err)
+	}
+	if err := streamer.Publish(testAuditEvent(2, "secret.read")); !errors.Is(err, ErrAuditSinkUnavailable) {
+		t.Fatalf("Expected ErrAuditSinkUnavailable from Publish, got %v", err)
+	}
+
+	if _, err := NewAuditStreamer([]AuditSink{optional}, []string{"file"}); err == nil {
+		t.Fatal("Requiring an unconfigured sink should fail")
+	}
+
+	var none *AuditStreamer
+	if err := none.Check(); err != nil {
+		t.Fatal("A nil streamer has no required sinks")
+	}
+}
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// ErrAuditBufferFull is returned when the webhook buffer holds too many undelivered events
+var ErrAuditBufferFull = errors.New("audit webhook buffer is full")
+
+// WebhookSignatureHeader carries "sha256=<hex HMAC of the body>" when a webhook secret is set
+const WebhookSignatureHeader = "X-CipherSafe-Signature"
+
+// WebhookSinkOptions configures a WebhookAuditSink
+type WebhookSinkOptions struct {
+	URL           string
+	Secret        string
+	BufferPath    string        // Undelivered events, one JSON line each
+	BufferMax     int64         // Bytes; 0 means unlimited
+	MaxRetries    int           // Retries per delivery attempt after the first request
+	RetryBackoff  time.Duration // Doubles on every retry (default 500ms)
+	FlushInterval time.Duration // How often to retry the buffer after failures (default 10s)
+	Client        *http.Client
+}
+
+// WebhookAuditSink POSTs each event as JSON to a URL. Events are first appended to a
+// disk buffer and removed once delivered, so they survive webhook outages and restarts.
+type WebhookAuditSink struct {
+	opts WebhookSinkOptions
+
+	mu      sync.Mutex // Guards the buffer file and lastErr
+	lastErr error      // Last delivery error, reported with a full buffer
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewWebhookAuditSink creates the sink and starts delivering buffered events
+func NewWebhookAuditSink(opts WebhookSinkOptions) (*WebhookAuditSink, error) {
+	if opts.BufferPath == "" {
+		return nil, errors.New("audit webhook requires a buffer path")
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = 500 * time.Millisecond
+	}
+	if opts.FlushInterval == 0 {
+		opts.FlushInterval = 10 * time.Second
+	}
+	if opts.Client == nil {
+		opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	// Make sure the buffer is usable before accepting events
+	file, err := os.OpenFile(opts.BufferPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+
+	sink := &WebhookAuditSink{
+		opts: opts,
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	go sink.run()
+	sink.notify() // Deliver anything left over from a previous run
+	return sink, nil
+}
+
+func (s *WebhookAuditSink) Name() string { return config.AuditSinkWebhook }
+
+// Write appends the event to the disk buffer; delivery happens in the background
+func (s *WebhookAuditSink) Write(event *models.AuditEvent) error {
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	s.mu.Lock()
+	err = s.appendToBuffer(line)
+	s.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// Healthy reports an error once the buffer can no longer take events.
+// A webhook outage alone does not make the sink unhealthy while the buffer has room.
+func (s *WebhookAuditSink) Healthy() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.opts.BufferPath)
+	if err != nil {
+		return err
+	}
+	if s.opts.BufferMax > 0 && info.Size() >= s.opts.BufferMax {
+		return s.bufferFullError()
+	}
+	return nil
+}
+
+// Close stops background delivery; undelivered events stay in the buffer
+func (s *WebhookAuditSink) Close() error {
+	close(s.stop)
+	<-s.done
+	return nil
+}
+
+// Pending returns the number of bytes waiting in the buffer
+func (s *WebhookAuditSink) Pending() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.opts.BufferPath)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (s *WebhookAuditSink) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (s *WebhookAuditSink) run() {
+	defer close(s.done)
+
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+		s.flush()
+	}
+}
+
+// flush delivers buffered events in order, stopping at the first one that cannot be delivered
+func (s *WebhookAuditSink) flush() {
+	s.mu.Lock()
+	data, err := os.ReadFile(s.opts.BufferPath)
+	s.mu.Unlock()
+	if err != nil || len(data) == 0 {
+		return
+	}
+
+	var delivered int64
+	reader := bufio.NewReader(bytes.NewReader(data))
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err != nil {
+			break // Incomplete trailing line; it is still being written
+		}
+		if err := s.deliver(bytes.TrimSpace(line)); err != nil {
+			if s.setLastErr(err) == nil {
+				log.Printf("Audit webhook delivery failing, buffering events: %v", err)
+			}
+			break
+		}
+		s.setLastErr(nil)
+		delivered += int64(len(line))
+	}
+
+	if delivered > 0 {
+		s.mu.Lock()
+		if err := s.dropFromBuffer(delivered); err != nil {
+			log.Printf("Failed to trim audit webhook buffer: %v", err)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// deliver POSTs one event, retrying with exponential backoff
+func (s *WebhookAuditSink) deliver(body []byte) error {
+	backoff := s.opts.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= s.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-s.stop:
+				return errors.New("audit webhook sink closed")
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		}
+		if err = s.post(body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (s *WebhookAuditSink) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.opts.Secret != "" {
+		mac := hmac.New(sha256.New, []byte(s.opts.Secret))
+		mac.Write(body)
+		req.Header.Set(WebhookSignatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := s.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("audit webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+func (s *WebhookAuditSink) appendToBuffer(line []byte) error {
+	file, err := os.OpenFile(s.opts.BufferPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if s.opts.BufferMax > 0 {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		if info.Size()+int64(len(line)) > s.opts.BufferMax {
+			return s.bufferFullError()
+		}
+	}
+
+	if _, err := file.Write(line); err != nil {
+		return err
+	}
+	return file.Sync()
+}
+
+// dropFromBuffer removes the first n delivered bytes. Writers only append,
+// so everything after them is kept, including events added during delivery.
+func (s *WebhookAuditSink) dropFromBuffer(n int64) error {
+	data, err := os.ReadFile(s.opts.BufferPath)
+	if err != nil {
+		return err
+	}
+	if n > int64(len(data)) {
+		n = int64(len(data))
+	}
+
+	tmp := s.opts.BufferPath + ".tmp"
+	if err := os.WriteFile(tmp, data[n:], 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.opts.BufferPath)
+}
+
+// setLastErr records the latest delivery result and returns the previous error
+func (s *WebhookAuditSink) setLastErr(err error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.lastErr
+	s.lastErr = err
+	return previous
+}
+
+// bufferFullError must be called with mu held
+func (s *WebhookAuditSink) bufferFullError() error {
+	if s.lastErr != nil {
+		return fmt.Errorf("%w (last delivery error: %v)", ErrAuditBufferFull, s.lastErr)
+	}
+	return ErrAuditBufferFull
+}
@@ -5,24 +5,55 @@ import (
 	"ciphersafe/models"
 	"ciphersafe/utils"
 	"errors"
-	"time"
+	"log"
+	"sync"
 
-	"github.com/golang-jwt/jwt/v5"
 	"gorm.io/gorm"
 )
 
-// AuthService handles registration, login, and JWT generation
+var (
+	// ErrPasswordLoginDisabled is returned by Register and Login when DISABLE_PASSWORD_LOGIN is set
+	ErrPasswordLoginDisabled = errors.New("password sign-in is disabled; use single sign-on or a passkey")
+	ErrInvalidCredentials    = errors.New("invalid email or password")
+)
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// dummyPasswordHash is checked against when there is no real hash, so that unknown emails
+// and accounts without a password take as long as a wrong password
+func dummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = utils.HashPassword("ciphersafe-dummy-password", config.AppConfig.PasswordHash)
+	})
+	return dummyHash
+}
+
+// AuthService handles registration and login
 type AuthService struct {
-	UserService *UserService
+	UserService    *UserService
+	SessionService *SessionService
+	Throttle       *LoginThrottle
 }
 
 // NewAuthService creates a new AuthService
-func NewAuthService(userService *UserService) *AuthService {
-	return &AuthService{UserService: userService}
+func NewAuthService(userService *UserService, sessionService *SessionService, throttle *LoginThrottle) *AuthService {
+	return &AuthService{UserService: userService, SessionService: sessionService, Throttle: throttle}
 }
 
-// Register creates a new user, hashes their password, and saves them
+// Register creates a new user, hashes their password, and saves them. Passwords failing the
+// password policy get an error wrapping ErrWeakPassword. A new account is in no organization
+// yet, so organization minimums apply from its first password change.
 func (s *AuthService) Register(email, password string) (*models.User, error) {
+	if config.AppConfig.PasswordLoginDisabled {
+		return nil, ErrPasswordLoginDisabled
+	}
+	if err := CheckPasswordPolicy(password, 0); err != nil {
+		return nil, err
+	}
+
 	// Check if user already exists
 	_, err := s.UserService.FindUserByEmail(email)
 	if err == nil {
@@ -33,7 +64,7 @@ func (s *AuthService) Register(email, password string) (*models.User, error) {
 	}
 
 	// Hash the password
-	passwordHash, err := utils.HashPassword(password)
+	passwordHash, err := utils.HashPassword(password, config.AppConfig.PasswordHash)
 	if err != nil {
 		return nil, err
 	}
@@ -42,43 +73,147 @@ func (s *AuthService) Register(email, password string) (*models.User, error) {
 	return s.UserService.CreateUser(email, passwordHash)
 }
 
-// Login validates user credentials and returns a JWT token
-func (s *AuthService) Login(email, password string) (string, error) {
+// Login validates user credentials and starts a session. Users with TOTP enabled, or in an
+// organization requiring MFA, get ErrMFAPending instead and finish with a second factor.
+// Emails with recent failures get a *LoginThrottledError until their backoff or lockout ends.
+func (s *AuthService) Login(email, password, ip, userAgent string) (*SessionTokens, *models.User, error) {
+	if config.AppConfig.PasswordLoginDisabled {
+		return nil, nil, ErrPasswordLoginDisabled
+	}
+	attempt, err := s.Throttle.Attempt(email)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens, user, err := s.login(email, password, ip, userAgent)
+	switch {
+	case err == nil:
+		if err := s.Throttle.RecordSuccess(email); err != nil {
+			return nil, user, err
+		}
+	case errors.Is(err, ErrInvalidCredentials):
+		// A failure that locks the email out reports the lockout instead
+		if lockErr := attempt.Fail(); lockErr != nil {
+			return nil, user, lockErr
+		}
+	default:
+		if releaseErr := attempt.Release(); releaseErr != nil {
+			log.Printf("Failed to release the sign-in attempt of %s: %v", email, releaseErr)
+		}
+	}
+	return tokens, user, err
+}
+
+// login checks the credentials of an attempt that passed the throttle. A wrong email or
+// password is ErrInvalidCredentials.
+func (s *AuthService) login(email, password, ip, userAgent string) (*SessionTokens, *models.User, error) {
 	// Find user by email
 	user, err := s.UserService.FindUserByEmail(email)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return "", errors.New("invalid email or password")
+			// Spend the time of a password check so the response does not reveal the email is unknown
+			utils.CheckPasswordHash(password, dummyPasswordHash(), config.AppConfig.PasswordHash)
+			return nil, nil, ErrInvalidCredentials
 		}
-		return "", err
+		return nil, nil, err
+	}
+
+	// Check the password. Accounts created by single sign-on have none and never match.
+	hash := user.Password
+	if hash == "" {
+		hash = dummyPasswordHash()
+	}
+	match, needsRehash := utils.CheckPasswordHash(password, hash, config.AppConfig.PasswordHash)
+	if !match || user.Password == "" {
+		return nil, user, ErrInvalidCredentials
+	}
+	if needsRehash {
+		s.rehashPassword(user, password)
+	}
+	if !user.Active {
+		return nil, user, ErrUserDeactivated
+	}
+
+	// Organizations may restrict which sign-in methods their members use
+	if err := CheckAuthMethod(s.UserService.DB, user.ID, models.AuthMethodPassword); err != nil {
+		return nil, user, err
 	}
 
-	// Check the password
-	if !utils.CheckPasswordHash(password, user.Password) {
-		return "", errors.New("invalid email or password")
+	required, err := MFARequired(s.UserService.DB, user.ID)
+	if err != nil {
+		return nil, user, err
+	}
+	if user.TOTPEnabled || required {
+		return nil, user, ErrMFAPending
 	}
 
-	// Generate and return JWT token
-	return s.generateJWT(user.ID)
+	// Issue an access token and a refresh token for a new session
+	tokens, err := s.SessionService.CreateSession(user.ID, ip, userAgent)
+	if err != nil {
+		return nil, user, err
+	}
+	return tokens, user, nil
 }
 
-// generateJWT creates a new JWT token for a given user ID
-func (s *AuthService) generateJWT(userID uint) (string, error) {
-	// Create the claims
-	claims := jwt.MapClaims{
-		"sub": userID,                                // 'sub' (subject) is the standard claim for user ID
-		"iat": time.Now().Unix(),                     // 'iat' (issued at)
-		"exp": time.Now().Add(time.Hour * 24).Unix(), // 'exp' (expiration time) - 24 hours
+// ChangePassword replaces the password of a signed-in user after checking their current one,
+// and signs out their other sessions. The new password must pass the password policy with the
+// strictest minimum length of the user's organizations. Wrong current passwords count towards
+// the sign-in throttle, so a stolen session cannot be used to guess it.
+func (s *AuthService) ChangePassword(userID, sessionID uint, currentPassword, newPassword string) error {
+	if config.AppConfig.PasswordLoginDisabled {
+		return ErrPasswordLoginDisabled
+	}
+	user, err := s.UserService.FindUserByID(userID)
+	if err != nil {
+		return err
+	}
+	minLength, err := PasswordMinLength(s.UserService.DB, userID)
+	if err != nil {
+		return err
+	}
+	if err := CheckPasswordPolicy(newPassword, minLength); err != nil {
+		return err
 	}
 
-	// Create token
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	attempt, err := s.Throttle.Attempt(user.Email)
+	if err != nil {
+		return err
+	}
+	// Accounts created by single sign-on have no password to change
+	hash := user.Password
+	if hash == "" {
+		hash = dummyPasswordHash()
+	}
+	match, _ := utils.CheckPasswordHash(currentPassword, hash, config.AppConfig.PasswordHash)
+	if !match || user.Password == "" {
+		if lockErr := attempt.Fail(); lockErr != nil {
+			return lockErr
+		}
+		return ErrInvalidCredentials
+	}
+	if err := attempt.Release(); err != nil {
+		log.Printf("Failed to release the sign-in attempt of user %d: %v", userID, err)
+	}
 
-	// Sign token with our secret
-	tokenString, err := token.SignedString(config.AppConfig.JWTSecretKey)
+	newHash, err := utils.HashPassword(newPassword, config.AppConfig.PasswordHash)
 	if err != nil {
-		return "", err
+		return err
 	}
+	if err := s.UserService.DB.Model(user).Update("password", newHash).Error; err != nil {
+		return err
+	}
+	_, err = s.SessionService.RevokeOtherSessions(userID, sessionID)
+	return err
+}
 
-	return tokenString, nil
+// rehashPassword replaces a hash made with an older algorithm or cost, now that the password
+// is known. Failing to do so does not fail the sign-in; it is retried next time.
+func (s *AuthService) rehashPassword(user *models.User, password string) {
+	hash, err := utils.HashPassword(password, config.AppConfig.PasswordHash)
+	if err == nil {
+		err = s.UserService.DB.Model(user).Update("password", hash).Error
+	}
+	if err != nil {
+		log.Printf("Failed to upgrade the password hash of user %d: %v", user.ID, err)
+	}
 }
@@ -1,7 +1,6 @@
 package services
 
 import (
-	"ciphersafe/config"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
@@ -11,65 +10,132 @@ import (
 	"io"
 )
 
-// Encrypt encrypts plaintext using AES-GCM with a random nonce.
-// The output is hex-encoded "nonce||ciphertext".
+// DataKeySize is the size in bytes of a project data encryption key (AES-256)
+const DataKeySize = 32
+
+// Encrypt encrypts plaintext under the active master key using AES-GCM with a random nonce.
+// The output is "mk<version>:" followed by hex-encoded "nonce||ciphertext".
 func Encrypt(plaintext string) (string, error) {
-	block, err := aes.NewCipher(config.AppConfig.MasterEncryptionKey)
+	ring, err := MasterKeyring()
 	if err != nil {
 		return "", err
 	}
+	return ring.Encrypt([]byte(plaintext))
+}
 
-	gcm, err := cipher.NewGCM(block)
+// Decrypt decrypts a string produced by Encrypt, using the master key version in its header.
+func Decrypt(ciphertext string) (string, error) {
+	ring, err := MasterKeyring()
 	if err != nil {
 		return "", err
 	}
-
-	// Create a new nonce for every encryption
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+	plaintext, err := ring.Decrypt(ciphertext)
+	if err != nil {
 		return "", err
 	}
+	return string(plaintext), nil
+}
 
-	// Seal encrypts and authenticates the plaintext.
-	// We pass nil for additionalData.
-	ciphertext := gcm.Seal(nil, nonce, []byte(plaintext), nil)
+// GenerateDataKey returns a new random data encryption key
+func GenerateDataKey() ([]byte, error) {
+	dek := make([]byte, DataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return nil, err
+	}
+	return dek, nil
+}
 
-	// Prepend the nonce to the ciphertext
-	fullCiphertext := append(nonce, ciphertext...)
+// WrapDataKey encrypts a data encryption key under the active master key (the KEK)
+func WrapDataKey(dek []byte) (string, error) {
+	ring, err := MasterKeyring()
+	if err != nil {
+		return "", err
+	}
+	return ring.Encrypt(dek)
+}
 
-	return hex.EncodeToString(fullCiphertext), nil
+// UnwrapDataKey decrypts a data encryption key produced by WrapDataKey
+func UnwrapDataKey(wrapped string) ([]byte, error) {
+	ring, err := MasterKeyring()
+	if err != nil {
+		return nil, err
+	}
+	dek, err := ring.Decrypt(wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dek, nil
 }
 
-// Decrypt decrypts hex-encoded "nonce||ciphertext" string.
-func Decrypt(hexCiphertext string) (string, error) {
-	ciphertext, err := hex.DecodeString(hexCiphertext)
+// EncryptWithKey encrypts plaintext using AES-GCM under the given key.
+// aad is authenticated but not encrypted; the same aad must be passed to DecryptWithKey.
+// The output is hex-encoded "nonce||ciphertext".
+func EncryptWithKey(key, plaintext, aad []byte) (string, error) {
+	ciphertext, err := sealAESGCM(key, plaintext, aad)
 	if err != nil {
 		return "", err
 	}
+	return hex.EncodeToString(ciphertext), nil
+}
 
-	block, err := aes.NewCipher(config.AppConfig.MasterEncryptionKey)
+// DecryptWithKey decrypts a hex-encoded "nonce||ciphertext" string under the given key.
+// Decryption fails unless aad matches the value used at encryption time.
+func DecryptWithKey(key []byte, hexCiphertext string, aad []byte) ([]byte, error) {
+	ciphertext, err := hex.DecodeString(hexCiphertext)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	return openAESGCM(key, ciphertext, aad)
+}
+
+// sealAESGCM encrypts plaintext with a random nonce and returns "nonce||ciphertext"
+func sealAESGCM(key, plaintext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
 	}
 
 	gcm, err := cipher.NewGCM(block)
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	// Create a new nonce for every encryption
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	// Seal encrypts and authenticates the plaintext and the additional data,
+	// appending the result to the nonce
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// openAESGCM decrypts "nonce||ciphertext" produced by sealAESGCM
+func openAESGCM(key, ciphertext, aad []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
 	}
 
 	nonceSize := gcm.NonceSize()
 	if len(ciphertext) < nonceSize {
-		return "", errors.New("ciphertext too short")
+		return nil, errors.New("ciphertext too short")
 	}
 
 	// Split the nonce and the actual ciphertext
 	nonce, actualCiphertext := ciphertext[:nonceSize], ciphertext[nonceSize:]
 
 	// Open decrypts and authenticates the ciphertext
-	plaintext, err := gcm.Open(nil, nonce, actualCiphertext, nil)
+	plaintext, err := gcm.Open(nil, nonce, actualCiphertext, aad)
 	if err != nil {
-		return "", fmt.Errorf("decryption failed: %w", err)
+		return nil, fmt.Errorf("decryption failed: %w", err)
 	}
 
-	return string(plaintext), nil
+	return plaintext, nil
 }
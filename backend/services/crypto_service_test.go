@@ -2,8 +2,14 @@ package services
 
 import (
 	"ciphersafe/config"
+	"errors"
 	"os"
 	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func TestMain(m *testing.M) {
@@ -20,6 +26,26 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
+// newMockDB opens gorm on a mock database whose expectations must all be met by the end of the test
+func newMockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	sqlDB, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("Failed to create the mock database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+		sqlDB.Close()
+	})
+	db, err := gorm.Open(postgres.New(postgres.Config{Conn: sqlDB}), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("Failed to open gorm: %v", err)
+	}
+	return db, mock
+}
+
 func TestEncryptDecrypt(t *testing.T) {
 	plaintext := "my-secret-password"
 
@@ -73,3 +99,144 @@ func TestDecryptTamperedCiphertext(t *testing.T) {
 		t.Fatal("Expected error for tampered ciphertext")
 	}
 }
+
+func TestWrapUnwrapDataKey(t *testing.T) {
+	dek, err := GenerateDataKey()
+	if err != nil {
+		t.Fatalf("Failed to generate data key: %v", err)
+	}
+
+	wrapped, err := WrapDataKey(dek)
+	if err != nil {
+		t.Fatalf("Failed to wrap data key: %v", err)
+	}
+
+	unwrapped, err := UnwrapDataKey(wrapped)
+	if err != nil {
+		t.Fatalf("Failed to unwrap data key: %v", err)
+	}
+
+	if string(unwrapped) != string(dek) {
+		t.Fatal("Unwrapped data key does not match original")
+	}
+
+	// A value encrypted under one project's key must not open under another
+	ciphertext, err := EncryptWithKey(dek, []byte("project-secret"), nil)
+	if err != nil {
+		t.Fatalf("Failed to encrypt with data key: %v", err)
+	}
+
+	otherDEK, _ := GenerateDataKey()
+	if _, err := DecryptWithKey(otherDEK, ciphertext, nil); err == nil {
+		t.Fatal("Expected error decrypting with a different data key")
+	}
+}
+
+func TestKeyringRotation(t *testing.T) {
+	oldKey := []byte("abcdefghijklmnopqrstuvwxyz012345")
+	newKey := []byte("ABCDEFGHIJKLMNOPQRSTUVWXYZ012345")
+
+	oldProvider, _ := NewAESKeyProvider("old", oldKey)
+	newProvider, _ := NewAESKeyProvider("new", newKey)
+
+	oldRing, err := NewKeyring(map[uint32]KeyProvider{1: oldProvider}, 1)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	ciphertext, err := oldRing.Encrypt([]byte("rotate-me"))
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+
+	version, _, err := ParseKeyHeader(ciphertext)
+	if err != nil || version != 1 {
+		t.Fatalf("Expected key version 1 in header, got %d (%v)", version, err)
+	}
+
+	// After adding a new active key, old ciphertexts must still decrypt
+	newRing, err := NewKeyring(map[uint32]KeyProvider{1: oldProvider, 2: newProvider}, 2)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	plaintext, err := newRing.Decrypt(ciphertext)
+	if err != nil || string(plaintext) != "rotate-me" {
+		t.Fatalf("Failed to decrypt old ciphertext after rotation: %v", err)
+	}
+
+	rewrapped, err := newRing.Encrypt(plaintext)
+	if err != nil {
+		t.Fatalf("Failed to re-encrypt: %v", err)
+	}
+	if version, _, _ := ParseKeyHeader(rewrapped); version != 2 {
+		t.Fatalf("Expected key version 2 in header, got %d", version)
+	}
+
+	// Legacy ciphertexts without a header belong to version 1
+	legacy, _ := EncryptWithKey(oldKey, []byte("legacy"), nil)
+	if plaintext, err := newRing.Decrypt(legacy); err != nil || string(plaintext) != "legacy" {
+		t.Fatalf("Failed to decrypt legacy ciphertext: %v", err)
+	}
+}
+
+func TestSecretValueBoundToContext(t *testing.T) {
+	dek, _ := GenerateDataKey()
+	ctx := ValueContext{ProjectID: 1, EnvironmentID: 3, SecretID: 7, Key: "API_KEY", Version: 2}
+
+	ciphertext, err := sealSecretValue(dek, ctx, "s3cr3t")
+	if err != nil {
+		t.Fatalf("Failed to seal: %v", err)
+	}
+
+	plaintext, err := openSecretValue(dek, ctx, ciphertext)
+	if err != nil || plaintext != "s3cr3t" {
+		t.Fatalf("Failed to open bound value: %v", err)
+	}
+
+	// A ciphertext moved to another secret, project, environment, key or version must not decrypt
+	moved := []ValueContext{
+		{ProjectID: 2, EnvironmentID: 3, SecretID: 7, Key: "API_KEY", Version: 2},
+		{ProjectID: 1, EnvironmentID: 4, SecretID: 7, Key: "API_KEY", Version: 2},
+		{ProjectID: 1, EnvironmentID: 3, SecretID: 8, Key: "API_KEY", Version: 2},
+		{ProjectID: 1, EnvironmentID: 3, SecretID: 7, Key: "OTHER_KEY", Version: 2},
+		{ProjectID: 1, EnvironmentID: 3, SecretID: 7, Key: "API_KEY", Version: 1},
+	}
+	for _, other := range moved {
+		if _, err := openSecretValue(dek, other, ciphertext); err == nil {
+			t.Fatalf("Expected error opening value under context %+v", other)
+		}
+	}
+
+	// Stripping the bound marker must not bypass authentication
+	if _, err := openSecretValue(dek, ctx, ciphertext[len(boundValuePrefix):]); err == nil {
+		t.Fatal("Expected error opening bound value without its marker")
+	}
+}
+
+func TestLegacySecretValuesOnlyOpenedByMigration(t *testing.T) {
+	dek, _ := GenerateDataKey()
+	ctx := ValueContext{ProjectID: 1, EnvironmentID: 3, SecretID: 7, Key: "API_KEY", Version: 2}
+
+	unbound, _ := EncryptWithKey(dek, []byte("unbound"), nil)
+	legacyBound, _ := EncryptWithKey(dek, []byte("bound without environment"), ctx.legacyAAD())
+	legacy := map[string]string{
+		unbound:                              "unbound",
+		legacyBoundValuePrefix + legacyBound: "bound without environment",
+	}
+
+	for ciphertext, want := range legacy {
+		if _, err := openSecretValue(dek, ctx, ciphertext); !errors.Is(err, ErrUnboundCiphertext) {
+			t.Fatalf("Expected ErrUnboundCiphertext reading %q, got %v", want, err)
+		}
+
+		// The migration upgrades them to the current binding
+		bound, err := rebind(dek, ctx, ciphertext)
+		if err != nil {
+			t.Fatalf("Failed to rebind %q: %v", want, err)
+		}
+		if plaintext, err := openSecretValue(dek, ctx, bound); err != nil || plaintext != want {
+			t.Fatalf("Expected %q after rebinding, got %q (%v)", want, plaintext, err)
+		}
+	}
+}
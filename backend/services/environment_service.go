@@ -0,0 +1,184 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrEnvironmentExists   = errors.New("the project already has an environment with this name")
+	ErrEnvironmentNotEmpty = errors.New("environment still has secrets; delete them first")
+	ErrEnvironmentName     = errors.New("environment names are up to 32 lowercase letters, digits, '-' or '_'")
+	ErrEnvironmentRoles    = errors.New("write_role must be at least writer and at least read_role")
+)
+
+// DefaultEnvironments are created with every project. Secrets written before environments
+// existed are moved into the last one.
+var DefaultEnvironments = []string{"development", "staging", "production"}
+
+var environmentNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,31}$`)
+
+// EnvironmentService manages the environments of a project
+type EnvironmentService struct {
+	DB *gorm.DB
+}
+
+// NewEnvironmentService creates a new EnvironmentService
+func NewEnvironmentService(db *gorm.DB) *EnvironmentService {
+	return &EnvironmentService{DB: db}
+}
+
+// CreateDefaultEnvironments adds the DefaultEnvironments to a new project
+func CreateDefaultEnvironments(db *gorm.DB, projectID uint) error {
+	environments := make([]models.Environment, 0, len(DefaultEnvironments))
+	for _, name := range DefaultEnvironments {
+		environments = append(environments, models.Environment{ProjectID: projectID, Name: name,
+			ReadRole: models.RoleReader, WriteRole: models.RoleWriter})
+	}
+	return db.Create(&environments).Error
+}
+
+// FindEnvironment looks up an environment of a project by name
+func FindEnvironment(db *gorm.DB, projectID uint, name string) (*models.Environment, error) {
+	var environment models.Environment
+	if err := db.Where("project_id = ? AND name = ?", projectID, name).First(&environment).Error; err != nil {
+		return nil, err
+	}
+	return &environment, nil
+}
+
+// ListEnvironments returns the environments of a project in the order they were created
+func (s *EnvironmentService) ListEnvironments(projectID uint) ([]models.Environment, error) {
+	var environments []models.Environment
+	err := s.DB.Where("project_id = ?", projectID).Order("id").Find(&environments).Error
+	return environments, err
+}
+
+// CreateEnvironment adds an environment to a project. Empty roles default to reader and writer.
+func (s *EnvironmentService) CreateEnvironment(projectID uint, name string, readRole, writeRole models.Role) (*models.Environment, error) {
+	environment := &models.Environment{ProjectID: projectID, Name: name, ReadRole: readRole, WriteRole: writeRole}
+	if err := validateEnvironment(environment); err != nil {
+		return nil, err
+	}
+	if err := s.checkNameFree(projectID, name, 0); err != nil {
+		return nil, err
+	}
+	if err := s.DB.Create(environment).Error; err != nil {
+		return nil, err
+	}
+	return environment, nil
+}
+
+// UpdateEnvironment renames an environment and changes the roles its secrets need
+func (s *EnvironmentService) UpdateEnvironment(projectID, environmentID uint, name string, readRole, writeRole models.Role) (*models.Environment, error) {
+	var environment models.Environment
+	if err := s.DB.Where("project_id = ?", projectID).First(&environment, environmentID).Error; err != nil {
+		return nil, err
+	}
+
+	environment.Name, environment.ReadRole, environment.WriteRole = name, readRole, writeRole
+	if err := validateEnvironment(&environment); err != nil {
+		return nil, err
+	}
+	if err := s.checkNameFree(projectID, name, environmentID); err != nil {
+		return nil, err
+	}
+	err := s.DB.Model(&environment).Updates(map[string]interface{}{
+		"name":       environment.Name,
+		"read_role":  environment.ReadRole,
+		"write_role": environment.WriteRole,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	return &environment, nil
+}
+
+// DeleteEnvironment removes an environment that no longer holds secrets
+func (s *EnvironmentService) DeleteEnvironment(projectID, environmentID uint) (*models.Environment, error) {
+	var environment models.Environment
+	if err := s.DB.Where("project_id = ?", projectID).First(&environment, environmentID).Error; err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.DB.Model(&models.Secret{}).Where("environment_id = ?", environmentID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrEnvironmentNotEmpty
+	}
+	if err := s.DB.Delete(&environment).Error; err != nil {
+		return nil, err
+	}
+	return &environment, nil
+}
+
+func (s *EnvironmentService) checkNameFree(projectID uint, name string, exceptID uint) error {
+	var count int64
+	err := s.DB.Model(&models.Environment{}).
+		Where("project_id = ? AND name = ? AND id <> ?", projectID, name, exceptID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrEnvironmentExists
+	}
+	return nil
+}
+
+// validateEnvironment checks the name and roles of an environment, defaulting empty roles
+// to reader and writer
+func validateEnvironment(environment *models.Environment) error {
+	if !environmentNamePattern.MatchString(environment.Name) {
+		return ErrEnvironmentName
+	}
+	if environment.ReadRole == "" {
+		environment.ReadRole = models.RoleReader
+	}
+	if environment.WriteRole == "" {
+		environment.WriteRole = higherRole(models.RoleWriter, environment.ReadRole)
+	}
+	if !environment.ReadRole.Valid() || !environment.WriteRole.Valid() {
+		return ErrInvalidRole
+	}
+	if !environment.WriteRole.AtLeast(models.RoleWriter) || !environment.WriteRole.AtLeast(environment.ReadRole) {
+		return ErrEnvironmentRoles
+	}
+	return nil
+}
+
+// BackfillEnvironments gives projects created before environments existed the default
+// environments, and moves their secrets into the last of them
+func BackfillEnvironments(db *gorm.DB) error {
+	var projectIDs []uint
+	err := db.Model(&models.Project{}).
+		Where("NOT EXISTS (SELECT 1 FROM environments e WHERE e.project_id = projects.id)").
+		Pluck("id", &projectIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, projectID := range projectIDs {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := CreateDefaultEnvironments(tx, projectID); err != nil {
+				return err
+			}
+			target, err := FindEnvironment(tx, projectID, DefaultEnvironments[len(DefaultEnvironments)-1])
+			if err != nil {
+				return err
+			}
+			return tx.Unscoped().Model(&models.Secret{}).
+				Where("project_id = ? AND environment_id = 0", projectID).
+				Update("environment_id", target.ID).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestValidateEnvironment(t *testing.T) {
+	tests := []struct {
+		name      string
+		readRole  models.Role
+		writeRole models.Role
+		wantRead  models.Role
+		wantWrite models.Role
+		err       error
+	}{
+		{"production", "", "", models.RoleReader, models.RoleWriter, nil},
+		{"prod", models.RoleWriter, "", models.RoleWriter, models.RoleWriter, nil},
+		{"prod", models.RoleAdmin, "", models.RoleAdmin, models.RoleAdmin, nil},
+		{"qa_2", models.RoleReader, models.RoleOwner, models.RoleReader, models.RoleOwner, nil},
+		{"Production", "", "", "", "", ErrEnvironmentName},
+		{"-dev", "", "", "", "", ErrEnvironmentName},
+		{"", "", "", "", "", ErrEnvironmentName},
+		{"dev", "", models.RoleReader, "", "", ErrEnvironmentRoles},
+		{"dev", models.RoleAdmin, models.RoleWriter, "", "", ErrEnvironmentRoles},
+		{"dev", "superuser", "", "", "", ErrInvalidRole},
+	}
+
+	for _, tt := range tests {
+		environment := &models.Environment{Name: tt.name, ReadRole: tt.readRole, WriteRole: tt.writeRole}
+		err := validateEnvironment(environment)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%q (%q, %q): got error %v, want %v", tt.name, tt.readRole, tt.writeRole, err, tt.err)
+			continue
+		}
+		if err == nil && (environment.ReadRole != tt.wantRead || environment.WriteRole != tt.wantWrite) {
+			t.Errorf("%q (%q, %q): got roles %q, %q, want %q, %q", tt.name, tt.readRole, tt.writeRole,
+				environment.ReadRole, environment.WriteRole, tt.wantRead, tt.wantWrite)
+		}
+	}
+}
+
+func TestEnvironmentRequiredRole(t *testing.T) {
+	production := &models.Environment{Name: "production", ReadRole: models.RoleWriter, WriteRole: models.RoleAdmin}
+	development := &models.Environment{Name: "development", ReadRole: models.RoleReader, WriteRole: models.RoleWriter}
+
+	tests := []struct {
+		environment *models.Environment
+		minRole     models.Role
+		want        models.Role
+	}{
+		{production, models.RoleReader, models.RoleWriter},
+		{production, models.RoleWriter, models.RoleAdmin},
+		{production, models.RoleOwner, models.RoleOwner},
+		{development, models.RoleReader, models.RoleReader},
+		{development, models.RoleWriter, models.RoleWriter},
+		{&models.Environment{}, models.RoleWriter, models.RoleWriter}, // Unknown environment
+	}
+
+	for _, tt := range tests {
+		if got := tt.environment.RequiredRole(tt.minRole); got != tt.want {
+			t.Errorf("%q needing %q: got %q, want %q", tt.environment.Name, tt.minRole, got, tt.want)
+		}
+	}
+}
+
+func TestInstallSecretKeyIndexAuditsDeletions(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT \* FROM "secrets" WHERE \(EXISTS`).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "project_id", "environment_id", "key"}).AddRow(4, 7, 3, "API_KEY"))
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "secrets" SET "deleted_at"=\$1 WHERE "secrets"."id" = \$2`).
+		WithArgs(sqlmock.AnyArg(), 4).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`SAVEPOINT`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`pg_advisory_xact_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`FROM "audit_events"`).WillReturnRows(sqlmock.NewRows([]string{"seq", "hash"}))
+	mock.ExpectQuery(`INSERT INTO "audit_events"`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), nil, ActorSystem, "secret.delete", "secret", "4", 7,
+			sqlmock.AnyArg(), sqlmock.AnyArg(), AuditSuccess, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+	mock.ExpectCommit()
+
+	mock.ExpectExec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_secrets_environment_key`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := InstallSecretKeyIndex(db, NewAuditService(db, nil)); err != nil {
+		t.Fatalf("Failed to install the index: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KeyProvider protects one master key (KEK). Key material may live outside the process,
+// so callers only ever ask the provider to wrap or unwrap small payloads such as data keys.
+type KeyProvider interface {
+	// Name describes the provider and key for logs and the admin API, never the key itself
+	Name() string
+	// Wrap encrypts and authenticates plaintext under the master key
+	Wrap(plaintext []byte) ([]byte, error)
+	// Unwrap reverses Wrap
+	Unwrap(ciphertext []byte) ([]byte, error)
+}
+
+// AESKeyProvider wraps with AES-GCM under an in-memory key
+type AESKeyProvider struct {
+	name string
+	key  []byte
+}
+
+// NewAESKeyProvider creates a provider for a raw AES-128/192/256 key
+func NewAESKeyProvider(name string, key []byte) (*AESKeyProvider, error) {
+	switch len(key) {
+	case 16, 24, 32:
+	default:
+		return nil, fmt.Errorf("%s: master key must be 16, 24 or 32 bytes, got %d", name, len(key))
+	}
+	return &AESKeyProvider{name: name, key: key}, nil
+}
+
+func (p *AESKeyProvider) Name() string { return p.name }
+
+func (p *AESKeyProvider) Wrap(plaintext []byte) ([]byte, error) {
+	return sealAESGCM(p.key, plaintext, nil)
+}
+
+func (p *AESKeyProvider) Unwrap(ciphertext []byte) ([]byte, error) {
+	return openAESGCM(p.key, ciphertext, nil)
+}
+
+// NewFileKeyProvider loads a master key from a file readable only by its owner.
+// The file may hold the raw key bytes, or the key in hex or base64.
+func NewFileKeyProvider(path string) (*AESKeyProvider, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode().Perm()&0o077 != 0 {
+		return nil, fmt.Errorf("key file %s must not be accessible by group or others (mode %o)", path, info.Mode().Perm())
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewAESKeyProvider("file:"+path, decodeKeyFile(data))
+}
+
+// decodeKeyFile accepts raw, hex or base64 key material
+func decodeKeyFile(data []byte) []byte {
+	text := strings.TrimSpace(string(data))
+	if key, err := hex.DecodeString(text); err == nil && validAESKeySize(len(key)) {
+		return key
+	}
+	if key, err := base64.StdEncoding.DecodeString(text); err == nil && validAESKeySize(len(key)) {
+		return key
+	}
+	return data
+}
+
+func validAESKeySize(n int) bool {
+	return n == 16 || n == 24 || n == 32
+}
+
+// NewKeyProviders creates a provider for every master key version in the config
+func NewKeyProviders(cfg *config.Config) (map[uint32]KeyProvider, error) {
+	providers := make(map[uint32]KeyProvider)
+
+	switch cfg.KeyProvider {
+	case config.KeyProviderEnv:
+		for version, key := range cfg.MasterKeys {
+			p, err := NewAESKeyProvider(fmt.Sprintf("env:v%d", version), key)
+			if err != nil {
+				return nil, err
+			}
+			providers[version] = p
+		}
+
+	case config.KeyProviderFile:
+		for version, path := range cfg.MasterKeyRefs {
+			p, err := NewFileKeyProvider(path)
+			if err != nil {
+				return nil, err
+			}
+			providers[version] = p
+		}
+
+	case config.KeyProviderPKCS11:
+		for version, label := range cfg.MasterKeyRefs {
+			p, err := NewPKCS11KeyProvider(cfg.PKCS11Module, cfg.PKCS11TokenLabel, cfg.PKCS11PIN, label)
+			if err != nil {
+				return nil, err
+			}
+			providers[version] = p
+		}
+
+	case config.KeyProviderKMS:
+		for version, keyID := range cfg.MasterKeyRefs {
+			providers[version] = NewKMSKeyProvider(cfg.KMSURL, cfg.KMSToken, keyID)
+		}
+
+	default:
+		return nil, errors.New("unknown key provider " + cfg.KeyProvider)
+	}
+
+	return providers, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KMSKeyProvider wraps and unwraps through a remote KMS over HTTP.
+//
+// Protocol (JSON, binary fields base64-encoded):
+//
+//	POST {base}/v1/keys/{keyID}/wrap    {"plaintext": "..."}  -> {"ciphertext": "..."}
+//	POST {base}/v1/keys/{keyID}/unwrap  {"ciphertext": "..."} -> {"plaintext": "..."}
+//
+// Requests carry "Authorization: Bearer <token>" when a token is configured.
+// Errors are reported with a non-2xx status and an optional {"error": "..."} body.
+type KMSKeyProvider struct {
+	baseURL string
+	token   string
+	keyID   string
+	client  *http.Client
+}
+
+// NewKMSKeyProvider creates a provider for a key held by the KMS at baseURL
+func NewKMSKeyProvider(baseURL, token, keyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{
+		baseURL: strings.TrimRight(baseURL, "/"),
+		token:   token,
+		keyID:   keyID,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type kmsRequest struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+}
+
+type kmsResponse struct {
+	Plaintext  []byte `json:"plaintext,omitempty"`
+	Ciphertext []byte `json:"ciphertext,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+func (p *KMSKeyProvider) Name() string { return "kms:" + p.keyID }
+
+func (p *KMSKeyProvider) Wrap(plaintext []byte) ([]byte, error) {
+	resp, err := p.call("wrap", kmsRequest{Plaintext: plaintext})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+func (p *KMSKeyProvider) Unwrap(ciphertext []byte) ([]byte, error) {
+	resp, err := p.call("unwrap", kmsRequest{Ciphertext: ciphertext})
+	if err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+func (p *KMSKeyProvider) call(op string, body kmsRequest) (*kmsResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	endpoint := fmt.Sprintf("%s/v1/keys/%s/%s", p.baseURL, url.PathEscape(p.keyID), op)
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("kms %s failed: %w", op, err)
+	}
+	defer res.Body.Close()
+
+	var out kmsResponse
+	if err := json.NewDecoder(res.Body).Decode(&out); err != nil && res.StatusCode/100 == 2 {
+		return nil, fmt.Errorf("kms %s returned an invalid response: %w", op, err)
+	}
+	if res.StatusCode/100 != 2 {
+		if out.Error != "" {
+			return nil, fmt.Errorf("kms %s failed: %s", op, out.Error)
+		}
+		return nil, fmt.Errorf("kms %s failed with status %d", op, res.StatusCode)
+	}
+
+	return &out, nil
+}
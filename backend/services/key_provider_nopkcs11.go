@@ -0,0 +1,10 @@
+//go:build !pkcs11
+
+package services
+
+import "errors"
+
+// NewPKCS11KeyProvider is unavailable unless the server is built with -tags pkcs11
+func NewPKCS11KeyProvider(module, tokenLabel, pin, keyLabel string) (KeyProvider, error) {
+	return nil, errors.New("pkcs11 key provider requires building with -tags pkcs11")
+}
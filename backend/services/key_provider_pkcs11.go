@@ -0,0 +1,172 @@
+//go:build pkcs11
+
+package services
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+const pkcs11GCMNonceSize = 12
+
+// pkcs11Token is a logged-in session on a token, shared by every key stored on it.
+// PKCS#11 sessions cannot run two operations at once, so all use is serialized.
+type pkcs11Token struct {
+	mu      sync.Mutex
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+}
+
+var (
+	pkcs11TokensMu sync.Mutex
+	pkcs11Tokens   = map[string]*pkcs11Token{}
+)
+
+// PKCS11KeyProvider wraps with AES-GCM inside a PKCS#11 token; the key never leaves the token.
+// Build with -tags pkcs11 (requires cgo). SoftHSM v2 works for development and tests.
+type PKCS11KeyProvider struct {
+	label string
+	token *pkcs11Token
+	key   pkcs11.ObjectHandle
+}
+
+// NewPKCS11KeyProvider finds the AES secret key labelled keyLabel on the token labelled tokenLabel
+func NewPKCS11KeyProvider(module, tokenLabel, pin, keyLabel string) (KeyProvider, error) {
+	token, err := openPKCS11Token(module, tokenLabel, pin)
+	if err != nil {
+		return nil, err
+	}
+
+	token.mu.Lock()
+	defer token.mu.Unlock()
+
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_SECRET_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_AES),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, keyLabel),
+	}
+	if err := token.ctx.FindObjectsInit(token.session, template); err != nil {
+		return nil, err
+	}
+	objects, _, err := token.ctx.FindObjects(token.session, 2)
+	if finalErr := token.ctx.FindObjectsFinal(token.session); err == nil {
+		err = finalErr
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch len(objects) {
+	case 0:
+		return nil, fmt.Errorf("pkcs11: no AES key labelled %q on token %q", keyLabel, tokenLabel)
+	case 1:
+	default:
+		return nil, fmt.Errorf("pkcs11: more than one AES key labelled %q on token %q", keyLabel, tokenLabel)
+	}
+
+	return &PKCS11KeyProvider{label: keyLabel, token: token, key: objects[0]}, nil
+}
+
+// openPKCS11Token loads the module once and logs in to the token once per process
+func openPKCS11Token(module, tokenLabel, pin string) (*pkcs11Token, error) {
+	pkcs11TokensMu.Lock()
+	defer pkcs11TokensMu.Unlock()
+
+	cacheKey := module + "|" + tokenLabel
+	if token, ok := pkcs11Tokens[cacheKey]; ok {
+		return token, nil
+	}
+
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, fmt.Errorf("pkcs11: failed to load module %s", module)
+	}
+	if err := ctx.Initialize(); err != nil {
+		if e, ok := err.(pkcs11.Error); !ok || e != pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED {
+			return nil, err
+		}
+	}
+
+	slots, err := ctx.GetSlotList(true)
+	if err != nil {
+		return nil, err
+	}
+	for _, slot := range slots {
+		info, err := ctx.GetTokenInfo(slot)
+		if err != nil || strings.TrimSpace(info.Label) != tokenLabel {
+			continue
+		}
+
+		session, err := ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION)
+		if err != nil {
+			return nil, err
+		}
+		if err := ctx.Login(session, pkcs11.CKU_USER, pin); err != nil {
+			if e, ok := err.(pkcs11.Error); !ok || e != pkcs11.CKR_USER_ALREADY_LOGGED_IN {
+				return nil, err
+			}
+		}
+
+		token := &pkcs11Token{ctx: ctx, session: session}
+		pkcs11Tokens[cacheKey] = token
+		return token, nil
+	}
+
+	return nil, fmt.Errorf("pkcs11: token %q not found", tokenLabel)
+}
+
+func (p *PKCS11KeyProvider) Name() string { return "pkcs11:" + p.label }
+
+// Wrap returns nonce||ciphertext||tag, matching the layout of AESKeyProvider
+func (p *PKCS11KeyProvider) Wrap(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, pkcs11GCMNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+
+	p.token.mu.Lock()
+	defer p.token.mu.Unlock()
+
+	params := pkcs11.NewGCMParams(nonce, nil, 128)
+	defer params.Free()
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+	if err := p.token.ctx.EncryptInit(p.token.session, mech, p.key); err != nil {
+		return nil, err
+	}
+	ciphertext, err := p.token.ctx.Encrypt(p.token.session, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(nonce, ciphertext...), nil
+}
+
+func (p *PKCS11KeyProvider) Unwrap(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) < pkcs11GCMNonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
+	nonce, body := ciphertext[:pkcs11GCMNonceSize], ciphertext[pkcs11GCMNonceSize:]
+
+	p.token.mu.Lock()
+	defer p.token.mu.Unlock()
+
+	params := pkcs11.NewGCMParams(nonce, nil, 128)
+	defer params.Free()
+
+	mech := []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_AES_GCM, params)}
+	if err := p.token.ctx.DecryptInit(p.token.session, mech, p.key); err != nil {
+		return nil, err
+	}
+	plaintext, err := p.token.ctx.Decrypt(p.token.session, body)
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed: %w", err)
+	}
+
+	return plaintext, nil
+}
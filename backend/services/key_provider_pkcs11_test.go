@@ -0,0 +1,39 @@
+//go:build pkcs11
+
+package services
+
+import (
+	"os"
+	"testing"
+)
+
+// TestPKCS11KeyProvider runs against a prepared SoftHSM token, for example:
+//
+//	softhsm2-util --init-token --free --label ciphersafe --pin 1234 --so-pin 1234
+//	pkcs11-tool --module $PKCS11_MODULE --login --pin 1234 --keygen --key-type AES:32 --label master-1
+//	PKCS11_MODULE=/usr/lib/softhsm/libsofthsm2.so PKCS11_TOKEN_LABEL=ciphersafe PKCS11_PIN=1234 \
+//	PKCS11_KEY_LABEL=master-1 go test -tags pkcs11 ./services
+func TestPKCS11KeyProvider(t *testing.T) {
+	module := os.Getenv("PKCS11_MODULE")
+	if module == "" {
+		t.Skip("PKCS11_MODULE not set")
+	}
+
+	provider, err := NewPKCS11KeyProvider(module, os.Getenv("PKCS11_TOKEN_LABEL"), os.Getenv("PKCS11_PIN"), os.Getenv("PKCS11_KEY_LABEL"))
+	if err != nil {
+		t.Fatalf("Failed to open PKCS#11 key: %v", err)
+	}
+
+	wrapped, err := provider.Wrap([]byte("data-key"))
+	if err != nil {
+		t.Fatalf("Failed to wrap: %v", err)
+	}
+	if plaintext, err := provider.Unwrap(wrapped); err != nil || string(plaintext) != "data-key" {
+		t.Fatalf("Failed to unwrap: %v", err)
+	}
+
+	wrapped[len(wrapped)-1] ^= 1
+	if _, err := provider.Unwrap(wrapped); err == nil {
+		t.Fatal("Expected error for tampered ciphertext")
+	}
+}
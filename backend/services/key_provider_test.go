@@ -0,0 +1,108 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// kmsStandIn is a minimal local KMS speaking the KMSKeyProvider protocol
+func kmsStandIn(t *testing.T, token string, keys map[string][]byte) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(kmsResponse{Error: "unauthorized"})
+			return
+		}
+
+		parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/v1/keys/"), "/")
+		key, ok := keys[parts[0]]
+		if len(parts) != 2 || !ok {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(kmsResponse{Error: "unknown key"})
+			return
+		}
+
+		var req kmsRequest
+		json.NewDecoder(r.Body).Decode(&req)
+
+		var resp kmsResponse
+		var err error
+		switch parts[1] {
+		case "wrap":
+			resp.Ciphertext, err = sealAESGCM(key, req.Plaintext, nil)
+		case "unwrap":
+			resp.Plaintext, err = openAESGCM(key, req.Ciphertext, nil)
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			resp = kmsResponse{Error: err.Error()}
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	server := kmsStandIn(t, "kms-token", map[string][]byte{
+		"master-1": []byte("0123456789abcdef0123456789abcdef"),
+	})
+	defer server.Close()
+
+	provider := NewKMSKeyProvider(server.URL, "kms-token", "master-1")
+
+	ring, err := NewKeyring(map[uint32]KeyProvider{1: provider}, 1)
+	if err != nil {
+		t.Fatalf("Failed to create keyring: %v", err)
+	}
+
+	wrapped, err := ring.Encrypt([]byte("data-key"))
+	if err != nil {
+		t.Fatalf("Failed to wrap through KMS: %v", err)
+	}
+
+	plaintext, err := ring.Decrypt(wrapped)
+	if err != nil || string(plaintext) != "data-key" {
+		t.Fatalf("Failed to unwrap through KMS: %v", err)
+	}
+
+	if _, err := NewKMSKeyProvider(server.URL, "wrong-token", "master-1").Wrap([]byte("x")); err == nil {
+		t.Fatal("Expected error with an invalid KMS token")
+	}
+	if _, err := NewKMSKeyProvider(server.URL, "kms-token", "missing").Wrap([]byte("x")); err == nil {
+		t.Fatal("Expected error for an unknown KMS key")
+	}
+}
+
+func TestFileKeyProvider(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "master.key")
+
+	if err := os.WriteFile(path, []byte("MDEyMzQ1Njc4OWFiY2RlZjAxMjM0NTY3ODlhYmNkZWY=\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	provider, err := NewFileKeyProvider(path)
+	if err != nil {
+		t.Fatalf("Failed to load key file: %v", err)
+	}
+
+	wrapped, err := provider.Wrap([]byte("data-key"))
+	if err != nil {
+		t.Fatalf("Failed to wrap: %v", err)
+	}
+	if plaintext, err := provider.Unwrap(wrapped); err != nil || string(plaintext) != "data-key" {
+		t.Fatalf("Failed to unwrap: %v", err)
+	}
+
+	// Key files readable by other users are refused
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatalf("Failed to chmod key file: %v", err)
+	}
+	if _, err := NewFileKeyProvider(path); err == nil {
+		t.Fatal("Expected error for a world-readable key file")
+	}
+}
@@ -0,0 +1,135 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ErrProjectKeyShredded is returned when a project's data key has been destroyed
+var ErrProjectKeyShredded = errors.New("project encryption key has been shredded")
+
+// NewWrappedDataKey generates a fresh project data key and returns it wrapped by the master key
+func NewWrappedDataKey() (string, error) {
+	dek, err := GenerateDataKey()
+	if err != nil {
+		return "", err
+	}
+	return WrapDataKey(dek)
+}
+
+// ProjectDataKey loads and unwraps the data encryption key of a project
+func ProjectDataKey(db *gorm.DB, projectID uint) ([]byte, error) {
+	var project models.Project
+	if err := db.Unscoped().Select("id", "encrypted_dek", "key_shredded_at").First(&project, projectID).Error; err != nil {
+		return nil, err
+	}
+	if project.KeyShreddedAt != nil || project.EncryptedDEK == "" {
+		return nil, ErrProjectKeyShredded
+	}
+	return UnwrapDataKey(project.EncryptedDEK)
+}
+
+// ShredProjectKey destroys the wrapped data key of a project and deletes the project.
+// Every secret and version encrypted under the key becomes permanently unreadable.
+func ShredProjectKey(db *gorm.DB, projectID uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		if err := tx.Model(&models.Project{}).Where("id = ?", projectID).Updates(map[string]interface{}{
+			"encrypted_dek":   "",
+			"key_shredded_at": now,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", projectID).Delete(&models.Secret{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", projectID).Delete(&models.Environment{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", projectID).Delete(&models.ProjectMember{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("project_id = ?", projectID).Delete(&models.ServiceAccountGrant{}).Error; err != nil {
+			return err
+		}
+		// Service accounts owned by the project go with it; their keys stop authenticating
+		if err := tx.Where("project_id = ?", projectID).Delete(&models.ServiceAccount{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.Project{}, projectID).Error
+	})
+}
+
+// MigrateToEnvelopeEncryption gives every project without a data key its own DEK and
+// re-encrypts that project's secrets, which were written under the master key, with it.
+// Each project is migrated in its own transaction so a crash leaves no project half-converted.
+func MigrateToEnvelopeEncryption(db *gorm.DB) error {
+	var projects []models.Project
+	if err := db.Unscoped().Where("encrypted_dek = '' AND key_shredded_at IS NULL").Find(&projects).Error; err != nil {
+		return err
+	}
+
+	for _, project := range projects {
+		if err := migrateProjectToEnvelope(db, project.ID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func migrateProjectToEnvelope(db *gorm.DB, projectID uint) error {
+	dek, err := GenerateDataKey()
+	if err != nil {
+		return err
+	}
+	wrapped, err := WrapDataKey(dek)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var secrets []models.Secret
+		if err := tx.Unscoped().Where("project_id = ?", projectID).Find(&secrets).Error; err != nil {
+			return err
+		}
+
+		for _, secret := range secrets {
+			value, err := reencryptUnderDataKey(secret.Value, dek)
+			if err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(&secret).Update("value", value).Error; err != nil {
+				return err
+			}
+
+			var versions []models.SecretVersion
+			if err := tx.Where("secret_id = ?", secret.ID).Find(&versions).Error; err != nil {
+				return err
+			}
+			for _, v := range versions {
+				value, err := reencryptUnderDataKey(v.Value, dek)
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(&v).Update("value", value).Error; err != nil {
+					return err
+				}
+			}
+		}
+
+		return tx.Unscoped().Model(&models.Project{}).Where("id = ?", projectID).Update("encrypted_dek", wrapped).Error
+	})
+}
+
+// reencryptUnderDataKey decrypts a master-key ciphertext and encrypts it again under dek
+func reencryptUnderDataKey(ciphertext string, dek []byte) (string, error) {
+	plaintext, err := Decrypt(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return EncryptWithKey(dek, []byte(plaintext), nil)
+}
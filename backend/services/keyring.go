@@ -0,0 +1,162 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// keyHeaderPrefix marks a master-key ciphertext that records its key version: "mk<version>:<hex>".
+// Ciphertexts without the header predate the keyring and belong to key version 1.
+const keyHeaderPrefix = "mk"
+
+// legacyKeyVersion is the key version assumed for ciphertexts without a header
+const legacyKeyVersion = 1
+
+// Keyring holds every known master key provider by version, with one marked active.
+// New ciphertexts always use the active key; old ones stay readable while their key is present.
+type Keyring struct {
+	mu     sync.RWMutex
+	keys   map[uint32]KeyProvider
+	active uint32
+}
+
+// NewKeyring creates a Keyring from versioned key providers
+func NewKeyring(keys map[uint32]KeyProvider, active uint32) (*Keyring, error) {
+	if _, ok := keys[active]; !ok {
+		return nil, fmt.Errorf("active master key version %d is not in the keyring", active)
+	}
+
+	ring := &Keyring{keys: make(map[uint32]KeyProvider, len(keys)), active: active}
+	for version, key := range keys {
+		ring.keys[version] = key
+	}
+	return ring, nil
+}
+
+// ActiveVersion returns the version of the key used for new encryptions
+func (k *Keyring) ActiveVersion() uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Providers describes the provider behind each key version
+func (k *Keyring) Providers() map[uint32]string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	names := make(map[uint32]string, len(k.keys))
+	for version, key := range k.keys {
+		names[version] = key.Name()
+	}
+	return names
+}
+
+// Versions returns all key versions in ascending order
+func (k *Keyring) Versions() []uint32 {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	versions := make([]uint32, 0, len(k.keys))
+	for version := range k.keys {
+		versions = append(versions, version)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// Encrypt encrypts plaintext under the active key and prefixes the key version header
+func (k *Keyring) Encrypt(plaintext []byte) (string, error) {
+	k.mu.RLock()
+	version, key := k.active, k.keys[k.active]
+	k.mu.RUnlock()
+
+	ciphertext, err := key.Wrap(plaintext)
+	if err != nil {
+		return "", err
+	}
+	return keyHeaderPrefix + strconv.FormatUint(uint64(version), 10) + ":" + hex.EncodeToString(ciphertext), nil
+}
+
+// Decrypt decrypts a ciphertext with the key version recorded in its header
+func (k *Keyring) Decrypt(ciphertext string) ([]byte, error) {
+	version, body, err := ParseKeyHeader(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.RLock()
+	key, ok := k.keys[version]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("master key version %d is not in the keyring", version)
+	}
+
+	raw, err := hex.DecodeString(body)
+	if err != nil {
+		return nil, err
+	}
+	return key.Unwrap(raw)
+}
+
+// ParseKeyHeader splits a master-key ciphertext into its key version and hex body
+func ParseKeyHeader(ciphertext string) (uint32, string, error) {
+	if !strings.HasPrefix(ciphertext, keyHeaderPrefix) {
+		return legacyKeyVersion, ciphertext, nil
+	}
+
+	versionStr, body, found := strings.Cut(strings.TrimPrefix(ciphertext, keyHeaderPrefix), ":")
+	if !found {
+		return 0, "", errors.New("malformed key version header")
+	}
+	version, err := strconv.ParseUint(versionStr, 10, 32)
+	if err != nil {
+		return 0, "", errors.New("malformed key version header")
+	}
+	return uint32(version), body, nil
+}
+
+var (
+	masterKeyringMu sync.Mutex
+	masterKeyring   *Keyring
+)
+
+// ErrSealed is returned when the master key is needed while the server is sealed
+var ErrSealed = errors.New("server is sealed")
+
+// MasterKeyring returns the process-wide master keyring, building it from config on first use.
+// In sealed mode the keyring only exists between unseal and seal.
+func MasterKeyring() (*Keyring, error) {
+	masterKeyringMu.Lock()
+	defer masterKeyringMu.Unlock()
+
+	if masterKeyring == nil {
+		if config.AppConfig.IsSealable() {
+			return nil, ErrSealed
+		}
+
+		providers, err := NewKeyProviders(config.AppConfig)
+		if err != nil {
+			return nil, err
+		}
+		ring, err := NewKeyring(providers, config.AppConfig.ActiveMasterKeyVersion)
+		if err != nil {
+			return nil, err
+		}
+		masterKeyring = ring
+	}
+	return masterKeyring, nil
+}
+
+// setMasterKeyring installs or, with nil, removes the process-wide master keyring
+func setMasterKeyring(ring *Keyring) {
+	masterKeyringMu.Lock()
+	defer masterKeyringMu.Unlock()
+	masterKeyring = ring
+}
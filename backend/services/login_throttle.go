@@ -0,0 +1,224 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// loginBackoffMaxShift bounds the doubling so the backoff cannot overflow
+const loginBackoffMaxShift = 20
+
+// LoginThrottledError is returned while an email has to wait before its next sign-in attempt
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool // Locked out after too many failures, rather than backing off
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return "too many failed sign-in attempts; the account is temporarily locked"
+	}
+	return "too many failed sign-in attempts; try again later"
+}
+
+// LoginThrottle slows down password and second-factor guessing per email with exponential
+// backoff and a temporary lockout. Unknown emails are throttled like registered ones.
+type LoginThrottle struct {
+	DB *gorm.DB
+}
+
+// NewLoginThrottle creates a new LoginThrottle
+func NewLoginThrottle(db *gorm.DB) *LoginThrottle {
+	return &LoginThrottle{DB: db}
+}
+
+// LoginAttempt is a sign-in attempt that passed the throttle. It counts as a failure from the
+// start; end it with Fail, Release or RecordSuccess.
+type LoginAttempt struct {
+	throttle    *LoginThrottle
+	key         string
+	counted     bool                 // False when throttling is disabled
+	previous    *models.LoginLockout // The record before the attempt, nil if there was none
+	failures    int
+	at          time.Time
+	lockedUntil *time.Time // Set when the attempt locked the email out
+}
+
+// Attempt starts a sign-in attempt for email, or returns a *LoginThrottledError if email may
+// not try yet. The check and counting the attempt as a failure happen in one transaction, so
+// concurrent guesses cannot all pass the check before any of them is recorded.
+func (t *LoginThrottle) Attempt(email string) (*LoginAttempt, error) {
+	cfg := config.AppConfig
+	attempt := &LoginAttempt{throttle: t, key: loginThrottleKey(email)}
+	if cfg.LoginMaxFailures == 0 {
+		return attempt, nil
+	}
+
+	// Postgres keeps microseconds, and Release compares the stored time with this one
+	now := time.Now().Truncate(time.Microsecond)
+	err := t.DB.Transaction(func(tx *gorm.DB) error {
+		// Concurrent attempts for a new email must not race on the unique index
+		created := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&models.LoginLockout{Email: attempt.key, LastFailedAt: now})
+		if created.Error != nil {
+			return created.Error
+		}
+
+		var lockout models.LoginLockout
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("email = ?", attempt.key).First(&lockout).Error; err != nil {
+			return err
+		}
+		if created.RowsAffected == 0 {
+			previous := lockout
+			attempt.previous = &previous
+			if wait, locked := loginWait(&lockout, now, cfg.LoginBackoffBase, cfg.LoginLockoutDuration); wait > 0 {
+				return &LoginThrottledError{RetryAfter: wait, Locked: locked}
+			}
+		}
+
+		attempt.failures, attempt.lockedUntil = countLoginFailure(&lockout, now, cfg.LoginMaxFailures, cfg.LoginLockoutDuration)
+		attempt.at = now
+		attempt.counted = true
+		return tx.Model(&lockout).Updates(map[string]interface{}{
+			"failed_count": attempt.failures, "last_failed_at": now, "locked_until": attempt.lockedUntil,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return attempt, nil
+}
+
+// Fail ends an attempt whose password or code was wrong. It stays counted; Fail returns a
+// *LoginThrottledError when it locked the email out.
+func (a *LoginAttempt) Fail() error {
+	if a.lockedUntil != nil {
+		return &LoginThrottledError{RetryAfter: time.Until(*a.lockedUntil), Locked: true}
+	}
+	return nil
+}
+
+// Release ends an attempt that neither failed nor completed a sign-in, such as a correct
+// password that still needs a second factor, and restores the record from before it
+func (a *LoginAttempt) Release() error {
+	if !a.counted {
+		return nil
+	}
+	return a.throttle.DB.Transaction(func(tx *gorm.DB) error {
+		var lockout models.LoginLockout
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("email = ?", a.key).First(&lockout).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil // Cleared meanwhile
+		}
+		if err != nil {
+			return err
+		}
+		// Leave the record alone if another attempt was counted since
+		if lockout.FailedCount != a.failures || !lockout.LastFailedAt.Equal(a.at) {
+			return nil
+		}
+
+		if a.previous == nil {
+			return tx.Delete(&lockout).Error
+		}
+		return tx.Model(&lockout).Updates(map[string]interface{}{
+			"failed_count": a.previous.FailedCount, "last_failed_at": a.previous.LastFailedAt, "locked_until": a.previous.LockedUntil,
+		}).Error
+	})
+}
+
+// RecordSuccess forgets the failed attempts of an email once a sign-in completes
+func (t *LoginThrottle) RecordSuccess(email string) error {
+	return t.DB.Where("email = ?", loginThrottleKey(email)).Delete(&models.LoginLockout{}).Error
+}
+
+// ListLockouts returns the emails that are locked out or have recent failures, and drops
+// the records that no longer matter
+func (t *LoginThrottle) ListLockouts() ([]models.LoginLockout, error) {
+	now := time.Now()
+	staleBefore := now.Add(-config.AppConfig.LoginLockoutDuration)
+	err := t.DB.Where("last_failed_at < ? AND (locked_until IS NULL OR locked_until < ?)", staleBefore, now).
+		Delete(&models.LoginLockout{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var lockouts []models.LoginLockout
+	err = t.DB.Order("last_failed_at desc").Find(&lockouts).Error
+	return lockouts, err
+}
+
+// ClearLockout deletes a lockout record, letting the email sign in again immediately
+func (t *LoginThrottle) ClearLockout(id uint) (*models.LoginLockout, error) {
+	var lockout models.LoginLockout
+	if err := t.DB.First(&lockout, id).Error; err != nil {
+		return nil, err
+	}
+	if err := t.DB.Delete(&lockout).Error; err != nil {
+		return nil, err
+	}
+	return &lockout, nil
+}
+
+// countLoginFailure returns the failure count of lockout after one more failure at now, and
+// when the email is locked out until if that reaches maxFailures
+func countLoginFailure(lockout *models.LoginLockout, now time.Time, maxFailures int, lockoutDuration time.Duration) (int, *time.Time) {
+	failures := activeLoginFailures(lockout, now, lockoutDuration) + 1
+	if failures < maxFailures {
+		return failures, nil
+	}
+	until := now.Add(lockoutDuration)
+	return failures, &until
+}
+
+// loginWait returns how long the email of lockout must wait before its next attempt at now,
+// and whether that is because it is locked out
+func loginWait(lockout *models.LoginLockout, now time.Time, base, lockoutDuration time.Duration) (time.Duration, bool) {
+	if lockout.LockedUntil != nil && lockout.LockedUntil.After(now) {
+		return lockout.LockedUntil.Sub(now), true
+	}
+
+	failures := activeLoginFailures(lockout, now, lockoutDuration)
+	if failures == 0 {
+		return 0, false
+	}
+	if wait := lockout.LastFailedAt.Add(loginBackoff(failures, base, lockoutDuration)).Sub(now); wait > 0 {
+		return wait, false
+	}
+	return 0, false
+}
+
+// activeLoginFailures returns the failures that still count: a served lockout or a quiet
+// period as long as the lockout starts the count over
+func activeLoginFailures(lockout *models.LoginLockout, now time.Time, lockoutDuration time.Duration) int {
+	if lockout.LockedUntil != nil && !lockout.LockedUntil.After(now) {
+		return 0
+	}
+	if now.Sub(lockout.LastFailedAt) > lockoutDuration {
+		return 0
+	}
+	return lockout.FailedCount
+}
+
+// loginBackoff is the wait after the given number of consecutive failures: base, doubled for
+// each further failure, at most max
+func loginBackoff(failures int, base, max time.Duration) time.Duration {
+	shift := failures - 1
+	if shift > loginBackoffMaxShift {
+		shift = loginBackoffMaxShift
+	}
+	if delay := base << shift; delay < max {
+		return delay
+	}
+	return max
+}
+
+func loginThrottleKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
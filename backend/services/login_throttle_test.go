@@ -0,0 +1,109 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"testing"
+	"time"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{4, 8 * time.Second},
+		{11, 15 * time.Minute}, // Capped at the lockout duration
+		{1000, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := loginBackoff(tt.failures, time.Second, 15*time.Minute); got != tt.want {
+			t.Errorf("loginBackoff(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestLoginWait(t *testing.T) {
+	now := time.Now()
+	lockedUntil := now.Add(10 * time.Minute)
+	expiredLock := now.Add(-time.Second)
+
+	tests := []struct {
+		name    string
+		lockout models.LoginLockout
+		wait    time.Duration
+		locked  bool
+	}{
+		{"backing off", models.LoginLockout{FailedCount: 3, LastFailedAt: now.Add(-time.Second)}, 3 * time.Second, false},
+		{"backoff over", models.LoginLockout{FailedCount: 3, LastFailedAt: now.Add(-5 * time.Second)}, 0, false},
+		{"locked", models.LoginLockout{FailedCount: 5, LastFailedAt: now, LockedUntil: &lockedUntil}, 10 * time.Minute, true},
+		{"lock served", models.LoginLockout{FailedCount: 5, LastFailedAt: now.Add(-time.Minute), LockedUntil: &expiredLock}, 0, false},
+		{"stale failures", models.LoginLockout{FailedCount: 4, LastFailedAt: now.Add(-time.Hour)}, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wait, locked := loginWait(&tt.lockout, now, time.Second, 15*time.Minute)
+			if wait != tt.wait || locked != tt.locked {
+				t.Fatalf("Got wait %v (locked %v), want %v (locked %v)", wait, locked, tt.wait, tt.locked)
+			}
+		})
+	}
+}
+
+func TestActiveLoginFailuresReset(t *testing.T) {
+	now := time.Now()
+	expiredLock := now.Add(-time.Second)
+
+	// A served lockout starts the count over, so the next failure does not lock again at once
+	served := models.LoginLockout{FailedCount: 5, LastFailedAt: now.Add(-15 * time.Minute), LockedUntil: &expiredLock}
+	if got := activeLoginFailures(&served, now, 15*time.Minute); got != 0 {
+		t.Fatalf("Expected a served lockout to reset the failures, got %d", got)
+	}
+
+	recent := models.LoginLockout{FailedCount: 2, LastFailedAt: now.Add(-time.Minute)}
+	if got := activeLoginFailures(&recent, now, 15*time.Minute); got != 2 {
+		t.Fatalf("Expected recent failures to count, got %d", got)
+	}
+}
+
+func TestCountLoginFailure(t *testing.T) {
+	now := time.Now()
+
+	recent := models.LoginLockout{FailedCount: 3, LastFailedAt: now.Add(-time.Minute)}
+	if failures, lockedUntil := countLoginFailure(&recent, now, 5, 15*time.Minute); failures != 4 || lockedUntil != nil {
+		t.Fatalf("Expected a fourth failure without a lockout, got %d (locked until %v)", failures, lockedUntil)
+	}
+
+	recent.FailedCount = 4
+	failures, lockedUntil := countLoginFailure(&recent, now, 5, 15*time.Minute)
+	if failures != 5 || lockedUntil == nil || !lockedUntil.Equal(now.Add(15*time.Minute)) {
+		t.Fatalf("Expected the fifth failure to lock the email for the lockout duration, got %d (locked until %v)", failures, lockedUntil)
+	}
+
+	stale := models.LoginLockout{FailedCount: 4, LastFailedAt: now.Add(-time.Hour)}
+	if failures, _ := countLoginFailure(&stale, now, 5, 15*time.Minute); failures != 1 {
+		t.Fatalf("Expected stale failures to start the count over, got %d", failures)
+	}
+}
+
+func TestLoginAttemptWithThrottlingDisabled(t *testing.T) {
+	maxFailures := config.AppConfig.LoginMaxFailures
+	config.AppConfig.LoginMaxFailures = 0
+	defer func() { config.AppConfig.LoginMaxFailures = maxFailures }()
+
+	// Without throttling no record is read or written, so no database is needed
+	attempt, err := NewLoginThrottle(nil).Attempt("someone@example.com")
+	if err != nil {
+		t.Fatalf("Attempt failed: %v", err)
+	}
+	if err := attempt.Fail(); err != nil {
+		t.Errorf("Expected no lockout, got %v", err)
+	}
+	if err := attempt.Release(); err != nil {
+		t.Errorf("Expected releasing to do nothing, got %v", err)
+	}
+}
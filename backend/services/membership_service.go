@@ -0,0 +1,250 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrMemberExists  = errors.New("user is already a member of this project")
+	ErrLastOwner     = errors.New("a project must keep at least one owner")
+	ErrInvalidRole   = errors.New("invalid role")
+	ErrOwnerRequired = errors.New("only owners can grant, change or remove the owner role")
+)
+
+// MembershipService manages who can access a project and with which role
+type MembershipService struct {
+	DB *gorm.DB
+}
+
+// NewMembershipService creates a new MembershipService
+func NewMembershipService(db *gorm.DB) *MembershipService {
+	return &MembershipService{DB: db}
+}
+
+// ProjectRole returns the effective role of a user on a project, or "" if they have no access.
+// It is the higher of their project membership and the role implied by their organization role.
+func ProjectRole(db *gorm.DB, userID, projectID uint) (models.Role, error) {
+	var project models.Project
+	err := db.Select("id", "organization_id").First(&project, projectID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	var role models.Role
+	var member models.ProjectMember
+	err = db.Where("project_id = ? AND user_id = ?", projectID, userID).First(&member).Error
+	if err == nil {
+		role = member.Role
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", err
+	}
+
+	if project.OrganizationID != nil {
+		orgRole, err := OrgRole(db, userID, *project.OrganizationID)
+		if err != nil {
+			return "", err
+		}
+		role = higherRole(role, orgRole.ProjectRole())
+	}
+
+	return role, nil
+}
+
+// ProjectsForUser lists every project the user can access, with their effective role.
+// When orgID is set, only that organization's projects are returned.
+func (s *MembershipService) ProjectsForUser(userID uint, orgID *uint) ([]models.Project, error) {
+	type grant struct {
+		ProjectID uint
+		Role      models.Role
+	}
+
+	var direct []grant
+	err := s.DB.Model(&models.ProjectMember{}).
+		Select("project_id, role").
+		Where("user_id = ?", userID).
+		Scan(&direct).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var viaOrg []struct {
+		ProjectID uint
+		Role      models.OrgRole
+	}
+	err = s.DB.Model(&models.Project{}).
+		Select("projects.id AS project_id, organization_members.role AS role").
+		Joins("JOIN organization_members ON organization_members.organization_id = projects.organization_id").
+		Where("organization_members.user_id = ? AND organization_members.role IN ?", userID,
+			[]models.OrgRole{models.OrgRoleAdmin, models.OrgRoleOwner}).
+		Scan(&viaOrg).Error
+	if err != nil {
+		return nil, err
+	}
+
+	roles := make(map[uint]models.Role)
+	for _, g := range direct {
+		roles[g.ProjectID] = higherRole(roles[g.ProjectID], g.Role)
+	}
+	for _, g := range viaOrg {
+		roles[g.ProjectID] = higherRole(roles[g.ProjectID], g.Role.ProjectRole())
+	}
+	if len(roles) == 0 {
+		return []models.Project{}, nil
+	}
+
+	ids := make([]uint, 0, len(roles))
+	for id := range roles {
+		ids = append(ids, id)
+	}
+
+	// Secrets are not loaded here: environments can require a higher role to read them,
+	// so they are only listed per environment, after verifyEnvironmentRole
+	query := s.DB.Where("id IN ?", ids).Order("id")
+	if orgID != nil {
+		query = query.Where("organization_id = ?", *orgID)
+	}
+
+	var projects []models.Project
+	if err := query.Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	for i := range projects {
+		projects[i].Role = roles[projects[i].ID]
+	}
+
+	return projects, nil
+}
+
+// higherRole returns the more privileged of two roles; "" means no role
+func higherRole(a, b models.Role) models.Role {
+	if b.Valid() && !a.AtLeast(b) {
+		return b
+	}
+	return a
+}
+
+// ListMembers returns all members of a project with their user records
+func (s *MembershipService) ListMembers(projectID uint) ([]models.ProjectMember, error) {
+	var members []models.ProjectMember
+	result := s.DB.Preload("User").Where("project_id = ?", projectID).Order("id").Find(&members)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return members, nil
+}
+
+// AddMember grants an existing user, found by email, a role on a project.
+// actorRole is the role of the caller making the change.
+func (s *MembershipService) AddMember(projectID uint, email string, role, actorRole models.Role) (*models.ProjectMember, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+	if role == models.RoleOwner && actorRole != models.RoleOwner {
+		return nil, ErrOwnerRequired
+	}
+
+	var user models.User
+	if err := s.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	member := &models.ProjectMember{ProjectID: projectID, UserID: user.ID, Role: role}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.ProjectMember{}).Where("project_id = ? AND user_id = ?", projectID, user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrMemberExists
+		}
+		return tx.Create(member).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	member.User = user
+	return member, nil
+}
+
+// UpdateMemberRole changes the role of a member. actorRole is the role of the caller.
+func (s *MembershipService) UpdateMemberRole(projectID, userID uint, role, actorRole models.Role) (*models.ProjectMember, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+
+	var member models.ProjectMember
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("project_id = ? AND user_id = ?", projectID, userID).First(&member).Error; err != nil {
+			return err
+		}
+		if (member.Role == models.RoleOwner || role == models.RoleOwner) && actorRole != models.RoleOwner {
+			return ErrOwnerRequired
+		}
+		if member.Role == models.RoleOwner && role != models.RoleOwner {
+			if err := ensureAnotherOwner(tx, projectID, userID); err != nil {
+				return err
+			}
+		}
+		// A role set by hand is no longer managed by SCIM group sync
+		member.Role, member.Source = role, ""
+		return tx.Model(&member).Updates(map[string]interface{}{"role": role, "source": ""}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// RemoveMember revokes a user's access to a project. actorRole is the role of the caller.
+func (s *MembershipService) RemoveMember(projectID, userID uint, actorRole models.Role) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var member models.ProjectMember
+		if err := tx.Where("project_id = ? AND user_id = ?", projectID, userID).First(&member).Error; err != nil {
+			return err
+		}
+		if member.Role == models.RoleOwner {
+			if actorRole != models.RoleOwner {
+				return ErrOwnerRequired
+			}
+			if err := ensureAnotherOwner(tx, projectID, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&member).Error
+	})
+}
+
+// ensureAnotherOwner fails if userID is the only owner of the project
+func ensureAnotherOwner(tx *gorm.DB, projectID, userID uint) error {
+	var owners int64
+	err := tx.Model(&models.ProjectMember{}).
+		Where("project_id = ? AND role = ? AND user_id <> ?", projectID, models.RoleOwner, userID).
+		Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+	return nil
+}
+
+// BackfillProjectOwners gives every project created before memberships existed
+// an owner membership for its OwnerID
+func BackfillProjectOwners(db *gorm.DB) error {
+	return db.Exec(`
+		INSERT INTO project_members (created_at, updated_at, project_id, user_id, role)
+		SELECT NOW(), NOW(), p.id, p.owner_id, ?
+		FROM projects p
+		WHERE NOT EXISTS (
+			SELECT 1 FROM project_members m WHERE m.project_id = p.id AND m.user_id = p.owner_id
+		)`, models.RoleOwner).Error
+}
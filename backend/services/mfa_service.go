@@ -0,0 +1,319 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"ciphersafe/utils"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// MFAIssuer names the account in authenticator apps
+const MFAIssuer = "CipherSafe"
+
+const (
+	recoveryCodeCount = 10
+	mfaTokenTTL       = 5 * time.Minute
+	mfaTokenType      = "mfa_pending"
+	totpSkew          = 1 // Accepted clock drift, in 30 second steps
+)
+
+// Second factor methods, as recorded in audit events
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+)
+
+var (
+	ErrMFAPending        = errors.New("a second factor is required")
+	ErrMFAAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrMFANotEnrolling   = errors.New("start TOTP enrollment first")
+	ErrInvalidMFACode    = errors.New("invalid authentication code")
+	ErrInvalidMFAToken   = errors.New("invalid or expired MFA token")
+	ErrMFARequired       = errors.New("an organization you belong to requires two-factor authentication")
+)
+
+// recoveryCodeEncoding avoids padding; codes are shown lowercase
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TOTPEnrollment is shown once while enrolling an authenticator app
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"` // Render as a QR code
+}
+
+// MFAStatus summarizes a user's second factors
+type MFAStatus struct {
+	TOTPEnabled            bool  `json:"totp_enabled"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+	Required               bool  `json:"required"` // Required by one of the user's organizations
+}
+
+// MFAService manages TOTP enrollment, verification and recovery codes
+type MFAService struct {
+	DB *gorm.DB
+}
+
+// NewMFAService creates a new MFAService
+func NewMFAService(db *gorm.DB) *MFAService {
+	return &MFAService{DB: db}
+}
+
+// Status returns a user's second factor status
+func (s *MFAService) Status(user *models.User) (*MFAStatus, error) {
+	required, err := MFARequired(s.DB, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := &MFAStatus{TOTPEnabled: user.TOTPEnabled, Required: required}
+	err = s.DB.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", user.ID).
+		Count(&status.RecoveryCodesRemaining).Error
+	return status, err
+}
+
+// BeginTOTPEnrollment stores a new pending TOTP secret for the user. It only takes effect
+// once ConfirmTOTPEnrollment sees a valid code, so a half-finished enrollment locks nobody out.
+func (s *MFAService) BeginTOTPEnrollment(user *models.User) (*TOTPEnrollment, error) {
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	secret, err := utils.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := Encrypt(secret)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.DB.Model(user).Update("totp_secret", encrypted).Error; err != nil {
+		return nil, err
+	}
+
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: utils.TOTPProvisioningURI(MFAIssuer, user.Email, secret),
+	}, nil
+}
+
+// ConfirmTOTPEnrollment enables TOTP once the user proves their app works and returns
+// a fresh set of recovery codes. The codes are only available in this response.
+func (s *MFAService) ConfirmTOTPEnrollment(user *models.User, code string) ([]string, error) {
+	if user.TOTPEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.TOTPSecret == "" {
+		return nil, ErrMFANotEnrolling
+	}
+	if err := s.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+
+	var codes []string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(user).Update("totp_enabled", true).Error; err != nil {
+			return err
+		}
+		var err error
+		codes, err = replaceRecoveryCodes(tx, user.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// VerifySecondFactor checks a TOTP code or, failing that, a recovery code, and returns
+// which one was used. Both are single-use.
+func (s *MFAService) VerifySecondFactor(user *models.User, code string) (string, error) {
+	if !user.TOTPEnabled {
+		return "", ErrMFANotEnabled
+	}
+
+	err := s.verifyTOTP(user, code)
+	if err == nil {
+		return MFAMethodTOTP, nil
+	}
+	if !errors.Is(err, ErrInvalidMFACode) {
+		return "", err
+	}
+
+	if err := s.useRecoveryCode(user.ID, code); err != nil {
+		return "", err
+	}
+	return MFAMethodRecoveryCode, nil
+}
+
+// DisableTOTP removes the user's authenticator and recovery codes after checking a code.
+// It is refused while one of the user's organizations requires MFA.
+func (s *MFAService) DisableTOTP(user *models.User, code string) error {
+	required, err := MFARequired(s.DB, user.ID)
+	if err != nil {
+		return err
+	}
+	if required {
+		return ErrMFARequired
+	}
+	if _, err := s.VerifySecondFactor(user, code); err != nil {
+		return err
+	}
+
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Model(user).Updates(map[string]interface{}{
+			"totp_secret":       "",
+			"totp_enabled":      false,
+			"totp_last_counter": 0,
+		}).Error
+	})
+}
+
+// RegenerateRecoveryCodes replaces the user's recovery codes after checking a TOTP code
+func (s *MFAService) RegenerateRecoveryCodes(user *models.User, code string) ([]string, error) {
+	if !user.TOTPEnabled {
+		return nil, ErrMFANotEnabled
+	}
+	if err := s.verifyTOTP(user, code); err != nil {
+		return nil, err
+	}
+	return replaceRecoveryCodes(s.DB, user.ID)
+}
+
+// verifyTOTP checks a code against the user's secret and consumes its time step,
+// so an observed code cannot be replayed within its validity window
+func (s *MFAService) verifyTOTP(user *models.User, code string) error {
+	secret, err := Decrypt(user.TOTPSecret)
+	if err != nil {
+		return err
+	}
+
+	counter, ok, err := utils.ValidateTOTP(secret, code, time.Now(), totpSkew)
+	if err != nil {
+		return err
+	}
+	if !ok || counter <= user.TOTPLastCounter {
+		return ErrInvalidMFACode
+	}
+
+	result := s.DB.Model(&models.User{}).
+		Where("id = ? AND totp_last_counter < ?", user.ID, counter).
+		Update("totp_last_counter", counter)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode // Used concurrently
+	}
+	user.TOTPLastCounter = counter
+	return nil
+}
+
+func (s *MFAService) useRecoveryCode(userID uint, code string) error {
+	result := s.DB.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, hashRecoveryCode(userID, code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrInvalidMFACode
+	}
+	return nil
+}
+
+// MFARequired reports whether any organization the user belongs to requires MFA
+func MFARequired(db *gorm.DB, userID uint) (bool, error) {
+	var count int64
+	err := db.Model(&models.Organization{}).
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ? AND organizations.setting_require_mfa", userID).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// GenerateMFAToken returns a short-lived token proving the password step of a login succeeded.
+// It has no session, so AuthMiddleware refuses it everywhere else.
+func GenerateMFAToken(userID uint) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": userID,
+		"typ": mfaTokenType,
+		"iat": now.Unix(),
+		"exp": now.Add(mfaTokenTTL).Unix(),
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(config.AppConfig.JWTSecretKey)
+}
+
+// ParseMFAToken returns the user ID of a valid token from GenerateMFAToken
+func ParseMFAToken(tokenString string) (uint, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		return config.AppConfig.JWTSecretKey, nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || !token.Valid {
+		return 0, ErrInvalidMFAToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["typ"] != mfaTokenType {
+		return 0, ErrInvalidMFAToken
+	}
+	userID, ok := claims["sub"].(float64)
+	if !ok {
+		return 0, ErrInvalidMFAToken
+	}
+	return uint(userID), nil
+}
+
+// replaceRecoveryCodes deletes a user's recovery codes and returns new ones
+func replaceRecoveryCodes(tx *gorm.DB, userID uint) ([]string, error) {
+	if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, recoveryCodeCount)
+	rows := make([]models.RecoveryCode, recoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+		rows[i] = models.RecoveryCode{UserID: userID, CodeHash: hashRecoveryCode(userID, code)}
+	}
+	if err := tx.Create(&rows).Error; err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// generateRecoveryCode returns a code like "k3j9a-x2m4q" carrying 50 random bits
+func generateRecoveryCode() (string, error) {
+	random := make([]byte, 7)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	encoded := strings.ToLower(recoveryCodeEncoding.EncodeToString(random))[:10]
+	return encoded[:5] + "-" + encoded[5:], nil
+}
+
+// hashRecoveryCode hashes a code bound to its user; input is normalized so
+// codes are accepted with or without the dash and in any case
+func hashRecoveryCode(userID uint, code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%d:%s", userID, normalized)))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,50 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMFAToken(t *testing.T) {
+	token, err := GenerateMFAToken(7)
+	if err != nil {
+		t.Fatalf("Failed to generate MFA token: %v", err)
+	}
+
+	userID, err := ParseMFAToken(token)
+	if err != nil || userID != 7 {
+		t.Fatalf("Expected user 7, got %d (%v)", userID, err)
+	}
+
+	// A session access token must not pass as the first step of a login, and vice versa
+	accessToken, _ := generateAccessToken(7, 1)
+	if _, err := ParseMFAToken(accessToken); err != ErrInvalidMFAToken {
+		t.Fatalf("Access token should be rejected, got %v", err)
+	}
+	if _, err := ParseMFAToken(token + "x"); err != ErrInvalidMFAToken {
+		t.Fatalf("Tampered token should be rejected, got %v", err)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	code, err := generateRecoveryCode()
+	if err != nil {
+		t.Fatalf("Failed to generate recovery code: %v", err)
+	}
+	if len(code) != 11 || code[5] != '-' || code != strings.ToLower(code) {
+		t.Fatalf("Unexpected recovery code format %q", code)
+	}
+
+	other, _ := generateRecoveryCode()
+	if code == other {
+		t.Fatal("Recovery codes should be random")
+	}
+
+	hash := hashRecoveryCode(1, code)
+	if hashRecoveryCode(1, strings.ToUpper(strings.ReplaceAll(code, "-", ""))) != hash {
+		t.Fatal("Recovery codes should be accepted without the dash and in any case")
+	}
+	if hashRecoveryCode(2, code) == hash {
+		t.Fatal("Recovery code hashes should be bound to their user")
+	}
+}
@@ -0,0 +1,28 @@
+package services
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+// RunEncryptionMigrations upgrades stored ciphertexts to the current formats.
+// It needs the master key, so in sealed mode it runs after unseal instead of at startup.
+func RunEncryptionMigrations(db *gorm.DB) error {
+	// Give secrets created before versioning existed their first version
+	if err := BackfillSecretVersions(db); err != nil {
+		return fmt.Errorf("failed to backfill secret versions: %w", err)
+	}
+
+	// Move projects still encrypted under the master key to their own data key
+	if err := MigrateToEnvelopeEncryption(db); err != nil {
+		return fmt.Errorf("failed to migrate to envelope encryption: %w", err)
+	}
+
+	// Bind secret ciphertexts to their project, environment, key and version
+	if err := BindSecretCiphertexts(db); err != nil {
+		return fmt.Errorf("failed to bind secret ciphertexts: %w", err)
+	}
+
+	return nil
+}
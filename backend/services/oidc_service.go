@@ -0,0 +1,243 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// oidcLoginTimeout is how long a user has to finish signing in at the provider
+const oidcLoginTimeout = 10 * time.Minute
+
+var (
+	ErrOIDCDisabled     = errors.New("single sign-on is not configured")
+	ErrOIDCState        = errors.New("unknown or expired single sign-on request")
+	ErrOIDCEmailMissing = errors.New("the identity provider did not return an email address")
+	ErrOIDCEmailInUse   = errors.New("an account with this email already exists; the provider must verify the email to link it")
+)
+
+// OIDCService runs the single sign-on login flow and provisions its users
+type OIDCService struct {
+	DB          *gorm.DB
+	UserService *UserService
+	Client      *http.Client
+
+	mu       sync.Mutex
+	provider *OIDCProvider
+}
+
+// NewOIDCService creates a new OIDCService
+func NewOIDCService(db *gorm.DB, userService *UserService) *OIDCService {
+	return &OIDCService{DB: db, UserService: userService, Client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// BeginLogin stores a new login request and returns the provider URL to send the browser to
+func (s *OIDCService) BeginLogin() (string, error) {
+	provider, err := s.Provider()
+	if err != nil {
+		return "", err
+	}
+
+	state := &models.OIDCLoginState{ExpiresAt: time.Now().Add(oidcLoginTimeout)}
+	for _, value := range []*string{&state.State, &state.Nonce, &state.CodeVerifier} {
+		if *value, err = randomURLToken(32); err != nil {
+			return "", err
+		}
+	}
+
+	// Drop requests that were never completed
+	if err := s.DB.Where("expires_at < ?", time.Now()).Delete(&models.OIDCLoginState{}).Error; err != nil {
+		return "", err
+	}
+	if err := s.DB.Create(state).Error; err != nil {
+		return "", err
+	}
+
+	cfg := config.AppConfig
+	return provider.AuthCodeURL(cfg.OIDCClientID, cfg.OIDCRedirectURL, cfg.OIDCScopes, state.State, state.Nonce, state.CodeVerifier), nil
+}
+
+// FinishLogin handles the provider's callback: it redeems the code, verifies the ID token,
+// provisions the user on first sign-in and applies the group mappings. created reports
+// whether the account was just provisioned.
+func (s *OIDCService) FinishLogin(stateValue, code string) (*models.User, bool, error) {
+	provider, err := s.Provider()
+	if err != nil {
+		return nil, false, err
+	}
+
+	state, err := s.consumeState(stateValue)
+	if err != nil {
+		return nil, false, err
+	}
+
+	cfg := config.AppConfig
+	idToken, err := provider.Exchange(cfg.OIDCClientID, cfg.OIDCClientSecret, cfg.OIDCRedirectURL, code, state.CodeVerifier)
+	if err != nil {
+		return nil, false, err
+	}
+	identity, err := provider.VerifyIDToken(idToken, cfg.OIDCClientID, state.Nonce, cfg.OIDCGroupsClaim)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var user *models.User
+	var created bool
+	err = s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user, created, err = (&UserService{DB: tx}).ProvisionOIDCUser(identity); err != nil {
+			return err
+		}
+		if !user.Active {
+			return ErrUserDeactivated
+		}
+		return ApplyOIDCGroupMappings(tx, user.ID, identity.Groups)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return user, created, nil
+}
+
+// Provider returns the discovered provider, running discovery on first use
+func (s *OIDCService) Provider() (*OIDCProvider, error) {
+	if !config.AppConfig.OIDCEnabled() {
+		return nil, ErrOIDCDisabled
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.provider == nil {
+		// A failed discovery is retried on the next login rather than cached
+		provider, err := DiscoverOIDCProvider(config.AppConfig.OIDCIssuer, s.Client)
+		if err != nil {
+			return nil, err
+		}
+		s.provider = provider
+	}
+	return s.provider, nil
+}
+
+// consumeState looks up and deletes a pending login request
+func (s *OIDCService) consumeState(value string) (*models.OIDCLoginState, error) {
+	var state models.OIDCLoginState
+	err := s.DB.Where("state = ? AND expires_at > ?", value, time.Now()).First(&state).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrOIDCState
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one callback can claim a request
+	result := s.DB.Delete(&state)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrOIDCState
+	}
+	return &state, nil
+}
+
+// ApplyOIDCGroupMappings grants the roles configured for the user's provider groups. Roles are
+// only ever raised: a mapping never downgrades or removes a membership granted by hand, and
+// leaving a group at the provider does not revoke access here.
+func ApplyOIDCGroupMappings(db *gorm.DB, userID uint, groups []string) error {
+	orgRoles, projectRoles := mappedRoles(config.AppConfig.OIDCGroupMappings, groups)
+
+	for orgID, role := range orgRoles {
+		var org models.Organization
+		if err := db.Select("id").First(&org, orgID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue // Mapped organization was deleted
+			}
+			return err
+		}
+
+		var member models.OrganizationMember
+		err := db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			member = models.OrganizationMember{OrganizationID: orgID, UserID: userID, Role: role}
+			if err := db.Create(&member).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !member.Role.AtLeast(role):
+			if err := db.Model(&member).Update("role", role).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	for projectID, role := range projectRoles {
+		var project models.Project
+		if err := db.Select("id").First(&project, projectID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				continue // Mapped project was deleted
+			}
+			return err
+		}
+
+		var member models.ProjectMember
+		err := db.Where("project_id = ? AND user_id = ?", projectID, userID).First(&member).Error
+		switch {
+		case errors.Is(err, gorm.ErrRecordNotFound):
+			member = models.ProjectMember{ProjectID: projectID, UserID: userID, Role: role}
+			if err := db.Create(&member).Error; err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !member.Role.AtLeast(role):
+			if err := db.Model(&member).Update("role", role).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// mappedRoles returns the highest role each organization and project is mapped to for groups
+func mappedRoles(mappings []config.GroupMapping, groups []string) (map[uint]models.OrgRole, map[uint]models.Role) {
+	member := make(map[string]bool, len(groups))
+	for _, group := range groups {
+		member[group] = true
+	}
+
+	orgRoles := map[uint]models.OrgRole{}
+	projectRoles := map[uint]models.Role{}
+	for _, mapping := range mappings {
+		if !member[mapping.Group] {
+			continue
+		}
+		switch mapping.Target {
+		case config.GroupTargetOrganization:
+			role := models.OrgRole(mapping.Role)
+			if !orgRoles[mapping.TargetID].AtLeast(role) {
+				orgRoles[mapping.TargetID] = role
+			}
+		case config.GroupTargetProject:
+			projectRoles[mapping.TargetID] = higherRole(projectRoles[mapping.TargetID], models.Role(mapping.Role))
+		}
+	}
+	return orgRoles, projectRoles
+}
+
+// randomURLToken returns n random bytes encoded as unpadded base64url
+func randomURLToken(n int) (string, error) {
+	random := make([]byte, n)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(random), nil
+}
@@ -0,0 +1,249 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// mockOIDCProvider is a minimal OpenID Connect provider that issues one code per authorization
+type mockOIDCProvider struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+	kid    string
+
+	mu     sync.Mutex
+	codes  map[string]mockAuthorization
+	claims jwt.MapClaims // Extra or overriding ID token claims
+}
+
+type mockAuthorization struct {
+	challenge string
+	nonce     string
+}
+
+func newMockOIDCProvider(t *testing.T) *mockOIDCProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	m := &mockOIDCProvider{t: t, key: key, kid: "key-1", codes: map[string]mockAuthorization{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.server.URL,
+			"authorization_endpoint": m.server.URL + "/authorize",
+			"token_endpoint":         m.server.URL + "/token",
+			"jwks_uri":               m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": m.kid,
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", m.token)
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// authorize plays the user signing in: it parses the authorization URL and returns a code
+func (m *mockOIDCProvider) authorize(authURL string) string {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		m.t.Fatalf("Invalid authorization URL: %v", err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("response_type") != "code" {
+		m.t.Fatalf("Unexpected authorization request %s", authURL)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	code := "code-" + query.Get("state")
+	m.codes[code] = mockAuthorization{challenge: query.Get("code_challenge"), nonce: query.Get("nonce")}
+	return code
+}
+
+func (m *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	authorization, ok := m.codes[r.FormValue("code")]
+	delete(m.codes, r.FormValue("code"))
+	if !ok || PKCEChallenge(r.FormValue("code_verifier")) != authorization.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+	if clientID, _, _ := r.BasicAuth(); clientID != "ciphersafe" {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iss":            m.server.URL,
+		"sub":            "user-123",
+		"aud":            "ciphersafe",
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+		"nonce":          authorization.nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"groups":         []string{"engineering", "cn=ops,ou=groups"},
+	}
+	for name, value := range m.claims {
+		claims[name] = value
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = m.kid
+	signed, err := token.SignedString(m.key)
+	if err != nil {
+		m.t.Fatalf("Failed to sign ID token: %v", err)
+	}
+	json.NewEncoder(w).Encode(map[string]string{"id_token": signed, "token_type": "Bearer"})
+}
+
+// login runs the code flow against the mock provider the way OIDCService does
+func (m *mockOIDCProvider) login(provider *OIDCProvider, verifier, nonce string) (*OIDCIdentity, error) {
+	authURL := provider.AuthCodeURL("ciphersafe", "http://localhost:8080/auth/oidc/callback", []string{"openid", "email"}, "state-1", nonce, verifier)
+	code := m.authorize(authURL)
+	idToken, err := provider.Exchange("ciphersafe", "client-secret", "http://localhost:8080/auth/oidc/callback", code, verifier)
+	if err != nil {
+		return nil, err
+	}
+	return provider.VerifyIDToken(idToken, "ciphersafe", nonce, "groups")
+}
+
+func TestOIDCCodeFlow(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider, err := DiscoverOIDCProvider(mock.server.URL, mock.server.Client())
+	if err != nil {
+		t.Fatalf("Discovery failed: %v", err)
+	}
+
+	identity, err := mock.login(provider, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+	want := &OIDCIdentity{
+		Issuer:        mock.server.URL,
+		Subject:       "user-123",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Groups:        []string{"engineering", "cn=ops,ou=groups"},
+	}
+	if !reflect.DeepEqual(identity, want) {
+		t.Fatalf("Got identity %+v, want %+v", identity, want)
+	}
+}
+
+func TestOIDCPKCEVerifierChecked(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider, _ := DiscoverOIDCProvider(mock.server.URL, mock.server.Client())
+
+	authURL := provider.AuthCodeURL("ciphersafe", "http://localhost:8080/auth/oidc/callback", []string{"openid"}, "state-1", "nonce-1", "verifier-1")
+	code := mock.authorize(authURL)
+	// An intercepted code is useless without the verifier
+	if _, err := provider.Exchange("ciphersafe", "client-secret", "http://localhost:8080/auth/oidc/callback", code, "other-verifier"); !errors.Is(err, ErrOIDCVerification) {
+		t.Fatalf("Expected the exchange to fail, got %v", err)
+	}
+}
+
+func TestOIDCIDTokenRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		claims jwt.MapClaims
+	}{
+		{"wrong audience", jwt.MapClaims{"aud": "other-client"}},
+		{"wrong issuer", jwt.MapClaims{"iss": "https://evil.example"}},
+		{"expired", jwt.MapClaims{"exp": time.Now().Add(-time.Hour).Unix()}},
+		{"wrong nonce", jwt.MapClaims{"nonce": "replayed"}},
+		{"no subject", jwt.MapClaims{"sub": ""}},
+		{"other authorized party", jwt.MapClaims{"aud": []string{"ciphersafe", "other-client"}, "azp": "other-client"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mock := newMockOIDCProvider(t)
+			mock.claims = tt.claims
+			provider, _ := DiscoverOIDCProvider(mock.server.URL, mock.server.Client())
+
+			if _, err := mock.login(provider, "verifier-1", "nonce-1"); !errors.Is(err, ErrOIDCVerification) {
+				t.Fatalf("Expected ErrOIDCVerification, got %v", err)
+			}
+		})
+	}
+}
+
+func TestOIDCKeyRotation(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	provider, _ := DiscoverOIDCProvider(mock.server.URL, mock.server.Client())
+	if _, err := mock.login(provider, "verifier-1", "nonce-1"); err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	// The provider rotates its key; tokens signed with the new key are accepted once the
+	// key set may be refetched
+	newKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	mock.mu.Lock()
+	mock.key, mock.kid = newKey, "key-2"
+	mock.mu.Unlock()
+
+	if _, err := mock.login(provider, "verifier-2", "nonce-2"); !errors.Is(err, ErrOIDCVerification) {
+		t.Fatalf("Expected the key set to be cached for a while, got %v", err)
+	}
+	provider.keysFetched = time.Now().Add(-jwksRefreshInterval)
+	if _, err := mock.login(provider, "verifier-3", "nonce-3"); err != nil {
+		t.Fatalf("Expected the rotated key to be fetched, got %v", err)
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	mock := newMockOIDCProvider(t)
+	if _, err := DiscoverOIDCProvider(mock.server.URL+"/tenant", mock.server.Client()); err == nil {
+		t.Fatal("Expected discovery to fail for an unknown issuer")
+	}
+}
+
+func TestMappedRoles(t *testing.T) {
+	mappings := []config.GroupMapping{
+		{Group: "engineering", Target: config.GroupTargetOrganization, TargetID: 1, Role: "member"},
+		{Group: "leads", Target: config.GroupTargetOrganization, TargetID: 1, Role: "admin"},
+		{Group: "engineering", Target: config.GroupTargetProject, TargetID: 7, Role: "writer"},
+		{Group: "cn=ops,ou=groups", Target: config.GroupTargetProject, TargetID: 7, Role: "reader"},
+		{Group: "finance", Target: config.GroupTargetProject, TargetID: 9, Role: "owner"},
+	}
+
+	orgRoles, projectRoles := mappedRoles(mappings, []string{"engineering", "leads", "cn=ops,ou=groups"})
+	if !reflect.DeepEqual(orgRoles, map[uint]models.OrgRole{1: "admin"}) {
+		t.Errorf("Expected the highest organization role, got %v", orgRoles)
+	}
+	if !reflect.DeepEqual(projectRoles, map[uint]models.Role{7: "writer"}) {
+		t.Errorf("Expected the highest project role and no unmapped groups, got %v", projectRoles)
+	}
+}
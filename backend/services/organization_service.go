@@ -0,0 +1,282 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrQuotaExceeded    = errors.New("organization quota exceeded")
+	ErrOrgMemberExists  = errors.New("user is already a member of this organization")
+	ErrLastOrgOwner     = errors.New("an organization must keep at least one owner")
+	ErrOrgOwnerRequired = errors.New("only organization owners can grant, change or remove the owner role")
+	ErrAuthMethodDenied = errors.New("this sign-in method is not allowed by your organization")
+)
+
+// OrganizationService manages organizations, their members, settings and quotas
+type OrganizationService struct {
+	DB *gorm.DB
+}
+
+// NewOrganizationService creates a new OrganizationService
+func NewOrganizationService(db *gorm.DB) *OrganizationService {
+	return &OrganizationService{DB: db}
+}
+
+// OrgRole returns the role of a user on an organization, or "" if they are not a member
+func OrgRole(db *gorm.DB, userID, orgID uint) (models.OrgRole, error) {
+	var member models.OrganizationMember
+	err := db.Joins("JOIN organizations ON organizations.id = organization_members.organization_id AND organizations.deleted_at IS NULL").
+		Where("organization_members.organization_id = ? AND organization_members.user_id = ?", orgID, userID).
+		First(&member).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return member.Role, nil
+}
+
+// CreateOrganization creates an organization with the creator as its first owner
+func (s *OrganizationService) CreateOrganization(name string, ownerID uint) (*models.Organization, error) {
+	org := &models.Organization{Name: name}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(org).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: ownerID, Role: models.OrgRoleOwner}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	org.Role = models.OrgRoleOwner
+	return org, nil
+}
+
+// ListOrganizations returns the organizations a user belongs to, with their role
+func (s *OrganizationService) ListOrganizations(userID uint) ([]models.Organization, error) {
+	var orgs []models.Organization
+	result := s.DB.
+		Select("organizations.*, organization_members.role AS role").
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userID).
+		Order("organizations.id").
+		Find(&orgs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return orgs, nil
+}
+
+// GetOrganization finds an organization by ID
+func (s *OrganizationService) GetOrganization(orgID uint) (*models.Organization, error) {
+	var org models.Organization
+	if err := s.DB.First(&org, orgID).Error; err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+// UpdateSettings replaces the settings of an organization
+func (s *OrganizationService) UpdateSettings(orgID uint, settings models.OrganizationSettings) (*models.Organization, error) {
+	org, err := s.GetOrganization(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	org.Settings = settings
+	if err := s.DB.Model(org).Select("Settings").Updates(org).Error; err != nil {
+		return nil, err
+	}
+	return org, nil
+}
+
+// ListMembers returns all members of an organization with their user records
+func (s *OrganizationService) ListMembers(orgID uint) ([]models.OrganizationMember, error) {
+	var members []models.OrganizationMember
+	result := s.DB.Preload("User").Where("organization_id = ?", orgID).Order("id").Find(&members)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return members, nil
+}
+
+// AddMember adds an existing user, found by email, to an organization.
+// actorRole is the role of the caller making the change.
+func (s *OrganizationService) AddMember(orgID uint, email string, role, actorRole models.OrgRole) (*models.OrganizationMember, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+	if role == models.OrgRoleOwner && actorRole != models.OrgRoleOwner {
+		return nil, ErrOrgOwnerRequired
+	}
+
+	var user models.User
+	if err := s.DB.Where("email = ?", email).First(&user).Error; err != nil {
+		return nil, err
+	}
+
+	member := &models.OrganizationMember{OrganizationID: orgID, UserID: user.ID, Role: role}
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.OrganizationMember{}).Where("organization_id = ? AND user_id = ?", orgID, user.ID).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return ErrOrgMemberExists
+		}
+		return tx.Create(member).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	member.User = user
+	return member, nil
+}
+
+// UpdateMemberRole changes the role of an organization member. actorRole is the role of the caller.
+func (s *OrganizationService) UpdateMemberRole(orgID, userID uint, role, actorRole models.OrgRole) (*models.OrganizationMember, error) {
+	if !role.Valid() {
+		return nil, ErrInvalidRole
+	}
+
+	var member models.OrganizationMember
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+			return err
+		}
+		if (member.Role == models.OrgRoleOwner || role == models.OrgRoleOwner) && actorRole != models.OrgRoleOwner {
+			return ErrOrgOwnerRequired
+		}
+		if member.Role == models.OrgRoleOwner && role != models.OrgRoleOwner {
+			if err := ensureAnotherOrgOwner(tx, orgID, userID); err != nil {
+				return err
+			}
+		}
+		member.Role = role
+		return tx.Model(&member).Update("role", role).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &member, nil
+}
+
+// RemoveMember removes a user from an organization. actorRole is the role of the caller.
+func (s *OrganizationService) RemoveMember(orgID, userID uint, actorRole models.OrgRole) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var member models.OrganizationMember
+		if err := tx.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+			return err
+		}
+		if member.Role == models.OrgRoleOwner {
+			if actorRole != models.OrgRoleOwner {
+				return ErrOrgOwnerRequired
+			}
+			if err := ensureAnotherOrgOwner(tx, orgID, userID); err != nil {
+				return err
+			}
+		}
+		return tx.Delete(&member).Error
+	})
+}
+
+// ensureAnotherOrgOwner fails if userID is the only owner of the organization
+func ensureAnotherOrgOwner(tx *gorm.DB, orgID, userID uint) error {
+	var owners int64
+	err := tx.Model(&models.OrganizationMember{}).
+		Where("organization_id = ? AND role = ? AND user_id <> ?", orgID, models.OrgRoleOwner, userID).
+		Count(&owners).Error
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOrgOwner
+	}
+	return nil
+}
+
+// CheckProjectQuota fails with ErrQuotaExceeded if the organization cannot hold another project.
+// It locks the organization row, so call it in the transaction that creates the project.
+func CheckProjectQuota(tx *gorm.DB, orgID uint) error {
+	var org models.Organization
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&org, orgID).Error; err != nil {
+		return err
+	}
+	if org.Settings.MaxProjects == 0 {
+		return nil
+	}
+
+	var count int64
+	if err := tx.Model(&models.Project{}).Where("organization_id = ?", orgID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count >= int64(org.Settings.MaxProjects) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// CheckSecretQuota fails with ErrQuotaExceeded if adding n secrets to the project would take
+// its organization over its secret quota. Personal projects have no quota.
+func CheckSecretQuota(db *gorm.DB, projectID uint, n int) error {
+	var project models.Project
+	if err := db.Preload("Organization").First(&project, projectID).Error; err != nil {
+		return err
+	}
+	if project.Organization == nil || project.Organization.Settings.MaxSecrets == 0 {
+		return nil
+	}
+
+	var count int64
+	err := db.Model(&models.Secret{}).
+		Joins("JOIN projects ON projects.id = secrets.project_id AND projects.deleted_at IS NULL").
+		Where("projects.organization_id = ?", project.Organization.ID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count+int64(n) > int64(project.Organization.Settings.MaxSecrets) {
+		return ErrQuotaExceeded
+	}
+	return nil
+}
+
+// CheckAuthMethod fails with ErrAuthMethodDenied if any organization the user belongs to
+// does not allow signing in with method
+func CheckAuthMethod(db *gorm.DB, userID uint, method string) error {
+	var orgs []models.Organization
+	err := db.Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userID).
+		Find(&orgs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		if !org.Settings.AllowsAuthMethod(method) {
+			return ErrAuthMethodDenied
+		}
+	}
+	return nil
+}
+
+// PasswordMinLength returns the strictest password minimum length set by the organizations a
+// user belongs to, or 0 if none sets one
+func PasswordMinLength(db *gorm.DB, userID uint) (int, error) {
+	var minLength int
+	err := db.Model(&models.Organization{}).
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userID).
+		Select("COALESCE(MAX(organizations.setting_password_min_length), 0)").
+		Scan(&minLength).Error
+	return minLength, err
+}
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"bufio"
+	"ciphersafe/config"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrWeakPassword is wrapped by the errors of CheckPasswordPolicy, which say what is wrong
+var ErrWeakPassword = errors.New("password rejected")
+
+// CheckPasswordPolicy returns an error wrapping ErrWeakPassword if password is too short, too
+// long, or appears in the breached password list. minLength, such as the minimum of the user's
+// organizations, applies when it is stricter than PASSWORD_MIN_LENGTH.
+func CheckPasswordPolicy(password string, minLength int) error {
+	cfg := config.AppConfig
+	if minLength < cfg.PasswordMinLength {
+		minLength = cfg.PasswordMinLength
+	}
+	length := utf8.RuneCountInString(password)
+	if length < minLength {
+		return fmt.Errorf("%w: it must be at least %d characters long", ErrWeakPassword, minLength)
+	}
+	if length > cfg.PasswordMaxLength {
+		return fmt.Errorf("%w: it must be at most %d characters long", ErrWeakPassword, cfg.PasswordMaxLength)
+	}
+
+	if cfg.BreachedPasswordsPath == "" {
+		return nil
+	}
+	breached, err := PasswordBreached(cfg.BreachedPasswordsPath, password)
+	if err != nil {
+		return err
+	}
+	if breached {
+		return fmt.Errorf("%w: it appears in a known data breach; choose a different password", ErrWeakPassword)
+	}
+	return nil
+}
+
+// PasswordBreached looks up the SHA-1 of password in a local copy of the Pwned Passwords list,
+// without the password or its hash leaving the server. path is either a directory of
+// k-anonymity range files, named by the first five hex digits of the hash and holding
+// "SUFFIX:COUNT" lines, or a single file of "HASH:COUNT" lines sorted by hash.
+func PasswordBreached(path, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	if info.IsDir() {
+		return breachRangeContains(path, hash)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	return sortedHashListContains(file, info.Size(), hash)
+}
+
+// breachRangeContains scans the range file for the prefix of hash. A missing range file
+// means no breached password has that prefix.
+func breachRangeContains(dir, hash string) (bool, error) {
+	prefix, suffix := hash[:5], hash[5:]
+	file, err := os.Open(filepath.Join(dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(dir, prefix+".txt"))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if breachLineHash(scanner.Text()) == suffix {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// sortedHashListContains binary searches a file of lines sorted by hash, reading only the
+// few lines it compares, so the multi-gigabyte full list works without loading it
+func sortedHashListContains(list io.ReaderAt, size int64, hash string) (bool, error) {
+	lo, hi := int64(0), size
+	for lo < hi {
+		mid := lo + (hi-lo)/2
+		start, line, err := lineAtOrAfter(list, size, mid)
+		if err != nil {
+			return false, err
+		}
+		if start >= hi {
+			hi = mid
+			continue
+		}
+
+		switch lineHash := breachLineHash(line); {
+		case lineHash == hash:
+			return true, nil
+		case lineHash < hash:
+			lo = start + int64(len(line)) + 1
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// lineAtOrAfter returns the first line starting at or after offset, and where it starts.
+// start is size when there is none.
+func lineAtOrAfter(list io.ReaderAt, size, offset int64) (int64, string, error) {
+	start := offset
+	reader := bufio.NewReader(io.NewSectionReader(list, offset, size-offset))
+	if offset > 0 {
+		// Unless the previous byte ends a line, skip the rest of the line offset falls in
+		reader = bufio.NewReader(io.NewSectionReader(list, offset-1, size-offset+1))
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return size, "", nil
+		}
+		if err != nil {
+			return 0, "", err
+		}
+		start = offset - 1 + int64(len(skipped))
+	}
+
+	line, err := reader.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return 0, "", err
+	}
+	if line == "" {
+		return size, "", nil
+	}
+	return start, strings.TrimSuffix(line, "\n"), nil
+}
+
+// breachLineHash returns the upper-case hash (or suffix) of a "HASH:COUNT" line
+func breachLineHash(line string) string {
+	if i := strings.IndexByte(line, ':'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.ToUpper(strings.TrimSpace(line))
+}
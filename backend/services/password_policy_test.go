@@ -0,0 +1,96 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestPasswordBreachedSortedFile(t *testing.T) {
+	breached := []string{"password", "123456", "qwerty", "letmein", "dragon", "monkey", "iloveyou"}
+	lines := []string{}
+	for _, password := range breached {
+		lines = append(lines, sha1Hex(password)+":42")
+	}
+	sort.Strings(lines)
+
+	for _, ending := range []string{"\n", "\r\n"} {
+		path := filepath.Join(t.TempDir(), "pwned.txt")
+		if err := os.WriteFile(path, []byte(strings.Join(lines, ending)), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, password := range breached {
+			if found, err := PasswordBreached(path, password); err != nil || !found {
+				t.Errorf("Expected %q to be found, got %v, %v", password, found, err)
+			}
+		}
+		for _, password := range []string{"correct horse battery staple", "", "Password"} {
+			if found, err := PasswordBreached(path, password); err != nil || found {
+				t.Errorf("Expected %q not to be found, got %v, %v", password, found, err)
+			}
+		}
+	}
+}
+
+func TestPasswordBreachedRangeDirectory(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("password")
+	content := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n" + strings.ToLower(hash[5:]) + ":9545824\r\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:5]), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := PasswordBreached(dir, "password"); err != nil || !found {
+		t.Errorf("Expected the password to be found in its range, got %v, %v", found, err)
+	}
+	if found, err := PasswordBreached(dir, "correct horse battery staple"); err != nil || found {
+		t.Errorf("Expected a missing range to mean not breached, got %v, %v", found, err)
+	}
+}
+
+func TestCheckPasswordPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	if err := os.WriteFile(path, []byte(sha1Hex("password1")+":3\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.AppConfig
+	saved := *cfg
+	defer func() { *cfg = saved }()
+	cfg.PasswordMinLength, cfg.PasswordMaxLength, cfg.BreachedPasswordsPath = 8, 16, path
+
+	tests := []struct {
+		password  string
+		minLength int // The organization minimum
+		ok        bool
+	}{
+		{"s3cure-enough", 0, true},
+		{"ünïcödé", 0, false}, // 7 characters, though more bytes
+		{"ünïcödé!", 0, true},
+		{strings.Repeat("x", 17), 0, false},
+		{"password1", 0, false},
+		{"s3cure-enough", 14, false}, // A stricter organization minimum applies
+		{"s3cure-enough", 6, true},   // A laxer one does not lower the server minimum
+		{"short!", 6, false},
+	}
+	for _, tt := range tests {
+		err := CheckPasswordPolicy(tt.password, tt.minLength)
+		if tt.ok && err != nil {
+			t.Errorf("Expected %q to be accepted, got %v", tt.password, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("Expected %q to be rejected, got %v", tt.password, err)
+		}
+	}
+}
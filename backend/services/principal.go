@@ -0,0 +1,43 @@
+package services
+
+import (
+	"ciphersafe/models"
+
+	"gorm.io/gorm"
+)
+
+// Principal kinds
+const (
+	PrincipalUser           = "user"
+	PrincipalServiceAccount = "service_account"
+)
+
+// Principal is an authenticated caller: a user (directly or through an API token)
+// or a service account
+type Principal struct {
+	Kind string
+	ID   uint
+}
+
+// UserPrincipal returns the principal for a user
+func UserPrincipal(userID uint) Principal {
+	return Principal{Kind: PrincipalUser, ID: userID}
+}
+
+// ServiceAccountPrincipal returns the principal for a service account
+func ServiceAccountPrincipal(serviceAccountID uint) Principal {
+	return Principal{Kind: PrincipalServiceAccount, ID: serviceAccountID}
+}
+
+// IsUser reports whether the principal is a human user
+func (p Principal) IsUser() bool {
+	return p.Kind == PrincipalUser
+}
+
+// PrincipalProjectRole returns the effective role of any principal on a project
+func PrincipalProjectRole(db *gorm.DB, p Principal, projectID uint) (models.Role, error) {
+	if p.Kind == PrincipalServiceAccount {
+		return ServiceAccountProjectRole(db, p.ID, projectID)
+	}
+	return ProjectRole(db, p.ID, projectID)
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrSameEnvironment = errors.New("source and target are the same environment")
+	// ErrPromoteKeyMissing is wrapped with the key when a key to promote is not in the source
+	ErrPromoteKeyMissing = errors.New("key not found in the source environment")
+)
+
+// Differences between a source and a target environment
+const (
+	DiffMissing = "missing" // Only in the source
+	DiffExtra   = "extra"   // Only in the target
+	DiffChanged = "changed" // In both, with different values
+	DiffSame    = "same"    // In both, with the same value
+)
+
+// Outcomes of promoting a key
+const (
+	PromoteCreated   = "created"
+	PromoteUpdated   = "updated"
+	PromoteUnchanged = "unchanged"
+)
+
+// SecretDiff compares one key across two environments. Values are compared by hash and
+// never returned.
+type SecretDiff struct {
+	Key           string `json:"key"`
+	Status        string `json:"status"`
+	SourceVersion int    `json:"source_version,omitempty"`
+	TargetVersion int    `json:"target_version,omitempty"`
+}
+
+// PromotedSecret is the outcome of promoting one key into the target environment
+type PromotedSecret struct {
+	Key      string `json:"key"`
+	SecretID uint   `json:"secret_id"`
+	Result   string `json:"result"`
+	Version  int    `json:"version"`
+}
+
+// environmentValue is a decrypted secret of an environment
+type environmentValue struct {
+	Secret *models.Secret
+	Value  string
+	Digest [sha256.Size]byte
+}
+
+// DiffEnvironments compares the keys and values of two environments, which may belong to
+// different projects
+func (s *SecretService) DiffEnvironments(source, target *models.Environment) ([]SecretDiff, error) {
+	if source.ID == target.ID {
+		return nil, ErrSameEnvironment
+	}
+	sourceValues, err := environmentValues(s.DB, source)
+	if err != nil {
+		return nil, err
+	}
+	targetValues, err := environmentValues(s.DB, target)
+	if err != nil {
+		return nil, err
+	}
+	return diffEnvironmentValues(sourceValues, targetValues), nil
+}
+
+// Promote copies the values of keys from the source environment into the target in one
+// transaction: missing keys are created and differing ones get a new version. Either every
+// key is promoted or none is.
+func (s *SecretService) Promote(source, target *models.Environment, keys []string, author Principal) ([]PromotedSecret, error) {
+	if source.ID == target.ID {
+		return nil, ErrSameEnvironment
+	}
+
+	var promoted []PromotedSecret
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		// Lock the target first so concurrent promotions and edits apply one after the other
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("environment_id = ?", target.ID).Find(&[]models.Secret{}).Error; err != nil {
+			return err
+		}
+		sourceValues, err := environmentValues(tx, source)
+		if err != nil {
+			return err
+		}
+		targetValues, err := environmentValues(tx, target)
+		if err != nil {
+			return err
+		}
+
+		seen := make(map[string]bool)
+		for _, key := range keys {
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			from, ok := sourceValues[key]
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrPromoteKeyMissing, key)
+			}
+
+			to, exists := targetValues[key]
+			switch {
+			case !exists:
+				secret, err := s.createSecret(tx, target, key, from.Value, author)
+				if err != nil {
+					return err
+				}
+				promoted = append(promoted, PromotedSecret{Key: key, SecretID: secret.ID, Result: PromoteCreated, Version: secret.CurrentVersion})
+			case to.Digest != from.Digest:
+				v, err := s.writeVersion(tx, to.Secret, from.Value, author, nil)
+				if err != nil {
+					return err
+				}
+				promoted = append(promoted, PromotedSecret{Key: key, SecretID: to.Secret.ID, Result: PromoteUpdated, Version: v.Version})
+			default:
+				promoted = append(promoted, PromotedSecret{Key: key, SecretID: to.Secret.ID, Result: PromoteUnchanged, Version: to.Secret.CurrentVersion})
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return promoted, nil
+}
+
+// environmentValues decrypts the current values of an environment's secrets by key
+func environmentValues(db *gorm.DB, environment *models.Environment) (map[string]*environmentValue, error) {
+	var secrets []models.Secret
+	if err := db.Where("environment_id = ?", environment.ID).Order("id").Find(&secrets).Error; err != nil {
+		return nil, err
+	}
+	values := make(map[string]*environmentValue, len(secrets))
+	if len(secrets) == 0 {
+		return values, nil
+	}
+
+	dek, err := ProjectDataKey(db, environment.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range secrets {
+		secret := &secrets[i]
+		value, err := openSecretValue(dek, CurrentContext(secret), secret.Value)
+		if err != nil {
+			return nil, fmt.Errorf("secret %d: %w", secret.ID, err)
+		}
+		values[secret.Key] = &environmentValue{Secret: secret, Value: value, Digest: sha256.Sum256([]byte(value))}
+	}
+	return values, nil
+}
+
+// diffEnvironmentValues lists every key of either environment with how they compare, sorted by key
+func diffEnvironmentValues(source, target map[string]*environmentValue) []SecretDiff {
+	diffs := []SecretDiff{}
+	for key, from := range source {
+		diff := SecretDiff{Key: key, Status: DiffMissing, SourceVersion: from.Secret.CurrentVersion}
+		if to, ok := target[key]; ok {
+			diff.TargetVersion = to.Secret.CurrentVersion
+			diff.Status = DiffSame
+			if to.Digest != from.Digest {
+				diff.Status = DiffChanged
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	for key, to := range target {
+		if _, ok := source[key]; !ok {
+			diffs = append(diffs, SecretDiff{Key: key, Status: DiffExtra, TargetVersion: to.Secret.CurrentVersion})
+		}
+	}
+
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Key < diffs[j].Key })
+	return diffs
+}
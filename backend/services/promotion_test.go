@@ -0,0 +1,39 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"crypto/sha256"
+	"reflect"
+	"testing"
+)
+
+func TestDiffEnvironmentValues(t *testing.T) {
+	values := func(pairs ...string) map[string]*environmentValue {
+		m := make(map[string]*environmentValue)
+		for i := 0; i < len(pairs); i += 2 {
+			m[pairs[i]] = &environmentValue{
+				Secret: &models.Secret{Key: pairs[i], CurrentVersion: i/2 + 1},
+				Value:  pairs[i+1],
+				Digest: sha256.Sum256([]byte(pairs[i+1])),
+			}
+		}
+		return m
+	}
+
+	staging := values("API_URL", "https://staging.example.com", "DB_PASSWORD", "hunter2", "FEATURE_FLAG", "on")
+	production := values("DB_PASSWORD", "hunter2", "API_URL", "https://example.com", "LEGACY_KEY", "old")
+
+	want := []SecretDiff{
+		{Key: "API_URL", Status: DiffChanged, SourceVersion: 1, TargetVersion: 2},
+		{Key: "DB_PASSWORD", Status: DiffSame, SourceVersion: 2, TargetVersion: 1},
+		{Key: "FEATURE_FLAG", Status: DiffMissing, SourceVersion: 3},
+		{Key: "LEGACY_KEY", Status: DiffExtra, TargetVersion: 3},
+	}
+	if got := diffEnvironmentValues(staging, production); !reflect.DeepEqual(got, want) {
+		t.Fatalf("Got diff %+v, want %+v", got, want)
+	}
+
+	if got := diffEnvironmentValues(values(), values()); len(got) != 0 {
+		t.Fatalf("Expected empty environments to have no differences, got %+v", got)
+	}
+}
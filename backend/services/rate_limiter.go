@@ -0,0 +1,74 @@
+package services
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiterMaxKeys is how many keys a RateLimiter tracks before it drops idle ones
+const rateLimiterMaxKeys = 10000
+
+// RateLimiter is an in-memory token bucket per key, such as a client IP. A key can make
+// perMinute requests in a burst, and regains one request every minute/perMinute. Limits
+// are per server instance.
+type RateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // Tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+	now     func() time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewRateLimiter creates a limiter allowing perMinute requests per key; 0 allows everything
+func NewRateLimiter(perMinute int) *RateLimiter {
+	return &RateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(perMinute),
+		buckets: make(map[string]*tokenBucket),
+		now:     time.Now,
+	}
+}
+
+// Allow takes a request from key's budget. When none is left it returns false and how long
+// until the next one.
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	if l.burst == 0 {
+		return true, 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	bucket, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= rateLimiterMaxKeys {
+			l.prune(now)
+		}
+		bucket = &tokenBucket{tokens: l.burst, updated: now}
+		l.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate)
+	bucket.updated = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+}
+
+// prune drops the keys whose buckets have refilled, which behave like new keys anyway
+func (l *RateLimiter) prune(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.updated).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewRateLimiter(3)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+			t.Fatalf("Expected request %d of the burst to be allowed", i+1)
+		}
+	}
+	allowed, retryAfter := limiter.Allow("10.0.0.1")
+	if allowed || retryAfter != 20*time.Second {
+		t.Fatalf("Expected the fourth request to wait 20s, got allowed=%v retryAfter=%v", allowed, retryAfter)
+	}
+
+	// Other clients have their own budget
+	if allowed, _ := limiter.Allow("10.0.0.2"); !allowed {
+		t.Fatal("Expected another IP to be allowed")
+	}
+
+	now = now.Add(20 * time.Second)
+	if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+		t.Fatal("Expected a request to be allowed after the refill")
+	}
+	if allowed, _ := limiter.Allow("10.0.0.1"); allowed {
+		t.Fatal("Expected the refill to allow only one request")
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	limiter := NewRateLimiter(0)
+	for i := 0; i < 100; i++ {
+		if allowed, _ := limiter.Allow("10.0.0.1"); !allowed {
+			t.Fatal("Expected a zero limit to allow everything")
+		}
+	}
+}
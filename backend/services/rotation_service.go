@@ -0,0 +1,232 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrRotationInProgress is returned when a key rotation job is already running
+var ErrRotationInProgress = errors.New("key rotation already in progress")
+
+// rotationBatchSize is the number of rows rewrapped per query
+const rotationBatchSize = 100
+
+// Rotation job states
+const (
+	RotationIdle      = "idle"
+	RotationRunning   = "running"
+	RotationCompleted = "completed"
+	RotationFailed    = "failed"
+)
+
+// RotationStatus reports the progress of a key rotation job
+type RotationStatus struct {
+	State         string     `json:"state"`
+	TargetVersion uint32     `json:"target_version"`
+	Total         int64      `json:"total"`
+	Rewrapped     int64      `json:"rewrapped"`
+	Failed        int64      `json:"failed"`
+	LastError     string     `json:"last_error,omitempty"`
+	StartedAt     *time.Time `json:"started_at,omitempty"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+// KeyRotationService rewraps project data keys and TOTP secrets to the active master key
+// in the background
+type KeyRotationService struct {
+	DB *gorm.DB
+
+	mu     sync.Mutex
+	status RotationStatus
+}
+
+// NewKeyRotationService creates a new KeyRotationService
+func NewKeyRotationService(db *gorm.DB) *KeyRotationService {
+	return &KeyRotationService{DB: db, status: RotationStatus{State: RotationIdle}}
+}
+
+// Status returns a snapshot of the current or last rotation job
+func (s *KeyRotationService) Status() RotationStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// Start launches a rotation job rewrapping every data key and TOTP secret not yet under the
+// active master key.
+// The server keeps serving requests while it runs since old keys stay in the keyring.
+func (s *KeyRotationService) Start() (RotationStatus, error) {
+	ring, err := MasterKeyring()
+	if err != nil {
+		return RotationStatus{}, err
+	}
+	target := ring.ActiveVersion()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.status.State == RotationRunning {
+		return s.status, ErrRotationInProgress
+	}
+
+	var projects, users int64
+	if err := s.staleProjects(target).Count(&projects).Error; err != nil {
+		return RotationStatus{}, err
+	}
+	if err := s.staleTOTPSecrets(target).Count(&users).Error; err != nil {
+		return RotationStatus{}, err
+	}
+
+	now := time.Now()
+	s.status = RotationStatus{
+		State:         RotationRunning,
+		TargetVersion: target,
+		Total:         projects + users,
+		StartedAt:     &now,
+	}
+
+	go s.run(ring, target)
+
+	return s.status, nil
+}
+
+// staleProjects selects projects whose data key is wrapped by a key other than target
+func (s *KeyRotationService) staleProjects(target uint32) *gorm.DB {
+	header := keyHeaderPrefix + strconv.FormatUint(uint64(target), 10) + ":%"
+	return s.DB.Unscoped().Model(&models.Project{}).
+		Where("encrypted_dek <> '' AND encrypted_dek NOT LIKE ?", header)
+}
+
+// staleTOTPSecrets selects users whose TOTP secret is encrypted by a key other than target
+func (s *KeyRotationService) staleTOTPSecrets(target uint32) *gorm.DB {
+	header := keyHeaderPrefix + strconv.FormatUint(uint64(target), 10) + ":%"
+	return s.DB.Unscoped().Model(&models.User{}).
+		Where("totp_secret <> '' AND totp_secret NOT LIKE ?", header)
+}
+
+func (s *KeyRotationService) run(ring *Keyring, target uint32) {
+	if err := s.rewrapAll("project", s.staleProjects(target), func(id uint) error {
+		return s.rewrapProject(ring, id)
+	}); err != nil {
+		s.finish(err)
+		return
+	}
+	if err := s.rewrapAll("user", s.staleTOTPSecrets(target), func(id uint) error {
+		return s.rewrapTOTPSecret(ring, id)
+	}); err != nil {
+		s.finish(err)
+		return
+	}
+
+	s.finish(nil)
+}
+
+// rewrapAll walks the rows selected by stale in ID order and rewraps each of them,
+// counting failures without stopping
+func (s *KeyRotationService) rewrapAll(kind string, stale *gorm.DB, rewrap func(id uint) error) error {
+	var lastID uint
+	for {
+		var ids []uint
+		err := stale.Session(&gorm.Session{}).
+			Where("id > ?", lastID).
+			Order("id").
+			Limit(rotationBatchSize).
+			Pluck("id", &ids).Error
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		for _, id := range ids {
+			lastID = id
+			err := rewrap(id)
+			s.mu.Lock()
+			if err != nil {
+				s.status.Failed++
+				s.status.LastError = fmt.Sprintf("%s %d: %v", kind, id, err)
+			} else {
+				s.status.Rewrapped++
+			}
+			s.mu.Unlock()
+		}
+	}
+}
+
+// rewrapProject unwraps a project's data key and wraps it again under the active master key.
+// The secrets themselves are untouched because they are encrypted under the data key.
+func (s *KeyRotationService) rewrapProject(ring *Keyring, projectID uint) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var project models.Project
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&project, projectID).Error; err != nil {
+			return err
+		}
+		if project.EncryptedDEK == "" {
+			return nil // Shredded since the batch was read
+		}
+
+		dek, err := ring.Decrypt(project.EncryptedDEK)
+		if err != nil {
+			return err
+		}
+		wrapped, err := ring.Encrypt(dek)
+		if err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Model(&project).Update("encrypted_dek", wrapped).Error
+	})
+}
+
+// rewrapTOTPSecret re-encrypts a user's TOTP secret under the active master key
+func (s *KeyRotationService) rewrapTOTPSecret(ring *Keyring, userID uint) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		var user models.User
+		if err := tx.Unscoped().Clauses(clause.Locking{Strength: "UPDATE"}).First(&user, userID).Error; err != nil {
+			return err
+		}
+		if user.TOTPSecret == "" {
+			return nil // Disabled since the batch was read
+		}
+
+		secret, err := ring.Decrypt(user.TOTPSecret)
+		if err != nil {
+			return err
+		}
+		encrypted, err := ring.Encrypt(secret)
+		if err != nil {
+			return err
+		}
+
+		return tx.Unscoped().Model(&user).Update("totp_secret", encrypted).Error
+	})
+}
+
+func (s *KeyRotationService) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.status.FinishedAt = &now
+	switch {
+	case err != nil:
+		s.status.State = RotationFailed
+		s.status.LastError = err.Error()
+	case s.status.Failed > 0:
+		s.status.State = RotationFailed
+	default:
+		s.status.State = RotationCompleted
+	}
+
+	log.Printf("Key rotation to version %d finished: %s (%d rewrapped, %d failed)",
+		s.status.TargetVersion, s.status.State, s.status.Rewrapped, s.status.Failed)
+}
@@ -0,0 +1,355 @@
+package services
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SCIM 2.0 schema URNs (RFC 7643, RFC 7644)
+const (
+	SCIMSchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	SCIMSchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+var (
+	ErrSCIMNotFound      = errors.New("resource not found")
+	ErrSCIMUniqueness    = errors.New("a resource with this identifier already exists")
+	ErrSCIMInvalidValue  = errors.New("invalid value")
+	ErrSCIMInvalidFilter = errors.New("invalid filter")
+	ErrSCIMInvalidPath   = errors.New("invalid path")
+)
+
+// SCIMMeta is the read-only metadata of a resource
+type SCIMMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+// SCIMName is the structured name of a user
+type SCIMName struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// SCIMEmail is one of a user's email addresses
+type SCIMEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// SCIMMember references a user from a group, or a group from a user
+type SCIMMember struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+// SCIMUserResource is the SCIM representation of a user. userName is the user's email.
+type SCIMUserResource struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	UserName    string       `json:"userName"`
+	Name        *SCIMName    `json:"name,omitempty"`
+	DisplayName string       `json:"displayName,omitempty"`
+	Emails      []SCIMEmail  `json:"emails,omitempty"`
+	Active      *bool        `json:"active,omitempty"`
+	Groups      []SCIMMember `json:"groups,omitempty"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// SCIMGroupResource is the SCIM representation of a group
+type SCIMGroupResource struct {
+	Schemas     []string     `json:"schemas"`
+	ID          string       `json:"id,omitempty"`
+	ExternalID  string       `json:"externalId,omitempty"`
+	DisplayName string       `json:"displayName"`
+	Members     []SCIMMember `json:"members"`
+	Meta        *SCIMMeta    `json:"meta,omitempty"`
+}
+
+// SCIMListResponse is a page of query results
+type SCIMListResponse struct {
+	Schemas      []string    `json:"schemas"`
+	TotalResults int64       `json:"totalResults"`
+	StartIndex   int         `json:"startIndex"`
+	ItemsPerPage int         `json:"itemsPerPage"`
+	Resources    interface{} `json:"Resources"`
+}
+
+// SCIMPatchRequest is the body of a PATCH request
+type SCIMPatchRequest struct {
+	Schemas    []string             `json:"schemas"`
+	Operations []SCIMPatchOperation `json:"Operations"`
+}
+
+// SCIMPatchOperation is one add, replace or remove operation
+type SCIMPatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// SCIMFilter is an equality filter on one attribute, the only kind identity providers
+// send when provisioning. Attribute is lowercased; SCIM attribute names are case-insensitive.
+type SCIMFilter struct {
+	Attribute string
+	Value     string
+}
+
+var (
+	scimFilterPattern     = regexp.MustCompile(`^\s*([A-Za-z][\w.]*)\s+(?i:eq)\s+"((?:[^"\\]|\\.)*)"\s*$`)
+	scimMemberPathPattern = regexp.MustCompile(`^(?i:members)\[(.*)\]$`)
+)
+
+// CheckSCIMToken compares a presented bearer token with SCIM_TOKEN in constant time
+func CheckSCIMToken(presented, configured string) bool {
+	if presented == "" || configured == "" {
+		return false
+	}
+	a := sha256.Sum256([]byte(presented))
+	b := sha256.Sum256([]byte(configured))
+	return subtle.ConstantTimeCompare(a[:], b[:]) == 1
+}
+
+// ParseSCIMFilter parses an `attribute eq "value"` filter. An empty filter returns nil.
+func ParseSCIMFilter(filter string) (*SCIMFilter, error) {
+	if strings.TrimSpace(filter) == "" {
+		return nil, nil
+	}
+	match := scimFilterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return nil, fmt.Errorf("%w: only attribute eq \"value\" filters are supported", ErrSCIMInvalidFilter)
+	}
+	var value string
+	if err := json.Unmarshal([]byte(`"`+match[2]+`"`), &value); err != nil {
+		return nil, fmt.Errorf("%w: malformed string %q", ErrSCIMInvalidFilter, match[2])
+	}
+	return &SCIMFilter{Attribute: strings.ToLower(match[1]), Value: value}, nil
+}
+
+// ApplySCIMUserPatch applies PATCH operations to a user. Attributes CipherSafe does not
+// store are ignored, so providers that push their whole profile still work.
+func ApplySCIMUserPatch(user *SCIMUserResource, operations []SCIMPatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return fmt.Errorf("%w: unsupported operation %q", ErrSCIMInvalidValue, operation.Op)
+		}
+
+		if operation.Path == "" {
+			if op == "remove" {
+				return fmt.Errorf("%w: remove requires a path", ErrSCIMInvalidPath)
+			}
+			var attributes map[string]json.RawMessage
+			if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+				return fmt.Errorf("%w: operation without a path needs an object value", ErrSCIMInvalidValue)
+			}
+			for name, value := range attributes {
+				if err := setSCIMUserAttribute(user, name, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		if op == "remove" {
+			if err := removeSCIMUserAttribute(user, operation.Path); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := setSCIMUserAttribute(user, operation.Path, operation.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setSCIMUserAttribute(user *SCIMUserResource, path string, value json.RawMessage) error {
+	var err error
+	switch strings.ToLower(path) {
+	case "active":
+		var active bool
+		active, err = scimBool(value)
+		user.Active = &active
+	case "username":
+		err = scimString(value, &user.UserName)
+	case "displayname":
+		err = scimString(value, &user.DisplayName)
+	case "externalid":
+		err = scimString(value, &user.ExternalID)
+	case "name":
+		var name SCIMName
+		if err = json.Unmarshal(value, &name); err == nil {
+			user.Name = &name
+		}
+	case "name.formatted", "name.givenname", "name.familyname":
+		if user.Name == nil {
+			user.Name = &SCIMName{}
+		}
+		field := map[string]*string{
+			"name.formatted":  &user.Name.Formatted,
+			"name.givenname":  &user.Name.GivenName,
+			"name.familyname": &user.Name.FamilyName,
+		}[strings.ToLower(path)]
+		err = scimString(value, field)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrSCIMInvalidValue, path)
+	}
+	return nil
+}
+
+func removeSCIMUserAttribute(user *SCIMUserResource, path string) error {
+	switch strings.ToLower(path) {
+	case "username", "active":
+		return fmt.Errorf("%w: %s cannot be removed", ErrSCIMInvalidPath, path)
+	case "displayname":
+		user.DisplayName = ""
+	case "externalid":
+		user.ExternalID = ""
+	case "name":
+		user.Name = nil
+	}
+	return nil
+}
+
+// ApplySCIMGroupPatch applies PATCH operations to a group, including member changes addressed
+// as "members" or `members[value eq "id"]`
+func ApplySCIMGroupPatch(group *SCIMGroupResource, operations []SCIMPatchOperation) error {
+	for _, operation := range operations {
+		op := strings.ToLower(operation.Op)
+		if op != "add" && op != "replace" && op != "remove" {
+			return fmt.Errorf("%w: unsupported operation %q", ErrSCIMInvalidValue, operation.Op)
+		}
+
+		if operation.Path == "" {
+			if op == "remove" {
+				return fmt.Errorf("%w: remove requires a path", ErrSCIMInvalidPath)
+			}
+			var attributes map[string]json.RawMessage
+			if err := json.Unmarshal(operation.Value, &attributes); err != nil {
+				return fmt.Errorf("%w: operation without a path needs an object value", ErrSCIMInvalidValue)
+			}
+			for name, value := range attributes {
+				if err := setSCIMGroupAttribute(group, op, name, value); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		// members[value eq "id"] addresses one member
+		if match := scimMemberPathPattern.FindStringSubmatch(operation.Path); match != nil {
+			filter, err := ParseSCIMFilter(match[1])
+			if err != nil || filter == nil || filter.Attribute != "value" {
+				return fmt.Errorf("%w: %s", ErrSCIMInvalidPath, operation.Path)
+			}
+			if op != "remove" {
+				return fmt.Errorf("%w: only remove is supported on %s", ErrSCIMInvalidPath, operation.Path)
+			}
+			group.Members = withoutSCIMMembers(group.Members, []SCIMMember{{Value: filter.Value}})
+			continue
+		}
+
+		if op == "remove" {
+			switch strings.ToLower(operation.Path) {
+			case "members":
+				if len(operation.Value) == 0 || string(operation.Value) == "null" {
+					group.Members = nil
+					continue
+				}
+				var members []SCIMMember
+				if err := json.Unmarshal(operation.Value, &members); err != nil {
+					return fmt.Errorf("%w: members", ErrSCIMInvalidValue)
+				}
+				group.Members = withoutSCIMMembers(group.Members, members)
+			case "externalid":
+				group.ExternalID = ""
+			case "displayname":
+				return fmt.Errorf("%w: displayName cannot be removed", ErrSCIMInvalidPath)
+			}
+			continue
+		}
+		if err := setSCIMGroupAttribute(group, op, operation.Path, operation.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func setSCIMGroupAttribute(group *SCIMGroupResource, op, path string, value json.RawMessage) error {
+	switch strings.ToLower(path) {
+	case "displayname":
+		if err := scimString(value, &group.DisplayName); err != nil {
+			return fmt.Errorf("%w: %s", ErrSCIMInvalidValue, path)
+		}
+	case "externalid":
+		if err := scimString(value, &group.ExternalID); err != nil {
+			return fmt.Errorf("%w: %s", ErrSCIMInvalidValue, path)
+		}
+	case "members":
+		var members []SCIMMember
+		if err := json.Unmarshal(value, &members); err != nil {
+			return fmt.Errorf("%w: %s", ErrSCIMInvalidValue, path)
+		}
+		if op == "replace" {
+			group.Members = nil
+		}
+		group.Members = append(group.Members, withoutSCIMMembers(members, group.Members)...)
+	}
+	return nil
+}
+
+// withoutSCIMMembers returns members minus the ones whose value is in remove
+func withoutSCIMMembers(members, remove []SCIMMember) []SCIMMember {
+	removed := make(map[string]bool, len(remove))
+	for _, member := range remove {
+		removed[member.Value] = true
+	}
+	kept := make([]SCIMMember, 0, len(members))
+	for _, member := range members {
+		if !removed[member.Value] {
+			kept = append(kept, member)
+		}
+	}
+	return kept
+}
+
+// scimBool reads a boolean. Some providers send "True" and "False" as strings.
+func scimBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, err
+	}
+	switch strings.ToLower(s) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return false, fmt.Errorf("not a boolean: %q", s)
+}
+
+func scimString(value json.RawMessage, target *string) error {
+	return json.Unmarshal(value, target)
+}
@@ -0,0 +1,668 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// SCIMMaxPageSize caps the count of a SCIM list request
+const SCIMMaxPageSize = 200
+
+// SCIMService provisions users and groups pushed by an identity provider over SCIM 2.0.
+// Group membership grants project roles through SCIM_GROUP_MAPPINGS.
+type SCIMService struct {
+	DB *gorm.DB
+}
+
+// NewSCIMService creates a new SCIMService
+func NewSCIMService(db *gorm.DB) *SCIMService {
+	return &SCIMService{DB: db}
+}
+
+// ListUsers returns a page of users, optionally filtered on userName, externalId or emails.value.
+// startIndex is 1-based.
+func (s *SCIMService) ListUsers(filter string, startIndex, count int) (*SCIMListResponse, error) {
+	parsed, err := ParseSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.DB.Model(&models.User{})
+	if parsed != nil {
+		switch parsed.Attribute {
+		case "username", "emails.value", "emails":
+			query = query.Where("email = ?", parsed.Value)
+		case "externalid":
+			query = query.Where("scim_external_id = ?", parsed.Value)
+		default:
+			return nil, fmt.Errorf("%w: cannot filter users on %s", ErrSCIMInvalidFilter, parsed.Attribute)
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var users []models.User
+	if err := query.Order("id").Offset(startIndex - 1).Limit(count).Find(&users).Error; err != nil {
+		return nil, err
+	}
+
+	resources, err := scimUserResources(s.DB, users)
+	if err != nil {
+		return nil, err
+	}
+	return scimListResponse(total, startIndex, resources), nil
+}
+
+// GetUser returns one user
+func (s *SCIMService) GetUser(id string) (*SCIMUserResource, error) {
+	user, err := findSCIMUser(s.DB, id)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := scimUserResources(s.DB, []models.User{*user})
+	if err != nil {
+		return nil, err
+	}
+	return resources[0], nil
+}
+
+// CreateUser provisions a user without a password; they sign in with single sign-on.
+// An existing account with the same email is a conflict: providers look users up by
+// userName first and update the match instead.
+func (s *SCIMService) CreateUser(in *SCIMUserResource) (*SCIMUserResource, error) {
+	email := strings.TrimSpace(in.UserName)
+	if email == "" {
+		return nil, fmt.Errorf("%w: userName is required", ErrSCIMInvalidValue)
+	}
+
+	var user models.User
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := ensureSCIMUserUnique(tx, 0, email, in.ExternalID); err != nil {
+			return err
+		}
+		user = models.User{Email: email, DisplayName: scimDisplayName(in), SCIMExternalID: optionalString(in.ExternalID)}
+		if err := tx.Create(&user).Error; err != nil {
+			return err
+		}
+		// Active defaults to true in the database, so an inactive user is deactivated after creation
+		if in.Active != nil && !*in.Active {
+			return (&UserService{DB: tx}).DeactivateUser(user.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(strconv.FormatUint(uint64(user.ID), 10))
+}
+
+// ReplaceUser updates a user from a full representation (PUT)
+func (s *SCIMService) ReplaceUser(id string, in *SCIMUserResource) (*SCIMUserResource, error) {
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findSCIMUser(tx, id)
+		if err != nil {
+			return err
+		}
+		return updateSCIMUser(tx, user, in)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetUser(id)
+}
+
+// PatchUser applies PATCH operations to a user. Setting active to false deactivates the
+// account and signs the user out.
+func (s *SCIMService) PatchUser(id string, operations []SCIMPatchOperation) (*SCIMUserResource, error) {
+	current, err := s.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ApplySCIMUserPatch(current, operations); err != nil {
+		return nil, err
+	}
+	return s.ReplaceUser(id, current)
+}
+
+// DeleteUser deactivates a user and removes them from every SCIM group, which revokes the
+// project access those groups granted. The account is kept for the audit trail.
+func (s *SCIMService) DeleteUser(id string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		user, err := findSCIMUser(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := (&UserService{DB: tx}).DeactivateUser(user.ID); err != nil {
+			return err
+		}
+		if err := tx.Exec("DELETE FROM scim_group_members WHERE user_id = ?", user.ID).Error; err != nil {
+			return err
+		}
+		return syncSCIMMemberships(tx, []uint{user.ID})
+	})
+}
+
+// ListGroups returns a page of groups, optionally filtered on displayName or externalId
+func (s *SCIMService) ListGroups(filter string, startIndex, count int) (*SCIMListResponse, error) {
+	parsed, err := ParseSCIMFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	query := s.DB.Model(&models.SCIMGroup{})
+	if parsed != nil {
+		switch parsed.Attribute {
+		case "displayname":
+			query = query.Where("display_name = ?", parsed.Value)
+		case "externalid":
+			query = query.Where("external_id = ?", parsed.Value)
+		default:
+			return nil, fmt.Errorf("%w: cannot filter groups on %s", ErrSCIMInvalidFilter, parsed.Attribute)
+		}
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, err
+	}
+	var groups []models.SCIMGroup
+	if err := query.Preload("Members").Order("id").Offset(startIndex - 1).Limit(count).Find(&groups).Error; err != nil {
+		return nil, err
+	}
+
+	resources := make([]*SCIMGroupResource, len(groups))
+	for i := range groups {
+		resources[i] = scimGroupResource(&groups[i])
+	}
+	return scimListResponse(total, startIndex, resources), nil
+}
+
+// GetGroup returns one group with its members
+func (s *SCIMService) GetGroup(id string) (*SCIMGroupResource, error) {
+	group, err := findSCIMGroup(s.DB, id)
+	if err != nil {
+		return nil, err
+	}
+	return scimGroupResource(group), nil
+}
+
+// CreateGroup creates a group and grants its members the group's mapped project roles
+func (s *SCIMService) CreateGroup(in *SCIMGroupResource) (*SCIMGroupResource, error) {
+	name := strings.TrimSpace(in.DisplayName)
+	if name == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrSCIMInvalidValue)
+	}
+
+	var group models.SCIMGroup
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := ensureSCIMGroupUnique(tx, 0, name); err != nil {
+			return err
+		}
+		memberIDs, err := resolveSCIMMembers(tx, in.Members)
+		if err != nil {
+			return err
+		}
+		group = models.SCIMGroup{DisplayName: name, ExternalID: optionalString(in.ExternalID)}
+		if err := tx.Create(&group).Error; err != nil {
+			return err
+		}
+		if _, err := setSCIMGroupMembers(tx, group.ID, memberIDs); err != nil {
+			return err
+		}
+		return syncSCIMMemberships(tx, memberIDs)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGroup(strconv.FormatUint(uint64(group.ID), 10))
+}
+
+// ReplaceGroup updates a group from a full representation (PUT), resyncing the project
+// roles of its former and current members
+func (s *SCIMService) ReplaceGroup(id string, in *SCIMGroupResource) (*SCIMGroupResource, error) {
+	name := strings.TrimSpace(in.DisplayName)
+	if name == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrSCIMInvalidValue)
+	}
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		group, err := findSCIMGroup(tx, id)
+		if err != nil {
+			return err
+		}
+		if err := ensureSCIMGroupUnique(tx, group.ID, name); err != nil {
+			return err
+		}
+		memberIDs, err := resolveSCIMMembers(tx, in.Members)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Model(group).Updates(map[string]interface{}{
+			"display_name": name,
+			"external_id":  optionalString(in.ExternalID),
+		}).Error
+		if err != nil {
+			return err
+		}
+		affected, err := setSCIMGroupMembers(tx, group.ID, memberIDs)
+		if err != nil {
+			return err
+		}
+		return syncSCIMMemberships(tx, affected)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.GetGroup(id)
+}
+
+// PatchGroup applies PATCH operations to a group
+func (s *SCIMService) PatchGroup(id string, operations []SCIMPatchOperation) (*SCIMGroupResource, error) {
+	current, err := s.GetGroup(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := ApplySCIMGroupPatch(current, operations); err != nil {
+		return nil, err
+	}
+	return s.ReplaceGroup(id, current)
+}
+
+// DeleteGroup deletes a group and revokes the project roles it granted
+func (s *SCIMService) DeleteGroup(id string) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		group, err := findSCIMGroup(tx, id)
+		if err != nil {
+			return err
+		}
+		affected, err := setSCIMGroupMembers(tx, group.ID, nil)
+		if err != nil {
+			return err
+		}
+		if err := tx.Delete(group).Error; err != nil {
+			return err
+		}
+		return syncSCIMMemberships(tx, affected)
+	})
+}
+
+// updateSCIMUser writes a user's SCIM attributes and applies a change of active
+func updateSCIMUser(tx *gorm.DB, user *models.User, in *SCIMUserResource) error {
+	email := strings.TrimSpace(in.UserName)
+	if email == "" {
+		return fmt.Errorf("%w: userName is required", ErrSCIMInvalidValue)
+	}
+	if err := ensureSCIMUserUnique(tx, user.ID, email, in.ExternalID); err != nil {
+		return err
+	}
+
+	err := tx.Model(user).Updates(map[string]interface{}{
+		"email":            email,
+		"display_name":     scimDisplayName(in),
+		"scim_external_id": optionalString(in.ExternalID),
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	users := &UserService{DB: tx}
+	switch {
+	case in.Active == nil || *in.Active == user.Active:
+		return nil
+	case *in.Active:
+		return users.ActivateUser(user.ID)
+	default:
+		return users.DeactivateUser(user.ID)
+	}
+}
+
+// syncSCIMMemberships brings the SCIM-managed project memberships of users in line with
+// their groups. Memberships granted by hand are never changed, and the last owner of a
+// project is kept rather than removed.
+func syncSCIMMemberships(tx *gorm.DB, userIDs []uint) error {
+	for _, userID := range userIDs {
+		var groups []string
+		err := tx.Table("scim_groups").
+			Joins("JOIN scim_group_members ON scim_group_members.scim_group_id = scim_groups.id").
+			Where("scim_group_members.user_id = ?", userID).
+			Pluck("scim_groups.display_name", &groups).Error
+		if err != nil {
+			return err
+		}
+		_, desired := mappedRoles(config.AppConfig.SCIMGroupMappings, groups)
+
+		var current []models.ProjectMember
+		if err := tx.Where("user_id = ?", userID).Find(&current).Error; err != nil {
+			return err
+		}
+		plan := planSCIMMemberships(userID, current, desired)
+
+		for i := range plan.Create {
+			var project models.Project
+			if err := tx.Select("id").First(&project, plan.Create[i].ProjectID).Error; err != nil {
+				if errors.Is(err, gorm.ErrRecordNotFound) {
+					continue // Mapped project was deleted
+				}
+				return err
+			}
+			if err := tx.Create(&plan.Create[i]).Error; err != nil {
+				return err
+			}
+		}
+		for _, member := range plan.Update {
+			if err := keepLastOwner(tx, member, member.Role); err != nil {
+				if errors.Is(err, ErrLastOwner) {
+					continue
+				}
+				return err
+			}
+			if err := tx.Model(&models.ProjectMember{}).Where("id = ?", member.ID).Update("role", member.Role).Error; err != nil {
+				return err
+			}
+		}
+		for _, member := range plan.Remove {
+			if err := keepLastOwner(tx, member, ""); err != nil {
+				if errors.Is(err, ErrLastOwner) {
+					continue
+				}
+				return err
+			}
+			if err := tx.Delete(&models.ProjectMember{}, member.ID).Error; err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// keepLastOwner returns ErrLastOwner if changing member to role would leave its project
+// without an owner
+func keepLastOwner(tx *gorm.DB, member models.ProjectMember, role models.Role) error {
+	var stored models.ProjectMember
+	if err := tx.Select("role").First(&stored, member.ID).Error; err != nil {
+		return err
+	}
+	if stored.Role != models.RoleOwner || role == models.RoleOwner {
+		return nil
+	}
+	return ensureAnotherOwner(tx, member.ProjectID, member.UserID)
+}
+
+// scimMembershipPlan lists the membership changes that bring a user in line with their groups
+type scimMembershipPlan struct {
+	Create []models.ProjectMember
+	Update []models.ProjectMember // With the new role set
+	Remove []models.ProjectMember
+}
+
+// planSCIMMemberships compares a user's memberships with the project roles their groups map
+// to. Only memberships with source "scim" are updated or removed; a project the user already
+// belongs to by hand keeps its manual role.
+func planSCIMMemberships(userID uint, current []models.ProjectMember, desired map[uint]models.Role) scimMembershipPlan {
+	var plan scimMembershipPlan
+	byProject := make(map[uint]models.ProjectMember, len(current))
+	for _, member := range current {
+		byProject[member.ProjectID] = member
+	}
+
+	for projectID, role := range desired {
+		member, exists := byProject[projectID]
+		switch {
+		case !exists:
+			plan.Create = append(plan.Create, models.ProjectMember{ProjectID: projectID, UserID: userID, Role: role, Source: models.MemberSourceSCIM})
+		case member.Source == models.MemberSourceSCIM && member.Role != role:
+			member.Role = role
+			plan.Update = append(plan.Update, member)
+		}
+	}
+	for _, member := range current {
+		if _, wanted := desired[member.ProjectID]; member.Source == models.MemberSourceSCIM && !wanted {
+			plan.Remove = append(plan.Remove, member)
+		}
+	}
+
+	sort.Slice(plan.Create, func(i, j int) bool { return plan.Create[i].ProjectID < plan.Create[j].ProjectID })
+	sort.Slice(plan.Update, func(i, j int) bool { return plan.Update[i].ProjectID < plan.Update[j].ProjectID })
+	return plan
+}
+
+// setSCIMGroupMembers replaces a group's members and returns the former and new members
+func setSCIMGroupMembers(tx *gorm.DB, groupID uint, userIDs []uint) ([]uint, error) {
+	var former []uint
+	err := tx.Table("scim_group_members").Where("scim_group_id = ?", groupID).Pluck("user_id", &former).Error
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Exec("DELETE FROM scim_group_members WHERE scim_group_id = ?", groupID).Error; err != nil {
+		return nil, err
+	}
+	for _, userID := range userIDs {
+		if err := tx.Exec("INSERT INTO scim_group_members (scim_group_id, user_id) VALUES (?, ?)", groupID, userID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	affected := append(former, userIDs...)
+	sort.Slice(affected, func(i, j int) bool { return affected[i] < affected[j] })
+	unique := affected[:0]
+	for i, id := range affected {
+		if i == 0 || id != affected[i-1] {
+			unique = append(unique, id)
+		}
+	}
+	return unique, nil
+}
+
+// resolveSCIMMembers checks that every member references an existing user
+func resolveSCIMMembers(tx *gorm.DB, members []SCIMMember) ([]uint, error) {
+	seen := make(map[uint]bool, len(members))
+	ids := make([]uint, 0, len(members))
+	for _, member := range members {
+		id, err := strconv.ParseUint(member.Value, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: unknown member %q", ErrSCIMInvalidValue, member.Value)
+		}
+		if !seen[uint(id)] {
+			seen[uint(id)] = true
+			ids = append(ids, uint(id))
+		}
+	}
+	if len(ids) == 0 {
+		return ids, nil
+	}
+
+	var found int64
+	if err := tx.Model(&models.User{}).Where("id IN ?", ids).Count(&found).Error; err != nil {
+		return nil, err
+	}
+	if found != int64(len(ids)) {
+		return nil, fmt.Errorf("%w: members reference unknown users", ErrSCIMInvalidValue)
+	}
+	return ids, nil
+}
+
+func ensureSCIMUserUnique(tx *gorm.DB, userID uint, email, externalID string) error {
+	var count int64
+	if err := tx.Model(&models.User{}).Where("email = ? AND id <> ?", email, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: userName %q", ErrSCIMUniqueness, email)
+	}
+	if externalID == "" {
+		return nil
+	}
+	if err := tx.Model(&models.User{}).Where("scim_external_id = ? AND id <> ?", externalID, userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: externalId %q", ErrSCIMUniqueness, externalID)
+	}
+	return nil
+}
+
+func ensureSCIMGroupUnique(tx *gorm.DB, groupID uint, name string) error {
+	var count int64
+	if err := tx.Model(&models.SCIMGroup{}).Where("display_name = ? AND id <> ?", name, groupID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("%w: displayName %q", ErrSCIMUniqueness, name)
+	}
+	return nil
+}
+
+func findSCIMUser(db *gorm.DB, id string) (*models.User, error) {
+	var user models.User
+	if err := db.First(&user, scimID(id)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSCIMNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+func findSCIMGroup(db *gorm.DB, id string) (*models.SCIMGroup, error) {
+	var group models.SCIMGroup
+	if err := db.Preload("Members").First(&group, scimID(id)).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSCIMNotFound
+		}
+		return nil, err
+	}
+	return &group, nil
+}
+
+// scimID parses a resource ID; malformed IDs become 0, which matches nothing
+func scimID(id string) uint {
+	parsed, err := strconv.ParseUint(id, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return uint(parsed)
+}
+
+// scimUserResources converts users to SCIM resources, with the groups each belongs to
+func scimUserResources(db *gorm.DB, users []models.User) ([]*SCIMUserResource, error) {
+	ids := make([]uint, len(users))
+	for i, user := range users {
+		ids[i] = user.ID
+	}
+
+	var rows []struct {
+		UserID      uint
+		GroupID     uint
+		DisplayName string
+	}
+	if len(ids) > 0 {
+		err := db.Table("scim_group_members").
+			Select("scim_group_members.user_id, scim_groups.id AS group_id, scim_groups.display_name").
+			Joins("JOIN scim_groups ON scim_groups.id = scim_group_members.scim_group_id").
+			Where("scim_group_members.user_id IN ?", ids).
+			Order("scim_groups.id").
+			Scan(&rows).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	groups := make(map[uint][]SCIMMember)
+	for _, row := range rows {
+		id := strconv.FormatUint(uint64(row.GroupID), 10)
+		groups[row.UserID] = append(groups[row.UserID], SCIMMember{Value: id, Display: row.DisplayName, Ref: "/scim/v2/Groups/" + id})
+	}
+
+	resources := make([]*SCIMUserResource, len(users))
+	for i := range users {
+		user := &users[i]
+		id := strconv.FormatUint(uint64(user.ID), 10)
+		active := user.Active
+		resource := &SCIMUserResource{
+			Schemas:     []string{SCIMSchemaUser},
+			ID:          id,
+			UserName:    user.Email,
+			DisplayName: user.DisplayName,
+			Emails:      []SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+			Active:      &active,
+			Groups:      groups[user.ID],
+			Meta:        &SCIMMeta{ResourceType: "User", Created: user.CreatedAt, LastModified: user.UpdatedAt, Location: "/scim/v2/Users/" + id},
+		}
+		if user.SCIMExternalID != nil {
+			resource.ExternalID = *user.SCIMExternalID
+		}
+		if user.DisplayName != "" {
+			resource.Name = &SCIMName{Formatted: user.DisplayName}
+		}
+		resources[i] = resource
+	}
+	return resources, nil
+}
+
+func scimGroupResource(group *models.SCIMGroup) *SCIMGroupResource {
+	id := strconv.FormatUint(uint64(group.ID), 10)
+	resource := &SCIMGroupResource{
+		Schemas:     []string{SCIMSchemaGroup},
+		ID:          id,
+		DisplayName: group.DisplayName,
+		Members:     make([]SCIMMember, 0, len(group.Members)),
+		Meta:        &SCIMMeta{ResourceType: "Group", Created: group.CreatedAt, LastModified: group.UpdatedAt, Location: "/scim/v2/Groups/" + id},
+	}
+	if group.ExternalID != nil {
+		resource.ExternalID = *group.ExternalID
+	}
+	for _, user := range group.Members {
+		userID := strconv.FormatUint(uint64(user.ID), 10)
+		resource.Members = append(resource.Members, SCIMMember{Value: userID, Display: user.Email, Ref: "/scim/v2/Users/" + userID})
+	}
+	return resource
+}
+
+func scimListResponse(total int64, startIndex int, resources interface{}) *SCIMListResponse {
+	items := 0
+	switch r := resources.(type) {
+	case []*SCIMUserResource:
+		items = len(r)
+	case []*SCIMGroupResource:
+		items = len(r)
+	}
+	return &SCIMListResponse{
+		Schemas:      []string{SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: items,
+		Resources:    resources,
+	}
+}
+
+// scimDisplayName picks the name to show for a user: displayName, else the formatted name,
+// else given and family name
+func scimDisplayName(in *SCIMUserResource) string {
+	if name := strings.TrimSpace(in.DisplayName); name != "" {
+		return name
+	}
+	if in.Name == nil {
+		return ""
+	}
+	if name := strings.TrimSpace(in.Name.Formatted); name != "" {
+		return name
+	}
+	return strings.TrimSpace(in.Name.GivenName + " " + in.Name.FamilyName)
+}
+
+// optionalString maps "" to NULL so unique indexes only cover set values
+func optionalString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseSCIMFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   *SCIMFilter
+		err    error
+	}{
+		{``, nil, nil},
+		{`userName eq "alice@example.com"`, &SCIMFilter{Attribute: "username", Value: "alice@example.com"}, nil},
+		{`externalId EQ "00u1"`, &SCIMFilter{Attribute: "externalid", Value: "00u1"}, nil},
+		{`displayName eq "Ops \"on call\""`, &SCIMFilter{Attribute: "displayname", Value: `Ops "on call"`}, nil},
+		{`emails.value eq "a@b.c"`, &SCIMFilter{Attribute: "emails.value", Value: "a@b.c"}, nil},
+		{`userName sw "alice"`, nil, ErrSCIMInvalidFilter},
+		{`userName eq "a" and active eq true`, nil, ErrSCIMInvalidFilter},
+		{`userName eq alice`, nil, ErrSCIMInvalidFilter},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseSCIMFilter(tt.filter)
+		if !errors.Is(err, tt.err) {
+			t.Errorf("ParseSCIMFilter(%q) error = %v, want %v", tt.filter, err, tt.err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSCIMFilter(%q) = %+v, want %+v", tt.filter, got, tt.want)
+		}
+	}
+}
+
+func patchOperations(t *testing.T, raw string) []SCIMPatchOperation {
+	var request SCIMPatchRequest
+	if err := json.Unmarshal([]byte(raw), &request); err != nil {
+		t.Fatalf("Invalid test request: %v", err)
+	}
+	return request.Operations
+}
+
+func TestApplySCIMUserPatch(t *testing.T) {
+	active := true
+	user := &SCIMUserResource{UserName: "alice@example.com", Active: &active, DisplayName: "Alice"}
+
+	// Okta deactivates with a path; Azure sends string booleans and pathless objects
+	err := ApplySCIMUserPatch(user, patchOperations(t, `{"Operations": [
+		{"op": "replace", "path": "active", "value": false},
+		{"op": "Replace", "value": {"displayName": "Alice Smith", "externalId": "00u1", "title": "Engineer"}},
+		{"op": "add", "path": "name.givenName", "value": "Alice"}
+	]}`))
+	if err != nil {
+		t.Fatalf("Patch failed: %v", err)
+	}
+	if *user.Active || user.DisplayName != "Alice Smith" || user.ExternalID != "00u1" || user.Name.GivenName != "Alice" {
+		t.Fatalf("Unexpected user after patch: %+v", user)
+	}
+
+	if err := ApplySCIMUserPatch(user, patchOperations(t, `{"Operations": [{"op": "replace", "path": "active", "value": "True"}]}`)); err != nil || !*user.Active {
+		t.Fatalf("Expected the string boolean to reactivate the user, got %v", err)
+	}
+	if err := ApplySCIMUserPatch(user, patchOperations(t, `{"Operations": [{"op": "remove", "path": "userName"}]}`)); !errors.Is(err, ErrSCIMInvalidPath) {
+		t.Fatalf("Expected userName to be required, got %v", err)
+	}
+	if err := ApplySCIMUserPatch(user, patchOperations(t, `{"Operations": [{"op": "move", "path": "active", "value": true}]}`)); !errors.Is(err, ErrSCIMInvalidValue) {
+		t.Fatalf("Expected unknown operations to be rejected, got %v", err)
+	}
+}
+
+func TestApplySCIMGroupPatch(t *testing.T) {
+	members := func(values ...string) []SCIMMember {
+		list := []SCIMMember{}
+		for _, value := range values {
+			list = append(list, SCIMMember{Value: value})
+		}
+		return list
+	}
+	values := func(group *SCIMGroupResource) []string {
+		list := []string{}
+		for _, member := range group.Members {
+			list = append(list, member.Value)
+		}
+		return list
+	}
+
+	tests := []struct {
+		name string
+		ops  string
+		want []string
+	}{
+		{"add", `[{"op": "add", "path": "members", "value": [{"value": "3"}, {"value": "1"}]}]`, []string{"1", "2", "3"}},
+		{"remove by filter", `[{"op": "remove", "path": "members[value eq \"1\"]"}]`, []string{"2"}},
+		{"remove by value", `[{"op": "remove", "path": "members", "value": [{"value": "2"}]}]`, []string{"1"}},
+		{"remove all", `[{"op": "remove", "path": "members"}]`, []string{}},
+		{"replace", `[{"op": "replace", "path": "members", "value": [{"value": "5"}]}]`, []string{"5"}},
+		{"pathless", `[{"op": "replace", "value": {"displayName": "ops", "members": [{"value": "4"}]}}]`, []string{"4"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := &SCIMGroupResource{DisplayName: "engineering", Members: members("1", "2")}
+			if err := ApplySCIMGroupPatch(group, patchOperations(t, `{"Operations": `+tt.ops+`}`)); err != nil {
+				t.Fatalf("Patch failed: %v", err)
+			}
+			if got := values(group); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Got members %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	group := &SCIMGroupResource{DisplayName: "engineering"}
+	if err := ApplySCIMGroupPatch(group, patchOperations(t, `{"Operations": [{"op": "add", "path": "members[value eq \"1\"]"}]}`)); !errors.Is(err, ErrSCIMInvalidPath) {
+		t.Fatalf("Expected adding through a filter path to be rejected, got %v", err)
+	}
+}
+
+func TestPlanSCIMMemberships(t *testing.T) {
+	current := []models.ProjectMember{
+		{ID: 1, ProjectID: 10, UserID: 7, Role: models.RoleReader, Source: models.MemberSourceSCIM}, // Role changes
+		{ID: 2, ProjectID: 11, UserID: 7, Role: models.RoleReader},                                  // Manual, kept as is
+		{ID: 3, ProjectID: 12, UserID: 7, Role: models.RoleWriter, Source: models.MemberSourceSCIM}, // No longer mapped
+		{ID: 4, ProjectID: 13, UserID: 7, Role: models.RoleWriter},                                  // Manual, not mapped
+	}
+	desired := map[uint]models.Role{10: models.RoleWriter, 11: models.RoleAdmin, 14: models.RoleReader}
+
+	plan := planSCIMMemberships(7, current, desired)
+	want := scimMembershipPlan{
+		Create: []models.ProjectMember{{ProjectID: 14, UserID: 7, Role: models.RoleReader, Source: models.MemberSourceSCIM}},
+		Update: []models.ProjectMember{{ID: 1, ProjectID: 10, UserID: 7, Role: models.RoleWriter, Source: models.MemberSourceSCIM}},
+		Remove: []models.ProjectMember{current[2]},
+	}
+	if !reflect.DeepEqual(plan, want) {
+		t.Fatalf("Got plan %+v, want %+v", plan, want)
+	}
+}
+
+func TestCheckSCIMToken(t *testing.T) {
+	configured := "scim-token-0123456789abcdef0123456789"
+	if !CheckSCIMToken(configured, configured) {
+		t.Error("Expected the configured token to be accepted")
+	}
+	for _, presented := range []string{"", "scim-token", configured + "x"} {
+		if CheckSCIMToken(presented, configured) {
+			t.Errorf("Expected %q to be rejected", presented)
+		}
+	}
+	if CheckSCIMToken("", "") {
+		t.Error("Expected an unset token to reject everything")
+	}
+}
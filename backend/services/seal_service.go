@@ -0,0 +1,256 @@
+package services
+
+import (
+	"bytes"
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"ciphersafe/utils"
+	"errors"
+	"log"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// sealCheckPlaintext is encrypted under the master key at init to verify rebuilt keys
+const sealCheckPlaintext = "ciphersafe-seal-check"
+
+var (
+	ErrSealNotSupported   = errors.New("server is not running in sealed mode")
+	ErrAlreadyInitialized = errors.New("server is already initialized")
+	ErrNotInitialized     = errors.New("server is not initialized")
+	ErrInvalidKeyShares   = errors.New("unseal key shares do not rebuild the master key")
+)
+
+// SealStatus describes the seal state of the server
+type SealStatus struct {
+	Mode        string `json:"mode"`
+	Initialized bool   `json:"initialized"`
+	Sealed      bool   `json:"sealed"`
+	Shares      int    `json:"shares,omitempty"`
+	Threshold   int    `json:"threshold,omitempty"`
+	Progress    int    `json:"progress"` // Key shares submitted towards the threshold
+	// MigrationError is why the encryption migrations run at the last unseal failed, if they did
+	MigrationError string `json:"migration_error,omitempty"`
+}
+
+// SealService holds the sealed/unsealed state of the server.
+// While sealed, the master key is absent from memory and every secret operation fails.
+type SealService struct {
+	DB *gorm.DB
+
+	mu           sync.Mutex
+	pending      [][]byte // Key shares submitted since the last seal or reset
+	migrationErr error    // Result of the migrations run at the last unseal
+}
+
+// NewSealService creates a new SealService
+func NewSealService(db *gorm.DB) *SealService {
+	return &SealService{DB: db}
+}
+
+// IsSealed reports whether secret operations are currently refused
+func (s *SealService) IsSealed() bool {
+	if !config.AppConfig.IsSealable() {
+		return false
+	}
+	_, err := MasterKeyring()
+	return err != nil
+}
+
+// Status returns the current seal status
+func (s *SealService) Status() (SealStatus, error) {
+	if !config.AppConfig.IsSealable() {
+		return SealStatus{Mode: "none"}, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status()
+}
+
+func (s *SealService) status() (SealStatus, error) {
+	status := SealStatus{Mode: config.SealModeShamir, Sealed: s.IsSealed(), Progress: len(s.pending)}
+	if s.migrationErr != nil && !status.Sealed {
+		status.MigrationError = s.migrationErr.Error()
+	}
+
+	seal, err := s.loadSealConfig()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return status, nil
+	}
+	if err != nil {
+		return status, err
+	}
+
+	status.Initialized = true
+	status.Shares = seal.Shares
+	status.Threshold = seal.Threshold
+	return status, nil
+}
+
+// Initialize runs the init ceremony: it generates a new master key, splits it into
+// key shares for the operators and stores only a check value. The shares are returned
+// exactly once and the server stays sealed until they are submitted.
+func (s *SealService) Initialize(shares, threshold int) ([][]byte, error) {
+	if !config.AppConfig.IsSealable() {
+		return nil, ErrSealNotSupported
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.loadSealConfig(); err == nil {
+		return nil, ErrAlreadyInitialized
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	// Existing data keys, secret values from before data keys and TOTP secrets were encrypted
+	// under a different master key and would become unreadable
+	encrypted, err := masterKeyEncryptedRows(s.DB)
+	if err != nil {
+		return nil, err
+	}
+	if encrypted > 0 {
+		return nil, errors.New("cannot initialize sealed mode over data encrypted with another master key")
+	}
+
+	masterKey, err := GenerateDataKey()
+	if err != nil {
+		return nil, err
+	}
+
+	keyShares, err := utils.SplitSecret(masterKey, shares, threshold)
+	if err != nil {
+		return nil, err
+	}
+
+	check, err := EncryptWithKey(masterKey, []byte(sealCheckPlaintext), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	seal := &models.SealConfig{Shares: shares, Threshold: threshold, KeyCheck: check}
+	if err := s.DB.Create(seal).Error; err != nil {
+		return nil, err
+	}
+
+	return keyShares, nil
+}
+
+// SubmitShare records one operator's key share. Once the threshold is reached the master
+// key is rebuilt, verified and installed, and pending encryption migrations run.
+func (s *SealService) SubmitShare(share []byte) (SealStatus, error) {
+	if !config.AppConfig.IsSealable() {
+		return SealStatus{}, ErrSealNotSupported
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seal, err := s.loadSealConfig()
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return SealStatus{}, ErrNotInitialized
+	}
+	if err != nil {
+		return SealStatus{}, err
+	}
+
+	if !s.IsSealed() {
+		return s.status()
+	}
+
+	// Ignore a share submitted twice; it adds nothing towards the threshold
+	for _, pending := range s.pending {
+		if bytes.Equal(pending, share) {
+			return s.status()
+		}
+	}
+	s.pending = append(s.pending, share)
+
+	if len(s.pending) < seal.Threshold {
+		return s.status()
+	}
+
+	masterKey, err := utils.CombineShares(s.pending)
+	s.pending = nil
+	if err != nil {
+		return SealStatus{}, ErrInvalidKeyShares
+	}
+	if _, err := DecryptWithKey(masterKey, seal.KeyCheck, nil); err != nil {
+		return SealStatus{}, ErrInvalidKeyShares
+	}
+
+	provider, err := NewAESKeyProvider("shamir:v1", masterKey)
+	if err != nil {
+		return SealStatus{}, err
+	}
+	ring, err := NewKeyring(map[uint32]KeyProvider{1: provider}, 1)
+	if err != nil {
+		return SealStatus{}, err
+	}
+	setMasterKeyring(ring)
+	log.Println("Server unsealed")
+
+	s.migrationErr = RunEncryptionMigrations(s.DB)
+	if s.migrationErr != nil {
+		log.Println("Encryption migrations after unseal failed:", s.migrationErr)
+	}
+
+	return s.status()
+}
+
+// ResetUnseal discards key shares submitted so far
+func (s *SealService) ResetUnseal() (SealStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending = nil
+	if !config.AppConfig.IsSealable() {
+		return SealStatus{Mode: "none"}, nil
+	}
+	return s.status()
+}
+
+// Seal drops the master key from memory; secret operations fail until the server is unsealed again
+func (s *SealService) Seal() (SealStatus, error) {
+	if !config.AppConfig.IsSealable() {
+		return SealStatus{}, ErrSealNotSupported
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	setMasterKeyring(nil)
+	s.pending = nil
+	log.Println("Server sealed")
+
+	return s.status()
+}
+
+// masterKeyEncryptedRows counts rows holding data encrypted under the current master key
+func masterKeyEncryptedRows(db *gorm.DB) (int64, error) {
+	var total int64
+	counts := []*gorm.DB{
+		db.Unscoped().Model(&models.Project{}).Where("encrypted_dek <> ''"),
+		db.Unscoped().Model(&models.Secret{}).Where("value <> ''"),
+		db.Unscoped().Model(&models.User{}).Where("totp_secret <> ''"),
+	}
+	for _, query := range counts {
+		var count int64
+		if err := query.Count(&count).Error; err != nil {
+			return 0, err
+		}
+		total += count
+	}
+	return total, nil
+}
+
+func (s *SealService) loadSealConfig() (*models.SealConfig, error) {
+	var seal models.SealConfig
+	if err := s.DB.Order("id").First(&seal).Error; err != nil {
+		return nil, err
+	}
+	return &seal, nil
+}
@@ -0,0 +1,194 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// boundValuePrefix marks secret ciphertexts that carry their context as AES-GCM additional data.
+// Values with the older prefix, bound without the environment, or with none, written before
+// binding existed, are upgraded by BindSecretCiphertexts and are not read otherwise.
+const (
+	boundValuePrefix       = "b2:"
+	legacyBoundValuePrefix = "b1:"
+)
+
+// ErrUnboundCiphertext is returned for a secret value in a format from before the current
+// binding. The encryption migrations upgrade every such value, so one showing up later was
+// written to the database by something other than this server.
+var ErrUnboundCiphertext = errors.New("secret value is not bound to its context")
+
+// ValueContext identifies where a secret ciphertext lives. It is authenticated as AAD, so a
+// ciphertext copied to another secret, project, environment, key name or version fails to decrypt.
+type ValueContext struct {
+	ProjectID     uint
+	EnvironmentID uint
+	SecretID      uint
+	Key           string
+	Version       int
+}
+
+// CurrentContext is the context of the current value stored on the secret row
+func CurrentContext(secret *models.Secret) ValueContext {
+	return ValueContext{
+		ProjectID:     secret.ProjectID,
+		EnvironmentID: secret.EnvironmentID,
+		SecretID:      secret.ID,
+		Key:           secret.Key,
+		Version:       secret.CurrentVersion,
+	}
+}
+
+// VersionContext is the context of a historical version of a secret
+func VersionContext(secret *models.Secret, v *models.SecretVersion) ValueContext {
+	return ValueContext{
+		ProjectID:     secret.ProjectID,
+		EnvironmentID: secret.EnvironmentID,
+		SecretID:      secret.ID,
+		Key:           v.Key,
+		Version:       v.Version,
+	}
+}
+
+// aad encodes the context unambiguously; the key name is quoted so it cannot forge other fields
+func (c ValueContext) aad() []byte {
+	return []byte(fmt.Sprintf("ciphersafe/secret/v2|project=%d|environment=%d|secret=%d|key=%q|version=%d",
+		c.ProjectID, c.EnvironmentID, c.SecretID, c.Key, c.Version))
+}
+
+// legacyAAD is the context of values with legacyBoundValuePrefix, which left out the environment
+func (c ValueContext) legacyAAD() []byte {
+	return []byte(fmt.Sprintf("ciphersafe/secret/v1|project=%d|secret=%d|key=%q|version=%d",
+		c.ProjectID, c.SecretID, c.Key, c.Version))
+}
+
+// sealSecretValue encrypts a secret value under dek, bound to ctx
+func sealSecretValue(dek []byte, ctx ValueContext, plaintext string) (string, error) {
+	ciphertext, err := EncryptWithKey(dek, []byte(plaintext), ctx.aad())
+	if err != nil {
+		return "", err
+	}
+	return boundValuePrefix + ciphertext, nil
+}
+
+// openSecretValue decrypts a secret value under dek, checking it is bound to ctx. Values in an
+// older format fail with ErrUnboundCiphertext.
+func openSecretValue(dek []byte, ctx ValueContext, ciphertext string) (string, error) {
+	if !strings.HasPrefix(ciphertext, boundValuePrefix) {
+		return "", ErrUnboundCiphertext
+	}
+	plaintext, err := DecryptWithKey(dek, strings.TrimPrefix(ciphertext, boundValuePrefix), ctx.aad())
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// openLegacySecretValue decrypts a value written before the current binding: bound to ctx
+// without its environment, or not bound at all. Only BindSecretCiphertexts reads these.
+func openLegacySecretValue(dek []byte, ctx ValueContext, ciphertext string) (string, error) {
+	var (
+		plaintext []byte
+		err       error
+	)
+	if strings.HasPrefix(ciphertext, legacyBoundValuePrefix) {
+		plaintext, err = DecryptWithKey(dek, strings.TrimPrefix(ciphertext, legacyBoundValuePrefix), ctx.legacyAAD())
+	} else {
+		plaintext, err = DecryptWithKey(dek, ciphertext, nil)
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// BindSecretCiphertexts re-encrypts every secret value and version not yet in the current
+// format so it is authenticated against its full context. Each project is migrated in its own
+// transaction; rows already bound are skipped, so the migration can be re-run safely.
+func BindSecretCiphertexts(db *gorm.DB) error {
+	var projectIDs []uint
+	err := db.Unscoped().Model(&models.Secret{}).
+		Distinct("project_id").
+		Where("value NOT LIKE ? OR id IN (?)", boundValuePrefix+"%",
+			db.Model(&models.SecretVersion{}).Select("secret_id").Where("value NOT LIKE ?", boundValuePrefix+"%")).
+		Pluck("project_id", &projectIDs).Error
+	if err != nil {
+		return err
+	}
+
+	for _, projectID := range projectIDs {
+		if err := bindProjectCiphertexts(db, projectID); err != nil {
+			return fmt.Errorf("project %d: %w", projectID, err)
+		}
+	}
+
+	return nil
+}
+
+func bindProjectCiphertexts(db *gorm.DB, projectID uint) error {
+	dek, err := ProjectDataKey(db, projectID)
+	if err != nil {
+		if err == ErrProjectKeyShredded {
+			return nil // Nothing left to protect
+		}
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var secrets []models.Secret
+		if err := tx.Unscoped().Where("project_id = ?", projectID).Find(&secrets).Error; err != nil {
+			return err
+		}
+
+		for i := range secrets {
+			secret := &secrets[i]
+
+			var versions []models.SecretVersion
+			if err := tx.Where("secret_id = ?", secret.ID).Find(&versions).Error; err != nil {
+				return err
+			}
+			for j := range versions {
+				v := &versions[j]
+				if v.Key == "" {
+					v.Key = secret.Key // Versions written before key names were recorded
+				}
+				if strings.HasPrefix(v.Value, boundValuePrefix) {
+					continue
+				}
+				bound, err := rebind(dek, VersionContext(secret, v), v.Value)
+				if err != nil {
+					return err
+				}
+				if err := tx.Model(v).Updates(map[string]interface{}{"key": v.Key, "value": bound}).Error; err != nil {
+					return err
+				}
+			}
+
+			if strings.HasPrefix(secret.Value, boundValuePrefix) {
+				continue
+			}
+			bound, err := rebind(dek, CurrentContext(secret), secret.Value)
+			if err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Model(secret).Update("value", bound).Error; err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// rebind decrypts a value in an older format and seals it again bound to ctx
+func rebind(dek []byte, ctx ValueContext, ciphertext string) (string, error) {
+	plaintext, err := openLegacySecretValue(dek, ctx, ciphertext)
+	if err != nil {
+		return "", err
+	}
+	return sealSecretValue(dek, ctx, plaintext)
+}
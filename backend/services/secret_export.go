@@ -0,0 +1,214 @@
+package services
+
+import (
+	"bytes"
+	"ciphersafe/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Export formats
+const (
+	ExportFormatDotenv     = "dotenv"
+	ExportFormatJSON       = "json"
+	ExportFormatYAML       = "yaml"
+	ExportFormatShell      = "shell"      // export KEY='value' lines for eval or source
+	ExportFormatKubernetes = "kubernetes" // A v1 Secret manifest
+	ExportFormatDocker     = "docker"     // A file for docker run --env-file
+)
+
+var (
+	ErrExportFormat = errors.New("format must be dotenv, json, yaml, shell, kubernetes or docker")
+	// ErrExportUnsupported is wrapped with the key or name a format cannot represent
+	ErrExportUnsupported = errors.New("cannot export in this format")
+)
+
+var (
+	shellNamePattern      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	kubernetesKeyPattern  = regexp.MustCompile(`^[-._a-zA-Z0-9]+$`)
+	kubernetesNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+	dotenvPlainPattern    = regexp.MustCompile(`^[A-Za-z0-9_./:@%+,-]*$`)
+)
+
+// ExportOptions name the Kubernetes Secret of a kubernetes export
+type ExportOptions struct {
+	Name      string
+	Namespace string // Omitted from the manifest when empty
+}
+
+// kubernetesSecret is the manifest written by a kubernetes export
+type kubernetesSecret struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   kubernetesMeta    `yaml:"metadata"`
+	Type       string            `yaml:"type"`
+	Data       map[string]string `yaml:"data"`
+}
+
+type kubernetesMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// EnvironmentEntries decrypts the current values of an environment's secrets, sorted by key
+func (s *SecretService) EnvironmentEntries(environment *models.Environment) ([]SecretEntry, error) {
+	values, err := environmentValues(s.DB, environment)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]SecretEntry, 0, len(values))
+	for key, value := range values {
+		entries = append(entries, SecretEntry{Key: key, Value: value.Value})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// ExportSecrets renders entries in one of the export formats. Formats that cannot represent
+// a key or value, such as multiline values in a Docker env file, return an error wrapping
+// ErrExportUnsupported rather than leave it out.
+func ExportSecrets(format string, entries []SecretEntry, options ExportOptions) ([]byte, error) {
+	switch format {
+	case ExportFormatDotenv:
+		return exportDotenv(entries)
+	case ExportFormatJSON:
+		return exportJSON(entries)
+	case ExportFormatYAML:
+		return exportYAML(entries)
+	case ExportFormatShell:
+		return exportShell(entries)
+	case ExportFormatKubernetes:
+		return exportKubernetes(entries, options)
+	case ExportFormatDocker:
+		return exportDocker(entries)
+	}
+	return nil, ErrExportFormat
+}
+
+// KubernetesName turns a name into a valid Kubernetes object name, e.g. "My API" into "my-api"
+func KubernetesName(name string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') || r == '-' || r == '.' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('-')
+		}
+	}
+	result := strings.Trim(b.String(), "-.")
+	if len(result) > 253 {
+		result = strings.TrimRight(result[:253], "-.")
+	}
+	return result
+}
+
+// exportDotenv writes KEY=VALUE lines that parseDotenv reads back unchanged. Values are left
+// bare when safe, single-quoted (literal) when possible, and double-quoted with escapes otherwise.
+func exportDotenv(entries []SecretEntry) ([]byte, error) {
+	var b bytes.Buffer
+	for _, entry := range entries {
+		if !dotenvKeyPattern.MatchString(entry.Key) {
+			return nil, fmt.Errorf("%w: %q is not a valid dotenv key", ErrExportUnsupported, entry.Key)
+		}
+		b.WriteString(entry.Key)
+		b.WriteByte('=')
+		switch {
+		case dotenvPlainPattern.MatchString(entry.Value):
+			b.WriteString(entry.Value)
+		case !strings.ContainsAny(entry.Value, "'\r"):
+			// Parsers turn CRLF line endings into LF, so carriage returns need the escaped form
+			b.WriteString("'" + entry.Value + "'")
+		default:
+			b.WriteString(`"` + dotenvEscaper.Replace(entry.Value) + `"`)
+		}
+		b.WriteByte('\n')
+	}
+	return b.Bytes(), nil
+}
+
+var dotenvEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+func exportJSON(entries []SecretEntry) ([]byte, error) {
+	document := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		document[entry.Key] = entry.Value
+	}
+	out, err := json.MarshalIndent(document, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+func exportYAML(entries []SecretEntry) ([]byte, error) {
+	mapping := &yaml.Node{Kind: yaml.MappingNode}
+	for _, entry := range entries {
+		mapping.Content = append(mapping.Content,
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: entry.Key},
+			&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: entry.Value})
+	}
+	if len(entries) == 0 {
+		mapping.Style = yaml.FlowStyle
+	}
+	return yaml.Marshal(mapping)
+}
+
+// exportShell writes export statements safe to eval: values are single-quoted, and each
+// single quote inside a value closes the quoting, adds an escaped quote and reopens it
+func exportShell(entries []SecretEntry) ([]byte, error) {
+	var b bytes.Buffer
+	for _, entry := range entries {
+		if !shellNamePattern.MatchString(entry.Key) {
+			return nil, fmt.Errorf("%w: %q is not a valid shell variable name", ErrExportUnsupported, entry.Key)
+		}
+		fmt.Fprintf(&b, "export %s='%s'\n", entry.Key, strings.ReplaceAll(entry.Value, "'", `'\''`))
+	}
+	return b.Bytes(), nil
+}
+
+func exportKubernetes(entries []SecretEntry, options ExportOptions) ([]byte, error) {
+	if !kubernetesNamePattern.MatchString(options.Name) {
+		return nil, fmt.Errorf("%w: %q is not a valid Kubernetes Secret name", ErrExportUnsupported, options.Name)
+	}
+	if options.Namespace != "" && !kubernetesNamePattern.MatchString(options.Namespace) {
+		return nil, fmt.Errorf("%w: %q is not a valid Kubernetes namespace", ErrExportUnsupported, options.Namespace)
+	}
+
+	secret := kubernetesSecret{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   kubernetesMeta{Name: options.Name, Namespace: options.Namespace},
+		Type:       "Opaque",
+		Data:       make(map[string]string, len(entries)),
+	}
+	for _, entry := range entries {
+		if !kubernetesKeyPattern.MatchString(entry.Key) {
+			return nil, fmt.Errorf("%w: %q is not a valid Kubernetes Secret key", ErrExportUnsupported, entry.Key)
+		}
+		secret.Data[entry.Key] = base64.StdEncoding.EncodeToString([]byte(entry.Value))
+	}
+	return yaml.Marshal(secret)
+}
+
+// exportDocker writes KEY=VALUE lines. Docker takes the rest of the line literally, so
+// values need no quoting but cannot span lines.
+func exportDocker(entries []SecretEntry) ([]byte, error) {
+	var b bytes.Buffer
+	for _, entry := range entries {
+		if entry.Key == "" || strings.ContainsAny(entry.Key, "= \t\n") || strings.HasPrefix(entry.Key, "#") {
+			return nil, fmt.Errorf("%w: %q is not a valid Docker env file key", ErrExportUnsupported, entry.Key)
+		}
+		if strings.ContainsAny(entry.Value, "\r\n") {
+			return nil, fmt.Errorf("%w: %q has a multiline value, which Docker env files cannot hold", ErrExportUnsupported, entry.Key)
+		}
+		b.WriteString(entry.Key + "=" + entry.Value + "\n")
+	}
+	return b.Bytes(), nil
+}
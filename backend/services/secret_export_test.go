@@ -0,0 +1,185 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+var exportEntries = []SecretEntry{
+	{"API_KEY", "abc123"},
+	{"DB_URL", "postgres://user:p@ss@db:5432/app?sslmode=require"},
+	{"EMPTY", ""},
+	{"GREETING", "it's \"quoted\" $HOME `date`"},
+	{"PEM", "-----BEGIN KEY-----\nMIIB\n-----END KEY-----"},
+	{"SPACED", "  padded value # not a comment"},
+}
+
+func TestExportDotenvRoundTrip(t *testing.T) {
+	// Carriage returns must survive the parser turning CRLF line endings into LF
+	want := append(exportEntries[:len(exportEntries):len(exportEntries)],
+		SecretEntry{"WINDOWS", "first line\r\nsecond line\r"})
+	out, err := ExportSecrets(ExportFormatDotenv, want, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	entries, err := ParseImport(ImportFormatDotenv, string(out))
+	if err != nil {
+		t.Fatalf("Parsing the export failed: %v\n%s", err, out)
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Errorf("Round trip changed the entries:\n got %q\nwant %q", entries, want)
+	}
+	if !strings.Contains(string(out), "API_KEY=abc123\n") {
+		t.Errorf("Expected plain values to be left bare, got:\n%s", out)
+	}
+}
+
+func TestExportJSONAndYAMLRoundTrip(t *testing.T) {
+	for _, format := range []string{ExportFormatJSON, ExportFormatYAML} {
+		out, err := ExportSecrets(format, exportEntries, ExportOptions{})
+		if err != nil {
+			t.Fatalf("%s export failed: %v", format, err)
+		}
+		entries, err := ParseImport(format, string(out))
+		if err != nil {
+			t.Fatalf("Parsing the %s export failed: %v\n%s", format, err, out)
+		}
+		if !reflect.DeepEqual(entries, exportEntries) {
+			t.Errorf("%s round trip changed the entries:\n got %q\nwant %q", format, entries, exportEntries)
+		}
+	}
+
+	out, err := ExportSecrets(ExportFormatYAML, []SecretEntry{{"PORT", "5432"}, {"DEBUG", "true"}}, ExportOptions{})
+	if err != nil {
+		t.Fatalf("YAML export failed: %v", err)
+	}
+	var document map[string]interface{}
+	if err := yaml.Unmarshal(out, &document); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if document["PORT"] != "5432" || document["DEBUG"] != "true" {
+		t.Errorf("Expected numbers and booleans to stay strings, got %#v", document)
+	}
+}
+
+func TestExportShell(t *testing.T) {
+	out, err := ExportSecrets(ExportFormatShell, exportEntries, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if !strings.Contains(string(out), `export GREETING='it'\''s "quoted" $HOME `+"`date`'\n") {
+		t.Errorf("Expected single quotes to be escaped, got:\n%s", out)
+	}
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not available")
+	}
+	for _, entry := range exportEntries {
+		script := string(out) + `printf '%s' "$` + entry.Key + `"`
+		got, err := exec.Command(sh, "-c", script).Output()
+		if err != nil {
+			t.Fatalf("Running the export failed: %v", err)
+		}
+		if string(got) != entry.Value {
+			t.Errorf("Expected the shell to read %s as %q, got %q", entry.Key, entry.Value, got)
+		}
+	}
+
+	if _, err := ExportSecrets(ExportFormatShell, []SecretEntry{{"app.name", "x"}}, ExportOptions{}); !errors.Is(err, ErrExportUnsupported) {
+		t.Errorf("Expected ErrExportUnsupported for a key that is not a shell name, got %v", err)
+	}
+}
+
+func TestExportKubernetes(t *testing.T) {
+	out, err := ExportSecrets(ExportFormatKubernetes, exportEntries, ExportOptions{Name: "billing-production", Namespace: "billing"})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	var manifest kubernetesSecret
+	if err := yaml.Unmarshal(out, &manifest); err != nil {
+		t.Fatalf("Unmarshal failed: %v\n%s", err, out)
+	}
+	if manifest.APIVersion != "v1" || manifest.Kind != "Secret" || manifest.Type != "Opaque" {
+		t.Errorf("Unexpected manifest header: %+v", manifest)
+	}
+	if manifest.Metadata.Name != "billing-production" || manifest.Metadata.Namespace != "billing" {
+		t.Errorf("Unexpected metadata: %+v", manifest.Metadata)
+	}
+	for _, entry := range exportEntries {
+		decoded, err := base64.StdEncoding.DecodeString(manifest.Data[entry.Key])
+		if err != nil || string(decoded) != entry.Value {
+			t.Errorf("Expected %s to decode to %q, got %q (%v)", entry.Key, entry.Value, decoded, err)
+		}
+	}
+
+	out, err = ExportSecrets(ExportFormatKubernetes, nil, ExportOptions{Name: "empty"})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if strings.Contains(string(out), "namespace") {
+		t.Errorf("Expected no namespace when none is given, got:\n%s", out)
+	}
+
+	tests := []struct {
+		name    string
+		entries []SecretEntry
+		options ExportOptions
+	}{
+		{"uppercase name", nil, ExportOptions{Name: "Billing"}},
+		{"empty name", nil, ExportOptions{}},
+		{"invalid namespace", nil, ExportOptions{Name: "billing", Namespace: "team_a"}},
+		{"invalid key", []SecretEntry{{"my key", "x"}}, ExportOptions{Name: "billing"}},
+	}
+	for _, tt := range tests {
+		if _, err := ExportSecrets(ExportFormatKubernetes, tt.entries, tt.options); !errors.Is(err, ErrExportUnsupported) {
+			t.Errorf("%s: expected ErrExportUnsupported, got %v", tt.name, err)
+		}
+	}
+}
+
+func TestExportDocker(t *testing.T) {
+	entries := []SecretEntry{{"API_KEY", "abc123"}, {"QUOTED", `"kept as is" # too`}}
+	out, err := ExportSecrets(ExportFormatDocker, entries, ExportOptions{})
+	if err != nil {
+		t.Fatalf("Export failed: %v", err)
+	}
+	if want := "API_KEY=abc123\nQUOTED=\"kept as is\" # too\n"; string(out) != want {
+		t.Errorf("Expected %q, got %q", want, out)
+	}
+
+	_, err = ExportSecrets(ExportFormatDocker, []SecretEntry{{"PEM", "line one\nline two"}}, ExportOptions{})
+	if !errors.Is(err, ErrExportUnsupported) || !strings.Contains(err.Error(), "PEM") {
+		t.Errorf("Expected ErrExportUnsupported naming the multiline key, got %v", err)
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	if _, err := ExportSecrets("toml", exportEntries, ExportOptions{}); !errors.Is(err, ErrExportFormat) {
+		t.Errorf("Expected ErrExportFormat, got %v", err)
+	}
+}
+
+func TestKubernetesName(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"billing-production", "billing-production"},
+		{"My API-staging", "my-api-staging"},
+		{"_internal.tools_", "internal.tools"},
+		{"Café App", "caf--app"},
+		{strings.Repeat("a", 300), strings.Repeat("a", 253)},
+	}
+	for _, tt := range tests {
+		if got := KubernetesName(tt.input); got != tt.want {
+			t.Errorf("KubernetesName(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
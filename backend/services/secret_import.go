@@ -0,0 +1,351 @@
+package services
+
+import (
+	"bytes"
+	"ciphersafe/models"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Import formats
+const (
+	ImportFormatDotenv = "dotenv"
+	ImportFormatJSON   = "json"
+	ImportFormatYAML   = "yaml"
+)
+
+// Import modes, deciding what happens to keys that already hold a different value
+const (
+	ImportFailOnConflict = "fail"      // Abort the whole import
+	ImportSkipExisting   = "skip"      // Keep the existing value
+	ImportOverwrite      = "overwrite" // Write the imported value as a new version
+)
+
+// Outcomes of importing a key
+const (
+	ImportCreated   = "created"
+	ImportUpdated   = "updated"
+	ImportUnchanged = "unchanged" // Already held the imported value
+	ImportSkipped   = "skipped"
+)
+
+// ImportMaxEntries bounds the number of keys in one import
+const ImportMaxEntries = 1000
+
+var (
+	ErrImportFormat = errors.New("format must be dotenv, json or yaml")
+	ErrImportMode   = errors.New("mode must be fail, skip or overwrite")
+	// ErrImportInvalid is wrapped with the position and cause of a parse error
+	ErrImportInvalid  = errors.New("invalid import file")
+	ErrImportTooLarge = fmt.Errorf("an import can hold at most %d keys", ImportMaxEntries)
+	// ErrImportConflict is wrapped with the keys that already hold different values
+	ErrImportConflict = errors.New("keys already exist with different values")
+)
+
+var dotenvKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.-]*$`)
+
+// SecretEntry is a key and its plaintext value, as read from an import or written to an export
+type SecretEntry struct {
+	Key   string
+	Value string
+}
+
+// ImportedSecret is what an import does, or would do in a dry run, to one key
+type ImportedSecret struct {
+	Key      string `json:"key"`
+	Result   string `json:"result"`
+	SecretID uint   `json:"secret_id,omitempty"` // Unset for keys a dry run would create
+	Version  int    `json:"version,omitempty"`
+}
+
+// ParseImport reads the keys and values of a dotenv, flat JSON or YAML document. Keys
+// repeated in the document keep their last value.
+func ParseImport(format, content string) ([]SecretEntry, error) {
+	var (
+		entries []SecretEntry
+		err     error
+	)
+	switch format {
+	case ImportFormatDotenv:
+		entries, err = parseDotenv(content)
+	case ImportFormatJSON:
+		entries, err = parseJSONImport(content)
+	case ImportFormatYAML:
+		entries, err = parseYAMLImport(content)
+	default:
+		return nil, ErrImportFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entries = dedupeImportEntries(entries)
+	if len(entries) > ImportMaxEntries {
+		return nil, ErrImportTooLarge
+	}
+	return entries, nil
+}
+
+// Import writes entries into an environment in one transaction. With dryRun it only reports
+// what would happen. In ImportFailOnConflict mode, keys holding different values abort the
+// import before anything is written.
+func (s *SecretService) Import(environment *models.Environment, entries []SecretEntry, mode string, dryRun bool, author Principal) ([]ImportedSecret, error) {
+	if mode != ImportFailOnConflict && mode != ImportSkipExisting && mode != ImportOverwrite {
+		return nil, ErrImportMode
+	}
+
+	var results []ImportedSecret
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("environment_id = ?", environment.ID).Find(&[]models.Secret{}).Error; err != nil {
+			return err
+		}
+		existing, err := environmentValues(tx, environment)
+		if err != nil {
+			return err
+		}
+
+		results, err = planImport(entries, existing, mode)
+		if err != nil {
+			return err
+		}
+		created := 0
+		for _, result := range results {
+			if result.Result == ImportCreated {
+				created++
+			}
+		}
+		if err := CheckSecretQuota(tx, environment.ProjectID, created); err != nil {
+			return err
+		}
+		if dryRun {
+			return nil
+		}
+
+		for i, entry := range entries {
+			switch results[i].Result {
+			case ImportCreated:
+				secret, err := s.createSecret(tx, environment, entry.Key, entry.Value, author)
+				if err != nil {
+					return err
+				}
+				results[i].SecretID, results[i].Version = secret.ID, secret.CurrentVersion
+			case ImportUpdated:
+				v, err := s.writeVersion(tx, existing[entry.Key].Secret, entry.Value, author, nil)
+				if err != nil {
+					return err
+				}
+				results[i].Version = v.Version
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// planImport decides the outcome of each entry against the existing values of the environment
+func planImport(entries []SecretEntry, existing map[string]*environmentValue, mode string) ([]ImportedSecret, error) {
+	results := make([]ImportedSecret, 0, len(entries))
+	var conflicts []string
+	for _, entry := range entries {
+		current, ok := existing[entry.Key]
+		if !ok {
+			results = append(results, ImportedSecret{Key: entry.Key, Result: ImportCreated})
+			continue
+		}
+
+		result := ImportedSecret{Key: entry.Key, SecretID: current.Secret.ID, Version: current.Secret.CurrentVersion}
+		switch {
+		case current.Digest == sha256.Sum256([]byte(entry.Value)):
+			result.Result = ImportUnchanged
+		case mode == ImportOverwrite:
+			result.Result = ImportUpdated
+		case mode == ImportSkipExisting:
+			result.Result = ImportSkipped
+		default:
+			conflicts = append(conflicts, entry.Key)
+		}
+		results = append(results, result)
+	}
+
+	if len(conflicts) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrImportConflict, strings.Join(conflicts, ", "))
+	}
+	return results, nil
+}
+
+// dedupeImportEntries keeps the last value of repeated keys, at the position of their first
+func dedupeImportEntries(entries []SecretEntry) []SecretEntry {
+	index := make(map[string]int, len(entries))
+	deduped := make([]SecretEntry, 0, len(entries))
+	for _, entry := range entries {
+		if i, ok := index[entry.Key]; ok {
+			deduped[i].Value = entry.Value
+			continue
+		}
+		index[entry.Key] = len(deduped)
+		deduped = append(deduped, entry)
+	}
+	return deduped
+}
+
+// parseDotenv reads KEY=VALUE lines. Lines may start with "export"; values may be single
+// quoted (literal) or double quoted (with \n, \r, \t, \" and \\ escapes), and quoted values
+// may span several lines. Blank lines and # comments are ignored.
+func parseDotenv(content string) ([]SecretEntry, error) {
+	var entries []SecretEntry
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	for i := 0; i < len(lines); i++ {
+		lineNumber := i + 1
+		line := strings.TrimLeft(lines[i], " \t")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if rest := strings.TrimPrefix(line, "export"); rest != line && (strings.HasPrefix(rest, " ") || strings.HasPrefix(rest, "\t")) {
+			line = strings.TrimLeft(rest, " \t")
+		}
+
+		eq := strings.IndexByte(line, '=')
+		if eq < 0 {
+			return nil, fmt.Errorf("%w: line %d: expected KEY=VALUE", ErrImportInvalid, lineNumber)
+		}
+		key := strings.TrimSpace(line[:eq])
+		if !dotenvKeyPattern.MatchString(key) {
+			return nil, fmt.Errorf("%w: line %d: invalid key %q", ErrImportInvalid, lineNumber, key)
+		}
+
+		value := strings.TrimLeft(line[eq+1:], " \t")
+		if value == "" || (value[0] != '"' && value[0] != '\'') {
+			// Unquoted: a # after whitespace starts a comment
+			if comment := strings.Index(value, " #"); comment >= 0 {
+				value = value[:comment]
+			}
+			if comment := strings.Index(value, "\t#"); comment >= 0 {
+				value = value[:comment]
+			}
+			entries = append(entries, SecretEntry{Key: key, Value: strings.TrimSpace(value)})
+			continue
+		}
+
+		quote, body := value[0], value[1:]
+		end := closingQuote(body, quote)
+		for end < 0 {
+			if i++; i >= len(lines) {
+				return nil, fmt.Errorf("%w: line %d: unterminated quoted value", ErrImportInvalid, lineNumber)
+			}
+			body += "\n" + lines[i]
+			end = closingQuote(body, quote)
+		}
+		if trailing := strings.TrimSpace(body[end+1:]); trailing != "" && !strings.HasPrefix(trailing, "#") {
+			return nil, fmt.Errorf("%w: line %d: unexpected text after the closing quote", ErrImportInvalid, i+1)
+		}
+
+		value = body[:end]
+		if quote == '"' {
+			value = unescapeDotenv(value)
+		}
+		entries = append(entries, SecretEntry{Key: key, Value: value})
+	}
+	return entries, nil
+}
+
+// closingQuote returns the index of the quote ending a value, skipping escaped double quotes
+func closingQuote(body string, quote byte) int {
+	for i := 0; i < len(body); i++ {
+		if quote == '"' && body[i] == '\\' {
+			i++
+			continue
+		}
+		if body[i] == quote {
+			return i
+		}
+	}
+	return -1
+}
+
+var dotenvEscapes = strings.NewReplacer(`\n`, "\n", `\r`, "\r", `\t`, "\t", `\"`, `"`, `\\`, `\`)
+
+func unescapeDotenv(value string) string {
+	return dotenvEscapes.Replace(value)
+}
+
+// parseJSONImport reads a flat JSON object whose values are strings, numbers or booleans
+func parseJSONImport(content string) ([]SecretEntry, error) {
+	decoder := json.NewDecoder(strings.NewReader(content))
+	decoder.UseNumber()
+	var document map[string]interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImportInvalid, err)
+	}
+	if document == nil {
+		return nil, fmt.Errorf("%w: expected a JSON object", ErrImportInvalid)
+	}
+
+	keys := make([]string, 0, len(document))
+	for key := range document {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	entries := make([]SecretEntry, 0, len(keys))
+	for _, key := range keys {
+		if key == "" {
+			return nil, fmt.Errorf("%w: empty key", ErrImportInvalid)
+		}
+		var value string
+		switch v := document[key].(type) {
+		case string:
+			value = v
+		case json.Number:
+			value = v.String()
+		case bool:
+			value = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("%w: %q must be a string, number or boolean", ErrImportInvalid, key)
+		}
+		entries = append(entries, SecretEntry{Key: key, Value: value})
+	}
+	return entries, nil
+}
+
+// parseYAMLImport reads a YAML mapping of scalar values, keeping the document's order
+func parseYAMLImport(content string) ([]SecretEntry, error) {
+	var document yaml.Node
+	decoder := yaml.NewDecoder(bytes.NewReader([]byte(content)))
+	if err := decoder.Decode(&document); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImportInvalid, err)
+	}
+	if len(document.Content) != 1 || document.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%w: expected a YAML mapping", ErrImportInvalid)
+	}
+
+	mapping := document.Content[0]
+	entries := make([]SecretEntry, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		keyNode, valueNode := mapping.Content[i], mapping.Content[i+1]
+		if valueNode.Kind == yaml.AliasNode {
+			valueNode = valueNode.Alias
+		}
+		if keyNode.Kind != yaml.ScalarNode || keyNode.Value == "" {
+			return nil, fmt.Errorf("%w: line %d: keys must be non-empty strings", ErrImportInvalid, keyNode.Line)
+		}
+		if valueNode.Kind != yaml.ScalarNode || valueNode.Tag == "!!null" {
+			return nil, fmt.Errorf("%w: line %d: %q must be a string, number or boolean", ErrImportInvalid, keyNode.Line, keyNode.Value)
+		}
+		entries = append(entries, SecretEntry{Key: keyNode.Value, Value: valueNode.Value})
+	}
+	return entries, nil
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"crypto/sha256"
+	"errors"
+	"reflect"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestParseDotenv(t *testing.T) {
+	content := "# Database\r\n" +
+		"export DB_HOST=localhost\r\n" +
+		"DB_PORT = 5432 # default port\n" +
+		"EMPTY=\n" +
+		"HASH=abc#def\n" +
+		`GREETING="Hello\n\"World\"" # quoted` + "\n" +
+		"LITERAL='no $expansion or \\n escapes'\n" +
+		"CERT=\"-----BEGIN CERT-----\n" +
+		"MIIB\n" +
+		"-----END CERT-----\"\n" +
+		"  \n" +
+		"DB_HOST=db.internal\n"
+
+	entries, err := ParseImport(ImportFormatDotenv, content)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := []SecretEntry{
+		{"DB_HOST", "db.internal"},
+		{"DB_PORT", "5432"},
+		{"EMPTY", ""},
+		{"HASH", "abc#def"},
+		{"GREETING", "Hello\n\"World\""},
+		{"LITERAL", `no $expansion or \n escapes`},
+		{"CERT", "-----BEGIN CERT-----\nMIIB\n-----END CERT-----"},
+	}
+	if !reflect.DeepEqual(entries, want) {
+		t.Fatalf("Got %q, want %q", entries, want)
+	}
+
+	for _, invalid := range []string{"NO_EQUALS", "1KEY=value", "KEY=\"unterminated\nstill open", "KEY=\"quoted\" trailing"} {
+		if _, err := ParseImport(ImportFormatDotenv, invalid); !errors.Is(err, ErrImportInvalid) {
+			t.Errorf("Expected %q to be rejected, got %v", invalid, err)
+		}
+	}
+}
+
+func TestParseJSONAndYAMLImports(t *testing.T) {
+	want := []SecretEntry{{"API_KEY", "abc"}, {"DEBUG", "true"}, {"PORT", "8080"}, {"RATIO", "0.25"}}
+
+	entries, err := ParseImport(ImportFormatJSON, `{"PORT": 8080, "API_KEY": "abc", "DEBUG": true, "RATIO": 0.25}`)
+	if err != nil || !reflect.DeepEqual(entries, want) {
+		t.Fatalf("JSON: got %q, %v, want %q", entries, err, want)
+	}
+
+	entries, err = ParseImport(ImportFormatYAML, "API_KEY: abc\nDEBUG: true\nPORT: 8080\nRATIO: 0.25\n")
+	if err != nil || !reflect.DeepEqual(entries, want) {
+		t.Fatalf("YAML: got %q, %v, want %q", entries, err, want)
+	}
+
+	invalid := []struct{ format, content string }{
+		{ImportFormatJSON, `{"NESTED": {"a": 1}}`},
+		{ImportFormatJSON, `["a", "b"]`},
+		{ImportFormatJSON, `{"NULL": null}`},
+		{ImportFormatYAML, "LIST:\n  - a\n"},
+		{ImportFormatYAML, "EMPTY:\n"},
+		{ImportFormatYAML, "- a\n- b\n"},
+	}
+	for _, tt := range invalid {
+		if _, err := ParseImport(tt.format, tt.content); !errors.Is(err, ErrImportInvalid) {
+			t.Errorf("Expected %s %q to be rejected, got %v", tt.format, tt.content, err)
+		}
+	}
+	if _, err := ParseImport("toml", "A = 1"); !errors.Is(err, ErrImportFormat) {
+		t.Errorf("Expected an unknown format to be rejected, got %v", err)
+	}
+}
+
+func TestPlanImport(t *testing.T) {
+	existing := map[string]*environmentValue{
+		"SAME":    {Secret: &models.Secret{Model: gorm.Model{ID: 1}, Key: "SAME", CurrentVersion: 2}, Digest: sha256.Sum256([]byte("one"))},
+		"CHANGED": {Secret: &models.Secret{Model: gorm.Model{ID: 2}, Key: "CHANGED", CurrentVersion: 5}, Digest: sha256.Sum256([]byte("old"))},
+	}
+	entries := []SecretEntry{{"NEW", "x"}, {"SAME", "one"}, {"CHANGED", "new"}}
+
+	results := func(mode string) []string {
+		plan, err := planImport(entries, existing, mode)
+		if err != nil {
+			t.Fatalf("Mode %s: %v", mode, err)
+		}
+		outcomes := []string{}
+		for _, result := range plan {
+			outcomes = append(outcomes, result.Result)
+		}
+		return outcomes
+	}
+
+	if got, want := results(ImportOverwrite), []string{ImportCreated, ImportUnchanged, ImportUpdated}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Overwrite: got %v, want %v", got, want)
+	}
+	if got, want := results(ImportSkipExisting), []string{ImportCreated, ImportUnchanged, ImportSkipped}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Skip: got %v, want %v", got, want)
+	}
+	if _, err := planImport(entries, existing, ImportFailOnConflict); !errors.Is(err, ErrImportConflict) {
+		t.Errorf("Expected the changed key to fail the import, got %v", err)
+	}
+	if _, err := planImport(entries[:2], existing, ImportFailOnConflict); err != nil {
+		t.Errorf("Expected matching values not to conflict, got %v", err)
+	}
+}
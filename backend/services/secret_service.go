@@ -0,0 +1,394 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrVersionAlreadyCurrent is returned when rolling back to the version that is already current
+	ErrVersionAlreadyCurrent = errors.New("version is already current")
+	// ErrVersionConflict is returned when a secret changed since the version the caller last read
+	ErrVersionConflict = errors.New("secret was modified by someone else")
+	// ErrSecretKeyExists is returned when an environment already has a secret with the key
+	ErrSecretKeyExists = errors.New("a secret with this key already exists in the environment")
+)
+
+// SecretService handles secret writes and version history
+type SecretService struct {
+	DB *gorm.DB
+}
+
+// NewSecretService creates a new SecretService
+func NewSecretService(db *gorm.DB) *SecretService {
+	return &SecretService{DB: db}
+}
+
+// CreateSecret saves a new secret in an environment together with its first version
+func (s *SecretService) CreateSecret(environment *models.Environment, key, value string, author Principal) (*models.Secret, error) {
+	var secret *models.Secret
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		secret, err = s.createSecret(tx, environment, key, value, author)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+// createSecret checks the quota and writes a new secret with its first version.
+// It must be called inside a transaction.
+func (s *SecretService) createSecret(tx *gorm.DB, environment *models.Environment, key, value string, author Principal) (*models.Secret, error) {
+	if err := CheckSecretQuota(tx, environment.ProjectID, 1); err != nil {
+		return nil, err
+	}
+	if err := ensureSecretKeyUnique(tx, environment.ID, 0, key); err != nil {
+		return nil, err
+	}
+	secret := &models.Secret{
+		ProjectID:     environment.ProjectID,
+		EnvironmentID: environment.ID,
+		Key:           key,
+	}
+	if err := tx.Create(secret).Error; err != nil {
+		return nil, secretKeyError(err)
+	}
+	if _, err := s.writeVersion(tx, secret, value, author, nil); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// CurrentVersion returns the current version number of a secret
+func (s *SecretService) CurrentVersion(secretID uint) (int, error) {
+	var secret models.Secret
+	if err := s.DB.Select("current_version").First(&secret, secretID).Error; err != nil {
+		return 0, err
+	}
+	return secret.CurrentVersion, nil
+}
+
+// ListVersions returns the version history of a secret, newest first
+func (s *SecretService) ListVersions(secretID uint) ([]models.SecretVersion, error) {
+	var versions []models.SecretVersion
+	result := s.DB.Where("secret_id = ?", secretID).Order("version desc").Find(&versions)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return versions, nil
+}
+
+// GetVersion finds a specific version of a secret
+func (s *SecretService) GetVersion(secretID uint, version int) (*models.SecretVersion, error) {
+	var v models.SecretVersion
+	result := s.DB.Where("secret_id = ? AND version = ?", secretID, version).First(&v)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &v, nil
+}
+
+// SecretVersionDiff reports how the key and value of a secret changed between two versions
+type SecretVersionDiff struct {
+	SecretID     uint   `json:"secret_id"`
+	From         int    `json:"from"`
+	To           int    `json:"to"`
+	FromKey      string `json:"from_key"`
+	ToKey        string `json:"to_key"`
+	KeyChanged   bool   `json:"key_changed"`
+	FromValue    string `json:"from_value"`
+	ToValue      string `json:"to_value"`
+	ValueChanged bool   `json:"value_changed"`
+}
+
+// DiffVersions decrypts two versions of a secret and compares their keys and values
+func (s *SecretService) DiffVersions(secret *models.Secret, from, to int) (*SecretVersionDiff, error) {
+	fromVersion, err := s.GetVersion(secret.ID, from)
+	if err != nil {
+		return nil, err
+	}
+	toVersion, err := s.GetVersion(secret.ID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	dek, err := s.DataKey(secret.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	fromValue, err := s.DecryptValue(dek, VersionContext(secret, fromVersion), fromVersion.Value)
+	if err != nil {
+		return nil, err
+	}
+	toValue, err := s.DecryptValue(dek, VersionContext(secret, toVersion), toVersion.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretVersionDiff{
+		SecretID:     secret.ID,
+		From:         from,
+		To:           to,
+		FromKey:      fromVersion.Key,
+		ToKey:        toVersion.Key,
+		KeyChanged:   fromVersion.Key != toVersion.Key,
+		FromValue:    fromValue,
+		ToValue:      toValue,
+		ValueChanged: fromValue != toValue,
+	}, nil
+}
+
+// DataKey returns the unwrapped data encryption key of a project
+func (s *SecretService) DataKey(projectID uint) ([]byte, error) {
+	return ProjectDataKey(s.DB, projectID)
+}
+
+// DecryptValue decrypts a secret or version value with its project's data key.
+// ctx must describe where the ciphertext was read from, or authentication fails.
+func (s *SecretService) DecryptValue(dek []byte, ctx ValueContext, ciphertext string) (string, error) {
+	return openSecretValue(dek, ctx, ciphertext)
+}
+
+// Rollback restores the value of an earlier version by writing it as a new version.
+// History is never rewritten, so the rollback itself shows up in the version list.
+func (s *SecretService) Rollback(secretID uint, version int, author Principal) (*models.SecretVersion, error) {
+	var restored *models.SecretVersion
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		secret, err := lockSecret(tx, secretID)
+		if err != nil {
+			return err
+		}
+
+		var target models.SecretVersion
+		if err := tx.Where("secret_id = ? AND version = ?", secretID, version).First(&target).Error; err != nil {
+			return err
+		}
+		if target.Version == secret.CurrentVersion {
+			return ErrVersionAlreadyCurrent
+		}
+
+		dek, err := ProjectDataKey(tx, secret.ProjectID)
+		if err != nil {
+			return err
+		}
+		plaintext, err := s.DecryptValue(dek, VersionContext(secret, &target), target.Value)
+		if err != nil {
+			return err
+		}
+
+		restored, err = s.writeVersion(tx, secret, plaintext, author, &target.Version)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return restored, nil
+}
+
+// UpdateSecret writes a new version with a new value and/or key name.
+// When expectedVersion is set the update only applies if it is still the current version,
+// so concurrent editors cannot silently overwrite each other.
+func (s *SecretService) UpdateSecret(secretID uint, expectedVersion *int, newKey, newValue *string, author Principal) (*models.Secret, error) {
+	var updated *models.Secret
+
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		secret, err := lockSecret(tx, secretID)
+		if err != nil {
+			return err
+		}
+		if expectedVersion != nil && *expectedVersion != secret.CurrentVersion {
+			return ErrVersionConflict
+		}
+
+		var value string
+		if newValue != nil {
+			value = *newValue
+		} else {
+			// Renaming only: carry the current value over, re-encrypted for the new key name
+			dek, err := ProjectDataKey(tx, secret.ProjectID)
+			if err != nil {
+				return err
+			}
+			if value, err = s.DecryptValue(dek, CurrentContext(secret), secret.Value); err != nil {
+				return err
+			}
+		}
+		if newKey != nil && *newKey != secret.Key {
+			if err := ensureSecretKeyUnique(tx, secret.EnvironmentID, secret.ID, *newKey); err != nil {
+				return err
+			}
+			secret.Key = *newKey
+		}
+
+		if _, err := s.writeVersion(tx, secret, value, author, nil); err != nil {
+			return secretKeyError(err)
+		}
+		updated = secret
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return updated, nil
+}
+
+// ensureSecretKeyUnique returns ErrSecretKeyExists if another secret than secretID in the
+// environment has key
+func ensureSecretKeyUnique(tx *gorm.DB, environmentID, secretID uint, key string) error {
+	var count int64
+	err := tx.Model(&models.Secret{}).
+		Where("environment_id = ? AND key = ? AND id <> ?", environmentID, key, secretID).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrSecretKeyExists
+	}
+	return nil
+}
+
+// secretKeyError maps a violation of the unique key index, hit when a concurrent write took
+// the key after ensureSecretKeyUnique, to ErrSecretKeyExists
+func secretKeyError(err error) error {
+	if errors.Is(err, gorm.ErrDuplicatedKey) {
+		return ErrSecretKeyExists
+	}
+	return err
+}
+
+// lockSecret loads a secret row with FOR UPDATE so version numbers are assigned serially
+func lockSecret(tx *gorm.DB, secretID uint) (*models.Secret, error) {
+	var secret models.Secret
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&secret, secretID).Error; err != nil {
+		return nil, err
+	}
+	return &secret, nil
+}
+
+// writeVersion encrypts value under the project data key, appends it as the next version and makes it current.
+// It must be called inside a transaction.
+func (s *SecretService) writeVersion(tx *gorm.DB, secret *models.Secret, value string, author Principal, restoredFrom *int) (*models.SecretVersion, error) {
+	dek, err := ProjectDataKey(tx, secret.ProjectID)
+	if err != nil {
+		return nil, err
+	}
+	v := &models.SecretVersion{
+		SecretID:     secret.ID,
+		Version:      secret.CurrentVersion + 1,
+		Key:          secret.Key,
+		AuthorID:     author.ID,
+		AuthorType:   author.Kind,
+		RestoredFrom: restoredFrom,
+	}
+
+	v.Value, err = sealSecretValue(dek, VersionContext(secret, v), value)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Create(v).Error; err != nil {
+		return nil, err
+	}
+
+	secret.Value = v.Value
+	secret.CurrentVersion = v.Version
+	if err := tx.Model(secret).Updates(map[string]interface{}{
+		"key":             secret.Key,
+		"value":           secret.Value,
+		"current_version": secret.CurrentVersion,
+	}).Error; err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// BackfillSecretVersions creates a first version for secrets written before
+// versioning existed, attributing it to the project owner.
+func BackfillSecretVersions(db *gorm.DB) error {
+	var secrets []models.Secret
+	// Projects deleted since still name the author of their secrets' first version
+	err := db.Preload("Project", func(tx *gorm.DB) *gorm.DB { return tx.Unscoped() }).
+		Where("current_version = 0").Find(&secrets).Error
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range secrets {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			v := &models.SecretVersion{
+				SecretID: secret.ID,
+				Version:  1,
+				Key:      secret.Key,
+				Value:    secret.Value,
+				AuthorID: secret.Project.OwnerID,
+			}
+			if err := tx.Create(v).Error; err != nil {
+				return err
+			}
+			return tx.Model(&secret).Update("current_version", 1).Error
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// InstallSecretKeyIndex makes secret keys unique per environment. Secrets that are not deleted
+// and share a key with a newer one in the same environment, which only older servers could
+// write, are soft-deleted first, keeping the newest as reads already did. Each deletion is
+// recorded as a secret.delete audit event by the system actor.
+func InstallSecretKeyIndex(db *gorm.DB, audit *AuditService) error {
+	var shadowed []models.Secret
+	err := db.Where(`EXISTS (
+			SELECT 1 FROM secrets newer
+			WHERE newer.environment_id = secrets.environment_id AND newer.key = secrets.key
+				AND newer.deleted_at IS NULL AND newer.id > secrets.id
+		)`).Order("id").Find(&shadowed).Error
+	if err != nil {
+		return err
+	}
+
+	for _, secret := range shadowed {
+		// The deletion and its audit event are committed together
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Delete(&secret).Error; err != nil {
+				return err
+			}
+			_, err := NewAuditService(tx, audit.Sinks).Record(AuditEntry{
+				ActorType:    ActorSystem,
+				Action:       "secret.delete",
+				ResourceType: "secret",
+				ResourceID:   strconv.FormatUint(uint64(secret.ID), 10),
+				ProjectID:    &secret.ProjectID,
+				Result:       AuditSuccess,
+				Details: map[string]interface{}{"key": secret.Key, "environment_id": secret.EnvironmentID,
+					"reason": "shadowed by a newer secret with the same key"},
+			})
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("deleting shadowed secret %d: %w", secret.ID, err)
+		}
+	}
+	if len(shadowed) > 0 {
+		log.Printf("Deleted %d secrets shadowed by a newer secret with the same key", len(shadowed))
+	}
+
+	return db.Exec(`CREATE UNIQUE INDEX IF NOT EXISTS idx_secrets_environment_key
+		ON secrets (environment_id, key) WHERE deleted_at IS NULL`).Error
+}
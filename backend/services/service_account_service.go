@@ -0,0 +1,295 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ServiceAccountKeyPrefix marks service account keys so they can be told apart from other credentials
+const ServiceAccountKeyPrefix = "cs_sa_"
+
+var (
+	ErrInvalidServiceAccountKey = errors.New("invalid, expired or revoked service account key")
+	ErrServiceAccountOwner      = errors.New("a service account must belong to exactly one organization or project")
+	ErrServiceAccountScope      = errors.New("service accounts can only be granted access to projects of their own organization or project")
+	ErrServiceAccountRole       = errors.New("service accounts can be granted reader, writer or admin")
+	ErrKeyAlreadyRevoked        = errors.New("key is already revoked")
+)
+
+// ServiceAccountService manages service accounts, their keys and their project grants
+type ServiceAccountService struct {
+	DB *gorm.DB
+}
+
+// NewServiceAccountService creates a new ServiceAccountService
+func NewServiceAccountService(db *gorm.DB) *ServiceAccountService {
+	return &ServiceAccountService{DB: db}
+}
+
+// CreateServiceAccount creates a service account owned by an organization or a project
+func (s *ServiceAccountService) CreateServiceAccount(name, description string, orgID, projectID *uint, createdByID uint) (*models.ServiceAccount, error) {
+	if (orgID == nil) == (projectID == nil) {
+		return nil, ErrServiceAccountOwner
+	}
+
+	account := &models.ServiceAccount{
+		Name:           name,
+		Description:    description,
+		OrganizationID: orgID,
+		ProjectID:      projectID,
+		CreatedByID:    createdByID,
+	}
+	if err := s.DB.Create(account).Error; err != nil {
+		return nil, err
+	}
+	return account, nil
+}
+
+// ListServiceAccounts lists the service accounts owned by an organization or a project
+func (s *ServiceAccountService) ListServiceAccounts(orgID, projectID *uint) ([]models.ServiceAccount, error) {
+	query := s.DB.Order("id")
+	switch {
+	case orgID != nil:
+		query = query.Where("organization_id = ?", *orgID)
+	case projectID != nil:
+		query = query.Where("project_id = ?", *projectID)
+	default:
+		return nil, ErrServiceAccountOwner
+	}
+
+	var accounts []models.ServiceAccount
+	err := query.Find(&accounts).Error
+	return accounts, err
+}
+
+// GetServiceAccount returns a service account by ID
+func (s *ServiceAccountService) GetServiceAccount(id uint) (*models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	if err := s.DB.First(&account, id).Error; err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// DeleteServiceAccount revokes every key and grant of a service account and deletes it
+func (s *ServiceAccountService) DeleteServiceAccount(id uint) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.ServiceAccountKey{}).
+			Where("service_account_id = ? AND revoked_at IS NULL", id).
+			Update("revoked_at", time.Now()).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("service_account_id = ?", id).Delete(&models.ServiceAccountGrant{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&models.ServiceAccount{}, id).Error
+	})
+}
+
+// CanManageServiceAccount reports whether a user administers the organization or project
+// that owns a service account
+func CanManageServiceAccount(db *gorm.DB, userID uint, account *models.ServiceAccount) (bool, error) {
+	if account.OrganizationID != nil {
+		role, err := OrgRole(db, userID, *account.OrganizationID)
+		if err != nil {
+			return false, err
+		}
+		return role.AtLeast(models.OrgRoleAdmin), nil
+	}
+
+	role, err := ProjectRole(db, userID, *account.ProjectID)
+	if err != nil {
+		return false, err
+	}
+	return role.AtLeast(models.RoleAdmin), nil
+}
+
+// CreateKey issues a new key for a service account and returns it with the plaintext key.
+// The plaintext is never stored and cannot be retrieved again.
+func (s *ServiceAccountService) CreateKey(serviceAccountID uint, name string, expiresAt *time.Time) (*models.ServiceAccountKey, string, error) {
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrTokenExpiryInPast
+	}
+
+	plaintext, err := generateToken(ServiceAccountKeyPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := &models.ServiceAccountKey{
+		ServiceAccountID: serviceAccountID,
+		Name:             name,
+		Prefix:           plaintext[:len(ServiceAccountKeyPrefix)+8],
+		TokenHash:        HashAPIToken(plaintext),
+		ExpiresAt:        expiresAt,
+	}
+	if err := s.DB.Create(key).Error; err != nil {
+		return nil, "", err
+	}
+
+	return key, plaintext, nil
+}
+
+// ListKeys returns a service account's keys, newest first
+func (s *ServiceAccountService) ListKeys(serviceAccountID uint) ([]models.ServiceAccountKey, error) {
+	var keys []models.ServiceAccountKey
+	err := s.DB.Where("service_account_id = ?", serviceAccountID).Order("id desc").Find(&keys).Error
+	return keys, err
+}
+
+// RevokeKey permanently disables one key of a service account
+func (s *ServiceAccountService) RevokeKey(serviceAccountID, keyID uint) error {
+	var key models.ServiceAccountKey
+	if err := s.DB.Where("id = ? AND service_account_id = ?", keyID, serviceAccountID).First(&key).Error; err != nil {
+		return err
+	}
+	if key.RevokedAt != nil {
+		return ErrKeyAlreadyRevoked
+	}
+	return s.DB.Model(&key).Update("revoked_at", time.Now()).Error
+}
+
+// Authenticate resolves a plaintext key to its service account and records its use
+func (s *ServiceAccountService) Authenticate(plaintext string) (*models.ServiceAccount, *models.ServiceAccountKey, error) {
+	if !IsServiceAccountKey(plaintext) {
+		return nil, nil, ErrInvalidServiceAccountKey
+	}
+
+	var key models.ServiceAccountKey
+	if err := s.DB.Where("token_hash = ?", HashAPIToken(plaintext)).First(&key).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidServiceAccountKey
+		}
+		return nil, nil, err
+	}
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !key.ExpiresAt.After(time.Now())) {
+		return nil, nil, ErrInvalidServiceAccountKey
+	}
+
+	// Deleted accounts are filtered out by the soft-delete scope
+	account, err := s.GetServiceAccount(key.ServiceAccountID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidServiceAccountKey
+		}
+		return nil, nil, err
+	}
+
+	if err := touchLastUsed(s.DB, &key, &key.LastUsedAt); err != nil {
+		return nil, nil, err
+	}
+
+	return account, &key, nil
+}
+
+// ListGrants lists the service accounts with access to a project
+func (s *ServiceAccountService) ListGrants(projectID uint) ([]models.ServiceAccountGrant, error) {
+	var grants []models.ServiceAccountGrant
+	err := s.DB.Preload("ServiceAccount").Where("project_id = ?", projectID).Order("id").Find(&grants).Error
+	return grants, err
+}
+
+// GrantRole gives a service account a role on a project, replacing any existing grant.
+// The account must belong to the project or to the project's organization.
+func (s *ServiceAccountService) GrantRole(projectID, serviceAccountID uint, role models.Role) (*models.ServiceAccountGrant, error) {
+	if !role.Valid() || role == models.RoleOwner {
+		return nil, ErrServiceAccountRole
+	}
+
+	account, err := s.GetServiceAccount(serviceAccountID)
+	if err != nil {
+		return nil, err
+	}
+	var project models.Project
+	if err := s.DB.Select("id", "organization_id").First(&project, projectID).Error; err != nil {
+		return nil, err
+	}
+
+	ownProject := account.ProjectID != nil && *account.ProjectID == projectID
+	ownOrg := account.OrganizationID != nil && project.OrganizationID != nil && *account.OrganizationID == *project.OrganizationID
+	if !ownProject && !ownOrg {
+		return nil, ErrServiceAccountScope
+	}
+
+	var grant models.ServiceAccountGrant
+	err = s.DB.Where("project_id = ? AND service_account_id = ?", projectID, serviceAccountID).First(&grant).Error
+	switch {
+	case err == nil:
+		if err := s.DB.Model(&grant).Update("role", role).Error; err != nil {
+			return nil, err
+		}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		grant = models.ServiceAccountGrant{ProjectID: projectID, ServiceAccountID: serviceAccountID, Role: role}
+		if err := s.DB.Create(&grant).Error; err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+
+	grant.ServiceAccount = *account
+	return &grant, nil
+}
+
+// RevokeGrant removes a service account's access to a project
+func (s *ServiceAccountService) RevokeGrant(projectID, serviceAccountID uint) error {
+	result := s.DB.Where("project_id = ? AND service_account_id = ?", projectID, serviceAccountID).
+		Delete(&models.ServiceAccountGrant{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// ProjectsForServiceAccount lists the projects a service account has been granted, with its role
+func (s *ServiceAccountService) ProjectsForServiceAccount(serviceAccountID uint) ([]models.Project, error) {
+	var grants []models.ServiceAccountGrant
+	if err := s.DB.Where("service_account_id = ?", serviceAccountID).Find(&grants).Error; err != nil {
+		return nil, err
+	}
+	if len(grants) == 0 {
+		return []models.Project{}, nil
+	}
+
+	roles := make(map[uint]models.Role, len(grants))
+	ids := make([]uint, 0, len(grants))
+	for _, grant := range grants {
+		roles[grant.ProjectID] = grant.Role
+		ids = append(ids, grant.ProjectID)
+	}
+
+	var projects []models.Project
+	if err := s.DB.Where("id IN ?", ids).Order("id").Find(&projects).Error; err != nil {
+		return nil, err
+	}
+	for i := range projects {
+		projects[i].Role = roles[projects[i].ID]
+	}
+	return projects, nil
+}
+
+// ServiceAccountProjectRole returns a service account's role on a project, or "" without a grant.
+// Unlike users, service accounts never inherit roles from their organization.
+func ServiceAccountProjectRole(db *gorm.DB, serviceAccountID, projectID uint) (models.Role, error) {
+	var grant models.ServiceAccountGrant
+	err := db.Where("project_id = ? AND service_account_id = ?", projectID, serviceAccountID).First(&grant).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return grant.Role, nil
+}
+
+// IsServiceAccountKey reports whether a bearer credential is a service account key
+func IsServiceAccountKey(credential string) bool {
+	return strings.HasPrefix(credential, ServiceAccountKeyPrefix)
+}
@@ -0,0 +1,40 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestServiceAccountKeyPrefix(t *testing.T) {
+	key, err := generateToken(ServiceAccountKeyPrefix)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	if !IsServiceAccountKey(key) || !strings.HasPrefix(key, ServiceAccountKeyPrefix) {
+		t.Fatalf("Key should carry the %s prefix: %q", ServiceAccountKeyPrefix, key)
+	}
+	if IsAPIToken(key) {
+		t.Fatal("A service account key should not be mistaken for an API token")
+	}
+
+	token, _ := generateToken(APITokenPrefix)
+	if IsServiceAccountKey(token) {
+		t.Fatal("An API token should not be mistaken for a service account key")
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	user := UserPrincipal(7)
+	account := ServiceAccountPrincipal(7)
+
+	if !user.IsUser() || account.IsUser() {
+		t.Fatal("Only user principals should report IsUser")
+	}
+	if user == account {
+		t.Fatal("A user and a service account with the same ID must be different principals")
+	}
+	if account.Kind != ActorServiceAccount {
+		t.Fatalf("Service account principals should match the audit actor type, got %q", account.Kind)
+	}
+}
@@ -0,0 +1,248 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"errors"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"gorm.io/gorm"
+)
+
+// RefreshTokenPrefix marks refresh tokens so they are never mistaken for other credentials
+const RefreshTokenPrefix = "cs_rt_"
+
+var (
+	ErrInvalidRefreshToken   = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused    = errors.New("refresh token was already used; the session has been revoked")
+	ErrSessionInvalid        = errors.New("session has expired or been revoked")
+	ErrSessionAlreadyRevoked = errors.New("session is already revoked")
+)
+
+// SessionTokens are the credentials handed out when a session starts or is refreshed
+type SessionTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration // Lifetime of AccessToken
+	Session      *models.Session
+}
+
+// SessionService manages sign-in sessions and their rotating refresh tokens
+type SessionService struct {
+	DB *gorm.DB
+}
+
+// NewSessionService creates a new SessionService
+func NewSessionService(db *gorm.DB) *SessionService {
+	return &SessionService{DB: db}
+}
+
+// CreateSession starts a session for a user who has just signed in. Deactivated users get
+// ErrUserDeactivated, whichever way they authenticated.
+func (s *SessionService) CreateSession(userID uint, ip, userAgent string) (*SessionTokens, error) {
+	if err := EnsureUserActive(s.DB, userID); err != nil {
+		return nil, err
+	}
+
+	session := &models.Session{
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		ExpiresAt: time.Now().Add(config.AppConfig.SessionMaxLifetime),
+	}
+
+	var refreshToken string
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(session).Error; err != nil {
+			return err
+		}
+		var err error
+		refreshToken, err = issueRefreshToken(tx, session)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return newSessionTokens(session, refreshToken)
+}
+
+// Refresh exchanges a refresh token for a new access token and the next refresh token.
+// A token can only be used once: presenting it again means it was stolen (or the client
+// is misbehaving), so the whole session is revoked and ErrRefreshTokenReused returned.
+// The session is returned whenever the token was recognized, also on errors.
+func (s *SessionService) Refresh(plaintext string) (*SessionTokens, *models.Session, error) {
+	var token models.RefreshToken
+	if err := s.DB.Where("token_hash = ?", HashAPIToken(plaintext)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidRefreshToken
+		}
+		return nil, nil, err
+	}
+
+	var session models.Session
+	if err := s.DB.First(&session, token.SessionID).Error; err != nil {
+		return nil, nil, err
+	}
+	if !sessionActive(&session) {
+		return nil, &session, ErrSessionInvalid
+	}
+	if token.UsedAt != nil {
+		return nil, &session, s.revokeReusedSession(&session)
+	}
+	if !token.ExpiresAt.After(time.Now()) {
+		return nil, &session, ErrInvalidRefreshToken
+	}
+
+	var refreshToken string
+	reused := false
+	err := s.DB.Transaction(func(tx *gorm.DB) error {
+		// Only one concurrent request can claim the token; the others count as reuse
+		result := tx.Model(&token).Where("used_at IS NULL").Update("used_at", time.Now())
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			reused = true
+			return nil
+		}
+
+		var err error
+		if refreshToken, err = issueRefreshToken(tx, &session); err != nil {
+			return err
+		}
+		return touchLastUsed(tx, &session, &session.LastUsedAt)
+	})
+	if err != nil {
+		return nil, &session, err
+	}
+	if reused {
+		return nil, &session, s.revokeReusedSession(&session)
+	}
+
+	tokens, err := newSessionTokens(&session, refreshToken)
+	return tokens, &session, err
+}
+
+// ValidateSession checks that an access token's session belongs to the user and is still
+// active, and records its use
+func (s *SessionService) ValidateSession(sessionID, userID uint) (*models.Session, error) {
+	var session models.Session
+	if err := s.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrSessionInvalid
+		}
+		return nil, err
+	}
+	if !sessionActive(&session) {
+		return nil, ErrSessionInvalid
+	}
+
+	if err := touchLastUsed(s.DB, &session, &session.LastUsedAt); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// MarkStepUp records that the session's user just re-authenticated with a security key
+func (s *SessionService) MarkStepUp(sessionID uint) error {
+	return s.DB.Model(&models.Session{}).Where("id = ?", sessionID).Update("step_up_at", time.Now()).Error
+}
+
+// ListSessions returns a user's active sessions, newest first
+func (s *SessionService) ListSessions(userID uint) ([]models.Session, error) {
+	var sessions []models.Session
+	err := s.DB.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("id desc").Find(&sessions).Error
+	return sessions, err
+}
+
+// RevokeSession signs one of a user's sessions out. Its access tokens stop working immediately.
+func (s *SessionService) RevokeSession(userID, sessionID uint) error {
+	var session models.Session
+	if err := s.DB.Where("id = ? AND user_id = ?", sessionID, userID).First(&session).Error; err != nil {
+		return err
+	}
+	if session.RevokedAt != nil {
+		return ErrSessionAlreadyRevoked
+	}
+	return s.DB.Model(&session).Update("revoked_at", time.Now()).Error
+}
+
+// RevokeAllSessions signs a user out everywhere and returns the number of sessions revoked
+func (s *SessionService) RevokeAllSessions(userID uint) (int64, error) {
+	result := s.DB.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// RevokeOtherSessions signs a user out everywhere except the session keepID and returns the
+// number of sessions revoked
+func (s *SessionService) RevokeOtherSessions(userID, keepID uint) (int64, error) {
+	result := s.DB.Model(&models.Session{}).Where("user_id = ? AND id <> ? AND revoked_at IS NULL", userID, keepID).
+		Update("revoked_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+func (s *SessionService) revokeReusedSession(session *models.Session) error {
+	if err := s.DB.Model(session).Where("revoked_at IS NULL").Update("revoked_at", time.Now()).Error; err != nil {
+		return err
+	}
+	return ErrRefreshTokenReused
+}
+
+func sessionActive(session *models.Session) bool {
+	return session.RevokedAt == nil && session.ExpiresAt.After(time.Now())
+}
+
+// issueRefreshToken stores the next refresh token of a session and returns its plaintext.
+// It never outlives the session.
+func issueRefreshToken(tx *gorm.DB, session *models.Session) (string, error) {
+	plaintext, err := generateToken(RefreshTokenPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	expiresAt := time.Now().Add(config.AppConfig.RefreshTokenTTL)
+	if expiresAt.After(session.ExpiresAt) {
+		expiresAt = session.ExpiresAt
+	}
+
+	token := &models.RefreshToken{
+		SessionID: session.ID,
+		TokenHash: HashAPIToken(plaintext),
+		ExpiresAt: expiresAt,
+	}
+	if err := tx.Create(token).Error; err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+func newSessionTokens(session *models.Session, refreshToken string) (*SessionTokens, error) {
+	accessToken, err := generateAccessToken(session.UserID, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    config.AppConfig.AccessTokenTTL,
+		Session:      session,
+	}, nil
+}
+
+// generateAccessToken creates a short-lived JWT for a user's session
+func generateAccessToken(userID, sessionID uint) (string, error) {
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"sub": userID,                                          // 'sub' (subject) is the standard claim for user ID
+		"sid": sessionID,                                       // Session, checked on every request so it can be revoked
+		"iat": now.Unix(),                                      // 'iat' (issued at)
+		"exp": now.Add(config.AppConfig.AccessTokenTTL).Unix(), // 'exp' (expiration time)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(config.AppConfig.JWTSecretKey)
+}
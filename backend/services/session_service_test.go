@@ -0,0 +1,52 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestGenerateAccessToken(t *testing.T) {
+	tokenString, err := generateAccessToken(7, 42)
+	if err != nil {
+		t.Fatalf("Failed to generate access token: %v", err)
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		return config.AppConfig.JWTSecretKey, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to parse access token: %v", err)
+	}
+
+	if claims["sub"] != float64(7) || claims["sid"] != float64(42) {
+		t.Fatalf("Unexpected subject or session claims: %v", claims)
+	}
+	lifetime := time.Duration(claims["exp"].(float64)-claims["iat"].(float64)) * time.Second
+	if lifetime != config.AppConfig.AccessTokenTTL {
+		t.Fatalf("Access token should live %v, got %v", config.AppConfig.AccessTokenTTL, lifetime)
+	}
+}
+
+func TestSessionActive(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		session models.Session
+		want    bool
+	}{
+		{"active", models.Session{ExpiresAt: now.Add(time.Hour)}, true},
+		{"expired", models.Session{ExpiresAt: now.Add(-time.Second)}, false},
+		{"revoked", models.Session{ExpiresAt: now.Add(time.Hour), RevokedAt: &now}, false},
+	}
+
+	for _, tt := range tests {
+		if got := sessionActive(&tt.session); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
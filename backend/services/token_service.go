@@ -0,0 +1,193 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// APITokenPrefix marks personal access tokens so they can be told apart from JWTs
+const APITokenPrefix = "cs_pat_"
+
+// apiTokenDisplayLength is how much of a token is kept in clear to recognize it
+const apiTokenDisplayLength = len(APITokenPrefix) + 8
+
+// lastUsedResolution limits how often authenticating with a token writes last_used_at
+const lastUsedResolution = time.Minute
+
+var (
+	ErrInvalidAPIToken     = errors.New("invalid, expired or revoked API token")
+	ErrInvalidPermission   = errors.New("permission must be read or write")
+	ErrTokenScope          = errors.New("a token can only be scoped to projects where you have at least the requested permission")
+	ErrTokenProjects       = errors.New("a token must be scoped to at least one project")
+	ErrTokenExpiryInPast   = errors.New("token expiry must be in the future")
+	ErrTokenAlreadyRevoked = errors.New("token is already revoked")
+)
+
+// TokenService manages personal access tokens
+type TokenService struct {
+	DB *gorm.DB
+}
+
+// NewTokenService creates a new TokenService
+func NewTokenService(db *gorm.DB) *TokenService {
+	return &TokenService{DB: db}
+}
+
+// CreateToken issues a token for userID and returns it with the plaintext token.
+// The plaintext is never stored and cannot be retrieved again.
+func (s *TokenService) CreateToken(userID uint, name string, permission models.TokenPermission, projectIDs []uint, expiresAt *time.Time) (*models.APIToken, string, error) {
+	if permission.Role() == "" {
+		return nil, "", ErrInvalidPermission
+	}
+	if len(projectIDs) == 0 {
+		return nil, "", ErrTokenProjects
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return nil, "", ErrTokenExpiryInPast
+	}
+
+	// A token can never grant more than its user has
+	projectIDs = uniqueIDs(projectIDs)
+	for _, projectID := range projectIDs {
+		role, err := ProjectRole(s.DB, userID, projectID)
+		if err != nil {
+			return nil, "", err
+		}
+		if !role.AtLeast(permission.Role()) {
+			return nil, "", ErrTokenScope
+		}
+	}
+
+	plaintext, err := generateToken(APITokenPrefix)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token := &models.APIToken{
+		UserID:     userID,
+		Name:       name,
+		Prefix:     plaintext[:apiTokenDisplayLength],
+		TokenHash:  HashAPIToken(plaintext),
+		Permission: permission,
+		ProjectIDs: projectIDs,
+		ExpiresAt:  expiresAt,
+	}
+	if err := s.DB.Create(token).Error; err != nil {
+		return nil, "", err
+	}
+
+	return token, plaintext, nil
+}
+
+// ListTokens returns a user's tokens, newest first, including revoked and expired ones
+func (s *TokenService) ListTokens(userID uint) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+	err := s.DB.Where("user_id = ?", userID).Order("id desc").Find(&tokens).Error
+	return tokens, err
+}
+
+// GetToken returns one of a user's tokens
+func (s *TokenService) GetToken(userID, tokenID uint) (*models.APIToken, error) {
+	var token models.APIToken
+	if err := s.DB.Where("id = ? AND user_id = ?", tokenID, userID).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// RevokeToken permanently disables a token. The row is kept so audit events can refer to it.
+func (s *TokenService) RevokeToken(userID, tokenID uint) (*models.APIToken, error) {
+	token, err := s.GetToken(userID, tokenID)
+	if err != nil {
+		return nil, err
+	}
+	if token.RevokedAt != nil {
+		return nil, ErrTokenAlreadyRevoked
+	}
+
+	now := time.Now()
+	if err := s.DB.Model(token).Update("revoked_at", now).Error; err != nil {
+		return nil, err
+	}
+	token.RevokedAt = &now
+	return token, nil
+}
+
+// Authenticate resolves a plaintext token and records its use
+func (s *TokenService) Authenticate(plaintext string) (*models.APIToken, error) {
+	if !IsAPIToken(plaintext) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	var token models.APIToken
+	if err := s.DB.Where("token_hash = ?", HashAPIToken(plaintext)).First(&token).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrInvalidAPIToken
+		}
+		return nil, err
+	}
+
+	if token.RevokedAt != nil || (token.ExpiresAt != nil && !token.ExpiresAt.After(time.Now())) {
+		return nil, ErrInvalidAPIToken
+	}
+
+	if err := touchLastUsed(s.DB, &token, &token.LastUsedAt); err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// IsAPIToken reports whether a bearer credential is a personal access token
+func IsAPIToken(credential string) bool {
+	return strings.HasPrefix(credential, APITokenPrefix)
+}
+
+// HashAPIToken returns the hex SHA-256 of a token or service account key. They carry
+// 256 bits of randomness, so a fast unsalted hash is enough and keeps lookups indexable.
+func HashAPIToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateToken returns prefix followed by 256 random bits
+func generateToken(prefix string) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	return prefix + base64.RawURLEncoding.EncodeToString(random), nil
+}
+
+// touchLastUsed records a credential's use, at most once per lastUsedResolution
+func touchLastUsed(db *gorm.DB, model interface{}, lastUsedAt **time.Time) error {
+	now := time.Now()
+	if *lastUsedAt != nil && now.Sub(**lastUsedAt) < lastUsedResolution {
+		return nil
+	}
+	if err := db.Model(model).UpdateColumn("last_used_at", now).Error; err != nil {
+		return err
+	}
+	*lastUsedAt = &now
+	return nil
+}
+
+func uniqueIDs(ids []uint) []uint {
+	seen := make(map[uint]bool, len(ids))
+	var unique []uint
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
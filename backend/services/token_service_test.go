@@ -0,0 +1,58 @@
+package services
+
+import (
+	"ciphersafe/models"
+	"strings"
+	"testing"
+)
+
+func TestGenerateAPIToken(t *testing.T) {
+	first, err := generateToken(APITokenPrefix)
+	if err != nil {
+		t.Fatalf("Failed to generate token: %v", err)
+	}
+	second, _ := generateToken(APITokenPrefix)
+
+	if !IsAPIToken(first) || !strings.HasPrefix(first, APITokenPrefix) {
+		t.Fatalf("Token should carry the %s prefix: %q", APITokenPrefix, first)
+	}
+	if first == second {
+		t.Fatal("Tokens should be random")
+	}
+	if IsAPIToken("eyJhbGciOiJIUzI1NiJ9.e30.sig") {
+		t.Fatal("A JWT should not be mistaken for an API token")
+	}
+
+	hash := HashAPIToken(first)
+	if len(hash) != 64 || strings.Contains(hash, first) {
+		t.Fatalf("Unexpected token hash %q", hash)
+	}
+	if HashAPIToken(first) != hash || HashAPIToken(second) == hash {
+		t.Fatal("Token hashes should be deterministic and distinct")
+	}
+}
+
+func TestAPITokenScopeRole(t *testing.T) {
+	readToken := &models.APIToken{Permission: models.TokenRead, ProjectIDs: []uint{1, 2}}
+	writeToken := &models.APIToken{Permission: models.TokenWrite, ProjectIDs: []uint{1}}
+
+	tests := []struct {
+		name      string
+		token     *models.APIToken
+		projectID uint
+		role      models.Role
+		want      models.Role
+	}{
+		{"read token caps owner", readToken, 1, models.RoleOwner, models.RoleReader},
+		{"write token caps admin", writeToken, 1, models.RoleAdmin, models.RoleWriter},
+		{"write token keeps reader", writeToken, 1, models.RoleReader, models.RoleReader},
+		{"project outside scope", writeToken, 2, models.RoleOwner, ""},
+		{"no membership", readToken, 2, "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := tt.token.ScopeRole(tt.projectID, tt.role); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -2,10 +2,16 @@ package services
 
 import (
 	"ciphersafe/models"
+	"errors"
+	"strings"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// ErrUserDeactivated is returned when a deactivated user tries to sign in
+var ErrUserDeactivated = errors.New("this account has been deactivated")
+
 // UserService handles user-related database operations
 type UserService struct {
 	DB *gorm.DB
@@ -50,3 +56,73 @@ func (s *UserService) FindUserByID(userID uint) (*models.User, error) {
 	}
 	return &user, nil
 }
+
+// DeactivateUser disables a user's account and signs them out everywhere. The user and their
+// memberships are kept, so the audit trail stays intact and reactivation restores access.
+func (s *UserService) DeactivateUser(userID uint) error {
+	return s.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&models.User{}).Where("id = ? AND active", userID).
+			Updates(map[string]interface{}{"active": false, "deactivated_at": time.Now()}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&models.Session{}).Where("user_id = ? AND revoked_at IS NULL", userID).
+			Update("revoked_at", time.Now()).Error
+	})
+}
+
+// ActivateUser re-enables a deactivated account
+func (s *UserService) ActivateUser(userID uint) error {
+	return s.DB.Model(&models.User{}).Where("id = ?", userID).
+		Updates(map[string]interface{}{"active": true, "deactivated_at": nil}).Error
+}
+
+// EnsureUserActive returns ErrUserDeactivated if the user's account is deactivated
+func EnsureUserActive(db *gorm.DB, userID uint) error {
+	var user models.User
+	if err := db.Select("id", "active").First(&user, userID).Error; err != nil {
+		return err
+	}
+	if !user.Active {
+		return ErrUserDeactivated
+	}
+	return nil
+}
+
+// ProvisionOIDCUser returns the user for a single sign-on identity, creating it on first sign-in.
+// An existing password account is linked when the provider has verified that it owns the email.
+// created reports whether a new user was made.
+func (s *UserService) ProvisionOIDCUser(identity *OIDCIdentity) (*models.User, bool, error) {
+	var user models.User
+	err := s.DB.Where("oidc_issuer = ? AND oidc_subject = ?", identity.Issuer, identity.Subject).First(&user).Error
+	if err == nil {
+		return &user, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	email := strings.TrimSpace(identity.Email)
+	if email == "" {
+		return nil, false, ErrOIDCEmailMissing
+	}
+
+	existing, err := s.FindUserByEmail(email)
+	switch {
+	case err == nil:
+		if !identity.EmailVerified || existing.OIDCSubject != nil {
+			return nil, false, ErrOIDCEmailInUse
+		}
+		err := s.DB.Model(existing).Updates(map[string]interface{}{"oidc_issuer": identity.Issuer, "oidc_subject": identity.Subject}).Error
+		return existing, false, err
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, false, err
+	}
+
+	// Provisioned users have no password; password login never matches an empty hash
+	user = models.User{Email: email, OIDCIssuer: &identity.Issuer, OIDCSubject: &identity.Subject}
+	if err := s.DB.Create(&user).Error; err != nil {
+		return nil, false, err
+	}
+	return &user, true, nil
+}
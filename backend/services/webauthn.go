@@ -0,0 +1,293 @@
+package services
+
+import (
+	"bytes"
+	"ciphersafe/config"
+	"ciphersafe/utils"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// ErrWebAuthnVerification is returned when a registration or assertion does not check out
+var ErrWebAuthnVerification = errors.New("WebAuthn verification failed")
+
+// COSE algorithms accepted for credentials
+const (
+	COSEAlgES256 = -7
+	COSEAlgEdDSA = -8
+	COSEAlgRS256 = -257
+)
+
+// Authenticator data flags
+const (
+	authDataUserPresent  = 0x01
+	authDataUserVerified = 0x04
+	authDataAttested     = 0x40
+)
+
+// collectedClientData is the clientDataJSON the browser signs over
+type collectedClientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// authenticatorData is the parsed binary authenticator data
+type authenticatorData struct {
+	RPIDHash     []byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       []byte // Attested credential data, registration only
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key
+}
+
+// VerifiedCredential is a credential that passed registration
+type VerifiedCredential struct {
+	CredentialID []byte
+	PublicKey    []byte // COSE_Key, as sent by the authenticator
+	Algorithm    int64
+	SignCount    uint32
+	AAGUID       string
+}
+
+// VerifyRegistration checks a navigator.credentials.create() response against the challenge
+// issued for it. Attestation statements are not verified: the server asks for "none" and
+// does not restrict which authenticator models may be used.
+func VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (*VerifiedCredential, error) {
+	if err := verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+
+	decoded, _, err := utils.DecodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: attestation object: %v", ErrWebAuthnVerification, err)
+	}
+	attestation, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: attestation object is not a map", ErrWebAuthnVerification)
+	}
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("%w: missing authenticator data", ErrWebAuthnVerification)
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyAuthenticatorData(authData, false); err != nil {
+		return nil, err
+	}
+	if authData.CredentialID == nil {
+		return nil, fmt.Errorf("%w: no attested credential", ErrWebAuthnVerification)
+	}
+
+	_, alg, err := parseCOSEKey(authData.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VerifiedCredential{
+		CredentialID: authData.CredentialID,
+		PublicKey:    authData.PublicKey,
+		Algorithm:    alg,
+		SignCount:    authData.SignCount,
+		AAGUID:       hex.EncodeToString(authData.AAGUID),
+	}, nil
+}
+
+// VerifyAssertion checks a navigator.credentials.get() response against the challenge and
+// the stored credential, and returns the authenticator's new signature counter.
+// requireUV demands user verification (PIN or biometric), needed when the key is the only factor.
+func VerifyAssertion(challenge string, publicKey []byte, storedCount uint32, clientDataJSON, rawAuthData, signature []byte, requireUV bool) (uint32, error) {
+	if err := verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+
+	authData, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return 0, err
+	}
+	if err := verifyAuthenticatorData(authData, requireUV); err != nil {
+		return 0, err
+	}
+
+	key, alg, err := parseCOSEKey(publicKey)
+	if err != nil {
+		return 0, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), rawAuthData...), clientDataHash[:]...)
+	if !verifyCOSESignature(key, alg, signed, signature) {
+		return 0, fmt.Errorf("%w: bad signature", ErrWebAuthnVerification)
+	}
+
+	// Authenticators that keep a counter must increase it; otherwise the key was cloned
+	if (authData.SignCount != 0 || storedCount != 0) && authData.SignCount <= storedCount {
+		return 0, fmt.Errorf("%w: signature counter did not increase", ErrWebAuthnVerification)
+	}
+	return authData.SignCount, nil
+}
+
+// ClientDataChallenge extracts the challenge from clientDataJSON so the pending challenge
+// can be looked up before full verification
+func ClientDataChallenge(clientDataJSON []byte) (string, error) {
+	var clientData collectedClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil || clientData.Challenge == "" {
+		return "", fmt.Errorf("%w: invalid client data", ErrWebAuthnVerification)
+	}
+	return clientData.Challenge, nil
+}
+
+func verifyClientData(clientDataJSON []byte, ceremony, challenge string) error {
+	var clientData collectedClientData
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return fmt.Errorf("%w: invalid client data", ErrWebAuthnVerification)
+	}
+	if clientData.Type != ceremony {
+		return fmt.Errorf("%w: unexpected ceremony %q", ErrWebAuthnVerification, clientData.Type)
+	}
+	if clientData.Challenge != challenge {
+		return fmt.Errorf("%w: challenge mismatch", ErrWebAuthnVerification)
+	}
+	if clientData.CrossOrigin {
+		return fmt.Errorf("%w: cross-origin requests are not allowed", ErrWebAuthnVerification)
+	}
+	for _, origin := range config.AppConfig.WebAuthnOrigins {
+		if clientData.Origin == origin {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: origin %q is not allowed", ErrWebAuthnVerification, clientData.Origin)
+}
+
+func verifyAuthenticatorData(authData *authenticatorData, requireUV bool) error {
+	rpIDHash := sha256.Sum256([]byte(config.AppConfig.WebAuthnRPID))
+	if !bytes.Equal(authData.RPIDHash, rpIDHash[:]) {
+		return fmt.Errorf("%w: relying party ID mismatch", ErrWebAuthnVerification)
+	}
+	if authData.Flags&authDataUserPresent == 0 {
+		return fmt.Errorf("%w: user not present", ErrWebAuthnVerification)
+	}
+	if requireUV && authData.Flags&authDataUserVerified == 0 {
+		return fmt.Errorf("%w: user not verified", ErrWebAuthnVerification)
+	}
+	return nil
+}
+
+// parseAuthenticatorData splits authenticator data into its fields (WebAuthn §6.1)
+func parseAuthenticatorData(data []byte) (*authenticatorData, error) {
+	if len(data) < 37 {
+		return nil, fmt.Errorf("%w: authenticator data too short", ErrWebAuthnVerification)
+	}
+	authData := &authenticatorData{
+		RPIDHash:  data[:32],
+		Flags:     data[32],
+		SignCount: binary.BigEndian.Uint32(data[33:37]),
+	}
+	if authData.Flags&authDataAttested == 0 {
+		return authData, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("%w: attested credential data too short", ErrWebAuthnVerification)
+	}
+	authData.AAGUID = rest[:16]
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, fmt.Errorf("%w: credential ID too short", ErrWebAuthnVerification)
+	}
+	authData.CredentialID = rest[:idLength]
+	rest = rest[idLength:]
+
+	// The COSE key is followed by optional extensions; decoding it tells where it ends
+	_, after, err := utils.DecodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("%w: credential public key: %v", ErrWebAuthnVerification, err)
+	}
+	authData.PublicKey = rest[:len(rest)-len(after)]
+	return authData, nil
+}
+
+// parseCOSEKey decodes an ES256, RS256 or EdDSA COSE_Key (RFC 9053)
+func parseCOSEKey(raw []byte) (crypto.PublicKey, int64, error) {
+	decoded, _, err := utils.DecodeCBOR(raw)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%w: public key: %v", ErrWebAuthnVerification, err)
+	}
+	key, ok := decoded.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, fmt.Errorf("%w: public key is not a map", ErrWebAuthnVerification)
+	}
+
+	kty, _ := key[int64(1)].(int64)
+	alg, _ := key[int64(3)].(int64)
+	switch {
+	case kty == 2 && alg == COSEAlgES256:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		y, _ := key[int64(-3)].([]byte)
+		if crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, 0, fmt.Errorf("%w: invalid P-256 key", ErrWebAuthnVerification)
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, 0, fmt.Errorf("%w: point is not on P-256", ErrWebAuthnVerification)
+		}
+		return pub, alg, nil
+
+	case kty == 3 && alg == COSEAlgRS256:
+		n, _ := key[int64(-1)].([]byte)
+		e, _ := key[int64(-2)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, 0, fmt.Errorf("%w: invalid RSA key", ErrWebAuthnVerification)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, alg, nil
+
+	case kty == 1 && alg == COSEAlgEdDSA:
+		crv, _ := key[int64(-1)].(int64)
+		x, _ := key[int64(-2)].([]byte)
+		if crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, 0, fmt.Errorf("%w: invalid Ed25519 key", ErrWebAuthnVerification)
+		}
+		return ed25519.PublicKey(x), alg, nil
+
+	default:
+		return nil, 0, fmt.Errorf("%w: unsupported key type %d with algorithm %d", ErrWebAuthnVerification, kty, alg)
+	}
+}
+
+func verifyCOSESignature(key crypto.PublicKey, alg int64, signed, signature []byte) bool {
+	switch alg {
+	case COSEAlgES256:
+		digest := sha256.Sum256(signed)
+		return ecdsa.VerifyASN1(key.(*ecdsa.PublicKey), digest[:], signature)
+	case COSEAlgRS256:
+		digest := sha256.Sum256(signed)
+		return rsa.VerifyPKCS1v15(key.(*rsa.PublicKey), crypto.SHA256, digest[:], signature) == nil
+	case COSEAlgEdDSA:
+		return ed25519.Verify(key.(ed25519.PublicKey), signed, signature)
+	}
+	return false
+}
+
+// decodeBase64URL accepts the unpadded base64url that WebAuthn uses, with or without padding
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
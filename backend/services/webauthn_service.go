@@ -0,0 +1,393 @@
+package services
+
+import (
+	"ciphersafe/config"
+	"ciphersafe/models"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// WebAuthn ceremonies a challenge can be used for
+const (
+	WebAuthnRegister = "register"
+	WebAuthnLogin    = "login"
+	WebAuthnStepUp   = "step_up"
+)
+
+// webAuthnTimeout is how long the browser and the server wait for the authenticator
+const webAuthnTimeout = 5 * time.Minute
+
+var (
+	ErrWebAuthnChallenge     = errors.New("unknown or expired WebAuthn challenge")
+	ErrUnknownCredential     = errors.New("unknown WebAuthn credential")
+	ErrNoWebAuthnCredentials = errors.New("register a passkey or security key first")
+)
+
+// PublicKeyCredentialDescriptor identifies a registered credential to the browser
+type PublicKeyCredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"` // base64url
+}
+
+// CredentialCreationOptions is passed to navigator.credentials.create() (binary fields base64url)
+type CredentialCreationOptions struct {
+	Challenge string `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          string `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int64  `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int64                           `json:"timeout"`
+	ExcludeCredentials     []PublicKeyCredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// CredentialRequestOptions is passed to navigator.credentials.get() (binary fields base64url)
+type CredentialRequestOptions struct {
+	Challenge        string                          `json:"challenge"`
+	RPID             string                          `json:"rpId"`
+	Timeout          int64                           `json:"timeout"`
+	AllowCredentials []PublicKeyCredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                          `json:"userVerification"`
+}
+
+// RegistrationResponse is the JSON form of the credential returned by create()
+type RegistrationResponse struct {
+	ID       string `json:"id" binding:"required"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+		AttestationObject string `json:"attestationObject" binding:"required"`
+	} `json:"response" binding:"required"`
+}
+
+// AssertionResponse is the JSON form of the credential returned by get()
+type AssertionResponse struct {
+	ID       string `json:"id" binding:"required"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON" binding:"required"`
+		AuthenticatorData string `json:"authenticatorData" binding:"required"`
+		Signature         string `json:"signature" binding:"required"`
+		UserHandle        string `json:"userHandle"`
+	} `json:"response" binding:"required"`
+}
+
+// WebAuthnService registers passkeys and verifies them for login and step-up
+type WebAuthnService struct {
+	DB *gorm.DB
+}
+
+// NewWebAuthnService creates a new WebAuthnService
+func NewWebAuthnService(db *gorm.DB) *WebAuthnService {
+	return &WebAuthnService{DB: db}
+}
+
+// BeginRegistration returns the options for registering a new credential for user
+func (s *WebAuthnService) BeginRegistration(user *models.User) (*CredentialCreationOptions, error) {
+	existing, err := s.credentialDescriptors(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	challenge, err := s.createChallenge(WebAuthnRegister, &user.ID, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	options := &CredentialCreationOptions{
+		Challenge:          challenge,
+		Timeout:            webAuthnTimeout.Milliseconds(),
+		ExcludeCredentials: existing,
+		Attestation:        "none",
+	}
+	options.RP.ID = config.AppConfig.WebAuthnRPID
+	options.RP.Name = config.AppConfig.WebAuthnRPName
+	options.User.ID = webAuthnUserHandle(user.ID)
+	options.User.Name = user.Email
+	options.User.DisplayName = user.Email
+	for _, alg := range []int64{COSEAlgES256, COSEAlgEdDSA, COSEAlgRS256} {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int64  `json:"alg"`
+		}{"public-key", alg})
+	}
+	// Discoverable credentials allow signing in without typing an email
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	options.AuthenticatorSelection.UserVerification = "preferred"
+	return options, nil
+}
+
+// FinishRegistration verifies a create() response and stores the credential
+func (s *WebAuthnService) FinishRegistration(userID uint, name string, response RegistrationResponse) (*models.WebAuthnCredential, error) {
+	clientDataJSON, err := decodeBase64URL(response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid clientDataJSON encoding", ErrWebAuthnVerification)
+	}
+	attestationObject, err := decodeBase64URL(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid attestationObject encoding", ErrWebAuthnVerification)
+	}
+
+	challenge, err := s.consumeChallenge(clientDataJSON, WebAuthnRegister)
+	if err != nil {
+		return nil, err
+	}
+	if challenge.UserID == nil || *challenge.UserID != userID {
+		return nil, ErrWebAuthnChallenge
+	}
+
+	verified, err := VerifyRegistration(challenge.Challenge, clientDataJSON, attestationObject)
+	if err != nil {
+		return nil, err
+	}
+
+	credential := &models.WebAuthnCredential{
+		UserID:       userID,
+		Name:         name,
+		CredentialID: base64.RawURLEncoding.EncodeToString(verified.CredentialID),
+		PublicKey:    verified.PublicKey,
+		Algorithm:    verified.Algorithm,
+		SignCount:    verified.SignCount,
+		AAGUID:       verified.AAGUID,
+	}
+	if err := s.DB.Create(credential).Error; err != nil {
+		return nil, err
+	}
+	return credential, nil
+}
+
+// BeginLogin returns options for a passkey login. No credentials are listed, so the
+// authenticator offers its discoverable credentials and no account is revealed.
+func (s *WebAuthnService) BeginLogin() (*CredentialRequestOptions, error) {
+	challenge, err := s.createChallenge(WebAuthnLogin, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialRequestOptions{
+		Challenge:        challenge,
+		RPID:             config.AppConfig.WebAuthnRPID,
+		Timeout:          webAuthnTimeout.Milliseconds(),
+		AllowCredentials: []PublicKeyCredentialDescriptor{},
+		UserVerification: "required",
+	}, nil
+}
+
+// FinishLogin verifies a passkey login and returns the credential used; its UserID is the
+// user signing in. User verification is required since the passkey is the only factor.
+func (s *WebAuthnService) FinishLogin(response AssertionResponse) (*models.WebAuthnCredential, error) {
+	return s.verifyAssertion(response, WebAuthnLogin, nil, nil, true)
+}
+
+// BeginStepUp returns options for re-authenticating the current session with one of the
+// user's credentials
+func (s *WebAuthnService) BeginStepUp(userID, sessionID uint) (*CredentialRequestOptions, error) {
+	allowed, err := s.credentialDescriptors(userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(allowed) == 0 {
+		return nil, ErrNoWebAuthnCredentials
+	}
+
+	challenge, err := s.createChallenge(WebAuthnStepUp, &userID, &sessionID)
+	if err != nil {
+		return nil, err
+	}
+	return &CredentialRequestOptions{
+		Challenge:        challenge,
+		RPID:             config.AppConfig.WebAuthnRPID,
+		Timeout:          webAuthnTimeout.Milliseconds(),
+		AllowCredentials: allowed,
+		UserVerification: "preferred",
+	}, nil
+}
+
+// FinishStepUp verifies a step-up assertion made by the user in the given session
+func (s *WebAuthnService) FinishStepUp(userID, sessionID uint, response AssertionResponse) (*models.WebAuthnCredential, error) {
+	return s.verifyAssertion(response, WebAuthnStepUp, &userID, &sessionID, false)
+}
+
+// ListCredentials returns a user's registered credentials
+func (s *WebAuthnService) ListCredentials(userID uint) ([]models.WebAuthnCredential, error) {
+	var credentials []models.WebAuthnCredential
+	err := s.DB.Where("user_id = ?", userID).Order("id").Find(&credentials).Error
+	return credentials, err
+}
+
+// DeleteCredential removes one of a user's credentials
+func (s *WebAuthnService) DeleteCredential(userID, credentialID uint) error {
+	result := s.DB.Where("id = ? AND user_id = ?", credentialID, userID).Delete(&models.WebAuthnCredential{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return nil
+}
+
+// verifyAssertion checks a get() response for a ceremony. userID and sessionID, when set,
+// must match the ones the challenge was issued to.
+func (s *WebAuthnService) verifyAssertion(response AssertionResponse, purpose string, userID, sessionID *uint, requireUV bool) (*models.WebAuthnCredential, error) {
+	clientDataJSON, err := decodeBase64URL(response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid clientDataJSON encoding", ErrWebAuthnVerification)
+	}
+	authData, err := decodeBase64URL(response.Response.AuthenticatorData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid authenticatorData encoding", ErrWebAuthnVerification)
+	}
+	signature, err := decodeBase64URL(response.Response.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid signature encoding", ErrWebAuthnVerification)
+	}
+
+	challenge, err := s.consumeChallenge(clientDataJSON, purpose)
+	if err != nil {
+		return nil, err
+	}
+	if !sameID(challenge.UserID, userID) || !sameID(challenge.SessionID, sessionID) {
+		return nil, ErrWebAuthnChallenge
+	}
+
+	var credential models.WebAuthnCredential
+	if err := s.DB.Where("credential_id = ?", normalizeBase64URL(response.ID)).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrUnknownCredential
+		}
+		return nil, err
+	}
+	if userID != nil && credential.UserID != *userID {
+		return nil, ErrUnknownCredential
+	}
+	if response.Response.UserHandle != "" && response.Response.UserHandle != webAuthnUserHandle(credential.UserID) {
+		return nil, fmt.Errorf("%w: user handle does not match the credential", ErrWebAuthnVerification)
+	}
+
+	count, err := VerifyAssertion(challenge.Challenge, credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature, requireUV)
+	if err != nil {
+		return nil, err
+	}
+
+	// The counter only moves forward: if a concurrent assertion already stored this count or a
+	// later one, this response is a replay or comes from a cloned key. Authenticators without a
+	// counter always report 0.
+	now := time.Now()
+	update := s.DB.Model(&models.WebAuthnCredential{}).Where("id = ?", credential.ID)
+	if count != 0 {
+		update = update.Where("sign_count < ?", count)
+	}
+	result := update.Updates(map[string]interface{}{"sign_count": count, "last_used_at": now})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, fmt.Errorf("%w: signature counter did not increase", ErrWebAuthnVerification)
+	}
+	credential.SignCount = count
+	credential.LastUsedAt = &now
+	return &credential, nil
+}
+
+func (s *WebAuthnService) credentialDescriptors(userID uint) ([]PublicKeyCredentialDescriptor, error) {
+	credentials, err := s.ListCredentials(userID)
+	if err != nil {
+		return nil, err
+	}
+	descriptors := make([]PublicKeyCredentialDescriptor, len(credentials))
+	for i, credential := range credentials {
+		descriptors[i] = PublicKeyCredentialDescriptor{Type: "public-key", ID: credential.CredentialID}
+	}
+	return descriptors, nil
+}
+
+// createChallenge stores a random single-use challenge for a ceremony
+func (s *WebAuthnService) createChallenge(purpose string, userID, sessionID *uint) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	challenge := base64.RawURLEncoding.EncodeToString(random)
+
+	// Drop expired challenges so abandoned ceremonies do not pile up
+	if err := s.DB.Where("expires_at < ?", time.Now()).Delete(&models.WebAuthnChallenge{}).Error; err != nil {
+		return "", err
+	}
+
+	row := &models.WebAuthnChallenge{
+		Challenge: challenge,
+		Purpose:   purpose,
+		UserID:    userID,
+		SessionID: sessionID,
+		ExpiresAt: time.Now().Add(webAuthnTimeout),
+	}
+	if err := s.DB.Create(row).Error; err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// consumeChallenge looks up and deletes the pending challenge signed in clientDataJSON
+func (s *WebAuthnService) consumeChallenge(clientDataJSON []byte, purpose string) (*models.WebAuthnChallenge, error) {
+	value, err := ClientDataChallenge(clientDataJSON)
+	if err != nil {
+		return nil, err
+	}
+
+	var challenge models.WebAuthnChallenge
+	err = s.DB.Where("challenge = ? AND purpose = ? AND expires_at > ?", value, purpose, time.Now()).First(&challenge).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrWebAuthnChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Only one request can claim a challenge
+	result := s.DB.Delete(&challenge)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrWebAuthnChallenge
+	}
+	return &challenge, nil
+}
+
+// webAuthnUserHandle is the opaque user.id given to authenticators
+func webAuthnUserHandle(userID uint) string {
+	handle := make([]byte, 8)
+	binary.BigEndian.PutUint64(handle, uint64(userID))
+	return base64.RawURLEncoding.EncodeToString(handle)
+}
+
+func normalizeBase64URL(value string) string {
+	decoded, err := decodeBase64URL(value)
+	if err != nil {
+		return value
+	}
+	return base64.RawURLEncoding.EncodeToString(decoded)
+}
+
+func sameID(a, b *uint) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
@@ -0,0 +1,300 @@
+package services
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// softAuthenticator is a P-256 WebAuthn authenticator implemented in software
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	rpID         string
+	origin       string
+	count        uint32
+	flags        byte
+}
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return &softAuthenticator{
+		key:          key,
+		credentialID: []byte("soft-credential-1"),
+		rpID:         "localhost",
+		origin:       "http://localhost:3000",
+		flags:        authDataUserPresent | authDataUserVerified,
+	}
+}
+
+// cborPair is a map entry for cborEncode; a slice keeps the encoding deterministic
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+// cborEncode covers the CBOR the authenticator emits: integers, byte and text strings, maps
+func cborEncode(item interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n <= 0xff:
+			return []byte{major<<5 | 24, byte(n)}
+		default:
+			return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+		}
+	}
+	switch v := item.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case []cborPair:
+		out := head(5, uint64(len(v)))
+		for _, pair := range v {
+			out = append(out, cborEncode(pair.key)...)
+			out = append(out, cborEncode(pair.value)...)
+		}
+		return out
+	}
+	panic("unsupported CBOR type")
+}
+
+func (a *softAuthenticator) coseKey() []byte {
+	x := make([]byte, 32)
+	y := make([]byte, 32)
+	a.key.X.FillBytes(x)
+	a.key.Y.FillBytes(y)
+	return cborEncode([]cborPair{{1, 2}, {3, COSEAlgES256}, {-1, 1}, {-2, x}, {-3, y}})
+}
+
+func (a *softAuthenticator) clientData(ceremony, challenge string) []byte {
+	data, _ := json.Marshal(collectedClientData{Type: ceremony, Challenge: challenge, Origin: a.origin})
+	return data
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append([]byte(nil), rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= authDataAttested
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.count)
+	if attested {
+		data = append(data, make([]byte, 16)...) // AAGUID
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.credentialID)))
+		data = append(data, a.credentialID...)
+		data = append(data, a.coseKey()...)
+	}
+	return data
+}
+
+// register answers navigator.credentials.create()
+func (a *softAuthenticator) register(challenge string) (clientDataJSON, attestationObject []byte) {
+	clientDataJSON = a.clientData("webauthn.create", challenge)
+	attestationObject = cborEncode([]cborPair{
+		{"fmt", "none"},
+		{"attStmt", []cborPair{}},
+		{"authData", a.authData(true)},
+	})
+	return clientDataJSON, attestationObject
+}
+
+// assert answers navigator.credentials.get(), bumping the signature counter
+func (a *softAuthenticator) assert(t *testing.T, challenge string) (clientDataJSON, authData, signature []byte) {
+	a.count++
+	clientDataJSON = a.clientData("webauthn.get", challenge)
+	authData = a.authData(false)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	digest := sha256.Sum256(append(append([]byte(nil), authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return clientDataJSON, authData, signature
+}
+
+func registeredSoftAuthenticator(t *testing.T) (*softAuthenticator, *VerifiedCredential) {
+	authenticator := newSoftAuthenticator(t)
+	clientDataJSON, attestationObject := authenticator.register("register-challenge")
+	credential, err := VerifyRegistration("register-challenge", clientDataJSON, attestationObject)
+	if err != nil {
+		t.Fatalf("Registration failed: %v", err)
+	}
+	return authenticator, credential
+}
+
+func TestWebAuthnRegistration(t *testing.T) {
+	authenticator, credential := registeredSoftAuthenticator(t)
+
+	if string(credential.CredentialID) != string(authenticator.credentialID) {
+		t.Errorf("Expected credential ID %q, got %q", authenticator.credentialID, credential.CredentialID)
+	}
+	if credential.Algorithm != COSEAlgES256 {
+		t.Errorf("Expected ES256, got %d", credential.Algorithm)
+	}
+	if credential.AAGUID != "00000000000000000000000000000000" {
+		t.Errorf("Unexpected AAGUID %q", credential.AAGUID)
+	}
+
+	clientDataJSON, attestationObject := authenticator.register("register-challenge")
+	if _, err := VerifyRegistration("other-challenge", clientDataJSON, attestationObject); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Errorf("Expected a challenge mismatch, got %v", err)
+	}
+
+	// An assertion cannot be replayed as a registration
+	getClientData := authenticator.clientData("webauthn.get", "register-challenge")
+	if _, err := VerifyRegistration("register-challenge", getClientData, attestationObject); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Errorf("Expected the ceremony type to be checked, got %v", err)
+	}
+}
+
+func TestWebAuthnAssertion(t *testing.T) {
+	authenticator, credential := registeredSoftAuthenticator(t)
+
+	clientDataJSON, authData, signature := authenticator.assert(t, "login-challenge")
+	count, err := VerifyAssertion("login-challenge", credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature, true)
+	if err != nil {
+		t.Fatalf("Assertion failed: %v", err)
+	}
+	if count != 1 {
+		t.Fatalf("Expected signature counter 1, got %d", count)
+	}
+
+	// Replaying the same response must fail because the counter did not move
+	if _, err := VerifyAssertion("login-challenge", credential.PublicKey, count, clientDataJSON, authData, signature, true); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("Expected a counter regression to be rejected, got %v", err)
+	}
+}
+
+func TestWebAuthnConcurrentAssertionRejected(t *testing.T) {
+	authenticator, credential := registeredSoftAuthenticator(t)
+	clientDataJSON, authData, signature := authenticator.assert(t, "login-challenge")
+
+	db, mock := newMockDB(t)
+	credentialID := base64.RawURLEncoding.EncodeToString(authenticator.credentialID)
+	mock.ExpectQuery(`FROM "web_authn_challenges"`).WillReturnRows(sqlmock.NewRows([]string{"id", "challenge", "purpose", "expires_at"}).
+		AddRow(1, "login-challenge", WebAuthnLogin, time.Now().Add(time.Minute)))
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "web_authn_challenges"`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectQuery(`FROM "web_authn_credentials"`).WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "credential_id", "public_key", "algorithm", "sign_count"}).
+		AddRow(3, 2, credentialID, credential.PublicKey, credential.Algorithm, 0))
+
+	// Another request stored this counter value between the read and the update
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE "web_authn_credentials" SET .* WHERE id = \$3 AND sign_count < \$4`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), 3, 1).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+
+	var response AssertionResponse
+	response.ID = credentialID
+	response.Response.ClientDataJSON = base64.RawURLEncoding.EncodeToString(clientDataJSON)
+	response.Response.AuthenticatorData = base64.RawURLEncoding.EncodeToString(authData)
+	response.Response.Signature = base64.RawURLEncoding.EncodeToString(signature)
+
+	service := NewWebAuthnService(db)
+	if _, err := service.verifyAssertion(response, WebAuthnLogin, nil, nil, true); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Errorf("Expected the assertion to be rejected, got %v", err)
+	}
+}
+
+func TestWebAuthnAssertionRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(a *softAuthenticator)
+		tamper func(clientDataJSON, authData, signature []byte) ([]byte, []byte, []byte)
+		uv     bool
+	}{
+		{name: "wrong origin", modify: func(a *softAuthenticator) { a.origin = "https://evil.example" }},
+		{name: "wrong relying party", modify: func(a *softAuthenticator) { a.rpID = "evil.example" }},
+		{name: "user not present", modify: func(a *softAuthenticator) { a.flags = 0 }},
+		{name: "user not verified", modify: func(a *softAuthenticator) { a.flags = authDataUserPresent }, uv: true},
+		{name: "bad signature", tamper: func(c, a, s []byte) ([]byte, []byte, []byte) {
+			s[len(s)-1] ^= 0xff
+			return c, a, s
+		}},
+		{name: "tampered authenticator data", tamper: func(c, a, s []byte) ([]byte, []byte, []byte) {
+			a[36]++ // Signature counter
+			return c, a, s
+		}},
+		{name: "different challenge", tamper: func(c, a, s []byte) ([]byte, []byte, []byte) {
+			var clientData collectedClientData
+			json.Unmarshal(c, &clientData)
+			clientData.Challenge = "other-challenge"
+			c, _ = json.Marshal(clientData)
+			return c, a, s
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authenticator, credential := registeredSoftAuthenticator(t)
+			if tt.modify != nil {
+				tt.modify(authenticator)
+			}
+			clientDataJSON, authData, signature := authenticator.assert(t, "challenge")
+			if tt.tamper != nil {
+				clientDataJSON, authData, signature = tt.tamper(clientDataJSON, authData, signature)
+			}
+
+			_, err := VerifyAssertion("challenge", credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature, tt.uv)
+			if !errors.Is(err, ErrWebAuthnVerification) {
+				t.Fatalf("Expected ErrWebAuthnVerification, got %v", err)
+			}
+		})
+	}
+}
+
+func TestWebAuthnUserVerificationOptional(t *testing.T) {
+	authenticator, credential := registeredSoftAuthenticator(t)
+	authenticator.flags = authDataUserPresent
+
+	// Step-up accepts a security key without a PIN; login does not
+	clientDataJSON, authData, signature := authenticator.assert(t, "challenge")
+	if _, err := VerifyAssertion("challenge", credential.PublicKey, credential.SignCount, clientDataJSON, authData, signature, false); err != nil {
+		t.Fatalf("Expected presence alone to be enough without requireUV, got %v", err)
+	}
+}
+
+func TestClientDataChallenge(t *testing.T) {
+	authenticator := newSoftAuthenticator(t)
+	challenge, err := ClientDataChallenge(authenticator.clientData("webauthn.get", "abc"))
+	if err != nil || challenge != "abc" {
+		t.Fatalf("Expected challenge abc, got %q (%v)", challenge, err)
+	}
+	if _, err := ClientDataChallenge([]byte("not json")); !errors.Is(err, ErrWebAuthnVerification) {
+		t.Fatalf("Expected malformed client data to be rejected, got %v", err)
+	}
+}
+
+func TestWebAuthnUserHandle(t *testing.T) {
+	handle, err := base64.RawURLEncoding.DecodeString(webAuthnUserHandle(258))
+	if err != nil || len(handle) != 8 || binary.BigEndian.Uint64(handle) != 258 {
+		t.Fatalf("Unexpected user handle %x (%v)", handle, err)
+	}
+	if normalizeBase64URL("c29mdA==") != "c29mdA" {
+		t.Fatal("Padded credential IDs should be normalized")
+	}
+}
@@ -0,0 +1,184 @@
+package utils
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// ErrCBOR is returned for malformed or unsupported CBOR input
+var ErrCBOR = errors.New("malformed CBOR")
+
+// cborMaxDepth bounds nesting so hostile input cannot exhaust the stack
+const cborMaxDepth = 16
+
+// DecodeCBOR decodes the first CBOR (RFC 8949) data item in data and returns it with the
+// bytes that follow it. It covers what WebAuthn needs: integers (as int64), byte and text
+// strings, arrays, maps (map[interface{}]interface{} keyed by int64 or string), booleans,
+// null and floats. Tags are skipped; indefinite lengths are rejected.
+func DecodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeCBOR(data, 0)
+}
+
+func decodeCBOR(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > cborMaxDepth {
+		return nil, nil, fmt.Errorf("%w: nested too deeply", ErrCBOR)
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("%w: unexpected end of input", ErrCBOR)
+	}
+
+	major := data[0] >> 5
+	info := data[0] & 0x1f
+	data = data[1:]
+
+	if major == 7 {
+		return decodeCBORSimple(info, data)
+	}
+
+	arg, data, err := cborArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch major {
+	case 0: // Unsigned integer
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrCBOR)
+		}
+		return int64(arg), data, nil
+
+	case 1: // Negative integer -1-arg
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("%w: integer overflow", ErrCBOR)
+		}
+		return -1 - int64(arg), data, nil
+
+	case 2, 3: // Byte string, text string
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: string exceeds input", ErrCBOR)
+		}
+		value := data[:arg]
+		if major == 3 {
+			return string(value), data[arg:], nil
+		}
+		return append([]byte(nil), value...), data[arg:], nil
+
+	case 4: // Array
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: array exceeds input", ErrCBOR)
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeCBOR(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+
+	case 5: // Map
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("%w: map exceeds input", ErrCBOR)
+		}
+		entries := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, data, err = decodeCBOR(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("%w: unsupported map key type %T", ErrCBOR, key)
+			}
+			if value, data, err = decodeCBOR(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, data, nil
+
+	default: // 6: Tag; the tagged item is returned as is
+		return decodeCBOR(data, depth+1)
+	}
+}
+
+// cborArgument reads the length or value that follows an initial byte
+func cborArgument(info byte, data []byte) (uint64, []byte, error) {
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, nil, fmt.Errorf("%w: unsupported additional information %d", ErrCBOR, info)
+	}
+	if len(data) < size {
+		return 0, nil, fmt.Errorf("%w: unexpected end of input", ErrCBOR)
+	}
+
+	var arg uint64
+	for _, b := range data[:size] {
+		arg = arg<<8 | uint64(b)
+	}
+	return arg, data[size:], nil
+}
+
+func decodeCBORSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23: // null, undefined
+		return nil, data, nil
+	case 25:
+		if len(data) < 2 {
+			return nil, nil, fmt.Errorf("%w: unexpected end of input", ErrCBOR)
+		}
+		return float16ToFloat64(binary.BigEndian.Uint16(data)), data[2:], nil
+	case 26:
+		if len(data) < 4 {
+			return nil, nil, fmt.Errorf("%w: unexpected end of input", ErrCBOR)
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[4:], nil
+	case 27:
+		if len(data) < 8 {
+			return nil, nil, fmt.Errorf("%w: unexpected end of input", ErrCBOR)
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(data)), data[8:], nil
+	default:
+		return nil, nil, fmt.Errorf("%w: unsupported simple value %d", ErrCBOR, info)
+	}
+}
+
+func float16ToFloat64(h uint16) float64 {
+	sign := 1.0
+	if h&0x8000 != 0 {
+		sign = -1
+	}
+	exp := int(h>>10) & 0x1f
+	frac := float64(h & 0x3ff)
+
+	switch exp {
+	case 0:
+		return sign * math.Ldexp(frac, -24)
+	case 31:
+		if frac == 0 {
+			return sign * math.Inf(1)
+		}
+		return math.NaN()
+	default:
+		return sign * math.Ldexp(frac+1024, exp-25)
+	}
+}
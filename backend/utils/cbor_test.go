@@ -0,0 +1,77 @@
+package utils
+
+import (
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeCBOR(t *testing.T) {
+	// Examples from RFC 8949 appendix A
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1a000f4240", int64(1000000)},
+		{"20", int64(-1)},
+		{"3863", int64(-100)},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f93c00", 1.0},
+		{"fb3ff199999999999a", 1.1},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6449455446", "IETF"},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"a201020304", map[interface{}]interface{}{int64(1): int64(2), int64(3): int64(4)}},
+		{"a26161016162820203", map[interface{}]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"c074323031332d30332d32315432303a30343a30305a", "2013-03-21T20:04:00Z"}, // Tagged
+	}
+
+	for _, tt := range tests {
+		data, _ := hex.DecodeString(tt.hex)
+		got, rest, err := DecodeCBOR(data)
+		if err != nil {
+			t.Errorf("%s: %v", tt.hex, err)
+			continue
+		}
+		if len(rest) != 0 {
+			t.Errorf("%s: %d trailing bytes", tt.hex, len(rest))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %#v, want %#v", tt.hex, got, tt.want)
+		}
+	}
+}
+
+func TestDecodeCBORRest(t *testing.T) {
+	data, _ := hex.DecodeString("a1010203")
+	_, rest, err := DecodeCBOR(data)
+	if err != nil {
+		t.Fatalf("Failed to decode: %v", err)
+	}
+	if !reflect.DeepEqual(rest, []byte{0x03}) {
+		t.Fatalf("Expected the byte after the map to be returned, got %x", rest)
+	}
+}
+
+func TestDecodeCBORMalformed(t *testing.T) {
+	for _, input := range []string{
+		"",           // Empty
+		"19",         // Truncated argument
+		"45010203",   // Byte string longer than the input
+		"9f01ff",     // Indefinite-length array
+		"a1f501",     // Boolean map key
+		"9a7fffffff", // Huge array length
+	} {
+		data, _ := hex.DecodeString(input)
+		if _, _, err := DecodeCBOR(data); !errors.Is(err, ErrCBOR) {
+			t.Errorf("%q: expected ErrCBOR, got %v", input, err)
+		}
+	}
+}
@@ -1,17 +1,103 @@
 package utils
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// HashPassword generates a bcrypt hash of the password
-func HashPassword(password string) (string, error) {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14) // 14 is the cost factor
-	return string(bytes), err
+// Password hashing algorithms
+const (
+	PasswordAlgorithmArgon2id = "argon2id"
+	PasswordAlgorithmBcrypt   = "bcrypt"
+)
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+// PasswordParams selects the algorithm and cost of new password hashes. Stored hashes made
+// with other parameters still verify, and are reported as needing a rehash.
+type PasswordParams struct {
+	Algorithm   string
+	Memory      uint32 // Argon2id memory in KiB
+	Iterations  uint32 // Argon2id passes over the memory
+	Parallelism uint8  // Argon2id lanes
+	BcryptCost  int
+}
+
+// HashPassword hashes a password into a PHC string, e.g.
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>, or a bcrypt hash ($2a$...)
+func HashPassword(password string, params PasswordParams) (string, error) {
+	switch params.Algorithm {
+	case PasswordAlgorithmArgon2id:
+		salt := make([]byte, argon2SaltLength)
+		if _, err := rand.Read(salt); err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, argon2KeyLength)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations,
+			params.Parallelism, base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+	case PasswordAlgorithmBcrypt:
+		// bcrypt only uses the first 72 bytes of a password
+		bytes, err := bcrypt.GenerateFromPassword([]byte(password), params.BcryptCost)
+		return string(bytes), err
+	}
+	return "", fmt.Errorf("unknown password hash algorithm %q", params.Algorithm)
+}
+
+// CheckPasswordHash compares a plaintext password with a stored hash of either algorithm.
+// needsRehash reports that the password matched but the hash was not made with params, so
+// it should be replaced by a fresh hash.
+func CheckPasswordHash(password, hash string, params PasswordParams) (match bool, needsRehash bool) {
+	if strings.HasPrefix(hash, "$argon2id$") {
+		hashParams, salt, key, ok := parseArgon2Hash(hash)
+		if !ok {
+			return false, false
+		}
+		computed := argon2.IDKey([]byte(password), salt, hashParams.Iterations, hashParams.Memory, hashParams.Parallelism, uint32(len(key)))
+		if subtle.ConstantTimeCompare(computed, key) != 1 {
+			return false, false
+		}
+		stale := params.Algorithm != PasswordAlgorithmArgon2id || hashParams.Memory != params.Memory ||
+			hashParams.Iterations != params.Iterations || hashParams.Parallelism != params.Parallelism ||
+			len(key) != argon2KeyLength
+		return true, stale
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)); err != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(hash))
+	return true, err != nil || params.Algorithm != PasswordAlgorithmBcrypt || cost != params.BcryptCost
 }
 
-// CheckPasswordHash compares a plaintext password with a hash
-func CheckPasswordHash(password, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
-	return err == nil // Returns true if hash matches
+// parseArgon2Hash splits an Argon2id PHC string into its parameters, salt and key
+func parseArgon2Hash(hash string) (PasswordParams, []byte, []byte, bool) {
+	parts := strings.Split(hash, "$")
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	if len(parts) != 6 || parts[2] != fmt.Sprintf("v=%d", argon2.Version) {
+		return PasswordParams{}, nil, nil, false
+	}
+
+	params := PasswordParams{Algorithm: PasswordAlgorithmArgon2id}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return PasswordParams{}, nil, nil, false
+	}
+	if params.Iterations == 0 || params.Parallelism == 0 {
+		return PasswordParams{}, nil, nil, false
+	}
+
+	salt, errSalt := base64.RawStdEncoding.DecodeString(parts[4])
+	key, errKey := base64.RawStdEncoding.DecodeString(parts[5])
+	if errSalt != nil || errKey != nil || len(key) == 0 {
+		return PasswordParams{}, nil, nil, false
+	}
+	return params, salt, key, true
 }
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+// Cheap parameters keep the tests fast
+var testArgon2Params = PasswordParams{Algorithm: PasswordAlgorithmArgon2id, Memory: 1024, Iterations: 1, Parallelism: 1, BcryptCost: 4}
+
+func TestArgon2idHash(t *testing.T) {
+	hash, err := HashPassword("correct horse battery staple", testArgon2Params)
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Fatalf("Unexpected PHC string %q", hash)
+	}
+
+	if match, needsRehash := CheckPasswordHash("correct horse battery staple", hash, testArgon2Params); !match || needsRehash {
+		t.Errorf("Got match=%v needsRehash=%v, want a current match", match, needsRehash)
+	}
+	if match, _ := CheckPasswordHash("correct horse battery stable", hash, testArgon2Params); match {
+		t.Error("Expected a wrong password not to match")
+	}
+
+	stronger := testArgon2Params
+	stronger.Iterations = 2
+	if match, needsRehash := CheckPasswordHash("correct horse battery staple", hash, stronger); !match || !needsRehash {
+		t.Errorf("Got match=%v needsRehash=%v, want a match needing a rehash for new parameters", match, needsRehash)
+	}
+}
+
+func TestArgon2idLongPasswords(t *testing.T) {
+	// bcrypt ignores everything after 72 bytes; Argon2id must not
+	prefix := strings.Repeat("a", 72)
+	hash, err := HashPassword(prefix+"1", testArgon2Params)
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+	if match, _ := CheckPasswordHash(prefix+"2", hash, testArgon2Params); match {
+		t.Error("Expected passwords differing after 72 bytes not to match")
+	}
+}
+
+func TestBcryptHashNeedsRehash(t *testing.T) {
+	bcryptParams := testArgon2Params
+	bcryptParams.Algorithm = PasswordAlgorithmBcrypt
+	hash, err := HashPassword("hunter22", bcryptParams)
+	if err != nil {
+		t.Fatalf("Failed to hash: %v", err)
+	}
+
+	if match, needsRehash := CheckPasswordHash("hunter22", hash, bcryptParams); !match || needsRehash {
+		t.Errorf("Got match=%v needsRehash=%v with bcrypt configured, want a current match", match, needsRehash)
+	}
+	if match, needsRehash := CheckPasswordHash("hunter22", hash, testArgon2Params); !match || !needsRehash {
+		t.Errorf("Got match=%v needsRehash=%v with Argon2id configured, want a match needing a rehash", match, needsRehash)
+	}
+	if match, needsRehash := CheckPasswordHash("hunter23", hash, testArgon2Params); match || needsRehash {
+		t.Errorf("Got match=%v needsRehash=%v for a wrong password, want neither", match, needsRehash)
+	}
+}
+
+func TestMalformedArgon2idHashes(t *testing.T) {
+	hashes := []string{
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdA",
+		"$argon2id$v=16$m=1024,t=1,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=1024,t=0,p=1$c2FsdHNhbHQ$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$not base64$a2V5",
+		"$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHQ$",
+		"",
+	}
+	for _, hash := range hashes {
+		if match, _ := CheckPasswordHash("password", hash, testArgon2Params); match {
+			t.Errorf("Expected %q not to match", hash)
+		}
+	}
+}
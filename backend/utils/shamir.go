@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"crypto/rand"
+	"errors"
+)
+
+// SplitSecret splits secret into n shares, any k of which can rebuild it (Shamir's scheme over GF(256)).
+// Each share is len(secret)+1 bytes: the evaluated polynomial bytes followed by the x coordinate.
+func SplitSecret(secret []byte, n, k int) ([][]byte, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("secret must not be empty")
+	}
+	if k < 2 || n < k || n > 255 {
+		return nil, errors.New("shares must satisfy 2 <= threshold <= shares <= 255")
+	}
+
+	shares := make([][]byte, n)
+	for i := range shares {
+		shares[i] = make([]byte, len(secret)+1)
+		shares[i][len(secret)] = byte(i + 1) // x coordinates 1..n; x=0 would reveal the secret
+	}
+
+	// One random polynomial of degree k-1 per secret byte, with the byte as constant term
+	coeffs := make([]byte, k)
+	for b, s := range secret {
+		coeffs[0] = s
+		if _, err := rand.Read(coeffs[1:]); err != nil {
+			return nil, err
+		}
+		for i := range shares {
+			shares[i][b] = evalPolynomial(coeffs, byte(i+1))
+		}
+	}
+
+	return shares, nil
+}
+
+// CombineShares rebuilds a secret from at least threshold shares produced by SplitSecret.
+// Passing fewer shares than the threshold silently yields a wrong secret, so callers must verify it.
+func CombineShares(shares [][]byte) ([]byte, error) {
+	if len(shares) < 2 {
+		return nil, errors.New("at least two shares are required")
+	}
+
+	size := len(shares[0])
+	if size < 2 {
+		return nil, errors.New("share too short")
+	}
+	xs := make([]byte, len(shares))
+	seen := make(map[byte]bool, len(shares))
+	for i, share := range shares {
+		if len(share) != size {
+			return nil, errors.New("shares have different lengths")
+		}
+		x := share[size-1]
+		if x == 0 || seen[x] {
+			return nil, errors.New("duplicate or invalid share")
+		}
+		seen[x] = true
+		xs[i] = x
+	}
+
+	// Lagrange interpolation at x=0 for every byte
+	secret := make([]byte, size-1)
+	for b := range secret {
+		var value byte
+		for i, share := range shares {
+			basis := byte(1)
+			for j := range shares {
+				if i == j {
+					continue
+				}
+				// basis *= x_j / (x_j - x_i); subtraction is XOR in GF(256)
+				basis = gfMul(basis, gfDiv(xs[j], xs[j]^xs[i]))
+			}
+			value ^= gfMul(share[b], basis)
+		}
+		secret[b] = value
+	}
+
+	return secret, nil
+}
+
+// evalPolynomial evaluates coeffs at x using Horner's method
+func evalPolynomial(coeffs []byte, x byte) byte {
+	var result byte
+	for i := len(coeffs) - 1; i >= 0; i-- {
+		result = gfMul(result, x) ^ coeffs[i]
+	}
+	return result
+}
+
+// gfMul multiplies in GF(2^8) with the AES polynomial x^8 + x^4 + x^3 + x + 1
+func gfMul(a, b byte) byte {
+	var product byte
+	for b > 0 {
+		if b&1 == 1 {
+			product ^= a
+		}
+		carry := a & 0x80
+		a <<= 1
+		if carry != 0 {
+			a ^= 0x1b
+		}
+		b >>= 1
+	}
+	return product
+}
+
+// gfDiv divides in GF(2^8); b must be non-zero
+func gfDiv(a, b byte) byte {
+	// b^254 is the multiplicative inverse of b
+	inverse := byte(1)
+	for i := 0; i < 254; i++ {
+		inverse = gfMul(inverse, b)
+	}
+	return gfMul(a, inverse)
+}
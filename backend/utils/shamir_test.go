@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSplitCombineSecret(t *testing.T) {
+	secret := []byte("0123456789abcdef0123456789abcdef")
+
+	shares, err := SplitSecret(secret, 5, 3)
+	if err != nil {
+		t.Fatalf("Failed to split secret: %v", err)
+	}
+	if len(shares) != 5 {
+		t.Fatalf("Expected 5 shares, got %d", len(shares))
+	}
+
+	// Any 3 shares rebuild the secret
+	for _, subset := range [][]int{{0, 1, 2}, {4, 2, 0}, {1, 3, 4}, {0, 1, 2, 3, 4}} {
+		var picked [][]byte
+		for _, i := range subset {
+			picked = append(picked, shares[i])
+		}
+		combined, err := CombineShares(picked)
+		if err != nil {
+			t.Fatalf("Failed to combine shares %v: %v", subset, err)
+		}
+		if !bytes.Equal(combined, secret) {
+			t.Fatalf("Shares %v rebuilt the wrong secret", subset)
+		}
+	}
+
+	// Fewer shares than the threshold do not
+	combined, err := CombineShares([][]byte{shares[0], shares[1]})
+	if err == nil && bytes.Equal(combined, secret) {
+		t.Fatal("Two shares should not rebuild a threshold-3 secret")
+	}
+
+	if _, err := CombineShares([][]byte{shares[0], shares[0]}); err == nil {
+		t.Fatal("Expected error for duplicate shares")
+	}
+}
+
+func TestSplitSecretInvalidParameters(t *testing.T) {
+	if _, err := SplitSecret([]byte("key"), 3, 4); err == nil {
+		t.Fatal("Expected error when threshold exceeds shares")
+	}
+	if _, err := SplitSecret([]byte("key"), 3, 1); err == nil {
+		t.Fatal("Expected error for threshold below 2")
+	}
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHOTPVectors(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1 with 8 digits
+	key := []byte("12345678901234567890")
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		counter := TOTPCounter(time.Unix(v.unix, 0))
+		if got := HOTP(key, counter, 8); got != v.code {
+			t.Errorf("T=%d: got %s, want %s", v.unix, got, v.code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("Failed to generate secret: %v", err)
+	}
+	key, _ := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+
+	now := time.Unix(1700000000, 0)
+	code := HOTP(key, TOTPCounter(now), TOTPDigits)
+
+	counter, ok, err := ValidateTOTP(secret, code, now, 1)
+	if err != nil || !ok || counter != TOTPCounter(now) {
+		t.Fatalf("Current code should validate: ok=%v counter=%d err=%v", ok, counter, err)
+	}
+
+	// One step of drift is tolerated, two are not
+	if _, ok, _ := ValidateTOTP(secret, code, now.Add(TOTPPeriod), 1); !ok {
+		t.Fatal("Code from the previous step should validate with skew 1")
+	}
+	if _, ok, _ := ValidateTOTP(secret, code, now.Add(2*TOTPPeriod), 1); ok {
+		t.Fatal("Code from two steps ago should not validate")
+	}
+	if _, ok, _ := ValidateTOTP(secret, HOTP(key, TOTPCounter(now)+5, TOTPDigits), now, 1); ok {
+		t.Fatal("Code from another time step should not validate")
+	}
+	if _, ok, _ := ValidateTOTP(secret, code[:5], now, 1); ok {
+		t.Fatal("Short code should not validate")
+	}
+}
+
+func TestTOTPProvisioningURI(t *testing.T) {
+	uri := TOTPProvisioningURI("CipherSafe", "alice@example.com", "JBSWY3DPEHPK3PXP")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/CipherSafe:alice@example.com?") {
+		t.Fatalf("Unexpected URI label: %s", uri)
+	}
+	for _, part := range []string{"secret=JBSWY3DPEHPK3PXP", "issuer=CipherSafe", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("URI should contain %s: %s", part, uri)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import { useAuthStore } from '@/stores/authStore';
 import { useRouter } from 'next/navigation';
 import { Loader2, Lock } from 'lucide-react';
 import toast from 'react-hot-toast';
+import api from '@/services/api';
 
 export default function DashboardLayout({
   children,
@@ -20,7 +21,13 @@ export default function DashboardLayout({
     }
   }, [isAuthenticated, router]);
 
-  const handleLogout = () => {
+  const handleLogout = async () => {
+    try {
+      // Revoke the session on the server so its tokens stop working
+      await api.post('/auth/logout');
+    } catch {
+      // Sign out locally even if the server cannot be reached
+    }
     logout();
     toast.success('Logged out');
     router.push('/login');
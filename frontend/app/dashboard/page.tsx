@@ -20,6 +20,14 @@ interface Secret {
   key: string;
   value: string; 
   project_id: number;
+  environment: string;
+}
+
+interface Environment {
+  id: number;
+  name: string;
+  read_role: string;
+  write_role: string;
 }
 
 interface Project {
@@ -35,6 +43,8 @@ export default function DashboardPage() {
   const [projects, setProjects] = useState<Project[]>([]);
   const [secrets, setSecrets] = useState<Secret[]>([]);
   const [selectedProject, setSelectedProject] = useState<Project | null>(null);
+  const [environments, setEnvironments] = useState<Environment[]>([]);
+  const [selectedEnvironment, setSelectedEnvironment] = useState<string | null>(null);
   const [isLoadingProjects, setIsLoadingProjects] = useState(true);
   const [isLoadingSecrets, setIsLoadingSecrets] = useState(false);
   const [visibleSecrets, setVisibleSecrets] = useState<Record<number, boolean>>({});
@@ -60,19 +70,38 @@ export default function DashboardPage() {
     }
   };
 
-  const fetchSecrets = async (projectId: number) => {
+  const fetchSecrets = async (projectId: number, environment: string) => {
     setIsLoadingSecrets(true);
     setSecrets([]); // Clear old secrets
     try {
-      const response = await api.get(`/api/projects/${projectId}/secrets`);
+      const response = await api.get(`/api/projects/${projectId}/secrets`, {
+        params: { environment },
+      });
       setSecrets(response.data || []);
-    } catch (error) {
-      toast.error('Failed to load secrets');
+    } catch (error: any) {
+      toast.error(error.response?.data?.error || 'Failed to load secrets');
     } finally {
       setIsLoadingSecrets(false);
     }
   };
 
+  const fetchEnvironments = async (projectId: number) => {
+    setEnvironments([]);
+    setSelectedEnvironment(null);
+    setSecrets([]);
+    try {
+      const response = await api.get(`/api/projects/${projectId}/environments`);
+      const list: Environment[] = response.data || [];
+      setEnvironments(list);
+      if (list.length > 0) {
+        setSelectedEnvironment(list[0].name);
+        fetchSecrets(projectId, list[0].name);
+      }
+    } catch (error) {
+      toast.error('Failed to load environments');
+    }
+  };
+
   useEffect(() => {
     fetchProjects();
   }, []);
@@ -81,7 +110,14 @@ export default function DashboardPage() {
   const handleProjectSelect = (project: Project) => {
     setSelectedProject(project);
     setVisibleSecrets({}); // Reset visibility
-    fetchSecrets(project.ID);
+    fetchEnvironments(project.ID);
+  };
+
+  const handleEnvironmentSelect = (environment: string) => {
+    if (!selectedProject) return;
+    setSelectedEnvironment(environment);
+    setVisibleSecrets({});
+    fetchSecrets(selectedProject.ID, environment);
   };
 
   const toggleSecretVisibility = (secretId: number) => {
@@ -112,16 +148,17 @@ export default function DashboardPage() {
 
   const handleCreateSecret = async (e: FormEvent) => {
     e.preventDefault();
-    if (!selectedProject) return;
+    if (!selectedProject || !selectedEnvironment) return;
 
     try {
       await api.post('/api/secrets', {
         project_id: selectedProject.ID,
+        environment: selectedEnvironment,
         key: newSecretKey,
         value: newSecretValue,
       });
       toast.success('Secret created!');
-      fetchSecrets(selectedProject.ID); // Refresh list
+      fetchSecrets(selectedProject.ID, selectedEnvironment); // Refresh list
       setNewSecretKey('');
       setNewSecretValue('');
       setIsSecretModalOpen(false);
@@ -191,11 +228,27 @@ export default function DashboardPage() {
               <h2 className="text-xl font-semibold">{selectedProject.name}</h2>
               <button
                 onClick={() => setIsSecretModalOpen(true)}
-                className="p-2 bg-blue-600 rounded-md hover:bg-blue-700 flex items-center gap-2 text-sm px-3"
+                disabled={!selectedEnvironment}
+                className="p-2 bg-blue-600 rounded-md hover:bg-blue-700 flex items-center gap-2 text-sm px-3 disabled:opacity-50"
               >
                 <Plus className="h-4 w-4" /> Add Secret
               </button>
             </div>
+            <div className="flex gap-2 mb-4">
+              {environments.map((environment) => (
+                <button
+                  key={environment.id}
+                  onClick={() => handleEnvironmentSelect(environment.name)}
+                  className={`px-3 py-1 rounded-md text-sm ${
+                    selectedEnvironment === environment.name
+                      ? 'bg-blue-700 font-bold'
+                      : 'bg-gray-800 hover:bg-gray-700'
+                  }`}
+                >
+                  {environment.name}
+                </button>
+              ))}
+            </div>
             {isLoadingSecrets ? (
               <div className="flex justify-center p-4">
                 <Loader2 className="animate-spin" />
@@ -295,7 +348,7 @@ export default function DashboardPage() {
             onSubmit={handleCreateSecret}
             className="bg-gray-900 p-6 rounded-lg shadow-xl w-full max-w-md"
           >
-            <h3 className="text-lg font-bold mb-4">Add Secret</h3>
+            <h3 className="text-lg font-bold mb-4">Add Secret to {selectedEnvironment}</h3>
             <div className="mb-4">
               <label className="block text-sm mb-1">Key</label>
               <input
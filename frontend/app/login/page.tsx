@@ -1,6 +1,6 @@
 'use client';
 
-import { useState, FormEvent } from 'react';
+import { useState, useEffect, FormEvent } from 'react';
 import { useRouter } from 'next/navigation';
 import { useAuthStore } from '@/stores/authStore';
 import api from '@/services/api';
@@ -12,16 +12,68 @@ export default function LoginPage() {
   const [email, setEmail] = useState('');
   const [password, setPassword] = useState('');
   const [isLoading, setIsLoading] = useState(false);
-  const setToken = useAuthStore((state) => state.setToken);
+  // Second step for accounts with two-factor authentication
+  const [mfaToken, setMfaToken] = useState<string | null>(null);
+  const [mfaSecret, setMfaSecret] = useState<string | null>(null); // Set while enrolling during login
+  const [code, setCode] = useState('');
+  const [recoveryCodes, setRecoveryCodes] = useState<string[] | null>(null);
+  const [methods, setMethods] = useState({ password: true, oidc: false });
+  const setSession = useAuthStore((state) => state.setSession);
   const router = useRouter();
 
+  useEffect(() => {
+    api.get('/auth/methods').then((response) => setMethods(response.data)).catch(() => {});
+
+    // Single sign-on returns here with the login result in the URL fragment
+    const result = new URLSearchParams(window.location.hash.slice(1));
+    if (!result.toString()) {
+      return;
+    }
+    window.history.replaceState(null, '', window.location.pathname);
+    if (result.get('error')) {
+      toast.error(result.get('error')!);
+    } else if (result.get('mfa_required') === 'true') {
+      const token = result.get('mfa_token')!;
+      setMfaToken(token);
+      if (result.get('mfa_enrollment_required') === 'true') {
+        api.post('/auth/mfa/enroll', { mfa_token: token })
+          .then((enrollment) => setMfaSecret(enrollment.data.secret))
+          .catch(() => toast.error('Failed to start two-factor enrollment'));
+      }
+    } else if (result.get('token') && result.get('refresh_token')) {
+      setSession(result.get('token')!, result.get('refresh_token')!);
+      toast.success('Logged in successfully!');
+      router.push('/dashboard');
+    }
+  }, [router, setSession]);
+
   const handleSubmit = async (e: FormEvent) => {
     e.preventDefault();
     setIsLoading(true);
     try {
-      const response = await api.post('/auth/login', { email, password });
-      const { token } = response.data;
-      setToken(token);
+      if (mfaToken) {
+        const path = mfaSecret ? '/auth/mfa/enroll/confirm' : '/auth/mfa/verify';
+        const response = await api.post(path, { mfa_token: mfaToken, code });
+        const { token, refresh_token, recovery_codes } = response.data;
+        setSession(token, refresh_token);
+        if (recovery_codes) {
+          // Shown once; the user continues after saving them
+          setRecoveryCodes(recovery_codes);
+          return;
+        }
+      } else {
+        const response = await api.post('/auth/login', { email, password });
+        if (response.data.mfa_required) {
+          setMfaToken(response.data.mfa_token);
+          if (response.data.mfa_enrollment_required) {
+            const enrollment = await api.post('/auth/mfa/enroll', { mfa_token: response.data.mfa_token });
+            setMfaSecret(enrollment.data.secret);
+          }
+          return;
+        }
+        const { token, refresh_token } = response.data;
+        setSession(token, refresh_token);
+      }
       toast.success('Logged in successfully!');
       router.push('/dashboard'); 
     } catch (error: any) {
@@ -32,6 +84,30 @@ export default function LoginPage() {
     }
   };
 
+  if (recoveryCodes) {
+    return (
+      <div className="flex items-center justify-center min-h-screen">
+        <div className="p-8 bg-gray-900 rounded-lg shadow-xl w-full max-w-sm">
+          <h1 className="text-2xl font-bold mb-4 text-center">Save your recovery codes</h1>
+          <p className="text-sm mb-4 text-gray-400">
+            Each code signs you in once if you lose your authenticator. They will not be shown again.
+          </p>
+          <ul className="mb-6 font-mono grid grid-cols-2 gap-2">
+            {recoveryCodes.map((recoveryCode) => (
+              <li key={recoveryCode}>{recoveryCode}</li>
+            ))}
+          </ul>
+          <button
+            onClick={() => router.push('/dashboard')}
+            className="w-full p-3 bg-blue-600 rounded-md font-bold hover:bg-blue-700"
+          >
+            Continue
+          </button>
+        </div>
+      </div>
+    );
+  }
+
   return (
     <div className="flex items-center justify-center min-h-screen">
       <form
@@ -39,7 +115,29 @@ export default function LoginPage() {
         className="p-8 bg-gray-900 rounded-lg shadow-xl w-full max-w-sm"
       >
         <h1 className="text-3xl font-bold mb-6 text-center">CipherSafe Login</h1>
-        
+
+        {mfaToken ? (
+        <div className="mb-6">
+          {mfaSecret && (
+            <p className="text-sm mb-4 text-gray-400">
+              Your organization requires two-factor authentication. Add this key to your
+              authenticator app: <span className="font-mono break-all text-white">{mfaSecret}</span>
+            </p>
+          )}
+          <label className="block mb-2 text-sm font-medium">
+            {mfaSecret ? 'Code from your authenticator app' : 'Authentication code or recovery code'}
+          </label>
+          <input
+            type="text"
+            autoComplete="one-time-code"
+            value={code}
+            onChange={(e) => setCode(e.target.value)}
+            className="w-full p-3 bg-gray-800 border border-gray-700 rounded-md"
+            required
+          />
+        </div>
+        ) : methods.password && (
+        <>
         <div className="mb-4">
           <label className="block mb-2 text-sm font-medium">Email</label>
           <input
@@ -61,21 +159,36 @@ export default function LoginPage() {
             required
           />
         </div>
+        </>
+        )}
 
+        {(mfaToken || methods.password) && (
         <button
           type="submit"
           disabled={isLoading}
           className="w-full p-3 bg-blue-600 rounded-md font-bold hover:bg-blue-700 disabled:bg-gray-500 flex items-center justify-center"
         >
-          {isLoading ? <Loader2 className="animate-spin" /> : 'Login'}
+          {isLoading ? <Loader2 className="animate-spin" /> : mfaToken ? 'Verify' : 'Login'}
         </button>
-        
+        )}
+
+        {!mfaToken && methods.oidc && (
+          <a
+            href={`${process.env.NEXT_PUBLIC_API_URL}/auth/oidc/login`}
+            className="mt-4 w-full p-3 bg-gray-700 rounded-md font-bold hover:bg-gray-600 flex items-center justify-center"
+          >
+            Sign in with SSO
+          </a>
+        )}
+
+        {methods.password && (
         <p className="text-center text-sm mt-4">
           No account?{' '}
           <Link href="/register" className="text-blue-400 hover:underline">
             Register here
           </Link>
         </p>
+        )}
       </form>
     </div>
   );
@@ -1,4 +1,4 @@
-import axios from 'axios';
+import axios, { AxiosError, InternalAxiosRequestConfig } from 'axios';
 import { useAuthStore } from '@/stores/authStore';
 
 const api = axios.create({
@@ -20,10 +20,40 @@ api.interceptors.request.use(
   }
 );
 
+// Refresh tokens are single-use, so concurrent 401s share one refresh request
+let refreshing: Promise<string> | null = null;
+
+const refreshAccessToken = async (): Promise<string> => {
+  const { refreshToken, setSession } = useAuthStore.getState();
+  if (!refreshToken) {
+    throw new Error('No refresh token');
+  }
+  const response = await axios.post(`${process.env.NEXT_PUBLIC_API_URL}/auth/refresh`, {
+    refresh_token: refreshToken,
+  });
+  setSession(response.data.token, response.data.refresh_token);
+  return response.data.token;
+};
+
 // Handle 401 Unauthorized errors
 api.interceptors.response.use(
   (response) => response, 
-  (error) => {
+  async (error: AxiosError) => {
+    const original = error.config as (InternalAxiosRequestConfig & { _retried?: boolean }) | undefined;
+    if (error.response?.status === 401 && original && !original._retried && !original.url?.startsWith('/auth/')) {
+      // The access token expired; get a new one and retry once
+      original._retried = true;
+      try {
+        refreshing = refreshing ?? refreshAccessToken();
+        const token = await refreshing;
+        original.headers.Authorization = `Bearer ${token}`;
+        return api(original);
+      } catch {
+        // Fall through to logging out
+      } finally {
+        refreshing = null;
+      }
+    }
     if (error.response && error.response.status === 401) {
       // Token is invalid or expired
       // Log the user out
@@ -3,8 +3,10 @@ import { createJSONStorage, persist } from 'zustand/middleware';
 
 interface AuthState {
   token: string | null;
+  refreshToken: string | null;
   isAuthenticated: boolean;
   setToken: (token: string | null) => void;
+  setSession: (token: string, refreshToken: string) => void;
   logout: () => void;
 }
 
@@ -12,6 +14,7 @@ export const useAuthStore = create<AuthState>()(
   persist(
     (set) => ({
       token: null,
+      refreshToken: null,
       isAuthenticated: false,
       setToken: (token) => {
         set({
@@ -19,9 +22,17 @@ export const useAuthStore = create<AuthState>()(
           isAuthenticated: !!token,
         });
       },
+      setSession: (token, refreshToken) => {
+        set({
+          token: token,
+          refreshToken: refreshToken,
+          isAuthenticated: true,
+        });
+      },
       logout: () => {
         set({
           token: null,
+          refreshToken: null,
           isAuthenticated: false,
         });
       },
@@ -31,4 +42,4 @@ export const useAuthStore = create<AuthState>()(
       storage: createJSONStorage(() => localStorage), 
     }
   )
-);
\ No newline at end of file
+);